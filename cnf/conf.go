@@ -34,10 +34,17 @@ import (
 
 const (
 	dfltServerWriteTimeoutSecs = 30
+	dfltShutdownGraceSecs      = 10
 	dfltLanguage               = "en"
 	dfltMaxNumConcurrentJobs   = 4
 	dfltVertMaxNumErrors       = 100
 	dfltTimeZone               = "Europe/Prague"
+
+	KeyCaseCamel = "camel"
+	KeyCaseSnake = "snake"
+
+	ErrorFormatDefault = "default"
+	ErrorFormatProblem = "problem"
 )
 
 type LocaleConf struct {
@@ -98,9 +105,31 @@ type Conf struct {
 	AuthHeaderName         string               `json:"authHeaderName"`
 	AuthTokens             []string             `json:"authTokens"`
 
+	// ShutdownGraceSecs specifies how long the server waits
+	// for in-flight requests (and their worker queries) to finish
+	// once a termination signal is received.
+	ShutdownGraceSecs int `json:"shutdownGraceSecs"`
+
+	// ResponseKeyCase specifies a case of keys in JSON responses
+	// (`camel` or `snake`). Clients can override the value using
+	// the `X-Response-Key-Case` header. If empty, the keys are
+	// written as defined by the respective response types.
+	ResponseKeyCase string `json:"responseKeyCase"`
+
+	// ErrorResponseFormat specifies a format of error responses - either
+	// `default` or `problem` (RFC 7807 `application/problem+json`).
+	// Clients can request the latter also via the `Accept` header.
+	ErrorResponseFormat string `json:"errorResponseFormat"`
+
 	srcPath string
 }
 
+// IsValidKeyCase tests whether `v` is a supported
+// case of JSON response keys
+func IsValidKeyCase(v string) bool {
+	return v == KeyCaseCamel || v == KeyCaseSnake
+}
+
 func (conf *Conf) IsDebugMode() bool {
 	return conf.LogLevel == "debug"
 }
@@ -152,6 +181,13 @@ func ValidateAndDefaults(conf *Conf) {
 			dfltServerWriteTimeoutSecs,
 		)
 	}
+	if conf.ShutdownGraceSecs == 0 {
+		conf.ShutdownGraceSecs = dfltShutdownGraceSecs
+		log.Warn().Msgf(
+			"shutdownGraceSecs not specified, using default: %d",
+			dfltShutdownGraceSecs,
+		)
+	}
 	if conf.PublicURL == "" {
 		conf.PublicURL = fmt.Sprintf("http://%s", conf.ListenAddress)
 		log.Warn().Str("address", conf.PublicURL).Msg("publicUrl not set, using listenAddress")
@@ -189,6 +225,20 @@ func ValidateAndDefaults(conf *Conf) {
 	if err := conf.CorporaSetup.ValidateAndDefaults("corporaSetup"); err != nil {
 		log.Fatal().Err(err).Msg("invalid configuration")
 	}
+	if conf.ResponseKeyCase != "" && !IsValidKeyCase(conf.ResponseKeyCase) {
+		log.Fatal().
+			Str("value", conf.ResponseKeyCase).
+			Msg("invalid responseKeyCase (must be `camel` or `snake`)")
+	}
+	if conf.ErrorResponseFormat == "" {
+		conf.ErrorResponseFormat = ErrorFormatDefault
+
+	} else if conf.ErrorResponseFormat != ErrorFormatDefault &&
+		conf.ErrorResponseFormat != ErrorFormatProblem {
+		log.Fatal().
+			Str("value", conf.ErrorResponseFormat).
+			Msg("invalid errorResponseFormat (must be `default` or `problem`)")
+	}
 	if conf.TimeZone == "" {
 		log.Warn().
 			Str("timeZone", dfltTimeZone).
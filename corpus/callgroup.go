@@ -0,0 +1,106 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package corpus
+
+import (
+	"mquery/mango"
+	"sync"
+)
+
+type groupCall[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+
+	// dups is the number of callers waiting for the result
+	// (i.e. the calls which have not been performed)
+	dups int
+}
+
+// CallGroup coalesces concurrent calls of the same (by key)
+// function so only one of them is actually performed and the
+// others just wait for its result. This prevents bursts of
+// identical (and possibly expensive) Manatee calls e.g. on a cold
+// start when many clients ask for the same corpus information.
+// Results are not stored once the call is finished - use a cache
+// for that.
+type CallGroup[T any] struct {
+	calls map[string]*groupCall[T]
+	lock  sync.Mutex
+}
+
+// Do calls `fn` unless there is an unfinished call with the same
+// key in which case it waits for the result of that call.
+func (g *CallGroup[T]) Do(key string, fn func() (T, error)) (T, error) {
+	g.lock.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*groupCall[T])
+	}
+	if call, ok := g.calls[key]; ok {
+		call.dups++
+		g.lock.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call := &groupCall[T]{done: make(chan struct{})}
+	g.calls[key] = call
+	g.lock.Unlock()
+
+	call.value, call.err = fn()
+	g.lock.Lock()
+	// the call may have been forgotten and replaced by a newer one
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+	g.lock.Unlock()
+	close(call.done)
+	return call.value, call.err
+}
+
+// Forget makes the unfinished call with the key (if any) invisible
+// to new callers so they perform a new call. The callers already
+// waiting for the forgotten call still obtain its result.
+func (g *CallGroup[T]) Forget(key string) {
+	g.lock.Lock()
+	delete(g.calls, key)
+	g.lock.Unlock()
+}
+
+var (
+	corpusSizeCalls CallGroup[int64]
+
+	// loadCorpusSize performs the actual corpus size
+	// calls (it is replaceable for testing purposes)
+	loadCorpusSize = mango.GetCorpusSize
+)
+
+// GetCorpusSize works like mango.GetCorpusSize but concurrent
+// calls for the same corpus share a single Manatee call.
+func GetCorpusSize(corpusPath string) (int64, error) {
+	return corpusSizeCalls.Do(corpusPath, func() (int64, error) {
+		return loadCorpusSize(corpusPath)
+	})
+}
+
+// ForgetCorpusSize makes sure the size of a corpus is obtained
+// by a new Manatee call (i.e. an unfinished call started e.g. before
+// the corpus has been recompiled is not shared anymore).
+func ForgetCorpusSize(corpusPath string) {
+	corpusSizeCalls.Forget(corpusPath)
+}
@@ -0,0 +1,169 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package corpus
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitForDups waits until there are `n` callers waiting
+// for an unfinished call with the key
+func waitForDups[T any](t *testing.T, g *CallGroup[T], key string, n int) {
+	for i := 0; i < 1000; i++ {
+		g.lock.Lock()
+		call, ok := g.calls[key]
+		numDups := 0
+		if ok {
+			numDups = call.dups
+		}
+		g.lock.Unlock()
+		if numDups == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("callers of %s did not join the call", key)
+}
+
+func TestCallGroupSequentialCalls(t *testing.T) {
+	var g CallGroup[int]
+	var numCalls atomic.Int32
+	for i := 0; i < 3; i++ {
+		v, err := g.Do("a", func() (int, error) {
+			return int(numCalls.Add(1)), nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, i+1, v)
+	}
+	// results are not stored once a call is finished
+	assert.Equal(t, int32(3), numCalls.Load())
+	assert.Empty(t, g.calls)
+}
+
+func TestCallGroupSharesError(t *testing.T) {
+	var g CallGroup[int]
+	release := make(chan struct{})
+	testErr := errors.New("failed to open corpus")
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = g.Do("a", func() (int, error) {
+				<-release
+				return 0, testErr
+			})
+		}(i)
+	}
+	waitForDups(t, &g, "a", len(errs)-1)
+	close(release)
+	wg.Wait()
+	for _, err := range errs {
+		assert.ErrorIs(t, err, testErr)
+	}
+}
+
+func TestGetCorpusSizeConcurrentCalls(t *testing.T) {
+	var numCalls atomic.Int32
+	release := make(chan struct{})
+	orig := loadCorpusSize
+	loadCorpusSize = func(corpusPath string) (int64, error) {
+		numCalls.Add(1)
+		<-release
+		if corpusPath == "/var/registry/corp1" {
+			return 1000, nil
+		}
+		return 2000, nil
+	}
+	t.Cleanup(func() { loadCorpusSize = orig })
+
+	const numCallers = 50
+	var wg sync.WaitGroup
+	sizes := make([]int64, 2*numCallers)
+	for i := range sizes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			if i%2 == 0 {
+				sizes[i], err = GetCorpusSize("/var/registry/corp1")
+
+			} else {
+				sizes[i], err = GetCorpusSize("/var/registry/corp2")
+			}
+			assert.NoError(t, err)
+		}(i)
+	}
+	waitForDups(t, &corpusSizeCalls, "/var/registry/corp1", numCallers-1)
+	waitForDups(t, &corpusSizeCalls, "/var/registry/corp2", numCallers-1)
+	close(release)
+	wg.Wait()
+	// a single computation per corpus
+	assert.Equal(t, int32(2), numCalls.Load())
+	for i, size := range sizes {
+		if i%2 == 0 {
+			assert.Equal(t, int64(1000), size)
+
+		} else {
+			assert.Equal(t, int64(2000), size)
+		}
+	}
+}
+
+func TestCallGroupForget(t *testing.T) {
+	var g CallGroup[int]
+	release := make(chan struct{})
+	var numCalls atomic.Int32
+	var wg sync.WaitGroup
+	values := make([]int, 2)
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], _ = g.Do("a", func() (int, error) {
+				v := int(numCalls.Add(1))
+				<-release
+				return v, nil
+			})
+		}(i)
+	}
+	waitForDups(t, &g, "a", 1)
+	g.Forget("a")
+	// a new caller does not join the forgotten call
+	newValue := make(chan int)
+	go func() {
+		v, _ := g.Do("a", func() (int, error) {
+			return int(numCalls.Add(1)) * 10, nil
+		})
+		newValue <- v
+	}()
+	assert.Equal(t, 20, <-newValue)
+	close(release)
+	wg.Wait()
+	// the waiting callers obtain the result of the forgotten call
+	assert.Equal(t, []int{1, 1}, values)
+	assert.Empty(t, g.calls)
+}
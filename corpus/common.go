@@ -19,15 +19,26 @@
 package corpus
 
 import (
+	"bufio"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"mquery/rdb"
 	"os"
 	"path/filepath"
 )
 
+const (
+	// subcRangeSize is a size of a single range (two 64-bit
+	// positions) stored in a `.subc` file
+	subcRangeSize = 16
+)
+
 var (
 	ErrNotFound = errors.New("corpus not found")
+
+	ErrSubcorpusMismatch = errors.New("subcorpus does not match the corpus")
 )
 
 type SplitCorpus struct {
@@ -59,6 +70,64 @@ func OpenSplitCorpus(subcBaseDir, corpPath string) (*SplitCorpus, error) {
 	return ans, nil
 }
 
+// ValidateSubcorpus tests whether all the position ranges stored
+// in a `.subc` file fit within a corpus of the size `corpusSize`.
+// This prevents from using a subcorpus created for a different corpus
+// (or a different version of the corpus). In case of a mismatch,
+// a wrapped ErrSubcorpusMismatch is returned.
+func ValidateSubcorpus(subcPath string, corpusSize int64) error {
+	file, err := os.Open(subcPath)
+	if err != nil {
+		return fmt.Errorf("failed to validate subcorpus: %w", err)
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	buff := make([]byte, subcRangeSize)
+	var prevTo int64
+	for i := 0; ; i++ {
+		_, err := io.ReadFull(reader, buff)
+		if err == io.EOF {
+			return nil
+
+		} else if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("%w: invalid size of %s", ErrSubcorpusMismatch, filepath.Base(subcPath))
+
+		} else if err != nil {
+			return fmt.Errorf("failed to validate subcorpus: %w", err)
+		}
+		from := int64(binary.LittleEndian.Uint64(buff[:8]))
+		to := int64(binary.LittleEndian.Uint64(buff[8:]))
+		if from < prevTo || to <= from || to > corpusSize {
+			return fmt.Errorf(
+				"%w: invalid range %d [%d, %d) in %s (corpus size: %d)",
+				ErrSubcorpusMismatch, i, from, to, filepath.Base(subcPath), corpusSize,
+			)
+		}
+		prevTo = to
+	}
+}
+
+// ReadSubcorpusRanges reads all the position ranges ([from, to) pairs)
+// stored in a `.subc` file.
+func ReadSubcorpusRanges(subcPath string) ([][2]int64, error) {
+	data, err := os.ReadFile(subcPath)
+	if err != nil {
+		return [][2]int64{}, fmt.Errorf("failed to read subcorpus ranges: %w", err)
+	}
+	if len(data)%subcRangeSize != 0 {
+		return [][2]int64{}, fmt.Errorf(
+			"%w: invalid size of %s", ErrSubcorpusMismatch, filepath.Base(subcPath))
+	}
+	ans := make([][2]int64, 0, len(data)/subcRangeSize)
+	for i := 0; i < len(data); i += subcRangeSize {
+		ans = append(ans, [2]int64{
+			int64(binary.LittleEndian.Uint64(data[i : i+8])),
+			int64(binary.LittleEndian.Uint64(data[i+8 : i+subcRangeSize])),
+		})
+	}
+	return ans, nil
+}
+
 type QueryHandler interface {
 	PublishQuery(query rdb.Query) (<-chan *rdb.WorkerResult, error)
 }
@@ -20,6 +20,7 @@ package corpus
 
 import (
 	"fmt"
+	"mquery/mango"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -30,12 +31,31 @@ import (
 
 const (
 	DfltSplitChunkSize = 100000000
+	DfltMaxSplitChunks = 200
 	DfltMaximumRecords = 50
+	DfltFreqLimit      = 1
+	DfltMaxFreqItems   = 10000
+	DfltMaxCollSrchRng = 15
+	DfltCollMeasure    = "logDice"
+
+	DfltConcCacheTTLSecs  = 300
+	DfltConcCacheMaxLines = 5000000
+	DfltTTNormsCacheSize  = 100
+
+	// FullCorpusSubcID is a special subcorpus ID used by clients
+	// to search the whole corpus even if a default subcorpus is set
+	FullCorpusSubcID = "__full__"
 )
 
 type PosAttr struct {
 	Name        string            `json:"name"`
 	Description map[string]string `json:"description"`
+
+	// MultivalueSeparator is a separator of individual values
+	// in case the attribute packs multiple values into a single
+	// one (e.g. `a|b|c`). If set, frequency distributions and word
+	// lists of the attribute count the individual values.
+	MultivalueSeparator string `json:"multivalueSeparator"`
 }
 
 func (p PosAttr) IsZero() bool {
@@ -121,6 +141,32 @@ type CorpusSetup struct {
 	Variants          map[string]CorpusVariant `json:"variants"`
 	SrchKeywords      []string                 `json:"srchKeywords"`
 	WebURL            string                   `json:"webUrl"`
+
+	// DefaultFreqLimit is a minimum frequency applied to frequency
+	// distributions in case a client does not specify `flimit`
+	DefaultFreqLimit int `json:"defaultFreqLimit"`
+
+	// MinFreqLimit is a minimum `flimit` enforced for frequency
+	// distributions of the whole corpus (lower values requested
+	// by clients are raised) which prevents enumeration of
+	// the whole vocabulary of large corpora. Subcorpus queries
+	// are not affected. Zero means no limit.
+	MinFreqLimit int `json:"minFreqLimit"`
+
+	// DefaultSubc is an ID of a Manatee subcorpus (see CorporaSetup.SubcorporaDir)
+	// applied in case a client does not specify any
+	DefaultSubc string `json:"defaultSubc"`
+
+	// CollFreqDataAttrs are positional attributes the collocation
+	// frequency data of split corpus chunks are calculated for
+	// in case a client does not specify any. If empty, `word`
+	// and `lemma` are used.
+	CollFreqDataAttrs []string `json:"collFreqDataAttrs"`
+
+	// DefaultCollMeasure is a collocation measure (e.g. `logDice`)
+	// applied in case a client does not specify `measure`. If empty,
+	// CorporaSetup.DefaultCollMeasure is used.
+	DefaultCollMeasure string `json:"defaultCollMeasure"`
 }
 
 func (cs *CorpusSetup) LocaleDescription(lang string) string {
@@ -144,6 +190,13 @@ func (cs *CorpusSetup) GetPosAttr(name string) PosAttr {
 	return PosAttr{}
 }
 
+// GetMultivalueSeparator returns a multi-value separator of an attribute
+// a frequency criterion is based on. For multi-level criteria and for
+// attributes without the separator, an empty string is returned.
+func (cs *CorpusSetup) GetMultivalueSeparator(fcrit string) string {
+	return cs.GetPosAttr(FreqCritAttr(fcrit)).MultivalueSeparator
+}
+
 func (cs *CorpusSetup) GetStruct(name string) StructAttr {
 	for _, v := range cs.StructAttrs {
 		if v.Name == name {
@@ -179,6 +232,25 @@ func (cs *CorpusSetup) ValidateAndDefaults() error {
 		log.Warn().
 			Msg("no `ttOverviewAttrs` defined, some freq. function will be disabled")
 	}
+	for _, attr := range cs.CollFreqDataAttrs {
+		if cs.GetPosAttr(attr).IsZero() {
+			return fmt.Errorf("invalid `collFreqDataAttrs` item `%s` (not in `posAttrs`)", attr)
+		}
+	}
+	if cs.DefaultFreqLimit < 0 {
+		return fmt.Errorf("invalid `defaultFreqLimit` value %d (must be >= 1)", cs.DefaultFreqLimit)
+
+	} else if cs.DefaultFreqLimit == 0 {
+		cs.DefaultFreqLimit = DfltFreqLimit
+	}
+	if cs.MinFreqLimit < 0 {
+		return fmt.Errorf("invalid `minFreqLimit` value %d (must be >= 0)", cs.MinFreqLimit)
+	}
+	if cs.DefaultCollMeasure != "" {
+		if _, err := mango.ImportCollMeasure(cs.DefaultCollMeasure); err != nil {
+			return fmt.Errorf("invalid `defaultCollMeasure` value `%s`", cs.DefaultCollMeasure)
+		}
+	}
 	return nil
 }
 
@@ -244,8 +316,93 @@ type CorporaSetup struct {
 	// I.e. the value only affects newly created splits.
 	MultiprocChunkSize int64 `json:"multiprocChunkSize"`
 
+	// MaxSplitChunks is a maximum number of chunks (subcorpora)
+	// a corpus can be split into. In case a requested chunk size
+	// would produce more chunks, the size is increased accordingly.
+	MaxSplitChunks int `json:"maxSplitChunks"`
+
 	MktokencovPath string `json:"mktokencovPath"`
 
+	// SubcorporaDir is a directory containing Manatee subcorpora
+	// (`.subc` files) organized in per-corpus subdirectories
+	// (e.g. `[subcorporaDir]/syn2020/mysubc.subc`). Clients refer
+	// to the subcorpora by their IDs (= filenames without suffix).
+	SubcorporaDir string `json:"subcorporaDir"`
+
+	// StrictFreqLimit specifies how to handle non-positive `flimit`
+	// values. In strict mode, such requests are rejected,
+	// otherwise, the value is clamped to 1.
+	StrictFreqLimit bool `json:"strictFreqLimit"`
+
+	// MaxFreqItems is a maximum number of items a frequency
+	// distribution can return. Larger results are truncated
+	// (only the most frequent items are kept).
+	MaxFreqItems int `json:"maxFreqItems"`
+
+	// MaxCollSrchRange is a maximum distance (in tokens, on both
+	// sides of the KWIC) in which collocation candidates can be
+	// searched.
+	MaxCollSrchRange int `json:"maxCollSrchRange"`
+
+	// ResponseEnvelope enables wrapping of frequency, collocation
+	// and concordance results in an envelope with request metadata
+	// (query, corpus, processing time etc.). For backward compatibility,
+	// it is disabled by default.
+	ResponseEnvelope bool `json:"responseEnvelope"`
+
+	// ConcCacheTTLSecs specifies how long a compiled concordance
+	// is kept by a worker (since its last use) so that paging
+	// through the concordance does not need to evaluate the query
+	// again.
+	ConcCacheTTLSecs int `json:"concCacheTtlSecs"`
+
+	// ConcCacheMaxLines limits the total number of lines of all the
+	// concordances cached by a worker (i.e. it bounds the memory
+	// used by the cache).
+	ConcCacheMaxLines int `json:"concCacheMaxLines"`
+
+	// RejectMatchAllQueries enables rejection of queries which
+	// obviously match any token (e.g. `[]`) and are therefore very
+	// expensive on large corpora. Clients can still run such queries
+	// by explicitly allowing them via the `allowBroadQuery` argument.
+	RejectMatchAllQueries bool `json:"rejectMatchAllQueries"`
+
+	// StripControlChars enables removal of zero-width and bidi control
+	// characters (e.g. ZWJ, RLM, LRM) from values obtained from corpora
+	// along with their NFC normalization so otherwise identical values
+	// are grouped together (e.g. in frequency distributions).
+	StripControlChars bool `json:"stripControlChars"`
+
+	// SlowQueryThresholdSecs specifies how long a worker query must
+	// take to be logged (at the WARN level) as a slow one. Zero
+	// disables the logging.
+	SlowQueryThresholdSecs float64 `json:"slowQueryThresholdSecs"`
+
+	// RegistryRedactedKeys are registry keys (e.g. `PATH`) whose values
+	// are hidden when showing a registry via the API. If nil,
+	// DfltRegistryRedactedKeys are used.
+	RegistryRedactedKeys []string `json:"registryRedactedKeys"`
+
+	// CitationFields maps fields of a corpus citation block (as provided
+	// via the API) to registry keys they are read from. If nil,
+	// DfltCitationFields are used.
+	CitationFields map[string]string `json:"citationFields"`
+
+	// HideTokenPosRef removes the `#` reference (an absolute corpus
+	// position of the KWIC) from references of concordance lines provided
+	// to clients. The position is still used internally (e.g. for line IDs).
+	HideTokenPosRef bool `json:"hideTokenPosRef"`
+
+	// DefaultCollMeasure is a collocation measure applied in case
+	// neither a client nor the corpus configuration specifies one.
+	// If empty, DfltCollMeasure is used.
+	DefaultCollMeasure string `json:"defaultCollMeasure"`
+
+	// TTNormsCacheSize is a maximum number of text types norms
+	// (i.e. sizes of values of a structural attribute of a corpus)
+	// kept in memory so they do not have to be calculated repeatedly
+	TTNormsCacheSize int `json:"ttNormsCacheSize"`
+
 	Resources Resources `json:"resources"`
 }
 
@@ -253,6 +410,23 @@ func (cs *CorporaSetup) GetRegistryPath(corpusID string) string {
 	return filepath.Join(cs.RegistryDir, corpusID)
 }
 
+// GetSubcorpusPath returns a path of a subcorpus file
+// identified by `subcID` for a corpus `corpusID`.
+// The function does not test the file existence.
+func (cs *CorporaSetup) GetSubcorpusPath(corpusID, subcID string) string {
+	return filepath.Join(cs.SubcorporaDir, corpusID, subcID+".subc")
+}
+
+// GetDefaultCollMeasure returns a collocation measure applied
+// in case a client does not specify any. A corpus-specific value
+// has a precedence over the global one.
+func (cs *CorporaSetup) GetDefaultCollMeasure(corpusConf *CorpusSetup) string {
+	if corpusConf != nil && corpusConf.DefaultCollMeasure != "" {
+		return corpusConf.DefaultCollMeasure
+	}
+	return cs.DefaultCollMeasure
+}
+
 func (cs *CorporaSetup) ValidateAndDefaults(confContext string) error {
 	if cs == nil {
 		return fmt.Errorf("missing configuration section `%s`", confContext)
@@ -279,11 +453,106 @@ func (cs *CorporaSetup) ValidateAndDefaults(confContext string) error {
 	}
 
 	if cs.MultiprocChunkSize == 0 {
+		cs.MultiprocChunkSize = DfltSplitChunkSize
 		log.Warn().
 			Int("value", DfltSplitChunkSize).
 			Msgf("`%s.multiprocChunkSize` not set, using default", confContext)
 	}
 
+	if cs.MaxSplitChunks == 0 {
+		cs.MaxSplitChunks = DfltMaxSplitChunks
+		log.Warn().
+			Int("value", cs.MaxSplitChunks).
+			Msgf("`%s.maxSplitChunks` not set, using default", confContext)
+
+	} else if cs.MaxSplitChunks < 0 {
+		return fmt.Errorf("invalid `%s.maxSplitChunks` value (must be > 0)", confContext)
+	}
+
+	if cs.SubcorporaDir != "" {
+		isDir, err = fs.IsDir(cs.SubcorporaDir)
+		if err != nil {
+			return fmt.Errorf("failed to test `%s.subcorporaDir`: %w", confContext, err)
+		}
+		if !isDir {
+			return fmt.Errorf("`%s.subcorporaDir` is not a directory", confContext)
+		}
+
+	} else {
+		log.Warn().
+			Msgf("`%s.subcorporaDir` not set, subcorpora will not be available", confContext)
+	}
+
+	if cs.MaxFreqItems == 0 {
+		cs.MaxFreqItems = DfltMaxFreqItems
+		log.Warn().
+			Int("value", cs.MaxFreqItems).
+			Msgf("`%s.maxFreqItems` not set, using default", confContext)
+
+	} else if cs.MaxFreqItems < 0 {
+		return fmt.Errorf("invalid `%s.maxFreqItems` value (must be > 0)", confContext)
+	}
+
+	if cs.MaxCollSrchRange == 0 {
+		cs.MaxCollSrchRange = DfltMaxCollSrchRng
+		log.Warn().
+			Int("value", cs.MaxCollSrchRange).
+			Msgf("`%s.maxCollSrchRange` not set, using default", confContext)
+
+	} else if cs.MaxCollSrchRange < 0 {
+		return fmt.Errorf("invalid `%s.maxCollSrchRange` value (must be > 0)", confContext)
+	}
+
+	if cs.ConcCacheTTLSecs == 0 {
+		cs.ConcCacheTTLSecs = DfltConcCacheTTLSecs
+		log.Warn().
+			Int("value", cs.ConcCacheTTLSecs).
+			Msgf("`%s.concCacheTtlSecs` not set, using default", confContext)
+
+	} else if cs.ConcCacheTTLSecs < 0 {
+		return fmt.Errorf("invalid `%s.concCacheTtlSecs` value (must be > 0)", confContext)
+	}
+
+	if cs.ConcCacheMaxLines == 0 {
+		cs.ConcCacheMaxLines = DfltConcCacheMaxLines
+		log.Warn().
+			Int("value", cs.ConcCacheMaxLines).
+			Msgf("`%s.concCacheMaxLines` not set, using default", confContext)
+
+	} else if cs.ConcCacheMaxLines < 0 {
+		return fmt.Errorf("invalid `%s.concCacheMaxLines` value (must be > 0)", confContext)
+	}
+
+	if cs.TTNormsCacheSize == 0 {
+		cs.TTNormsCacheSize = DfltTTNormsCacheSize
+		log.Warn().
+			Int("value", cs.TTNormsCacheSize).
+			Msgf("`%s.ttNormsCacheSize` not set, using default", confContext)
+
+	} else if cs.TTNormsCacheSize < 0 {
+		return fmt.Errorf("invalid `%s.ttNormsCacheSize` value (must be > 0)", confContext)
+	}
+
+	if cs.DefaultCollMeasure == "" {
+		cs.DefaultCollMeasure = DfltCollMeasure
+
+	} else if _, err := mango.ImportCollMeasure(cs.DefaultCollMeasure); err != nil {
+		return fmt.Errorf(
+			"invalid `%s.defaultCollMeasure` value `%s`", confContext, cs.DefaultCollMeasure)
+	}
+
+	if cs.RegistryRedactedKeys == nil {
+		cs.RegistryRedactedKeys = DfltRegistryRedactedKeys
+	}
+
+	if cs.CitationFields == nil {
+		cs.CitationFields = DfltCitationFields
+	}
+
+	if cs.SlowQueryThresholdSecs < 0 {
+		return fmt.Errorf("invalid `%s.slowQueryThresholdSecs` value (must be >= 0)", confContext)
+	}
+
 	isFile, err := fs.IsFile(cs.MktokencovPath)
 	if err != nil {
 		return fmt.Errorf("failed to test `%s.mktokencovPath` file %w", confContext, err)
@@ -292,6 +561,23 @@ func (cs *CorporaSetup) ValidateAndDefaults(confContext string) error {
 		return fmt.Errorf("the `%s.mktokencovPath` does not point to a file", confContext)
 	}
 	for _, v := range cs.Resources {
+		if v.DefaultSubc != "" {
+			if cs.SubcorporaDir == "" {
+				return fmt.Errorf(
+					"corpus %s: `defaultSubc` requires `%s.subcorporaDir` to be set", v.ID, confContext)
+			}
+			// for dynamic corpora, we cannot test the file here as the
+			// actual corpus ID is known only when a request comes
+			if !v.IsDynamic() {
+				isFile, err := fs.IsFile(cs.GetSubcorpusPath(v.ID, v.DefaultSubc))
+				if err != nil {
+					return fmt.Errorf("corpus %s: failed to test `defaultSubc`: %w", v.ID, err)
+				}
+				if !isFile {
+					return fmt.Errorf("corpus %s: `defaultSubc` %s not found", v.ID, v.DefaultSubc)
+				}
+			}
+		}
 		if err := v.ValidateAndDefaults(); err != nil {
 			return err
 		}
@@ -0,0 +1,63 @@
+// Copyright 2019 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2019 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package corpus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCorpusSetup() *CorpusSetup {
+	return &CorpusSetup{
+		ID:       "corp1",
+		FullName: map[string]string{"en": "Corpus 1"},
+		PosAttrs: PosAttrList{{Name: "word"}, {Name: "lemma"}},
+	}
+}
+
+func TestGetDefaultCollMeasure(t *testing.T) {
+	conf := &CorporaSetup{DefaultCollMeasure: "tScore"}
+	corp := newTestCorpusSetup()
+	assert.Equal(t, "tScore", conf.GetDefaultCollMeasure(corp))
+	assert.Equal(t, "tScore", conf.GetDefaultCollMeasure(nil))
+	corp.DefaultCollMeasure = "mutualInfo"
+	assert.Equal(t, "mutualInfo", conf.GetDefaultCollMeasure(corp))
+}
+
+func TestCorpusSetupValidateDefaultCollMeasure(t *testing.T) {
+	corp := newTestCorpusSetup()
+	assert.NoError(t, corp.ValidateAndDefaults())
+	// no corpus-specific value means the global one is used
+	assert.Empty(t, corp.DefaultCollMeasure)
+
+	corp.DefaultCollMeasure = "logLikelihood"
+	assert.NoError(t, corp.ValidateAndDefaults())
+	corp.DefaultCollMeasure = "logdice"
+	assert.Error(t, corp.ValidateAndDefaults())
+}
+
+func TestCorpusSetupValidateMinFreqLimit(t *testing.T) {
+	corp := newTestCorpusSetup()
+	corp.MinFreqLimit = 10
+	assert.NoError(t, corp.ValidateAndDefaults())
+	assert.Equal(t, 10, corp.MinFreqLimit)
+	corp.MinFreqLimit = -1
+	assert.Error(t, corp.ValidateAndDefaults())
+}
@@ -26,13 +26,11 @@ import (
 	"mquery/mango"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/czcorpus/cnc-gokit/fs"
 	"github.com/czcorpus/cnc-gokit/maths"
-)
-
-const (
-	maxReasonableNumChunks = 200
+	"github.com/rs/zerolog/log"
 )
 
 func SplitCorpusExists(subcBaseDir, corpusPath string) (bool, error) {
@@ -68,16 +66,45 @@ func DeleteSplit(subcBaseDir, corpusPath string) error {
 	return nil
 }
 
-func SplitCorpus(subcBaseDir, corpusPath string, chunkSize int64) (*corpus.SplitCorpus, error) {
+// FitChunkSize returns a chunk size and a number of chunks for splitting
+// a corpus of the size `corpusSize` into chunks of the size `chunkSize`.
+// In case this would produce more than `maxChunks` chunks, the chunk size
+// is increased so the number of chunks stays within the limit.
+func FitChunkSize(corpusSize, chunkSize int64, maxChunks int) (int64, int) {
+	numChunks := int(math.Ceil(float64(corpusSize) / float64(chunkSize)))
+	if maxChunks > 0 && numChunks > maxChunks {
+		chunkSize = int64(math.Ceil(float64(corpusSize) / float64(maxChunks)))
+		numChunks = int(math.Ceil(float64(corpusSize) / float64(chunkSize)))
+	}
+	return chunkSize, numChunks
+}
+
+// SplitCorpus splits a corpus into subcorpora ("chunks") of the size
+// `chunkSize` (the last one may be smaller). In case this would produce
+// more than `maxChunks` chunks, a larger chunk size is used.
+func SplitCorpus(
+	subcBaseDir, corpusPath string,
+	chunkSize int64,
+	maxChunks int,
+) (*corpus.SplitCorpus, error) {
 
 	ans := &corpus.SplitCorpus{CorpusPath: corpusPath}
+	if chunkSize < 1 {
+		return ans, fmt.Errorf("failed create split corpus: invalid chunk size %d", chunkSize)
+	}
 	size, err := mango.GetCorpusSize(corpusPath)
 	if err != nil {
 		return ans, fmt.Errorf("failed create split corpus: %w", err)
 	}
-	numChunks := int(math.Ceil(float64(size) / float64(chunkSize)))
-	if numChunks > maxReasonableNumChunks {
-		return ans, fmt.Errorf("failed create split corpus: too much chunks (%d vs. limit %d)", numChunks, maxReasonableNumChunks)
+	fittedChunkSize, numChunks := FitChunkSize(size, chunkSize, maxChunks)
+	if fittedChunkSize != chunkSize {
+		log.Warn().
+			Str("corpus", filepath.Base(corpusPath)).
+			Int64("requestedChunkSize", chunkSize).
+			Int64("chunkSize", fittedChunkSize).
+			Int("maxChunks", maxChunks).
+			Msg("requested chunk size would produce too many chunks, using a larger one")
+		chunkSize = fittedChunkSize
 	}
 	ans.Subcorpora = make([]string, numChunks)
 	cname := filepath.Base(corpusPath)
@@ -102,6 +129,32 @@ func SplitCorpus(subcBaseDir, corpusPath string, chunkSize int64) (*corpus.Split
 	return ans, nil
 }
 
+// CollFreqDataExists tests whether the frequency data needed for
+// calculating collocations and text types (as created by the worker's
+// `calcCollFreqData`) exist for a subcorpus. Manatee stores subcorpus
+// frequencies of an attribute as `[subc path w/o suffix].[attr].frq`
+// while mktokencov creates `[subc path w/o suffix].[struct].token`.
+func CollFreqDataExists(subcPath string, attrs, structs []string) (bool, error) {
+	basePath := strings.TrimSuffix(subcPath, filepath.Ext(subcPath))
+	files := make([]string, 0, len(attrs)+len(structs))
+	for _, attr := range attrs {
+		files = append(files, fmt.Sprintf("%s.%s.frq", basePath, attr))
+	}
+	for _, strct := range structs {
+		files = append(files, fmt.Sprintf("%s.%s.token", basePath, strct))
+	}
+	for _, file := range files {
+		isFile, err := fs.IsFile(file)
+		if err != nil {
+			return false, fmt.Errorf("failed to determine coll. freq. data existence: %w", err)
+		}
+		if !isFile {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 func createSubcorpus(path string, fromIdx int64, toIdx int64) error {
 	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
@@ -0,0 +1,61 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package edit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFitChunkSizeWithinLimit(t *testing.T) {
+	chunkSize, numChunks := FitChunkSize(1000, 100, 20)
+	assert.Equal(t, int64(100), chunkSize)
+	assert.Equal(t, 10, numChunks)
+
+	chunkSize, numChunks = FitChunkSize(1050, 100, 20)
+	assert.Equal(t, int64(100), chunkSize)
+	assert.Equal(t, 11, numChunks)
+}
+
+func TestFitChunkSizeAdjusted(t *testing.T) {
+	chunkSize, numChunks := FitChunkSize(1000000, 10, 200)
+	assert.Equal(t, int64(5000), chunkSize)
+	assert.Equal(t, 200, numChunks)
+}
+
+func TestFitChunkSizeNeverExceedsMax(t *testing.T) {
+	for _, corpusSize := range []int64{1, 7, 999, 1000, 1001, 123456789, 12000000000} {
+		for _, chunkSize := range []int64{1, 3, 100, 99999} {
+			for _, maxChunks := range []int{1, 2, 7, 200} {
+				fitted, numChunks := FitChunkSize(corpusSize, chunkSize, maxChunks)
+				assert.LessOrEqual(t, numChunks, maxChunks, "size %d, chunk %d", corpusSize, chunkSize)
+				assert.GreaterOrEqual(t, fitted, chunkSize)
+				// the chunks must still cover the whole corpus
+				assert.GreaterOrEqual(t, fitted*int64(numChunks), corpusSize)
+			}
+		}
+	}
+}
+
+func TestFitChunkSizeUnlimited(t *testing.T) {
+	chunkSize, numChunks := FitChunkSize(1000000, 10, 0)
+	assert.Equal(t, int64(10), chunkSize)
+	assert.Equal(t, 100000, numChunks)
+}
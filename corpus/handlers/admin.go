@@ -0,0 +1,68 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"fmt"
+	"mquery/corpus"
+	"net/http"
+
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// InvalidateCorpusCaches removes all the cached data related to a corpus.
+// This is intended to be used once a corpus is recompiled. The corpus
+// information, text types norms and subcorpora validations cached by
+// the server are removed immediately while workers are notified to remove
+// their cached data (the latter is asynchronous so the response does not
+// wait for workers).
+func (a *Actions) InvalidateCorpusCaches(ctx *gin.Context) {
+	corpusID := ctx.Param("corpusId")
+	if a.conf.Resources.Get(corpusID) == nil {
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("corpus %s not found", corpusID), http.StatusNotFound)
+		return
+	}
+	numInfo := a.infoProvider.InvalidateCorpus(corpusID)
+	numNorms := a.ttNorms.RemoveCorpus(a.conf.GetRegistryPath(corpusID))
+	numSubc := forgetValidatedSubcorpora(a.conf.GetRegistryPath(corpusID))
+	corpus.ForgetCorpusSize(a.conf.GetRegistryPath(corpusID))
+	if err := a.radapter.PublishCorpusInvalidation(a.conf.GetRegistryPath(corpusID)); err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return
+	}
+	log.Info().
+		Str("corpusId", corpusID).
+		Int("numInfoEntries", numInfo).
+		Int("numNormsEntries", numNorms).
+		Int("numSubcValidations", numSubc).
+		Msg("invalidated corpus caches")
+	uniresp.WriteJSONResponse(
+		ctx.Writer,
+		map[string]any{
+			"ok":                  true,
+			"removedInfoEntries":  numInfo,
+			"removedNormsEntries": numNorms,
+			"removedSubcEntries":  numSubc,
+		},
+	)
+}
@@ -0,0 +1,117 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"mquery/cnf"
+	"mquery/corpus"
+	"mquery/corpus/infoload"
+	"mquery/results"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestCachingActions creates actions with the corpus info and
+// text types norms caches backed by counted calculations. It returns
+// counters of norms loads and corpus size calls.
+func newTestCachingActions(t *testing.T) (*Actions, *fakePublisher, *atomic.Int32, *atomic.Int32) {
+	conf := newTestConf(t)
+	assert.NoError(t, os.WriteFile(conf.GetRegistryPath("corp1"), []byte{}, 0644))
+	writeTestSubc(t, conf, "sub1")
+	numSizeCalls := stubCorpusSize(t, 1000)
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"corpusInfo": &results.CorpusInfo{},
+		},
+	}
+	var numNormsLoads atomic.Int32
+	actions := &Actions{
+		conf:         conf,
+		radapter:     pub,
+		infoProvider: infoload.NewManatee(pub, conf),
+		ttNorms: corpus.NewTTNormsCache(
+			10,
+			func(corpusPath, attr string) (map[string]int64, error) {
+				numNormsLoads.Add(1)
+				return map[string]int64{"a": 10, "b": 30}, nil
+			},
+		),
+		locales: cnf.LocalesConf{{Name: "en", IsDefault: true}},
+	}
+	return actions, pub, &numNormsLoads, numSizeCalls
+}
+
+// requestCachedData calls actions using the info and norms caches
+// and validates a subcorpus
+func requestCachedData(t *testing.T, actions *Actions) {
+	ctx, rec := newTestContext("/info/corp1")
+	actions.CorpusInfo(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ctx, rec = newTestContext("/text-types-sizes/corp1?attr=doc.genre")
+	actions.TextTypesSizes(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ctx, _ = newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&subc=sub1")
+	assert.NoError(t, DetermineQueryProps(ctx, actions.conf).err)
+}
+
+func TestInvalidateCorpusCaches(t *testing.T) {
+	stubAttrChecks(t)
+	actions, pub, numNormsLoads, numSizeCalls := newTestCachingActions(t)
+	for i := 0; i < 3; i++ {
+		requestCachedData(t, actions)
+	}
+	assert.Len(t, pub.queries, 1)
+	assert.Equal(t, int32(1), numNormsLoads.Load())
+	// the text types sizes (3x) and the subcorpus validation (1x)
+	assert.Equal(t, int32(4), numSizeCalls.Load())
+
+	ctx, rec := newTestContext("/admin/corpus/corp1/invalidate")
+	actions.InvalidateCorpusCaches(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var ans map[string]any
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Equal(t, true, ans["ok"])
+	assert.Equal(t, float64(1), ans["removedInfoEntries"])
+	assert.Equal(t, float64(1), ans["removedNormsEntries"])
+	assert.Equal(t, float64(1), ans["removedSubcEntries"])
+	assert.Equal(t, []string{actions.conf.GetRegistryPath("corp1")}, pub.invalidated)
+
+	// the next requests must recalculate the data (just once)
+	for i := 0; i < 2; i++ {
+		requestCachedData(t, actions)
+	}
+	assert.Len(t, pub.queries, 2)
+	assert.Equal(t, int32(2), numNormsLoads.Load())
+	assert.Equal(t, int32(7), numSizeCalls.Load())
+}
+
+func TestInvalidateCorpusCachesUnknownCorpus(t *testing.T) {
+	actions, pub, _, _ := newTestCachingActions(t)
+	ctx, rec := newTestContext("/admin/corpus/corp2/invalidate")
+	ctx.Params = gin.Params{{Key: "corpusId", Value: "corp2"}}
+	actions.InvalidateCorpusCaches(ctx)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Empty(t, pub.invalidated)
+}
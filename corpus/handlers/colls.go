@@ -20,8 +20,13 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
+	"mquery/mango"
 	"mquery/rdb"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/czcorpus/cnc-gokit/unireq"
 	"github.com/czcorpus/cnc-gokit/uniresp"
@@ -29,55 +34,142 @@ import (
 )
 
 const (
-	CollDefaultAttr        = "lemma"
-	defaultNumSubcSamples  = 30
-	defaultSrchLeft        = -5
-	defaultSrchRight       = 5
-	defaultMinCollFreq     = 3
-	defaultCollocationFunc = "logDice"
-	defaultCollMaxItems    = 20
+	CollDefaultAttr       = "lemma"
+	defaultNumSubcSamples = 30
+	defaultSrchLeft       = -5
+	defaultSrchRight      = 5
+	defaultMinCollFreq    = 3
+	defaultCollMaxItems   = 20
+
+	// maxCollAttrLookups limits the number of additional lookups
+	// (i.e. `maxItems` * number of `collAttrs`) as each of them
+	// requires a separate frequency distribution calculation
+	maxCollAttrLookups = 200
 )
 
-func (a *Actions) Collocations(ctx *gin.Context) {
-	queryProps := DetermineQueryProps(ctx, a.conf)
-	if queryProps.hasError() {
-		uniresp.RespondWithErrorJSON(ctx, queryProps.err, queryProps.status)
-		return
+// collFilterQueryOrFail applies the `filter` URL argument (if present)
+// to a query. The filter is a CQL structure expression (e.g.
+// `<doc genre="sport"/>` or `<s/> containing [lemma="ball"]`) the query
+// matches must be located within. In case of an invalid filter, an error
+// response is written and false is returned as the second value.
+func collFilterQueryOrFail(ctx *gin.Context, query string) (string, bool) {
+	if !ctx.Request.URL.Query().Has("filter") {
+		return query, true
+	}
+	filter := strings.TrimSpace(ctx.Query("filter"))
+	if !strings.HasPrefix(filter, "<") {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid `filter` value `%s` (must be a structure expression)", filter),
+			http.StatusUnprocessableEntity,
+		)
+		return "", false
 	}
+	return fmt.Sprintf("%s within %s", query, filter), true
+}
 
+// collArgsFromRequest creates collocations calculation arguments
+// based on the request URL arguments. In case of an invalid argument,
+// an error response is written and false is returned.
+func (a *Actions) collArgsFromRequest(
+	ctx *gin.Context,
+	queryProps queryProps,
+) (rdb.CollocationsArgs, bool) {
 	measure := ctx.Request.URL.Query().Get("measure")
 	if measure == "" {
-		measure = defaultCollocationFunc
+		measure = a.conf.GetDefaultCollMeasure(queryProps.corpusConf)
 	}
 
 	srchLeft, ok := unireq.GetURLIntArgOrFail(ctx, "srchLeft", defaultSrchLeft)
 	if !ok {
-		return
+		return rdb.CollocationsArgs{}, false
 	}
 	srchRight, ok := unireq.GetURLIntArgOrFail(ctx, "srchRight", defaultSrchRight)
 	if !ok {
-		return
+		return rdb.CollocationsArgs{}, false
+	}
+	srchRange := [2]int{srchLeft, srchRight}
+	if err := mango.ValidateCollSrchRange(srchRange, a.conf.MaxCollSrchRange); err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusUnprocessableEntity)
+		return rdb.CollocationsArgs{}, false
 	}
 	minCollFreq, ok := unireq.GetURLIntArgOrFail(ctx, "minCollFreq", defaultMinCollFreq)
 	if !ok {
-		return
+		return rdb.CollocationsArgs{}, false
+	}
+	// for backward compatibility, the candidate min. freq. defaults to `minCollFreq`
+	minCandFreq, ok := unireq.GetURLIntArgOrFail(ctx, "minFreq", minCollFreq)
+	if !ok {
+		return rdb.CollocationsArgs{}, false
 	}
 	maxItems, ok := unireq.GetURLIntArgOrFail(ctx, "maxItems", defaultCollMaxItems)
 	if !ok {
-		return
+		return rdb.CollocationsArgs{}, false
+	}
+	var minScore *float64
+	if ctx.Request.URL.Query().Has("minScore") {
+		v, err := strconv.ParseFloat(ctx.Query("minScore"), 64)
+		if err != nil {
+			uniresp.RespondWithErrorJSON(
+				ctx,
+				fmt.Errorf("invalid `minScore` value `%s`", ctx.Query("minScore")),
+				http.StatusUnprocessableEntity,
+			)
+			return rdb.CollocationsArgs{}, false
+		}
+		minScore = &v
+	}
+	collAttrs := ctx.QueryArray("collAttrs")
+	for _, attr := range collAttrs {
+		if queryProps.corpusConf.GetPosAttr(attr).IsZero() {
+			uniresp.RespondWithErrorJSON(
+				ctx, fmt.Errorf("unknown attribute `%s`", attr), http.StatusUnprocessableEntity)
+			return rdb.CollocationsArgs{}, false
+		}
+	}
+	query, ok := collFilterQueryOrFail(ctx, queryProps.query)
+	if !ok {
+		return rdb.CollocationsArgs{}, false
+	}
+	if maxItems*len(collAttrs) > maxCollAttrLookups {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf(
+				"too many `collAttrs` lookups (`maxItems` * num. of `collAttrs` must be <= %d)",
+				maxCollAttrLookups,
+			),
+			http.StatusUnprocessableEntity,
+		)
+		return rdb.CollocationsArgs{}, false
 	}
 
-	corpusPath := a.conf.GetRegistryPath(queryProps.corpus)
+	return rdb.CollocationsArgs{
+		CorpusPath:  a.conf.GetRegistryPath(queryProps.corpus),
+		SubcPath:    queryProps.subcPath,
+		Query:       query,
+		Attr:        CollDefaultAttr,
+		Measure:     measure,
+		SrchRange:   srchRange,
+		MinFreq:     int64(minCandFreq),
+		MinCoocFreq: int64(minCollFreq),
+		MaxItems:    maxItems,
+		CollAttrs:   collAttrs,
+		MinScore:    minScore,
+	}, true
+}
 
-	args, err := json.Marshal(rdb.CollocationsArgs{
-		CorpusPath: corpusPath,
-		Query:      queryProps.query,
-		Attr:       CollDefaultAttr,
-		Measure:    measure,
-		SrchRange:  [2]int{srchLeft, srchRight},
-		MinFreq:    int64(minCollFreq),
-		MaxItems:   maxItems,
-	})
+func (a *Actions) Collocations(ctx *gin.Context) {
+	t0 := time.Now()
+	queryProps := DetermineQueryProps(ctx, a.conf)
+	if queryProps.hasError() {
+		uniresp.RespondWithErrorJSON(ctx, queryProps.err, queryProps.status)
+		return
+	}
+	collArgs, ok := a.collArgsFromRequest(ctx, queryProps)
+	if !ok {
+		return
+	}
+	args, err := json.Marshal(collArgs)
 	if err != nil {
 		uniresp.WriteJSONErrorResponse(
 			ctx.Writer,
@@ -86,7 +178,7 @@ func (a *Actions) Collocations(ctx *gin.Context) {
 		)
 		return
 	}
-	wait, err := a.radapter.PublishQuery(rdb.Query{
+	wait, err := a.radapter.PublishQueryCtx(ctx.Request.Context(), rdb.Query{
 		Func: "collocations",
 		Args: args,
 	})
@@ -116,8 +208,20 @@ func (a *Actions) Collocations(ctx *gin.Context) {
 		)
 		return
 	}
-	uniresp.WriteJSONResponse(
-		ctx.Writer,
+	a.writeResult(
+		ctx,
+		t0,
+		queryProps,
+		map[string]any{
+			"measure":     collArgs.Measure,
+			"srchRange":   collArgs.SrchRange,
+			"minFreq":     collArgs.MinFreq,
+			"minCollFreq": collArgs.MinCoocFreq,
+			"maxItems":    collArgs.MaxItems,
+			"minScore":    collArgs.MinScore,
+			"filter":      ctx.Query("filter"),
+		},
+		false,
 		&result,
 	)
 }
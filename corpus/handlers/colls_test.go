@@ -0,0 +1,220 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"mquery/mango"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func publishTestColls(t *testing.T, url string) (rdb.CollocationsArgs, int) {
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"collocations": &results.Collocations{},
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	ctx, rec := newTestContext(url)
+	actions.Collocations(ctx)
+	var args rdb.CollocationsArgs
+	if rec.Code == http.StatusOK {
+		pub.publishedArgs(t, 0, &args)
+	}
+	return args, rec.Code
+}
+
+func TestCollocationsFreqFiltersDefaults(t *testing.T) {
+	args, status := publishTestColls(t, "/collocations/corp1?q=[lemma=\"pes\"]")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, int64(defaultMinCollFreq), args.MinFreq)
+	assert.Equal(t, int64(defaultMinCollFreq), args.MinCoocFreq)
+
+	// candidate min. freq. follows `minCollFreq` if not specified
+	args, status = publishTestColls(t, "/collocations/corp1?q=[lemma=\"pes\"]&minCollFreq=7")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, int64(7), args.MinFreq)
+	assert.Equal(t, int64(7), args.MinCoocFreq)
+}
+
+func TestCollocationsDefaultMeasure(t *testing.T) {
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"collocations": &results.Collocations{},
+		},
+	}
+	conf := newTestConf(t)
+	conf.DefaultCollMeasure = "tScore"
+	actions := &Actions{conf: conf, radapter: pub}
+	for _, args := range []string{"", "&measure=logDice"} {
+		ctx, rec := newTestContext("/collocations/corp1?q=[lemma=\"pes\"]" + args)
+		actions.Collocations(ctx)
+		assert.Equal(t, http.StatusOK, rec.Code, args)
+	}
+	conf.Resources.Get("corp1").DefaultCollMeasure = "mutualInfo"
+	ctx, rec := newTestContext("/collocations/corp1?q=[lemma=\"pes\"]")
+	actions.Collocations(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var measures []string
+	for i := range pub.queries {
+		var args rdb.CollocationsArgs
+		pub.publishedArgs(t, i, &args)
+		measures = append(measures, args.Measure)
+	}
+	assert.Equal(t, []string{"tScore", "logDice", "mutualInfo"}, measures)
+}
+
+func TestCollocationsFreqFiltersIndependent(t *testing.T) {
+	args, status := publishTestColls(t, "/collocations/corp1?q=[lemma=\"pes\"]&minFreq=50")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, int64(50), args.MinFreq)
+	assert.Equal(t, int64(defaultMinCollFreq), args.MinCoocFreq)
+
+	args, status = publishTestColls(
+		t, "/collocations/corp1?q=[lemma=\"pes\"]&minFreq=2&minCollFreq=10")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, int64(2), args.MinFreq)
+	assert.Equal(t, int64(10), args.MinCoocFreq)
+
+	_, status = publishTestColls(t, "/collocations/corp1?q=[lemma=\"pes\"]&minFreq=foo")
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+}
+
+func TestCollocationsSrchRange(t *testing.T) {
+	_, status := publishTestColls(t, "/collocations/corp1?q=[lemma=\"pes\"]&srchLeft=5&srchRight=-5")
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+
+	// the default limit is applied by ValidateAndDefaults so we test
+	// the oversized range with an explicit one
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"collocations": &results.Collocations{},
+		},
+	}
+	conf := newTestConf(t)
+	conf.MaxCollSrchRange = 10
+	actions := &Actions{conf: conf, radapter: pub}
+	ctx, rec := newTestContext("/collocations/corp1?q=[lemma=\"pes\"]&srchLeft=-50&srchRight=5")
+	actions.Collocations(ctx)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	ctx, rec = newTestContext("/collocations/corp1?q=[lemma=\"pes\"]&srchLeft=-10&srchRight=10")
+	actions.Collocations(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, pub.queries, 1)
+}
+
+func TestCollocationsCollAttrs(t *testing.T) {
+	args, status := publishTestColls(
+		t, "/collocations/corp1?q=[lemma=\"pes\"]&collAttrs=word&collAttrs=tag&maxItems=20")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"word", "tag"}, args.CollAttrs)
+
+	_, status = publishTestColls(t, "/collocations/corp1?q=[lemma=\"pes\"]&collAttrs=foo")
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+}
+
+func TestCollocationsCollAttrsLookupsBounded(t *testing.T) {
+	url := "/collocations/corp1?q=[lemma=\"pes\"]&collAttrs=word&collAttrs=tag&maxItems=%d"
+	_, status := publishTestColls(t, fmt.Sprintf(url, maxCollAttrLookups/2))
+	assert.Equal(t, http.StatusOK, status)
+	_, status = publishTestColls(t, fmt.Sprintf(url, maxCollAttrLookups/2+1))
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+}
+
+func TestCollocationsMinScore(t *testing.T) {
+	args, status := publishTestColls(
+		t, "/collocations/corp1?q=[lemma=\"pes\"]&measure=logDice&minScore=7.5&minFreq=3&maxItems=20")
+	assert.Equal(t, http.StatusOK, status)
+	if assert.NotNil(t, args.MinScore) {
+		assert.Equal(t, 7.5, *args.MinScore)
+	}
+	assert.Equal(t, int64(3), args.MinFreq)
+	assert.Equal(t, 20, args.MaxItems)
+
+	args, status = publishTestColls(t, "/collocations/corp1?q=[lemma=\"pes\"]")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, args.MinScore)
+
+	_, status = publishTestColls(t, "/collocations/corp1?q=[lemma=\"pes\"]&minScore=high")
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+}
+
+func TestCollocationsFilter(t *testing.T) {
+	// a fake corpus where "run" in sports documents co-occurs
+	// mostly with "race" while in general with "fast"
+	pub := &fakePublisher{
+		respond: func(query rdb.Query) results.SerializableResult {
+			var args rdb.CollocationsArgs
+			assert.NoError(t, json.Unmarshal(query.Args, &args))
+			if strings.Contains(args.Query, `within <doc genre="sport"/>`) {
+				return &results.Collocations{
+					ConcSize: 20,
+					Colls:    []*mango.GoCollItem{{Word: "race", Freq: 12}, {Word: "fast", Freq: 3}},
+				}
+			}
+			return &results.Collocations{
+				ConcSize: 100,
+				Colls:    []*mango.GoCollItem{{Word: "fast", Freq: 40}, {Word: "race", Freq: 15}},
+			}
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	fetch := func(url string) map[string]int64 {
+		ctx, rec := newTestContext(url)
+		actions.Collocations(ctx)
+		assert.Equal(t, http.StatusOK, rec.Code, url)
+		var ans struct {
+			Colls []*mango.GoCollItem `json:"colls"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+		freqs := make(map[string]int64)
+		for _, item := range ans.Colls {
+			freqs[item.Word] = item.Freq
+		}
+		return freqs
+	}
+	unfiltered := fetch("/collocations/corp1?q=[lemma=\"run\"]")
+	filtered := fetch(
+		"/collocations/corp1?q=[lemma=\"run\"]&filter=" + url.QueryEscape(`<doc genre="sport"/>`))
+	assert.Equal(t, map[string]int64{"fast": 40, "race": 15}, unfiltered)
+	assert.Equal(t, map[string]int64{"race": 12, "fast": 3}, filtered)
+
+	var args rdb.CollocationsArgs
+	pub.publishedArgs(t, 0, &args)
+	assert.Equal(t, `[lemma="run"]`, args.Query)
+	pub.publishedArgs(t, 1, &args)
+	assert.Equal(t, `[lemma="run"] within <doc genre="sport"/>`, args.Query)
+}
+
+func TestCollocationsInvalidFilter(t *testing.T) {
+	for _, filter := range []string{"", "sport", `[lemma="ball"]`} {
+		_, status := publishTestColls(
+			t, "/collocations/corp1?q=[lemma=\"run\"]&filter="+url.QueryEscape(filter))
+		assert.Equal(t, http.StatusUnprocessableEntity, status, filter)
+	}
+}
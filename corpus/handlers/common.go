@@ -22,14 +22,53 @@ import (
 	"errors"
 	"fmt"
 	"mquery/corpus"
+	"mquery/mango"
+	"mquery/rdb"
+	"mquery/results"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
 
+	"github.com/czcorpus/cnc-gokit/collections"
+	"github.com/czcorpus/cnc-gokit/fs"
+	"github.com/czcorpus/cnc-gokit/unireq"
+	"github.com/czcorpus/cnc-gokit/uniresp"
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+var (
+	// hasPosAttr, hasStructAttr and hasStruct test attributes
+	// (and structures) existence (the functions are replaceable
+	// so handlers can be tested without an actual corpus)
+	hasPosAttr    = mango.HasPosAttr
+	hasStructAttr = mango.HasStructAttr
+	hasStruct     = mango.HasStruct
+
+	// getCorpusConf reads a corpus registry value (replaceable
+	// for the same reason as the functions above)
+	getCorpusConf = mango.GetCorpusConf
+
+	// isAlignedWith tests whether corpora are aligned
+	isAlignedWith = mango.IsAlignedWith
+
+	// getCorpusSize, validateSubcorpus and forgetValidatedSubcorpora
+	// depend on the corpus size (the functions are replaceable so
+	// handlers can be tested without an actual corpus)
+	getCorpusSize             = corpus.GetCorpusSize
+	validateSubcorpus         = corpus.ValidateSubcorpusOnce
+	forgetValidatedSubcorpora = corpus.ForgetValidatedSubcorpora
 )
 
 type queryProps struct {
 	corpus     string
 	query      string
+	subcPath   string
 	err        error
 	corpusConf *corpus.CorpusSetup
 	status     int
@@ -39,11 +78,56 @@ func (qp queryProps) hasError() bool {
 	return qp.err != nil
 }
 
+// determineSubcPath resolves the `subc` URL argument (an ID
+// of a Manatee subcorpus file) into a full path of the subcorpus.
+// In case the argument is not present, the corpus' default subcorpus
+// (if configured) is used. To search the whole corpus regardless of
+// the default subcorpus, `subc=__full__` can be used. In case no
+// subcorpus applies, an empty string is returned.
+// On error, a recommended HTTP status is returned along with the error.
+func determineSubcPath(ctx *gin.Context, cConf *corpus.CorporaSetup, corpusID string) (string, int, error) {
+	subcID := ctx.Query("subc")
+	if subcID == corpus.FullCorpusSubcID {
+		return "", 0, nil
+	}
+	if subcID == "" {
+		if corpusConf := cConf.Resources.Get(corpusID); corpusConf != nil {
+			subcID = corpusConf.DefaultSubc
+		}
+	}
+	if subcID == "" {
+		return "", 0, nil
+	}
+	if cConf.SubcorporaDir == "" {
+		return "", http.StatusUnprocessableEntity, errors.New("subcorpora are not supported")
+	}
+	if strings.ContainsAny(subcID, "/\\") || strings.Contains(subcID, "..") {
+		return "", http.StatusUnprocessableEntity, fmt.Errorf("invalid subcorpus ID `%s`", subcID)
+	}
+	subcPath := cConf.GetSubcorpusPath(corpusID, subcID)
+	isFile, err := fs.IsFile(subcPath)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("failed to test subcorpus: %w", err)
+	}
+	if !isFile {
+		return "", http.StatusNotFound, fmt.Errorf("subcorpus `%s` not found", subcID)
+	}
+	err = validateSubcorpus(cConf.GetRegistryPath(corpusID), subcPath)
+	if errors.Is(err, corpus.ErrSubcorpusMismatch) {
+		return "", http.StatusUnprocessableEntity, err
+
+	} else if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	return subcPath, 0, nil
+}
+
 // DetermineQueryProps searches for common arguments
 // required for most query+operation actions (freqs, colls, concordance)
 // Those are:
 // * `q` for Manatee CQL query
 // * `subcorpus` for a named ad-hoc subcorpus
+// * `subc` for a Manatee subcorpus (a `.subc` file; a corpus default may apply)
 func DetermineQueryProps(ctx *gin.Context, cConf *corpus.CorporaSetup) queryProps {
 	var ans queryProps
 	ans.corpus = ctx.Param("corpusId")
@@ -62,6 +146,13 @@ func DetermineQueryProps(ctx *gin.Context, cConf *corpus.CorporaSetup) queryProp
 		ans.status = http.StatusBadRequest
 		return ans
 	}
+	if cConf.RejectMatchAllQueries && ctx.Query("allowBroadQuery") != "1" &&
+		corpus.IsMatchAllQuery(userQuery) {
+		ans.err = errors.New(
+			"query matches any token of the corpus (use `allowBroadQuery=1` to run it anyway)")
+		ans.status = http.StatusUnprocessableEntity
+		return ans
+	}
 	subc := ctx.Query("subcorpus")
 	if subc != "" {
 		ttCQL = corpus.SubcorpusToCQL(corpusConf.Subcorpora[subc].TextTypes)
@@ -71,6 +162,500 @@ func DetermineQueryProps(ctx *gin.Context, cConf *corpus.CorporaSetup) queryProp
 			return ans
 		}
 	}
+	ans.subcPath, ans.status, ans.err = determineSubcPath(ctx, cConf, ans.corpus)
+	if ans.err != nil {
+		return ans
+	}
 	ans.query = userQuery + ttCQL
 	return ans
 }
+
+// effectiveFreqLimitHeader reports `flimit` in case it has
+// been raised to the corpus `minFreqLimit`
+const effectiveFreqLimitHeader = "X-Effective-Flimit"
+
+// getFreqLimitArgOrFail reads the `flimit` URL argument. If omitted,
+// the corpus default value is used. Non-positive values are either rejected
+// (in the strict mode; status 422) or clamped to 1. For whole-corpus
+// queries, values below the corpus `minFreqLimit` are raised to it and
+// the effective value is reported via the effectiveFreqLimitHeader.
+// In case of an error, the function writes an error response and returns false.
+func getFreqLimitArgOrFail(
+	ctx *gin.Context,
+	conf *corpus.CorporaSetup,
+	corpusConf *corpus.CorpusSetup,
+) (int, bool) {
+	dflt := corpus.DfltFreqLimit
+	if corpusConf != nil && corpusConf.DefaultFreqLimit > 0 {
+		dflt = corpusConf.DefaultFreqLimit
+	}
+	flimit, ok := unireq.GetURLIntArgOrFail(ctx, "flimit", dflt)
+	if !ok {
+		return 0, false
+	}
+	if flimit < 1 {
+		if conf.StrictFreqLimit {
+			uniresp.RespondWithErrorJSON(
+				ctx,
+				fmt.Errorf("invalid `flimit` value %d (must be >= 1)", flimit),
+				http.StatusUnprocessableEntity,
+			)
+			return 0, false
+		}
+		flimit = 1
+	}
+	if corpusConf != nil && flimit < corpusConf.MinFreqLimit && !isSubcorpusQuery(ctx, corpusConf) {
+		flimit = corpusConf.MinFreqLimit
+		ctx.Writer.Header().Set(effectiveFreqLimitHeader, strconv.Itoa(flimit))
+	}
+	return flimit, true
+}
+
+// isSubcorpusQuery tests whether a request searches a subcorpus (either
+// an MQuery-defined or a Manatee one, including the default one)
+func isSubcorpusQuery(ctx *gin.Context, corpusConf *corpus.CorpusSetup) bool {
+	if ctx.Query("subcorpus") != "" {
+		return true
+	}
+	subcID := ctx.Query("subc")
+	if subcID == "" {
+		subcID = corpusConf.DefaultSubc
+	}
+	return subcID != "" && subcID != corpus.FullCorpusSubcID
+}
+
+// inputErrorReporter is implemented by worker results which
+// are able to tell whether a reported error has been caused
+// by invalid input arguments
+type inputErrorReporter interface {
+	IsInputError() bool
+}
+
+// resultErrorStatus determines an HTTP status for an error reported
+// by a worker within a result
+func resultErrorStatus(res inputErrorReporter) int {
+	if res.IsInputError() {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// queryErrorInfo describes a position of an error
+// within the user query (the `q` argument)
+type queryErrorInfo struct {
+
+	// Offset is a character (i.e. not byte) offset
+	// of the error within the query
+	Offset  int    `json:"offset"`
+	Message string `json:"message"`
+}
+
+type queryErrorResponse struct {
+	Code       int            `json:"code"`
+	Error      string         `json:"error"`
+	QueryError queryErrorInfo `json:"queryError"`
+}
+
+// noUserQuery is used as a position of the user query in case
+// a query processed by a worker does not contain the user query at all
+const noUserQuery = -1
+
+// findQueryError tries to determine a position of an error within
+// `query` based on an error message. The `queryPos` argument specifies
+// a byte position of `query` within the query actually processed by
+// a worker (MQuery may e.g. prepend a structure to it). In case the message
+// contains no position or the position is out of the query (e.g. within
+// a part added by MQuery), false is returned.
+func findQueryError(query string, queryPos int, msg string) (queryErrorInfo, bool) {
+	pos, ok := mango.ParseQueryErrorPosition(msg)
+	if !ok || query == "" || queryPos < 0 {
+		return queryErrorInfo{}, false
+	}
+	pos -= queryPos
+	if pos < 0 || pos > len(query) {
+		return queryErrorInfo{}, false
+	}
+	return queryErrorInfo{
+		Offset:  utf8.RuneCountInString(query[:pos]),
+		Message: msg,
+	}, true
+}
+
+// writeResultError writes an error reported by a worker within
+// a result. For invalid queries with a known error position, the
+// response contains also the `queryError` object so clients can
+// highlight the position within the `q` argument. The `queryPos`
+// argument is a byte position of the `q` argument within the query
+// processed by the worker (or noUserQuery).
+func writeResultError(ctx *gin.Context, err error, res inputErrorReporter, queryPos int) {
+	status := resultErrorStatus(res)
+	if res.IsInputError() {
+		if qErr, ok := findQueryError(ctx.Query("q"), queryPos, err.Error()); ok {
+			uniresp.WriteCustomJSONErrorResponse(
+				ctx.Writer,
+				queryErrorResponse{Code: status, Error: err.Error(), QueryError: qErr},
+				status,
+			)
+			return
+		}
+	}
+	uniresp.WriteJSONErrorResponse(ctx.Writer, uniresp.NewActionErrorFrom(err), status)
+}
+
+// chunkErrors collects errors of concurrently processed
+// chunks of a corpus along with respective HTTP statuses
+type chunkErrors struct {
+	errs     []error
+	statuses []int
+	lock     sync.Mutex
+}
+
+func (ce *chunkErrors) add(err error, status int) {
+	ce.lock.Lock()
+	ce.errs = append(ce.errs, err)
+	ce.statuses = append(ce.statuses, status)
+	ce.lock.Unlock()
+}
+
+// first returns the first collected error (if any) and its status
+func (ce *chunkErrors) first() (int, error) {
+	ce.lock.Lock()
+	defer ce.lock.Unlock()
+	if len(ce.errs) == 0 {
+		return 0, nil
+	}
+	return ce.statuses[0], ce.errs[0]
+}
+
+var structNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// getExcludedStructsOrFail reads the `excludeStruct` URL argument(s)
+// specifying structures (e.g. `note`) whose tokens should not be
+// searched. In case the corpus has its structural attributes configured,
+// the structures must be among them. In case of an invalid value,
+// the function writes an error response and returns false.
+func getExcludedStructsOrFail(ctx *gin.Context, corpusConf *corpus.CorpusSetup) ([]string, bool) {
+	structs := ctx.QueryArray("excludeStruct")
+	for _, strct := range structs {
+		if !structNameRegexp.MatchString(strct) {
+			uniresp.RespondWithErrorJSON(
+				ctx,
+				fmt.Errorf("invalid structure name `%s`", strct),
+				http.StatusUnprocessableEntity,
+			)
+			return nil, false
+		}
+		if len(corpusConf.StructAttrs) > 0 && !corpusHasStruct(corpusConf, strct) {
+			uniresp.RespondWithErrorJSON(
+				ctx,
+				fmt.Errorf("unknown structure `%s`", strct),
+				http.StatusUnprocessableEntity,
+			)
+			return nil, false
+		}
+	}
+	return structs, true
+}
+
+// validateAttrOrFail tests whether a positional attribute or
+// a structural attribute (in the `struct.attr` form) exists in a corpus
+// so requests with unknown attributes are rejected before Manatee
+// fails with a less understandable error. In case of an invalid
+// (or unverifiable) attribute, the function writes an error response
+// and returns false.
+func validateAttrOrFail(ctx *gin.Context, corpusPath, attr string) bool {
+	var exists bool
+	var err error
+	if strings.Contains(attr, ".") {
+		exists, err = hasStructAttr(corpusPath, attr)
+
+	} else {
+		exists, err = hasPosAttr(corpusPath, attr)
+	}
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return false
+	}
+	if !exists {
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("unknown attribute `%s`", attr), http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
+
+// validateFcritAttrsOrFail tests all the attributes used in a frequency
+// criterion (e.g. `lemma/e 0~0>0 tag 0`). See validateAttrOrFail.
+func validateFcritAttrsOrFail(ctx *gin.Context, corpusPath, fcrit string) bool {
+	items := strings.Fields(fcrit)
+	for i := 0; i < len(items); i += 2 {
+		attr := strings.SplitN(items[i], "/", 2)[0]
+		if !validateAttrOrFail(ctx, corpusPath, attr) {
+			return false
+		}
+	}
+	return true
+}
+
+// getFreqCritOrFail determines a frequency criterion based on one of
+// the `fcrit`, `attr` or `capture` URL arguments. The `attr` argument
+// specifies an attribute of the whole KWIC (e.g. `word` or `tag`).
+// The `capture` arguments specify labels of query tokens (e.g. `1`
+// for `1:[tag="N.*"]`) and produce a multi-level criterion over
+// the respective values (of the `captureAttr` attribute, `lemma` by default).
+// In case of invalid arguments, the function writes an error response
+// and returns false.
+func getFreqCritOrFail(ctx *gin.Context, query string) (string, bool) {
+	captures := ctx.QueryArray("capture")
+	attr := ctx.Query("attr")
+	numSpecified := 0
+	for _, specified := range []bool{ctx.Query("fcrit") != "", attr != "", len(captures) > 0} {
+		if specified {
+			numSpecified++
+		}
+	}
+	if numSpecified > 1 {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			errors.New("arguments `fcrit`, `attr` and `capture` cannot be combined"),
+			http.StatusBadRequest,
+		)
+		return "", false
+	}
+	if attr != "" {
+		return fmt.Sprintf(freqAttrCritTpl, attr), true
+	}
+	if len(captures) == 0 {
+		return ctx.DefaultQuery("fcrit", defaultFreqCrit), true
+	}
+	attr = ctx.DefaultQuery("captureAttr", CollDefaultAttr)
+	labels := corpus.QueryLabels(query)
+	crit := make([]string, len(captures))
+	for i, label := range captures {
+		if !collections.SliceContains(labels, label) {
+			uniresp.RespondWithErrorJSON(
+				ctx,
+				fmt.Errorf("label `%s` not found in the query", label),
+				http.StatusUnprocessableEntity,
+			)
+			return "", false
+		}
+		crit[i] = fmt.Sprintf("%s %s:0", attr, label)
+	}
+	return strings.Join(crit, " "), true
+}
+
+func corpusHasStruct(corpusConf *corpus.CorpusSetup, strct string) bool {
+	for _, sa := range corpusConf.StructAttrs {
+		if strings.SplitN(sa.Name, ".", 2)[0] == strct {
+			return true
+		}
+	}
+	return false
+}
+
+// getFreqNormBasisOrFail reads the `norm` URL argument specifying
+// what relative frequencies are calculated against. Supported values
+// are `search` (default), `corpus` and `struct:[structure]`.
+// In case of an invalid value, the function writes an error response
+// and returns false.
+func getFreqNormBasisOrFail(ctx *gin.Context, corpusConf *corpus.CorpusSetup) (string, bool) {
+	norm := ctx.DefaultQuery("norm", rdb.NormBasisSearch)
+	switch {
+	case norm == rdb.NormBasisSearch || norm == rdb.NormBasisCorpus || norm == rdb.NormBasisItem:
+		return norm, true
+	case strings.HasPrefix(norm, rdb.NormBasisStructPrefix):
+		strct := strings.TrimPrefix(norm, rdb.NormBasisStructPrefix)
+		if !structNameRegexp.MatchString(strct) {
+			uniresp.RespondWithErrorJSON(
+				ctx,
+				fmt.Errorf("invalid structure name `%s`", strct),
+				http.StatusUnprocessableEntity,
+			)
+			return "", false
+		}
+		if len(corpusConf.StructAttrs) > 0 && !corpusHasStruct(corpusConf, strct) {
+			uniresp.RespondWithErrorJSON(
+				ctx,
+				fmt.Errorf("unknown structure `%s`", strct),
+				http.StatusUnprocessableEntity,
+			)
+			return "", false
+		}
+		return norm, true
+	}
+	uniresp.RespondWithErrorJSON(
+		ctx,
+		fmt.Errorf(
+			"invalid `norm` value `%s` (allowed: %s, %s, %s, %s[structure])",
+			norm, rdb.NormBasisSearch, rdb.NormBasisCorpus, rdb.NormBasisItem, rdb.NormBasisStructPrefix,
+		),
+		http.StatusUnprocessableEntity,
+	)
+	return "", false
+}
+
+// getConcRelativeFreqsArgOrFail reads the `relativeTo` URL argument
+// and returns true if item frequencies should be (also) expressed
+// as percentages of the concordance size. In case of an invalid value,
+// the function writes an error response and returns false as the second
+// value.
+func getConcRelativeFreqsArgOrFail(ctx *gin.Context) (bool, bool) {
+	switch v := ctx.DefaultQuery("relativeTo", "norm"); v {
+	case "norm":
+		return false, true
+	case "conc":
+		return true, true
+	default:
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid `relativeTo` value `%s` (allowed: norm, conc)", v),
+			http.StatusUnprocessableEntity,
+		)
+		return false, false
+	}
+}
+
+// attachDrillDownQueries fills in queries matching occurrences
+// of individual frequency items (see corpus.DrillDownQuery).
+// Items a query cannot be created for are left without it.
+func attachDrillDownQueries(res *results.FreqDistrib, query, fcrit string) {
+	for _, item := range res.Freqs {
+		if q, ok := corpus.DrillDownQuery(query, fcrit, item.Word); ok {
+			item.Query = q
+		}
+	}
+}
+
+// matchStructQueryOrFail applies the `matchStruct` URL argument (if present)
+// to a query. The argument specifies a structure (e.g. `s`) whose instances
+// containing the query matches are counted instead of the matches themselves.
+// Combined with an attribute of an enclosing structure (e.g. `doc.genre`), this
+// allows e.g. counting sentences grouped by genres. Along with the query,
+// a byte position of the original query within it is returned. In case of
+// an invalid structure, an error response is written and false is returned
+// as the third value.
+func matchStructQueryOrFail(ctx *gin.Context, corpusPath, query string) (string, int, bool) {
+	strct := ctx.Query("matchStruct")
+	if strct == "" {
+		return query, 0, true
+	}
+	if !structNameRegexp.MatchString(strct) {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid structure name `%s`", strct),
+			http.StatusUnprocessableEntity,
+		)
+		return "", 0, false
+	}
+	exists, err := hasStruct(corpusPath, strct)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return "", 0, false
+	}
+	if !exists {
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("unknown structure `%s`", strct), http.StatusUnprocessableEntity)
+		return "", 0, false
+	}
+	// possible `within` parts of the query (e.g. the ones added by
+	// a subcorpus) are applied to the whole structures
+	prefix := fmt.Sprintf("<%s /> containing ", strct)
+	return prefix + query, len(prefix), true
+}
+
+// excludeStructsFromQuery modifies a CQL query so that it does
+// not match anything within the provided structures.
+func excludeStructsFromQuery(query string, structs []string) string {
+	for _, strct := range structs {
+		query = fmt.Sprintf("%s !within <%s />", query, strct)
+	}
+	return query
+}
+
+const (
+	freqSortByFreq  = "freq"
+	freqSortByValue = "value"
+	sortDirAsc      = "asc"
+	sortDirDesc     = "desc"
+)
+
+// freqSorting specifies the order of a frequency distribution
+type freqSorting struct {
+	byValue bool
+	desc    bool
+}
+
+// getFreqSortingOrFail reads the `sort` (`freq` or `value`) and `sortDir`
+// (`asc` or `desc`) URL arguments. By default, items are sorted by
+// frequency in descending order while values are sorted in ascending
+// order. In case of an invalid argument, the function writes an error
+// response and returns false.
+func getFreqSortingOrFail(ctx *gin.Context) (freqSorting, bool) {
+	var ans freqSorting
+	switch ctx.DefaultQuery("sort", freqSortByFreq) {
+	case freqSortByFreq:
+		ans.desc = true
+	case freqSortByValue:
+		ans.byValue = true
+	default:
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid `sort` value `%s` (must be `%s` or `%s`)",
+				ctx.Query("sort"), freqSortByFreq, freqSortByValue),
+			http.StatusUnprocessableEntity,
+		)
+		return ans, false
+	}
+	switch ctx.Query("sortDir") {
+	case "":
+	case sortDirAsc:
+		ans.desc = false
+	case sortDirDesc:
+		ans.desc = true
+	default:
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid `sortDir` value `%s` (must be `%s` or `%s`)",
+				ctx.Query("sortDir"), sortDirAsc, sortDirDesc),
+			http.StatusUnprocessableEntity,
+		)
+		return ans, false
+	}
+	return ans, true
+}
+
+// corpusCollator creates a collator based on the `LOCALE` of a corpus
+// (e.g. `cs_CZ.UTF-8`). In case the locale is not set or cannot be parsed,
+// a language-independent collator is returned.
+func corpusCollator(corpusPath string) *collate.Collator {
+	tag := language.Und
+	locale, err := getCorpusConf(corpusPath, "LOCALE")
+	if err != nil {
+		log.Warn().Err(err).Str("corpus", corpusPath).Msg("failed to get corpus locale")
+
+	} else if locale != "" {
+		locale = strings.ReplaceAll(strings.SplitN(locale, ".", 2)[0], "_", "-")
+		if t, err := language.Parse(locale); err == nil {
+			tag = t
+		}
+	}
+	return collate.New(tag)
+}
+
+// sortFreqs sorts the items of a frequency distribution. Values
+// are compared according to the locale of the corpus.
+func sortFreqs(freqs results.FreqDistribItemList, corpusPath string, sorting freqSorting) {
+	if sorting.byValue {
+		freqs.SortByValue(corpusCollator(corpusPath), sorting.desc)
+		return
+	}
+	freqs.SortByFreq()
+	if !sorting.desc {
+		for i, j := 0, len(freqs)-1; i < j; i, j = i+1, j-1 {
+			freqs[i], freqs[j] = freqs[j], freqs[i]
+		}
+	}
+}
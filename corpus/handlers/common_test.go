@@ -0,0 +1,501 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"mquery/corpus"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/czcorpus/cnc-gokit/collections"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePublisher replaces rdb.Adapter in tests. It records all
+// the published queries (and corpora invalidations) and answers
+// the queries with prepared results (by function name) or via
+// `respond` (if set). Functions listed in `pubErrors` fail already
+// when published.
+type fakePublisher struct {
+	results     map[string]results.SerializableResult
+	respond     func(query rdb.Query) results.SerializableResult
+	pubErrors   map[string]error
+	queries     []rdb.Query
+	jobKeys     map[string]bool
+	invalidated []string
+	lock        sync.Mutex
+}
+
+func (fp *fakePublisher) PublishQueryCtx(
+	ctx context.Context, query rdb.Query,
+) (<-chan *rdb.WorkerResult, error) {
+	fp.lock.Lock()
+	defer fp.lock.Unlock()
+	fp.queries = append(fp.queries, query)
+	if err := fp.pubErrors[query.Func]; err != nil {
+		return nil, err
+	}
+	ans := make(chan *rdb.WorkerResult, 1)
+	result := fp.results[query.Func]
+	if fp.respond != nil {
+		result = fp.respond(query)
+	}
+	res, err := rdb.CreateWorkerResult(result)
+	if err != nil {
+		return nil, err
+	}
+	ans <- res
+	return ans, nil
+}
+
+// PublishQuery allows the publisher to be used also
+// as a corpus.QueryHandler (e.g. by infoload.Manatee)
+func (fp *fakePublisher) PublishQuery(query rdb.Query) (<-chan *rdb.WorkerResult, error) {
+	return fp.PublishQueryCtx(context.Background(), query)
+}
+
+func (fp *fakePublisher) AcquireJobKey(key string) (bool, error) {
+	fp.lock.Lock()
+	defer fp.lock.Unlock()
+	if fp.jobKeys == nil {
+		fp.jobKeys = make(map[string]bool)
+	}
+	if fp.jobKeys[key] {
+		return false, nil
+	}
+	fp.jobKeys[key] = true
+	return true, nil
+}
+
+func (fp *fakePublisher) ReleaseJobKey(key string) error {
+	fp.lock.Lock()
+	defer fp.lock.Unlock()
+	delete(fp.jobKeys, key)
+	return nil
+}
+
+func (fp *fakePublisher) PublishCorpusInvalidation(corpusPath string) error {
+	fp.lock.Lock()
+	defer fp.lock.Unlock()
+	fp.invalidated = append(fp.invalidated, corpusPath)
+	return nil
+}
+
+// publishedArgs decodes arguments of the i-th published query
+func (fp *fakePublisher) publishedArgs(t *testing.T, i int, args any) {
+	fp.lock.Lock()
+	defer fp.lock.Unlock()
+	if !assert.Greater(t, len(fp.queries), i) {
+		t.FailNow()
+	}
+	assert.NoError(t, json.Unmarshal(fp.queries[i].Args, args))
+}
+
+// newTestConf creates a configuration with a single corpus
+// `corp1` along with (empty) registry and subcorpora directories
+func newTestConf(t *testing.T) *corpus.CorporaSetup {
+	tmp := t.TempDir()
+	conf := &corpus.CorporaSetup{
+		RegistryDir:   filepath.Join(tmp, "registry"),
+		SubcorporaDir: filepath.Join(tmp, "subcorpora"),
+		MaxFreqItems:  100,
+		Resources: corpus.Resources{
+			{
+				ID: "corp1",
+				PosAttrs: corpus.PosAttrList{
+					{Name: "word"}, {Name: "lemma"}, {Name: "tag"},
+				},
+			},
+		},
+	}
+	assert.NoError(t, os.MkdirAll(conf.RegistryDir, 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(conf.SubcorporaDir, "corp1"), 0755))
+	return conf
+}
+
+// writeTestSubc creates a `.subc` file with the provided
+// position ranges for the corpus `corp1`
+func writeTestSubc(t *testing.T, conf *corpus.CorporaSetup, subcID string, ranges ...[2]uint64) {
+	data := make([]byte, 0, 16*len(ranges))
+	for _, rng := range ranges {
+		for _, v := range rng {
+			for i := 0; i < 8; i++ {
+				data = append(data, byte(v>>(8*i)))
+			}
+		}
+	}
+	assert.NoError(t, os.WriteFile(conf.GetSubcorpusPath("corp1", subcID), data, 0644))
+}
+
+// stubCorpusSize makes all the corpora `size` tokens long for
+// the duration of a test (this applies also to validation of subcorpora).
+// It returns a counter of the corpus size calls.
+func stubCorpusSize(t *testing.T, size int64) *atomic.Int32 {
+	origSize, origValidate, origForget := getCorpusSize, validateSubcorpus, forgetValidatedSubcorpora
+	var numCalls atomic.Int32
+	getCorpusSize = func(corpusPath string) (int64, error) {
+		numCalls.Add(1)
+		return size, nil
+	}
+	validations := corpus.NewSubcValidations(getCorpusSize)
+	validateSubcorpus = validations.Validate
+	forgetValidatedSubcorpora = validations.RemoveCorpus
+	t.Cleanup(func() {
+		getCorpusSize, validateSubcorpus, forgetValidatedSubcorpora = origSize, origValidate, origForget
+	})
+	return &numCalls
+}
+
+// newTestContext creates a request context for a handler
+// with the `corpusId` path parameter set to `corp1`
+func newTestContext(url string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(rec)
+	ctx.Request = httptest.NewRequest(http.MethodGet, url, nil)
+	ctx.Params = gin.Params{{Key: "corpusId", Value: "corp1"}}
+	return ctx, rec
+}
+
+func TestDetermineQueryPropsSubc(t *testing.T) {
+	conf := newTestConf(t)
+	stubCorpusSize(t, 1000)
+	writeTestSubc(t, conf, "sub1")
+
+	ctx, _ := newTestContext("/collocations/corp1?q=[lemma=\"pes\"]")
+	props := DetermineQueryProps(ctx, conf)
+	assert.NoError(t, props.err)
+	assert.Equal(t, "", props.subcPath)
+
+	ctx, _ = newTestContext("/collocations/corp1?q=[lemma=\"pes\"]&subc=sub1")
+	props = DetermineQueryProps(ctx, conf)
+	assert.NoError(t, props.err)
+	assert.Equal(t, conf.GetSubcorpusPath("corp1", "sub1"), props.subcPath)
+
+	ctx, _ = newTestContext("/collocations/corp1?q=[lemma=\"pes\"]&subc=foo")
+	props = DetermineQueryProps(ctx, conf)
+	assert.Error(t, props.err)
+	assert.Equal(t, http.StatusNotFound, props.status)
+
+	ctx, _ = newTestContext("/collocations/corp1?q=[lemma=\"pes\"]&subc=../corp2/sub1")
+	props = DetermineQueryProps(ctx, conf)
+	assert.Error(t, props.err)
+	assert.Equal(t, http.StatusUnprocessableEntity, props.status)
+}
+
+func TestDetermineQueryPropsSubcMismatch(t *testing.T) {
+	conf := newTestConf(t)
+	// the test corpus is too small for the range
+	stubCorpusSize(t, 5)
+	writeTestSubc(t, conf, "sub1", [2]uint64{0, 10})
+
+	ctx, _ := newTestContext("/collocations/corp1?q=[lemma=\"pes\"]&subc=sub1")
+	props := DetermineQueryProps(ctx, conf)
+	assert.ErrorIs(t, props.err, corpus.ErrSubcorpusMismatch)
+	assert.Equal(t, http.StatusUnprocessableEntity, props.status)
+}
+
+func TestCollocationsWholeCorpusVsSubcorpus(t *testing.T) {
+	conf := newTestConf(t)
+	stubCorpusSize(t, 1000)
+	writeTestSubc(t, conf, "sub1")
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"collocations": &results.Collocations{},
+		},
+	}
+	actions := &Actions{conf: conf, radapter: pub}
+
+	ctx, rec := newTestContext("/collocations/corp1?q=[lemma=\"pes\"]")
+	actions.Collocations(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ctx, rec = newTestContext("/collocations/corp1?q=[lemma=\"pes\"]&subc=sub1")
+	actions.Collocations(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var whole, subc rdb.CollocationsArgs
+	pub.publishedArgs(t, 0, &whole)
+	pub.publishedArgs(t, 1, &subc)
+	assert.Equal(t, "", whole.SubcPath)
+	assert.Equal(t, conf.GetSubcorpusPath("corp1", "sub1"), subc.SubcPath)
+	whole.SubcPath = subc.SubcPath
+	assert.Equal(t, whole, subc)
+}
+
+func TestGetFreqLimitArg(t *testing.T) {
+	conf := newTestConf(t)
+	corpConf := conf.Resources.Get("corp1")
+
+	ctx, _ := newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&flimit=5")
+	flimit, ok := getFreqLimitArgOrFail(ctx, conf, corpConf)
+	assert.True(t, ok)
+	assert.Equal(t, 5, flimit)
+
+	ctx, _ = newTestContext("/freqs/corp1?q=[lemma=\"pes\"]")
+	flimit, ok = getFreqLimitArgOrFail(ctx, conf, corpConf)
+	assert.True(t, ok)
+	assert.Equal(t, corpus.DfltFreqLimit, flimit)
+
+	corpConf.DefaultFreqLimit = 3
+	ctx, _ = newTestContext("/freqs/corp1?q=[lemma=\"pes\"]")
+	flimit, ok = getFreqLimitArgOrFail(ctx, conf, corpConf)
+	assert.True(t, ok)
+	assert.Equal(t, 3, flimit)
+}
+
+func TestGetFreqLimitArgNonPositive(t *testing.T) {
+	conf := newTestConf(t)
+	corpConf := conf.Resources.Get("corp1")
+
+	for _, v := range []string{"0", "-3"} {
+		ctx, _ := newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&flimit=" + v)
+		flimit, ok := getFreqLimitArgOrFail(ctx, conf, corpConf)
+		assert.True(t, ok)
+		assert.Equal(t, 1, flimit)
+	}
+
+	conf.StrictFreqLimit = true
+	for _, v := range []string{"0", "-3"} {
+		ctx, rec := newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&flimit=" + v)
+		_, ok := getFreqLimitArgOrFail(ctx, conf, corpConf)
+		assert.False(t, ok)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	}
+	ctx, _ := newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&flimit=2")
+	flimit, ok := getFreqLimitArgOrFail(ctx, conf, corpConf)
+	assert.True(t, ok)
+	assert.Equal(t, 2, flimit)
+}
+
+func TestDetermineQueryPropsDefaultSubc(t *testing.T) {
+	conf := newTestConf(t)
+	stubCorpusSize(t, 1000)
+	writeTestSubc(t, conf, "nodup")
+	writeTestSubc(t, conf, "sub1")
+	conf.Resources.Get("corp1").DefaultSubc = "nodup"
+
+	ctx, _ := newTestContext("/freqs/corp1?q=[lemma=\"pes\"]")
+	props := DetermineQueryProps(ctx, conf)
+	assert.NoError(t, props.err)
+	assert.Equal(t, conf.GetSubcorpusPath("corp1", "nodup"), props.subcPath)
+
+	ctx, _ = newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&subc=sub1")
+	props = DetermineQueryProps(ctx, conf)
+	assert.NoError(t, props.err)
+	assert.Equal(t, conf.GetSubcorpusPath("corp1", "sub1"), props.subcPath)
+
+	ctx, _ = newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&subc=" + corpus.FullCorpusSubcID)
+	props = DetermineQueryProps(ctx, conf)
+	assert.NoError(t, props.err)
+	assert.Equal(t, "", props.subcPath)
+}
+
+// stubAttrChecks makes all the attributes (and structures)
+// valid for the duration of a test
+func stubAttrChecks(t *testing.T) {
+	origPosAttr, origStructAttr, origStruct := hasPosAttr, hasStructAttr, hasStruct
+	alwaysTrue := func(corpusPath, attr string) (bool, error) { return true, nil }
+	hasPosAttr, hasStructAttr, hasStruct = alwaysTrue, alwaysTrue, alwaysTrue
+	t.Cleanup(func() {
+		hasPosAttr, hasStructAttr, hasStruct = origPosAttr, origStructAttr, origStruct
+	})
+}
+
+// stubKnownAttrs replaces the attribute checks so that only
+// the provided (positional or structural) attributes and
+// structures exist
+func stubKnownAttrs(t *testing.T, attrs ...string) {
+	origPosAttr, origStructAttr, origStruct := hasPosAttr, hasStructAttr, hasStruct
+	isKnown := func(corpusPath, attr string) (bool, error) {
+		return collections.SliceContains(attrs, attr), nil
+	}
+	hasPosAttr, hasStructAttr, hasStruct = isKnown, isKnown, isKnown
+	t.Cleanup(func() {
+		hasPosAttr, hasStructAttr, hasStruct = origPosAttr, origStructAttr, origStruct
+	})
+}
+
+func TestValidateFcritAttrs(t *testing.T) {
+	stubKnownAttrs(t, "word", "lemma", "doc.genre")
+	for _, fcrit := range []string{"lemma/e 0~0>0", "word/i 0 lemma 0", "doc.genre 0"} {
+		ctx, rec := newTestContext("/freqs/corp1")
+		assert.True(t, validateFcritAttrsOrFail(ctx, "/var/registry/corp1", fcrit), fcrit)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	for _, fcrit := range []string{"tag 0", "word 0 tag/i 0", "doc.id 0"} {
+		ctx, rec := newTestContext("/freqs/corp1")
+		assert.False(t, validateFcritAttrsOrFail(ctx, "/var/registry/corp1", fcrit), fcrit)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Contains(t, rec.Body.String(), "unknown attribute")
+	}
+}
+
+func TestDetermineQueryPropsMatchAllGuard(t *testing.T) {
+	conf := newTestConf(t)
+	conf.RejectMatchAllQueries = true
+
+	ctx, _ := newTestContext("/concordance/corp1?q=[]")
+	props := DetermineQueryProps(ctx, conf)
+	assert.Error(t, props.err)
+	assert.Equal(t, http.StatusUnprocessableEntity, props.status)
+
+	ctx, _ = newTestContext("/concordance/corp1?q=[]&allowBroadQuery=1")
+	props = DetermineQueryProps(ctx, conf)
+	assert.NoError(t, props.err)
+
+	ctx, _ = newTestContext("/concordance/corp1?q=[lemma=\"pes\"]")
+	props = DetermineQueryProps(ctx, conf)
+	assert.NoError(t, props.err)
+
+	conf.RejectMatchAllQueries = false
+	ctx, _ = newTestContext("/concordance/corp1?q=[]")
+	props = DetermineQueryProps(ctx, conf)
+	assert.NoError(t, props.err)
+}
+
+func TestGetFreqSorting(t *testing.T) {
+	ctx, _ := newTestContext("/freqs-parallel/corp1?q=[lemma=\"pes\"]")
+	sorting, ok := getFreqSortingOrFail(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, freqSorting{byValue: false, desc: true}, sorting)
+
+	ctx, _ = newTestContext("/freqs-parallel/corp1?q=[lemma=\"pes\"]&sort=value")
+	sorting, ok = getFreqSortingOrFail(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, freqSorting{byValue: true, desc: false}, sorting)
+
+	ctx, _ = newTestContext("/freqs-parallel/corp1?q=[lemma=\"pes\"]&sort=value&sortDir=desc")
+	sorting, ok = getFreqSortingOrFail(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, freqSorting{byValue: true, desc: true}, sorting)
+
+	ctx, _ = newTestContext("/freqs-parallel/corp1?q=[lemma=\"pes\"]&sortDir=asc")
+	sorting, ok = getFreqSortingOrFail(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, freqSorting{byValue: false, desc: false}, sorting)
+
+	for _, args := range []string{"sort=alpha", "sortDir=up"} {
+		ctx, rec := newTestContext("/freqs-parallel/corp1?q=[lemma=\"pes\"]&" + args)
+		_, ok := getFreqSortingOrFail(ctx)
+		assert.False(t, ok)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	}
+}
+
+func TestSortFreqsByValueUsesCorpusLocale(t *testing.T) {
+	locales := map[string]string{"/corpora/cs": "cs_CZ.UTF-8", "/corpora/und": ""}
+	orig := getCorpusConf
+	getCorpusConf = func(corpusPath, prop string) (string, error) {
+		assert.Equal(t, "LOCALE", prop)
+		return locales[corpusPath], nil
+	}
+	t.Cleanup(func() { getCorpusConf = orig })
+
+	words := func(freqs results.FreqDistribItemList) []string {
+		ans := make([]string, len(freqs))
+		for i, item := range freqs {
+			ans[i] = item.Word
+		}
+		return ans
+	}
+	newFreqs := func() results.FreqDistribItemList {
+		return results.FreqDistribItemList{
+			{Word: "hrad", Freq: 10},
+			{Word: "čaj", Freq: 30},
+			{Word: "chata", Freq: 20},
+			{Word: "cesta", Freq: 40},
+		}
+	}
+
+	freqs := newFreqs()
+	sortFreqs(freqs, "/corpora/cs", freqSorting{byValue: true})
+	assert.Equal(t, []string{"cesta", "čaj", "hrad", "chata"}, words(freqs))
+
+	freqs = newFreqs()
+	sortFreqs(freqs, "/corpora/cs", freqSorting{byValue: true, desc: true})
+	assert.Equal(t, []string{"chata", "hrad", "čaj", "cesta"}, words(freqs))
+
+	freqs = newFreqs()
+	sortFreqs(freqs, "/corpora/und", freqSorting{byValue: true})
+	assert.Equal(t, []string{"čaj", "cesta", "chata", "hrad"}, words(freqs))
+}
+
+func TestSortFreqsByFreq(t *testing.T) {
+	freqs := results.FreqDistribItemList{
+		{Word: "a", Freq: 10},
+		{Word: "b", Freq: 30},
+		{Word: "c", Freq: 20},
+	}
+	sortFreqs(freqs, "/corpora/cs", freqSorting{desc: true})
+	assert.Equal(t, []int64{30, 20, 10}, []int64{freqs[0].Freq, freqs[1].Freq, freqs[2].Freq})
+
+	sortFreqs(freqs, "/corpora/cs", freqSorting{})
+	assert.Equal(t, []int64{10, 20, 30}, []int64{freqs[0].Freq, freqs[1].Freq, freqs[2].Freq})
+}
+
+func TestGetFreqLimitArgMinFreqLimit(t *testing.T) {
+	conf := newTestConf(t)
+	corpConf := conf.Resources.Get("corp1")
+	corpConf.MinFreqLimit = 50
+
+	for _, args := range []string{"flimit=1", "flimit=49", "flimit=0", ""} {
+		ctx, rec := newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&" + args)
+		flimit, ok := getFreqLimitArgOrFail(ctx, conf, corpConf)
+		assert.True(t, ok)
+		assert.Equal(t, 50, flimit, args)
+		assert.Equal(t, "50", rec.Header().Get(effectiveFreqLimitHeader), args)
+	}
+
+	ctx, rec := newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&flimit=70")
+	flimit, ok := getFreqLimitArgOrFail(ctx, conf, corpConf)
+	assert.True(t, ok)
+	assert.Equal(t, 70, flimit)
+	assert.Empty(t, rec.Header().Get(effectiveFreqLimitHeader))
+
+	// subcorpus queries may use lower values
+	for _, args := range []string{"subc=sub1", "subcorpus=sub2"} {
+		ctx, rec := newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&flimit=2&" + args)
+		flimit, ok := getFreqLimitArgOrFail(ctx, conf, corpConf)
+		assert.True(t, ok)
+		assert.Equal(t, 2, flimit, args)
+		assert.Empty(t, rec.Header().Get(effectiveFreqLimitHeader), args)
+	}
+
+	// the default subcorpus counts as a subcorpus unless
+	// the whole corpus is requested explicitly
+	corpConf.DefaultSubc = "sub1"
+	ctx, _ = newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&flimit=2")
+	flimit, ok = getFreqLimitArgOrFail(ctx, conf, corpConf)
+	assert.True(t, ok)
+	assert.Equal(t, 2, flimit)
+	ctx, _ = newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&flimit=2&subc=" + corpus.FullCorpusSubcID)
+	flimit, ok = getFreqLimitArgOrFail(ctx, conf, corpConf)
+	assert.True(t, ok)
+	assert.Equal(t, 50, flimit)
+}
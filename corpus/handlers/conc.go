@@ -20,21 +20,45 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"mquery/corpus"
+	"mquery/mango"
 	"mquery/rdb"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/czcorpus/cnc-gokit/collections"
+	"github.com/czcorpus/cnc-gokit/unireq"
 	"github.com/czcorpus/cnc-gokit/uniresp"
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
 )
 
 const (
 	dfltMaxContext = 50
+	dfltDocStruct  = "doc"
+	concFormatJSON = "json"
+	concFormatXML  = "xml"
+
+	// maxContextStructs is the max. number of structures
+	// (sentences) the `contextStructs` argument can request
+	maxContextStructs = 5
+
+	// maxStructContext is a token limit of a context requested via
+	// `contextStructs` which prevents huge lines in case of corpora
+	// with very long (or missing) structures
+	maxStructContext = 500
 )
 
 type ConcArgsBuilder func(conf *corpus.CorpusSetup, q string) rdb.ConcordanceArgs
 
 func (a *Actions) SyntaxConcordance(ctx *gin.Context) {
+	fromLine, ok := concFromLineOrFail(ctx)
+	if !ok {
+		return
+	}
 	a.anyConcordance(
 		ctx,
 		func(conf *corpus.CorpusSetup, q string) rdb.ConcordanceArgs {
@@ -44,44 +68,247 @@ func (a *Actions) SyntaxConcordance(ctx *gin.Context) {
 				Query:             q,
 				Attrs:             conf.SyntaxConcordance.ResultAttrs,
 				ParentIdxAttr:     conf.SyntaxConcordance.ParentAttr,
-				StartLine:         0, // TODO
-				MaxItems:          conf.MaximumRecords,
+				StartLine:         fromLine,
+				MaxItems:          concMaxItems(conf),
 				MaxContext:        dfltMaxContext,
 				ViewContextStruct: conf.ViewContextStruct,
+				HideTokenPosRef:   a.conf.HideTokenPosRef,
 			}
 		},
 	)
 }
 
+// concMaxItems returns the number of concordance lines to be
+// fetched for a corpus. The configured `maximumRecords` value is
+// clamped to mango.MaxRecordsInternalLimit which is the maximum
+// number of lines the concordance function is able to return.
+func concMaxItems(conf *corpus.CorpusSetup) int {
+	if conf.MaximumRecords > mango.MaxRecordsInternalLimit {
+		return mango.MaxRecordsInternalLimit
+	}
+	return conf.MaximumRecords
+}
+
+// concFromLineOrFail obtains the `fromLine` argument specifying
+// the first concordance line to be returned (i.e. a page). In case
+// of an invalid value, the function writes an error response and
+// returns false as the second value.
+func concFromLineOrFail(ctx *gin.Context) (int, bool) {
+	fromLine, ok := unireq.GetURLIntArgOrFail(ctx, "fromLine", 0)
+	if !ok {
+		return 0, false
+	}
+	if fromLine < 0 {
+		uniresp.RespondWithErrorJSON(
+			ctx, errors.New("invalid `fromLine` value (must be >= 0)"), http.StatusUnprocessableEntity)
+		return 0, false
+	}
+	return fromLine, true
+}
+
+// concArgs is a ConcArgsBuilder for a standard concordance
+// (returning the first page of the concordance)
+func (a *Actions) concArgs(conf *corpus.CorpusSetup, q string) rdb.ConcordanceArgs {
+	return rdb.ConcordanceArgs{
+		CorpusPath:        a.conf.GetRegistryPath(conf.ID),
+		Query:             q,
+		Attrs:             conf.PosAttrs.GetIDs(),
+		ParentIdxAttr:     conf.SyntaxConcordance.ParentAttr,
+		MaxItems:          concMaxItems(conf),
+		MaxContext:        dfltMaxContext,
+		ViewContextStruct: conf.ViewContextStruct,
+		HideTokenPosRef:   a.conf.HideTokenPosRef,
+	}
+}
+
 func (a *Actions) Concordance(ctx *gin.Context) {
+	sampleSize, ok := unireq.GetURLIntArgOrFail(ctx, "sample", 0)
+	if !ok {
+		return
+	}
+	if sampleSize < 0 || sampleSize > mango.MaxRecordsInternalLimit {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid `sample` value (must be between 0 and %d)", mango.MaxRecordsInternalLimit),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	seed, ok := unireq.GetURLIntArgOrFail(ctx, "seed", 0)
+	if !ok {
+		return
+	}
+	fromLine, ok := concFromLineOrFail(ctx)
+	if !ok {
+		return
+	}
+	if fromLine > 0 && sampleSize > 0 {
+		uniresp.RespondWithErrorJSON(
+			ctx, errors.New("`fromLine` cannot be combined with `sample`"), http.StatusUnprocessableEntity)
+		return
+	}
+	maxDocs, ok := unireq.GetURLIntArgOrFail(ctx, "maxDocs", 0)
+	if !ok {
+		return
+	}
+	if maxDocs < 0 {
+		uniresp.RespondWithErrorJSON(
+			ctx, errors.New("invalid `maxDocs` value (must be >= 0)"), http.StatusUnprocessableEntity)
+		return
+	}
+	docStruct := ctx.DefaultQuery("docStruct", dfltDocStruct)
+	if maxDocs > 0 {
+		exists, err := hasStruct(a.conf.GetRegistryPath(ctx.Param("corpusId")), docStruct)
+		if err != nil {
+			uniresp.WriteJSONErrorResponse(
+				ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			uniresp.RespondWithErrorJSON(
+				ctx, fmt.Errorf("unknown structure `%s`", docStruct), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	contextStructs, ok := unireq.GetURLIntArgOrFail(ctx, "contextStructs", 0)
+	if !ok {
+		return
+	}
+	if contextStructs < 0 || contextStructs > maxContextStructs {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid `contextStructs` value (must be between 0 and %d)", maxContextStructs),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	boundaryStruct := ctx.Query("markStruct")
+	if boundaryStruct != "" {
+		exists, err := hasStruct(a.conf.GetRegistryPath(ctx.Param("corpusId")), boundaryStruct)
+		if err != nil {
+			uniresp.WriteJSONErrorResponse(
+				ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			uniresp.RespondWithErrorJSON(
+				ctx, fmt.Errorf("unknown structure `%s`", boundaryStruct), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	glossCorpusPath, glossAttr, ok := a.glossArgsOrFail(ctx)
+	if !ok {
+		return
+	}
 	a.anyConcordance(
 		ctx,
 		func(conf *corpus.CorpusSetup, q string) rdb.ConcordanceArgs {
-			return rdb.ConcordanceArgs{
-				CorpusPath:        a.conf.GetRegistryPath(conf.ID),
-				Query:             q,
-				Attrs:             conf.PosAttrs.GetIDs(),
-				ParentIdxAttr:     conf.SyntaxConcordance.ParentAttr,
-				StartLine:         0, // TODO
-				MaxItems:          conf.MaximumRecords,
-				MaxContext:        dfltMaxContext,
-				ViewContextStruct: conf.ViewContextStruct,
+			args := a.concArgs(conf, q)
+			args.StartLine = fromLine
+			args.KWICAttrs = ctx.QueryArray("kwicAttrs")
+			args.ContextAttrs = ctx.QueryArray("contextAttrs")
+			if sampleSize > 0 {
+				args.SampleSize = sampleSize
+				args.SampleSeed = uint32(seed)
+				args.MaxItems = sampleSize
+			}
+			args.MaxDocs = maxDocs
+			args.DocStruct = docStruct
+			args.GlossCorpusPath = glossCorpusPath
+			args.GlossAttr = glossAttr
+			if contextStructs > 0 {
+				args.ContextStructs = contextStructs
+				args.MaxContext = maxStructContext
 			}
+			args.BoundaryStruct = boundaryStruct
+			return args
 		},
 	)
 }
 
+// glossArgsOrFail validates the `gloss` (an aligned corpus) and
+// `glossAttr` arguments and returns a registry path of the aligned
+// corpus along with the attribute. In case no gloss is requested,
+// an empty path is returned. In case of invalid arguments, the
+// function writes an error response and returns false as
+// the third value.
+func (a *Actions) glossArgsOrFail(ctx *gin.Context) (string, string, bool) {
+	glossCorpus := ctx.Query("gloss")
+	if glossCorpus == "" {
+		return "", "", true
+	}
+	if a.conf.Resources.Get(glossCorpus) == nil {
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("gloss corpus %s not found", glossCorpus), http.StatusNotFound)
+		return "", "", false
+	}
+	aligned, err := isAlignedWith(a.conf.GetRegistryPath(ctx.Param("corpusId")), glossCorpus)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return "", "", false
+	}
+	if !aligned {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("corpus %s is not aligned with %s", ctx.Param("corpusId"), glossCorpus),
+			http.StatusUnprocessableEntity,
+		)
+		return "", "", false
+	}
+	glossCorpusPath := a.conf.GetRegistryPath(glossCorpus)
+	glossAttr := ctx.DefaultQuery("glossAttr", dfltWordListAttr)
+	exists, err := hasPosAttr(glossCorpusPath, glossAttr)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return "", "", false
+	}
+	if !exists {
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("unknown attribute `%s`", glossAttr), http.StatusUnprocessableEntity)
+		return "", "", false
+	}
+	return glossCorpusPath, glossAttr, true
+}
+
+// validatePositionAttrs tests whether all the KWIC and context
+// attributes are among the attributes fetched for the concordance
+func validatePositionAttrs(args rdb.ConcordanceArgs) error {
+	for _, attrs := range [][]string{args.KWICAttrs, args.ContextAttrs} {
+		for _, attr := range attrs {
+			if !collections.SliceContains(args.Attrs, attr) {
+				return fmt.Errorf("unknown attribute `%s`", attr)
+			}
+		}
+	}
+	return nil
+}
+
 func (a *Actions) anyConcordance(ctx *gin.Context, argsBuilder ConcArgsBuilder) {
+	t0 := time.Now()
 	queryProps := DetermineQueryProps(ctx, a.conf)
 	if queryProps.hasError() {
 		uniresp.RespondWithErrorJSON(ctx, queryProps.err, queryProps.status)
 		return
 	}
+	wantsXML, ok := concOutputXMLOrFail(ctx)
+	if !ok {
+		return
+	}
 
-	args, err := json.Marshal(argsBuilder(
-		queryProps.corpusConf,
-		queryProps.query,
-	))
+	concArgs := argsBuilder(queryProps.corpusConf, queryProps.query)
+	concArgs.SubcPath = queryProps.subcPath
+	if err := validatePositionAttrs(concArgs); err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusUnprocessableEntity)
+		return
+	}
+	if err := mango.ValidateConcordanceArgs(
+		concArgs.StartLine, concArgs.MaxItems, concArgs.MaxContext); err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusUnprocessableEntity)
+		return
+	}
+	args, err := json.Marshal(concArgs)
 	if err != nil {
 		uniresp.WriteJSONErrorResponse(
 			ctx.Writer,
@@ -90,7 +317,7 @@ func (a *Actions) anyConcordance(ctx *gin.Context, argsBuilder ConcArgsBuilder)
 		)
 		return
 	}
-	wait, err := a.radapter.PublishQuery(rdb.Query{
+	wait, err := a.radapter.PublishQueryCtx(ctx.Request.Context(), rdb.Query{
 		Func: "concordance",
 		Args: args,
 	})
@@ -113,12 +340,37 @@ func (a *Actions) anyConcordance(ctx *gin.Context, argsBuilder ConcArgsBuilder)
 		return
 	}
 	if err := result.Err(); err != nil {
-		uniresp.WriteJSONErrorResponse(
-			ctx.Writer,
-			uniresp.NewActionErrorFrom(err),
-			http.StatusInternalServerError,
-		)
+		writeResultError(ctx, err, &result, 0)
+		return
+	}
+	if wantsXML {
+		ctx.Writer.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		if err := result.WriteXML(ctx.Writer, queryProps.corpus); err != nil {
+			log.Error().Err(err).Msg("failed to write concordance XML")
+		}
 		return
 	}
-	uniresp.WriteJSONResponse(ctx.Writer, &result)
+	a.writeResult(ctx, t0, queryProps, nil, result.Cached, &result)
+}
+
+// concOutputXMLOrFail determines whether a client requested XML
+// output (via `format=xml` or the `Accept` header). In case of
+// an unsupported format, the function writes an error response
+// and returns false as the second value.
+func concOutputXMLOrFail(ctx *gin.Context) (bool, bool) {
+	switch ctx.Query("format") {
+	case concFormatXML:
+		return true, true
+	case concFormatJSON:
+		return false, true
+	case "":
+		return strings.Contains(ctx.GetHeader("Accept"), "application/xml"), true
+	default:
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("unsupported format `%s`", ctx.Query("format")),
+			http.StatusUnprocessableEntity,
+		)
+		return false, false
+	}
 }
@@ -0,0 +1,428 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mquery/corpus"
+	"mquery/mango"
+	"mquery/merror"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcordanceSubcorpusScope(t *testing.T) {
+	conf := newTestConf(t)
+	stubCorpusSize(t, 1000)
+	writeTestSubc(t, conf, "sub1")
+	pub := &fakePublisher{
+		respond: func(query rdb.Query) results.SerializableResult {
+			var args rdb.ConcordanceArgs
+			assert.NoError(t, json.Unmarshal(query.Args, &args))
+			if args.SubcPath != "" {
+				return &results.Concordance{ConcSize: 3}
+			}
+			return &results.Concordance{ConcSize: 10}
+		},
+	}
+	actions := &Actions{conf: conf, radapter: pub}
+
+	concSize := func(url string) int {
+		ctx, rec := newTestContext(url)
+		actions.Concordance(ctx)
+		if !assert.Equal(t, http.StatusOK, rec.Code) {
+			return -1
+		}
+		var ans results.Concordance
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+		return ans.ConcSize
+	}
+	whole := concSize("/concordance/corp1?q=[lemma=\"pes\"]")
+	subc := concSize("/concordance/corp1?q=[lemma=\"pes\"]&subc=sub1")
+	assert.Equal(t, 10, whole)
+	assert.Equal(t, 3, subc)
+
+	var wholeArgs, subcArgs rdb.ConcordanceArgs
+	pub.publishedArgs(t, 0, &wholeArgs)
+	pub.publishedArgs(t, 1, &subcArgs)
+	assert.Equal(t, "", wholeArgs.SubcPath)
+	assert.Equal(t, conf.GetSubcorpusPath("corp1", "sub1"), subcArgs.SubcPath)
+	assert.Equal(t, wholeArgs.Query, subcArgs.Query)
+
+	ctx, rec := newTestContext("/concordance/corp1?q=[lemma=\"pes\"]&subc=missing")
+	actions.Concordance(ctx)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Len(t, pub.queries, 2)
+}
+
+func TestConcordanceFromLine(t *testing.T) {
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"concordance": &results.Concordance{ConcSize: 1000},
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+
+	ctx, rec := newTestContext("/concordance/corp1?q=[lemma=\"pes\"]")
+	actions.Concordance(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ctx, rec = newTestContext("/concordance/corp1?q=[lemma=\"pes\"]&fromLine=50")
+	actions.Concordance(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var page1, page2 rdb.ConcordanceArgs
+	pub.publishedArgs(t, 0, &page1)
+	pub.publishedArgs(t, 1, &page2)
+	assert.Equal(t, 0, page1.StartLine)
+	assert.Equal(t, 50, page2.StartLine)
+
+	for _, args := range []string{"fromLine=-1", "fromLine=foo", "fromLine=10&sample=5"} {
+		ctx, rec = newTestContext("/concordance/corp1?q=[lemma=\"pes\"]&" + args)
+		actions.Concordance(ctx)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, args)
+	}
+	assert.Len(t, pub.queries, 2)
+}
+
+func TestConcordanceMaxItemsClamped(t *testing.T) {
+	conf := newTestConf(t)
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"concordance": &results.Concordance{},
+		},
+	}
+	actions := &Actions{conf: conf, radapter: pub}
+	for i, maxRecords := range []int{50, mango.MaxRecordsInternalLimit, 5 * mango.MaxRecordsInternalLimit} {
+		conf.Resources.Get("corp1").MaximumRecords = maxRecords
+		ctx, rec := newTestContext("/concordance/corp1?q=[lemma=\"pes\"]")
+		actions.Concordance(ctx)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var args rdb.ConcordanceArgs
+		pub.publishedArgs(t, i, &args)
+		if maxRecords > mango.MaxRecordsInternalLimit {
+			assert.Equal(t, mango.MaxRecordsInternalLimit, args.MaxItems)
+
+		} else {
+			assert.Equal(t, maxRecords, args.MaxItems)
+		}
+	}
+}
+
+func TestConcordanceSampleArgs(t *testing.T) {
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"concordance": &results.Concordance{ConcSize: 1000},
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	for i := 0; i < 2; i++ {
+		ctx, rec := newTestContext("/concordance/corp1?q=[lemma=\"pes\"]&sample=20&seed=7")
+		actions.Concordance(ctx)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	var first, second rdb.ConcordanceArgs
+	pub.publishedArgs(t, 0, &first)
+	pub.publishedArgs(t, 1, &second)
+	assert.Equal(t, 20, first.SampleSize)
+	assert.Equal(t, uint32(7), first.SampleSeed)
+	assert.Equal(t, 20, first.MaxItems)
+	// the same seed must produce the same job (and thus the same sample)
+	assert.Equal(t, first, second)
+
+	tooLarge := fmt.Sprintf("sample=%d", mango.MaxRecordsInternalLimit+1)
+	for _, args := range []string{"sample=-1", tooLarge, "sample=x", "sample=5&seed=x"} {
+		ctx, rec := newTestContext("/concordance/corp1?q=[lemma=\"pes\"]&" + args)
+		actions.Concordance(ctx)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, args)
+	}
+	assert.Len(t, pub.queries, 2)
+}
+
+func TestConcordanceContextStructs(t *testing.T) {
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"concordance": &results.Concordance{},
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	ctx, rec := newTestContext("/concordance/corp1?q=[lemma=\"pes\"]&contextStructs=2")
+	actions.Concordance(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var args rdb.ConcordanceArgs
+	pub.publishedArgs(t, 0, &args)
+	assert.Equal(t, 2, args.ContextStructs)
+	assert.Equal(t, maxStructContext, args.MaxContext)
+
+	for _, v := range []string{"-1", "6", "x"} {
+		ctx, rec := newTestContext("/concordance/corp1?q=[lemma=\"pes\"]&contextStructs=" + v)
+		actions.Concordance(ctx)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, v)
+	}
+	assert.Len(t, pub.queries, 1)
+}
+
+func TestConcordanceMarkStruct(t *testing.T) {
+	stubKnownAttrs(t, "s")
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"concordance": &results.Concordance{
+				Lines: []results.ConcordanceLine{
+					{TokenPos: 10, Boundaries: []results.StructBoundary{{Pos: 0, Struct: "s"}}},
+				},
+			},
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	ctx, rec := newTestContext("/concordance/corp1?q=[lemma=\"pes\"]&markStruct=s")
+	actions.Concordance(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var args rdb.ConcordanceArgs
+	pub.publishedArgs(t, 0, &args)
+	assert.Equal(t, "s", args.BoundaryStruct)
+	assert.Contains(t, rec.Body.String(), `"boundaries":[{"pos":0,"struct":"s","close":false}]`)
+
+	ctx, rec = newTestContext("/concordance/corp1?q=[lemma=\"pes\"]&markStruct=p")
+	actions.Concordance(ctx)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Len(t, pub.queries, 1)
+}
+
+func TestConcordanceXMLOutput(t *testing.T) {
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"concordance": &results.Concordance{ConcSize: 1},
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+
+	ctx, rec := newTestContext("/concordance/corp1?q=[lemma=\"pes\"]&format=xml")
+	actions.Concordance(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "application/xml")
+	assert.Contains(t, rec.Body.String(), `<concordance corpus="corp1" concSize="1">`)
+
+	ctx, rec = newTestContext("/concordance/corp1?q=[lemma=\"pes\"]")
+	ctx.Request.Header.Set("Accept", "application/xml")
+	actions.Concordance(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "application/xml")
+
+	ctx, rec = newTestContext("/concordance/corp1?q=[lemma=\"pes\"]&format=json")
+	ctx.Request.Header.Set("Accept", "application/xml")
+	actions.Concordance(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var ans results.Concordance
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+
+	ctx, rec = newTestContext("/concordance/corp1?q=[lemma=\"pes\"]&format=csv")
+	actions.Concordance(ctx)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Len(t, pub.queries, 3)
+}
+
+func TestConcordanceZeroHits(t *testing.T) {
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"concordance": &results.Concordance{},
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	ctx, rec := newTestContext("/concordance/corp1?q=[lemma=\"xyzxyz\"]")
+	actions.Concordance(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var ans map[string]any
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Equal(t, []any{}, ans["lines"])
+	assert.Equal(t, float64(0), ans["concSize"])
+	assert.NotContains(t, ans, "error")
+}
+
+func TestConcordanceErrors(t *testing.T) {
+	var invalidQuery results.Concordance
+	invalidQuery.SetError(merror.NewInputError("invalid query: unexpected token"))
+	var failed results.Concordance
+	failed.SetError(errors.New("failed to open corpus data"))
+
+	for _, tc := range []struct {
+		result *results.Concordance
+		status int
+	}{
+		{&invalidQuery, http.StatusUnprocessableEntity},
+		{&failed, http.StatusInternalServerError},
+	} {
+		pub := &fakePublisher{
+			results: map[string]results.SerializableResult{"concordance": tc.result},
+		}
+		actions := &Actions{conf: newTestConf(t), radapter: pub}
+		ctx, rec := newTestContext("/concordance/corp1?q=[lemma=\"pes\"")
+		actions.Concordance(ctx)
+		assert.Equal(t, tc.status, rec.Code, tc.result.Error)
+		assert.Contains(t, rec.Body.String(), tc.result.Error)
+	}
+}
+
+func TestConcordanceQueryErrorPosition(t *testing.T) {
+	for _, tc := range []struct {
+		query    string
+		errMsg   string
+		expected *queryErrorInfo
+	}{
+		{
+			query:    `[lemma="pes"`,
+			errMsg:   "Query syntax error at position 12",
+			expected: &queryErrorInfo{Offset: 12, Message: "Query syntax error at position 12"},
+		},
+		{
+			// the offset is in characters, the reported position in bytes
+			query:    `[word="pěší"] [tag=`,
+			errMsg:   "unexpected end of query at pos 22",
+			expected: &queryErrorInfo{Offset: 19, Message: "unexpected end of query at pos 22"},
+		},
+		{
+			query:  `[lemma="pes"] within <doc`,
+			errMsg: "Query syntax error",
+		},
+		{
+			// the position is within a part of the query added by MQuery
+			query:  `[lemma="pes"`,
+			errMsg: "Query syntax error at position 40",
+		},
+	} {
+		var res results.Concordance
+		res.SetError(merror.NewInputError(tc.errMsg))
+		pub := &fakePublisher{
+			results: map[string]results.SerializableResult{"concordance": &res},
+		}
+		actions := &Actions{conf: newTestConf(t), radapter: pub}
+		ctx, rec := newTestContext("/concordance/corp1?q=" + url.QueryEscape(tc.query))
+		actions.Concordance(ctx)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, tc.query)
+		var ans struct {
+			Error      string          `json:"error"`
+			QueryError *queryErrorInfo `json:"queryError"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+		assert.Contains(t, ans.Error, tc.errMsg)
+		assert.Equal(t, tc.expected, ans.QueryError, tc.query)
+	}
+}
+
+func TestConcordanceServerErrorHasNoQueryError(t *testing.T) {
+	var res results.Concordance
+	res.SetError(errors.New("failed to read data at position 12"))
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{"concordance": &res},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	ctx, rec := newTestContext("/concordance/corp1?q=" + url.QueryEscape(`[lemma="pes"]`))
+	actions.Concordance(ctx)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "queryError")
+}
+
+func TestConcordanceUnknownCorpus(t *testing.T) {
+	pub := &fakePublisher{}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	ctx, rec := newTestContext("/concordance/corp2?q=[lemma=\"pes\"]")
+	ctx.Params = gin.Params{{Key: "corpusId", Value: "corp2"}}
+	actions.Concordance(ctx)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Empty(t, pub.queries)
+}
+
+func TestConcordanceHideTokenPosRef(t *testing.T) {
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"concordance": &results.Concordance{ConcSize: 1},
+		},
+	}
+	conf := newTestConf(t)
+	actions := &Actions{conf: conf, radapter: pub}
+
+	ctx, rec := newTestContext("/concordance/corp1?q=[lemma=\"pes\"]")
+	actions.Concordance(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	conf.HideTokenPosRef = true
+	ctx, rec = newTestContext("/concordance/corp1?q=[lemma=\"pes\"]")
+	actions.Concordance(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var visible, hidden rdb.ConcordanceArgs
+	pub.publishedArgs(t, 0, &visible)
+	pub.publishedArgs(t, 1, &hidden)
+	assert.False(t, visible.HideTokenPosRef)
+	assert.True(t, hidden.HideTokenPosRef)
+}
+
+func TestConcordanceGloss(t *testing.T) {
+	stubKnownAttrs(t, "word", "lemma")
+	origIsAlignedWith := isAlignedWith
+	isAlignedWith = func(corpusPath, name string) (bool, error) {
+		return filepath.Base(corpusPath) == "corp1" && name == "corp1_en", nil
+	}
+	t.Cleanup(func() { isAlignedWith = origIsAlignedWith })
+	conf := newTestConf(t)
+	conf.Resources = append(
+		conf.Resources,
+		&corpus.CorpusSetup{ID: "corp1_en"},
+		&corpus.CorpusSetup{ID: "corp3"},
+	)
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"concordance": &results.Concordance{ConcSize: 1},
+		},
+	}
+	actions := &Actions{conf: conf, radapter: pub}
+
+	ctx, rec := newTestContext("/concordance/corp1?q=[lemma=\"pes\"]&gloss=corp1_en&glossAttr=lemma")
+	actions.Concordance(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ctx, rec = newTestContext("/concordance/corp1?q=[lemma=\"pes\"]")
+	actions.Concordance(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var withGloss, noGloss rdb.ConcordanceArgs
+	pub.publishedArgs(t, 0, &withGloss)
+	pub.publishedArgs(t, 1, &noGloss)
+	assert.Equal(t, conf.GetRegistryPath("corp1_en"), withGloss.GlossCorpusPath)
+	assert.Equal(t, "lemma", withGloss.GlossAttr)
+	assert.Empty(t, noGloss.GlossCorpusPath)
+
+	for _, tc := range []struct {
+		args   string
+		status int
+	}{
+		{"gloss=corp9", http.StatusNotFound},
+		{"gloss=corp3", http.StatusUnprocessableEntity},
+		{"gloss=corp1_en&glossAttr=tag", http.StatusUnprocessableEntity},
+	} {
+		ctx, rec = newTestContext("/concordance/corp1?q=[lemma=\"pes\"]&" + tc.args)
+		actions.Concordance(ctx)
+		assert.Equal(t, tc.status, rec.Code, tc.args)
+	}
+	assert.Len(t, pub.queries, 2)
+}
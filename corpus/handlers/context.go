@@ -0,0 +1,238 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+
+	"github.com/czcorpus/cnc-gokit/unireq"
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	dfltWidenedContext = 50
+	maxWidenedContext  = 500
+	maxKWICLen         = 50
+)
+
+// WidenedContext returns a corpus region around a KWIC specified
+// by its token position (see `tokenPos` in concordance lines). This
+// allows obtaining a larger context of a concordance line without
+// re-running the concordance query.
+func (a *Actions) WidenedContext(ctx *gin.Context) {
+	corpusID := ctx.Param("corpusId")
+	corpusConf := a.conf.Resources.Get(corpusID)
+	if corpusConf == nil {
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("corpus %s not found", corpusID), http.StatusNotFound)
+		return
+	}
+	if !ctx.Request.URL.Query().Has("tokenPos") {
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("missing `tokenPos` argument"), http.StatusBadRequest)
+		return
+	}
+	tokenPos, ok := unireq.GetURLIntArgOrFail(ctx, "tokenPos", 0)
+	if !ok {
+		return
+	}
+	kwicLen, ok := unireq.GetURLIntArgOrFail(ctx, "kwicLen", 1)
+	if !ok {
+		return
+	}
+	leftCtx, rightCtx, ok := getContextSizeArgsOrFail(ctx)
+	if !ok {
+		return
+	}
+	if tokenPos < 0 {
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("invalid `tokenPos` value %d", tokenPos), http.StatusUnprocessableEntity)
+		return
+	}
+	if kwicLen < 1 || kwicLen > maxKWICLen {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid `kwicLen` value %d (must be between 1 and %d)", kwicLen, maxKWICLen),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	result, ok := a.corpRegionOrFail(
+		ctx,
+		rdb.CorpRegionArgs{
+			CorpusPath: a.conf.GetRegistryPath(corpusID),
+			Attrs:      corpusConf.PosAttrs.GetIDs(),
+			TokenPos:   int64(tokenPos),
+			KWICLen:    int64(kwicLen),
+			LeftCtx:    int64(leftCtx),
+			RightCtx:   int64(rightCtx),
+		},
+	)
+	if !ok {
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, &result)
+}
+
+// getContextSizeArgsOrFail reads the `leftCtx` and `rightCtx` URL
+// arguments. In case of an invalid value, the function writes
+// an error response and returns false.
+func getContextSizeArgsOrFail(ctx *gin.Context) (int, int, bool) {
+	leftCtx, ok := unireq.GetURLIntArgOrFail(ctx, "leftCtx", dfltWidenedContext)
+	if !ok {
+		return 0, 0, false
+	}
+	rightCtx, ok := unireq.GetURLIntArgOrFail(ctx, "rightCtx", dfltWidenedContext)
+	if !ok {
+		return 0, 0, false
+	}
+	for _, v := range []int{leftCtx, rightCtx} {
+		if v < 0 || v > maxWidenedContext {
+			uniresp.RespondWithErrorJSON(
+				ctx,
+				fmt.Errorf("invalid context size %d (must be between 0 and %d)", v, maxWidenedContext),
+				http.StatusUnprocessableEntity,
+			)
+			return 0, 0, false
+		}
+	}
+	return leftCtx, rightCtx, true
+}
+
+// corpRegionOrFail obtains a corpus region from a worker. In case
+// of an error, the function writes an error response and returns false.
+func (a *Actions) corpRegionOrFail(
+	ctx *gin.Context,
+	regionArgs rdb.CorpRegionArgs,
+) (results.CorpRegion, bool) {
+	args, err := json.Marshal(regionArgs)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer,
+			uniresp.NewActionErrorFrom(err),
+			http.StatusInternalServerError,
+		)
+		return results.CorpRegion{}, false
+	}
+	wait, err := a.radapter.PublishQueryCtx(ctx.Request.Context(), rdb.Query{
+		Func: "corpRegion",
+		Args: args,
+	})
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer,
+			uniresp.NewActionErrorFrom(err),
+			http.StatusInternalServerError,
+		)
+		return results.CorpRegion{}, false
+	}
+	rawResult := <-wait
+	result, err := rdb.DeserializeCorpRegionResult(rawResult)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer,
+			uniresp.NewActionErrorFrom(err),
+			http.StatusInternalServerError,
+		)
+		return result, false
+	}
+	if err := result.Err(); err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer,
+			uniresp.NewActionErrorFrom(err),
+			http.StatusInternalServerError,
+		)
+		return result, false
+	}
+	return result, true
+}
+
+// ConcLineByID returns a concordance line (i.e. its KWIC with
+// the surrounding context) specified by its stable ID (see `id` in
+// concordance lines). This allows fetching e.g. bookmarked examples
+// without the original query. As the ID does not contain the KWIC
+// length, the client may specify it via `kwicLen` (default 1).
+func (a *Actions) ConcLineByID(ctx *gin.Context) {
+	corpusID := ctx.Param("corpusId")
+	corpusConf := a.conf.Resources.Get(corpusID)
+	if corpusConf == nil {
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("corpus %s not found", corpusID), http.StatusNotFound)
+		return
+	}
+	lineCorpusID, tokenPos, err := results.ParseConcordanceLineID(ctx.Param("lineId"))
+	if err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusUnprocessableEntity)
+		return
+	}
+	if lineCorpusID != corpusID {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("line %s does not belong to corpus %s", ctx.Param("lineId"), corpusID),
+			http.StatusNotFound,
+		)
+		return
+	}
+	kwicLen, ok := unireq.GetURLIntArgOrFail(ctx, "kwicLen", 1)
+	if !ok {
+		return
+	}
+	if kwicLen < 1 || kwicLen > maxKWICLen {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid `kwicLen` value %d (must be between 1 and %d)", kwicLen, maxKWICLen),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	leftCtx, rightCtx, ok := getContextSizeArgsOrFail(ctx)
+	if !ok {
+		return
+	}
+	result, ok := a.corpRegionOrFail(
+		ctx,
+		rdb.CorpRegionArgs{
+			CorpusPath: a.conf.GetRegistryPath(corpusID),
+			Attrs:      corpusConf.PosAttrs.GetIDs(),
+			TokenPos:   tokenPos,
+			KWICLen:    int64(kwicLen),
+			LeftCtx:    int64(leftCtx),
+			RightCtx:   int64(rightCtx),
+		},
+	)
+	if !ok {
+		return
+	}
+	// the region is clamped to the corpus size
+	// so an out-of-range position yields no KWIC
+	if result.ToPos <= tokenPos {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("line %s not found", ctx.Param("lineId")),
+			http.StatusNotFound,
+		)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, &result)
+}
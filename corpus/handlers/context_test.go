@@ -0,0 +1,94 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+	"testing"
+
+	"github.com/czcorpus/mquery-common/concordance"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCorpusSize is a size of a corpus used
+// by the fake `corpRegion` worker function
+const testCorpusSize = 1000
+
+func newTestConcLineActions(t *testing.T) (*Actions, *fakePublisher) {
+	pub := &fakePublisher{
+		respond: func(query rdb.Query) results.SerializableResult {
+			var args rdb.CorpRegionArgs
+			assert.NoError(t, json.Unmarshal(query.Args, &args))
+			ans := &results.CorpRegion{
+				TokenPos: args.TokenPos,
+				FromPos:  args.TokenPos - args.LeftCtx,
+				ToPos:    args.TokenPos + args.KWICLen + args.RightCtx,
+			}
+			// Manatee clamps the region to the corpus
+			if ans.FromPos < 0 {
+				ans.FromPos = 0
+			}
+			if ans.ToPos > testCorpusSize {
+				ans.ToPos = testCorpusSize
+			}
+			return ans
+		},
+	}
+	return &Actions{conf: newTestConf(t), radapter: pub}, pub
+}
+
+func runTestConcLineByID(actions *Actions, lineID, query string) int {
+	ctx, rec := newTestContext("/conc-line/corp1/" + lineID + query)
+	ctx.Params = append(ctx.Params, gin.Param{Key: "lineId", Value: lineID})
+	actions.ConcLineByID(ctx)
+	return rec.Code
+}
+
+func TestConcLineByIDRoundTrip(t *testing.T) {
+	actions, pub := newTestConcLineActions(t)
+	line := concordance.NewLineParser([]string{"word"}).Parse([]string{"#421 pes {} attr"})[0]
+	lineID := results.NewConcordanceLine("corp1", line).ID
+
+	assert.Equal(t, http.StatusOK, runTestConcLineByID(actions, lineID, "?kwicLen=2&leftCtx=3"))
+	var args rdb.CorpRegionArgs
+	pub.publishedArgs(t, 0, &args)
+	assert.Equal(t, int64(421), args.TokenPos)
+	assert.Equal(t, int64(2), args.KWICLen)
+	assert.Equal(t, int64(3), args.LeftCtx)
+	assert.Equal(t, []string{"word", "lemma", "tag"}, args.Attrs)
+}
+
+func TestConcLineByIDOutOfRange(t *testing.T) {
+	actions, _ := newTestConcLineActions(t)
+	status := runTestConcLineByID(actions, "corp1:1000", "")
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestConcLineByIDInvalid(t *testing.T) {
+	actions, pub := newTestConcLineActions(t)
+	assert.Equal(t, http.StatusUnprocessableEntity, runTestConcLineByID(actions, "corp1:foo", ""))
+	assert.Equal(t, http.StatusNotFound, runTestConcLineByID(actions, "corp2:10", ""))
+	assert.Equal(
+		t, http.StatusUnprocessableEntity, runTestConcLineByID(actions, "corp1:10", "?kwicLen=0"))
+	assert.Empty(t, pub.queries)
+}
@@ -0,0 +1,226 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"mquery/corpus"
+	"mquery/rdb"
+	"net/http"
+	"sync"
+
+	"github.com/czcorpus/cnc-gokit/collections"
+	"github.com/czcorpus/cnc-gokit/unireq"
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxCoocMatrixWords limits the size of a co-occurrence matrix
+	// (n words require n + n * (n - 1) queries)
+	maxCoocMatrixWords = 20
+	dfltCoocWindow     = 5
+
+	// maxCoocPendingJobs is the max. number of jobs a single
+	// co-occurrence matrix request may have in the worker queue
+	// at a time (so it does not delay queries of other users
+	// too much)
+	maxCoocPendingJobs = 8
+)
+
+type coocMatrixCell struct {
+	Freq int64 `json:"freq"`
+
+	// LogDice is nil if the words do not co-occur
+	LogDice *float64 `json:"logDice"`
+}
+
+type coocMatrix struct {
+	Corpus string   `json:"corpus"`
+	Attr   string   `json:"attr"`
+	Window int      `json:"window"`
+	Words  []string `json:"words"`
+
+	// Freqs contains frequencies of individual words
+	// (in the same order as Words)
+	Freqs []int64 `json:"freqs"`
+
+	// Matrix[i][j] describes occurrences of Words[i] having Words[j]
+	// within the window. As each occurrence of Words[i] is counted
+	// once (regardless of how many times Words[j] is found around it),
+	// the matrix is symmetric only if the words do not cluster (e.g.
+	// `A B A` counts as two occurrences of A with B but as one occurrence
+	// of B with A). Diagonal cells are nil.
+	Matrix [][]*coocMatrixCell `json:"matrix"`
+}
+
+func coocLogDice(freqXY, freqX, freqY int64) *float64 {
+	if freqXY == 0 || freqX+freqY == 0 {
+		return nil
+	}
+	ans := 14 + math.Log2(2*float64(freqXY)/float64(freqX+freqY))
+	return &ans
+}
+
+// coocConcSizes obtains concordance sizes of the queries. The queries
+// are processed concurrently but at most maxCoocPendingJobs of them
+// are published at a time. In case of an error, the function returns
+// also a respective HTTP status.
+func (a *Actions) coocConcSizes(
+	ctx context.Context, corpusPath string, queries []string,
+) ([]int64, int, error) {
+	sizes := make([]int64, len(queries))
+	var errs chunkErrors
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, maxCoocPendingJobs)
+	for i, q := range queries {
+		slots <- struct{}{}
+		if _, err := errs.first(); err != nil {
+			<-slots
+			break
+		}
+		wait, err := a.publishJob(ctx, "concSize", rdb.ConcSizeArgs{CorpusPath: corpusPath, Query: q})
+		if err != nil {
+			<-slots
+			errs.add(err, http.StatusInternalServerError)
+			break
+		}
+		wg.Add(1)
+		go func(i int, wait <-chan *rdb.WorkerResult) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			result, err := rdb.DeserializeConcSizeResult(<-wait)
+			if err != nil {
+				errs.add(err, http.StatusInternalServerError)
+				return
+			}
+			if err := result.Err(); err != nil {
+				errs.add(err, resultErrorStatus(&result))
+				return
+			}
+			sizes[i] = result.ConcSize
+		}(i, wait)
+	}
+	wg.Wait()
+	status, err := errs.first()
+	return sizes, status, err
+}
+
+// CoocMatrix calculates pairwise co-occurrence frequencies (and
+// the respective logDice scores) among a set of words. Word B co-occurs
+// with word A if it is within `window` tokens (on both sides) of A.
+// Both directions of each pair are calculated (see coocMatrix.Matrix).
+// The required queries are processed by multiple workers concurrently
+// (see coocConcSizes).
+func (a *Actions) CoocMatrix(ctx *gin.Context) {
+	corpusID := ctx.Param("corpusId")
+	if a.conf.Resources.Get(corpusID) == nil {
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("corpus %s not found", corpusID), http.StatusNotFound)
+		return
+	}
+	words := ctx.QueryArray("word")
+	if len(words) < 2 {
+		uniresp.RespondWithErrorJSON(
+			ctx, errors.New("at least two `word` arguments required"), http.StatusBadRequest)
+		return
+	}
+	if len(words) > maxCoocMatrixWords {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("too many words (max. %d)", maxCoocMatrixWords),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	for i, w := range words {
+		if collections.SliceContains(words[:i], w) {
+			uniresp.RespondWithErrorJSON(
+				ctx, fmt.Errorf("duplicate word `%s`", w), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	window, ok := unireq.GetURLIntArgOrFail(ctx, "window", dfltCoocWindow)
+	if !ok {
+		return
+	}
+	if window < 1 || window > a.conf.MaxCollSrchRange {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid `window` value (must be between 1 and %d)", a.conf.MaxCollSrchRange),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	attr := ctx.DefaultQuery("attr", CollDefaultAttr)
+	corpusPath := a.conf.GetRegistryPath(corpusID)
+	if !validateAttrOrFail(ctx, corpusPath, attr) {
+		return
+	}
+
+	tokens := make([]string, len(words))
+	for i, w := range words {
+		tokens[i] = fmt.Sprintf("[%s=\"%s\"]", attr, corpus.EscapeCQLRegexp(w))
+	}
+	queries := make([]string, 0, len(words)+len(words)*(len(words)-1))
+	queries = append(queries, tokens...)
+	for i := 0; i < len(words); i++ {
+		for j := 0; j < len(words); j++ {
+			if i != j {
+				queries = append(
+					queries, fmt.Sprintf("(meet %s %s %d %d)", tokens[i], tokens[j], -window, window))
+			}
+		}
+	}
+	sizes, status, err := a.coocConcSizes(ctx.Request.Context(), corpusPath, queries)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(ctx.Writer, uniresp.NewActionErrorFrom(err), status)
+		return
+	}
+
+	ans := coocMatrix{
+		Corpus: corpusID,
+		Attr:   attr,
+		Window: window,
+		Words:  words,
+		Freqs:  sizes[:len(words)],
+		Matrix: make([][]*coocMatrixCell, len(words)),
+	}
+	for i := range ans.Matrix {
+		ans.Matrix[i] = make([]*coocMatrixCell, len(words))
+	}
+	k := len(words)
+	for i := 0; i < len(words); i++ {
+		for j := 0; j < len(words); j++ {
+			if i != j {
+				ans.Matrix[i][j] = &coocMatrixCell{
+					Freq:    sizes[k],
+					LogDice: coocLogDice(sizes[k], ans.Freqs[i], ans.Freqs[j]),
+				}
+				k++
+			}
+		}
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, ans)
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"mquery/corpus"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// delayedPublisher answers queries after a short delay
+// and records the max. number of queries waiting for
+// their results at a time
+type delayedPublisher struct {
+	fakePublisher
+	pending    int
+	maxPending int
+	pendLock   sync.Mutex
+}
+
+func (dp *delayedPublisher) PublishQueryCtx(
+	ctx context.Context, query rdb.Query,
+) (<-chan *rdb.WorkerResult, error) {
+	wait, err := dp.fakePublisher.PublishQueryCtx(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	dp.pendLock.Lock()
+	dp.pending++
+	if dp.pending > dp.maxPending {
+		dp.maxPending = dp.pending
+	}
+	dp.pendLock.Unlock()
+	ans := make(chan *rdb.WorkerResult)
+	go func() {
+		res := <-wait
+		time.Sleep(time.Millisecond)
+		dp.pendLock.Lock()
+		dp.pending--
+		dp.pendLock.Unlock()
+		ans <- res
+	}()
+	return ans, nil
+}
+
+// coocTestSizes returns concordance sizes of the co-occurrence
+// queries of a tiny fixture. Word frequencies are given by `freqs`,
+// `meets` maps "A B" to the number of occurrences of A with B around.
+func coocTestSizes(freqs map[string]int64, meets map[string]int64) func(query rdb.Query) results.SerializableResult {
+	return func(query rdb.Query) results.SerializableResult {
+		var args rdb.ConcSizeArgs
+		if err := json.Unmarshal(query.Args, &args); err != nil {
+			return &results.ConcSize{Error: err.Error()}
+		}
+		words := make([]string, 0, 2)
+		for _, part := range strings.Split(args.Query, "\"") {
+			if _, ok := freqs[part]; ok {
+				words = append(words, part)
+			}
+		}
+		if len(words) == 1 {
+			return &results.ConcSize{ConcSize: freqs[words[0]]}
+		}
+		return &results.ConcSize{ConcSize: meets[strings.Join(words, " ")]}
+	}
+}
+
+func newTestCoocConf(t *testing.T) *corpus.CorporaSetup {
+	conf := newTestConf(t)
+	conf.MaxCollSrchRange = 10
+	return conf
+}
+
+func TestCoocMatrixSymmetry(t *testing.T) {
+	stubAttrChecks(t)
+	pub := &fakePublisher{
+		respond: coocTestSizes(
+			map[string]int64{"pes": 100, "kočka": 80, "štěkat": 30},
+			map[string]int64{
+				"pes kočka": 10, "kočka pes": 10,
+				// "štěkat" tends to occur repeatedly around "pes"
+				"pes štěkat": 12, "štěkat pes": 20,
+			},
+		),
+	}
+	actions := &Actions{conf: newTestCoocConf(t), radapter: pub}
+	ctx, rec := newTestContext("/cooc-matrix/corp1?word=pes&word=kočka&word=štěkat")
+	actions.CoocMatrix(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var ans coocMatrix
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Equal(t, []int64{100, 80, 30}, ans.Freqs)
+	assert.Len(t, pub.queries, 3+3*2)
+	for i := range ans.Words {
+		assert.Nil(t, ans.Matrix[i][i])
+	}
+	// symmetric pair
+	assert.Equal(t, int64(10), ans.Matrix[0][1].Freq)
+	assert.Equal(t, *ans.Matrix[0][1], *ans.Matrix[1][0])
+	// asymmetric pair (both directions are calculated)
+	assert.Equal(t, int64(12), ans.Matrix[0][2].Freq)
+	assert.Equal(t, int64(20), ans.Matrix[2][0].Freq)
+	// words which do not co-occur
+	assert.Equal(t, int64(0), ans.Matrix[1][2].Freq)
+	assert.Nil(t, ans.Matrix[1][2].LogDice)
+	assert.Nil(t, ans.Matrix[2][1].LogDice)
+}
+
+func TestCoocMatrixBoundedJobs(t *testing.T) {
+	stubAttrChecks(t)
+	words := []string{"a", "b", "c", "d", "e", "f"}
+	freqs := make(map[string]int64)
+	for _, w := range words {
+		freqs[w] = 10
+	}
+	pub := &delayedPublisher{
+		fakePublisher: fakePublisher{respond: coocTestSizes(freqs, map[string]int64{})},
+	}
+	actions := &Actions{conf: newTestCoocConf(t), radapter: pub}
+	ctx, rec := newTestContext("/cooc-matrix/corp1?word=" + strings.Join(words, "&word="))
+	actions.CoocMatrix(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, pub.queries, 6+6*5)
+	assert.LessOrEqual(t, pub.maxPending, maxCoocPendingJobs)
+	assert.Greater(t, pub.maxPending, 1)
+}
+
+func TestCoocMatrixWorkerError(t *testing.T) {
+	stubAttrChecks(t)
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"concSize": &results.ConcSize{Error: "failed to open corpus"},
+		},
+	}
+	actions := &Actions{conf: newTestCoocConf(t), radapter: pub}
+	ctx, rec := newTestContext("/cooc-matrix/corp1?word=pes&word=kočka&word=štěkat")
+	actions.CoocMatrix(ctx)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.LessOrEqual(t, len(pub.queries), maxCoocPendingJobs+1)
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"fmt"
+	"mquery/rdb"
+	"net/http"
+	"strings"
+
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	dfltDensityDocAttr = "doc.id"
+
+	// densityMaxItems is the max. number of returned documents
+	// (i.e. the densest ones out of all the documents with hits)
+	densityMaxItems = 100
+)
+
+type docDensityItem struct {
+	Doc  string `json:"doc"`
+	Hits int64  `json:"hits"`
+	Size int64  `json:"size"`
+
+	// Density is the number of hits per 1000 tokens of the document
+	Density float64 `json:"density"`
+}
+
+type docDensity struct {
+	Corpus  string           `json:"corpus"`
+	DocAttr string           `json:"docAttr"`
+	Items   []docDensityItem `json:"items"`
+
+	// Truncated is true in case some documents have been
+	// removed (i.e. only the densest ones are returned)
+	Truncated bool `json:"truncated"`
+}
+
+// DocDensity calculates how densely hits of a query occur within
+// individual documents (hits per 1000 tokens). Documents are identified
+// by a structural attribute (`docAttr`, `doc.id` by default) and only
+// documents with at least one hit are returned. Items are sorted by
+// density in descending order and at most `densityMaxItems` of the densest
+// documents are returned.
+func (a *Actions) DocDensity(ctx *gin.Context) {
+	queryProps := DetermineQueryProps(ctx, a.conf)
+	if queryProps.hasError() {
+		uniresp.RespondWithErrorJSON(ctx, queryProps.err, queryProps.status)
+		return
+	}
+	corpusPath := a.conf.GetRegistryPath(queryProps.corpus)
+	docAttr := ctx.DefaultQuery("docAttr", dfltDensityDocAttr)
+	if len(strings.Split(docAttr, ".")) != 2 {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid attribute `%s` (must be `struct.attr`)", docAttr),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	if !validateAttrOrFail(ctx, corpusPath, docAttr) {
+		return
+	}
+	wait, err := a.publishJob(
+		ctx.Request.Context(),
+		"freqDistrib",
+		rdb.FreqDistribArgs{
+			CorpusPath:  corpusPath,
+			SubcPath:    queryProps.subcPath,
+			Query:       queryProps.query,
+			Crit:        fmt.Sprintf("%s 0", docAttr),
+			IsTextTypes: true,
+			FreqLimit:   1,
+			ItemsLimit:  a.conf.MaxFreqItems,
+			MaxResults:  densityMaxItems,
+			// documents must be ranked by their density within
+			// the whole distribution (and not just within the ones
+			// with the most hits)
+			SortByIPM: true,
+		},
+	)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return
+	}
+	result, err := rdb.DeserializeFreqDistribResult(<-wait)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return
+	}
+	if err := result.Err(); err != nil {
+		writeResultError(ctx, err, &result, 0)
+		return
+	}
+	ans := docDensity{
+		Corpus:    queryProps.corpus,
+		DocAttr:   docAttr,
+		Items:     make([]docDensityItem, 0, len(result.Freqs)),
+		Truncated: result.Truncated,
+	}
+	// the items are already sorted by density (i.e. by their
+	// IPM relative to the document size)
+	for _, item := range result.Freqs {
+		if item.Norm == 0 {
+			// e.g. documents sharing an empty ID - we cannot
+			// tell their size
+			continue
+		}
+		ans.Items = append(ans.Items, docDensityItem{
+			Doc:     item.Word,
+			Hits:    item.Freq,
+			Size:    item.Norm,
+			Density: float64(item.Freq) / float64(item.Norm) * 1000,
+		})
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, ans)
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocDensity(t *testing.T) {
+	stubKnownAttrs(t, "doc.id")
+	// the worker returns documents ranked by density
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"freqDistrib": &results.FreqDistrib{
+				Freqs: results.FreqDistribItemList{
+					{Word: "d5", Freq: 1, Norm: 10},
+					{Word: "d3", Freq: 8, Norm: 400},
+					{Word: "", Freq: 3, Norm: 0},
+					{Word: "d2", Freq: 30, Norm: 3000},
+					{Word: "d1", Freq: 50, Norm: 50000},
+				},
+				Truncated: true,
+			},
+		},
+	}
+	conf := newTestConf(t)
+	actions := &Actions{conf: conf, radapter: pub}
+	ctx, rec := newTestContext("/doc-density/corp1?q=[lemma=\"pes\"]")
+	actions.DocDensity(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var args rdb.FreqDistribArgs
+	pub.publishedArgs(t, 0, &args)
+	assert.Equal(t, "doc.id 0", args.Crit)
+	assert.True(t, args.IsTextTypes)
+	assert.True(t, args.SortByIPM)
+	assert.Equal(t, conf.MaxFreqItems, args.ItemsLimit)
+	assert.Equal(t, densityMaxItems, args.MaxResults)
+
+	var ans docDensity
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Equal(t, "doc.id", ans.DocAttr)
+	assert.True(t, ans.Truncated)
+	// a document with unknown size is skipped
+	assert.Equal(
+		t,
+		[]docDensityItem{
+			{Doc: "d5", Hits: 1, Size: 10, Density: 100},
+			{Doc: "d3", Hits: 8, Size: 400, Density: 20},
+			{Doc: "d2", Hits: 30, Size: 3000, Density: 10},
+			{Doc: "d1", Hits: 50, Size: 50000, Density: 1},
+		},
+		ans.Items,
+	)
+}
+
+func TestDocDensityInvalidDocAttr(t *testing.T) {
+	stubKnownAttrs(t, "doc.id")
+	pub := &fakePublisher{}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	for _, attr := range []string{"id", "doc.title"} {
+		ctx, rec := newTestContext("/doc-density/corp1?q=[lemma=\"pes\"]&docAttr=" + attr)
+		actions.DocDensity(ctx)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, attr)
+	}
+	assert.Empty(t, pub.queries)
+}
@@ -19,7 +19,9 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"mquery/cnf"
 	"mquery/corpus"
 	"mquery/corpus/edit"
@@ -45,14 +47,42 @@ func (variant corpusStructVariant) Validate() bool {
 	return variant == SplitCorpus
 }
 
+var (
+	dfltSplitCollFreqAttrs   = []string{"word", "lemma"}
+	dfltSplitCollFreqStructs = []string{"doc"}
+)
+
+// splitCorpusArgs specifies (optional) request body
+// of the SplitCorpus action
+type splitCorpusArgs struct {
+
+	// Attrs are positional attributes we want to prepare
+	// intermediate freq. data for
+	Attrs []string `json:"attrs" binding:"required,min=1,dive,required"`
+
+	// Structs any structure involved in possible text type
+	// freq. distribution must be here
+	Structs []string `json:"structs" binding:"required,dive,required"`
+}
+
 type multiSubcCorpus interface {
 	GetSubcorpora() []string
 }
 
+// queryPublisher is a part of rdb.Adapter the actions depend on
+// (it allows the adapter to be replaced e.g. in tests)
+type queryPublisher interface {
+	PublishQueryCtx(ctx context.Context, query rdb.Query) (<-chan *rdb.WorkerResult, error)
+	AcquireJobKey(key string) (bool, error)
+	ReleaseJobKey(key string) error
+	PublishCorpusInvalidation(corpusPath string) error
+}
+
 type Actions struct {
 	conf         *corpus.CorporaSetup
-	radapter     *rdb.Adapter
+	radapter     queryPublisher
 	infoProvider *infoload.Manatee
+	ttNorms      *corpus.TTNormsCache
 	locales      cnf.LocalesConf
 }
 
@@ -79,8 +109,43 @@ func (a *Actions) DeleteSplit(ctx *gin.Context) {
 
 }
 
+// getSplitCorpusArgsOrFail reads an optional request body specifying
+// attributes and structures to prepare freq. data for. Attributes not
+// specified in the body default to the corpus' `collFreqDataAttrs` (if set).
+// In case of an invalid body, an error response is written and false is returned.
+func (a *Actions) getSplitCorpusArgsOrFail(ctx *gin.Context, corpusID string) (splitCorpusArgs, bool) {
+	reqArgs := splitCorpusArgs{
+		Attrs:   dfltSplitCollFreqAttrs,
+		Structs: dfltSplitCollFreqStructs,
+	}
+	corpusConf := a.conf.Resources.Get(corpusID)
+	if corpusConf != nil && len(corpusConf.CollFreqDataAttrs) > 0 {
+		reqArgs.Attrs = corpusConf.CollFreqDataAttrs
+	}
+	if ctx.Request.ContentLength != 0 {
+		if !bindJSONBodyOrFail(ctx, &reqArgs) {
+			return reqArgs, false
+		}
+		if corpusConf != nil {
+			for _, attr := range reqArgs.Attrs {
+				if corpusConf.GetPosAttr(attr).IsZero() {
+					uniresp.WriteJSONErrorResponse(
+						ctx.Writer,
+						uniresp.NewActionError("invalid request body"),
+						http.StatusUnprocessableEntity,
+						fmt.Sprintf("field `attrs`: unknown attribute `%s`", attr),
+					)
+					return reqArgs, false
+				}
+			}
+		}
+	}
+	return reqArgs, true
+}
+
 func (a *Actions) SplitCorpus(ctx *gin.Context) {
-	corpPath := a.conf.GetRegistryPath(ctx.Param("corpusId"))
+	corpusID := ctx.Param("corpusId")
+	corpPath := a.conf.GetRegistryPath(corpusID)
 	exists, err := edit.SplitCorpusExists(a.conf.SplitCorporaDir, corpPath)
 	if err != nil {
 		uniresp.WriteJSONErrorResponse(
@@ -98,54 +163,109 @@ func (a *Actions) SplitCorpus(ctx *gin.Context) {
 		return
 	}
 
+	reqArgs, ok := a.getSplitCorpusArgsOrFail(ctx, corpusID)
+	if !ok {
+		return
+	}
+
 	// note: `splitCorpus` is very fast so there is no need to delegate it to a worker
-	corp, err := edit.SplitCorpus(a.conf.SplitCorporaDir, corpPath, int64(chunkSize))
+	corp, err := edit.SplitCorpus(
+		a.conf.SplitCorporaDir, corpPath, int64(chunkSize), a.conf.MaxSplitChunks)
 	if err != nil {
 		uniresp.WriteJSONErrorResponse(
 			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusConflict)
 		return
 	}
 
+	if _, err := a.calcCollFreqData(ctx.Request.Context(), corpPath, corp.Subcorpora, reqArgs); err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, corp)
+}
+
+// calcCollFreqData lets workers calculate frequency data needed
+// for collocations and text types for all the provided subcorpora
+// (typically chunks of a split corpus). Jobs identical to the ones
+// already being processed (e.g. due to a repeated request) are not
+// published again - the function returns the number of such skipped
+// jobs. The function blocks until all the published calculations are
+// finished. In case of errors, the first one is returned.
+func (a *Actions) calcCollFreqData(
+	ctx context.Context,
+	corpPath string,
+	subcorpora []string,
+	reqArgs splitCorpusArgs,
+) (int, error) {
 	wg := sync.WaitGroup{}
-	wg.Add(len(corp.Subcorpora))
-	errs := make([]error, 0, len(corp.Subcorpora))
-	for _, subc := range corp.Subcorpora {
-		args, err := json.Marshal(rdb.CalcCollFreqDataArgs{
+	wg.Add(len(subcorpora))
+	var errs chunkErrors
+	var numSkipped int
+	for _, subc := range subcorpora {
+		jobArgs := rdb.CalcCollFreqDataArgs{
 			CorpusPath:     corpPath,
 			SubcPath:       subc,
-			Attrs:          []string{"word", "lemma"}, // TODO this should not be hardcoded
-			Structs:        []string{"doc"},
+			Attrs:          reqArgs.Attrs,
+			Structs:        reqArgs.Structs,
 			MktokencovPath: a.conf.MktokencovPath,
-		})
+		}
+		jobKey := jobArgs.IdempotencyKey()
+		acquired, err := a.radapter.AcquireJobKey(jobKey)
+		if err != nil {
+			wg.Done()
+			log.Error().Err(err).Msg("failed to publish task")
+			errs.add(err, http.StatusInternalServerError)
+			continue
+		}
+		if !acquired {
+			wg.Done()
+			log.Info().Str("subc", subc).Msg("identical calcCollFreqData job already running, skipping")
+			numSkipped++
+			continue
+		}
+		args, err := json.Marshal(jobArgs)
 		if err != nil {
 			wg.Done()
+			a.releaseJobKey(jobKey)
 			log.Error().Err(err).Msg("failed to publish task")
-			errs = append(errs, err)
+			errs.add(err, http.StatusInternalServerError)
 			continue
 		}
-		wait, err := a.radapter.PublishQuery(rdb.Query{
+		wait, err := a.radapter.PublishQueryCtx(ctx, rdb.Query{
 			Func: "calcCollFreqData",
 			Args: args,
 		})
+		if err != nil {
+			wg.Done()
+			a.releaseJobKey(jobKey)
+			log.Error().Err(err).Msg("failed to publish task")
+			errs.add(err, http.StatusInternalServerError)
+			continue
+		}
 		go func() {
 			defer wg.Done()
 			ans := <-wait
+			a.releaseJobKey(jobKey)
 			resp, err := rdb.DeserializeCollFreqDataResult(ans)
 			if err != nil {
-				errs = append(errs, err)
+				errs.add(err, http.StatusInternalServerError)
 				log.Error().Err(err).Msg("failed to execute action calcCollFreqData")
+				return
 			}
 			if err := resp.Err(); err != nil {
-				errs = append(errs, err)
+				errs.add(err, http.StatusInternalServerError)
 				log.Error().Err(err).Msg("failed to execute action calcCollFreqData")
 			}
 		}()
 	}
 	wg.Wait()
-	if len(errs) > 0 {
-		uniresp.WriteJSONErrorResponse(
-			ctx.Writer, uniresp.NewActionErrorFrom(errs[0]), http.StatusInternalServerError)
-		return
+	_, err := errs.first()
+	return numSkipped, err
+}
+
+func (a *Actions) releaseJobKey(key string) {
+	if err := a.radapter.ReleaseJobKey(key); err != nil {
+		log.Error().Err(err).Str("key", key).Msg("failed to release job key")
 	}
-	uniresp.WriteJSONResponse(ctx.Writer, corp)
 }
@@ -0,0 +1,78 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"time"
+
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+)
+
+// responseEnvelope wraps a result along with metadata
+// describing how the result has been obtained.
+type responseEnvelope struct {
+	Corpus string `json:"corpus"`
+	Query  string `json:"query"`
+
+	// Criteria contains additional calculation arguments
+	// (e.g. a freq. criterion) as applied by the server
+	Criteria map[string]any `json:"criteria,omitempty"`
+
+	// ProcTimeSecs is the time spent by processing the request
+	// on the server side
+	ProcTimeSecs float64 `json:"procTimeSecs"`
+
+	// Cached specifies whether the result has been (at least partially)
+	// obtained from a cache (e.g. a worker reused an already compiled
+	// concordance).
+	Cached bool `json:"cached"`
+
+	Data any `json:"data"`
+}
+
+// writeResult writes the result either as is or (in case
+// the `responseEnvelope` is enabled in the configuration) wrapped
+// in a responseEnvelope. The `t0` argument should be the time
+// the request processing started, `cached` specifies whether
+// the result has been obtained from a cache.
+func (a *Actions) writeResult(
+	ctx *gin.Context,
+	t0 time.Time,
+	queryProps queryProps,
+	criteria map[string]any,
+	cached bool,
+	result any,
+) {
+	if !a.conf.ResponseEnvelope {
+		uniresp.WriteJSONResponse(ctx.Writer, result)
+		return
+	}
+	uniresp.WriteJSONResponse(
+		ctx.Writer,
+		responseEnvelope{
+			Corpus:       queryProps.corpus,
+			Query:        queryProps.query,
+			Criteria:     criteria,
+			ProcTimeSecs: time.Since(t0).Seconds(),
+			Cached:       cached,
+			Data:         result,
+		},
+	)
+}
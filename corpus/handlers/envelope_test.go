@@ -0,0 +1,107 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"mquery/mango"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testEnvelope struct {
+	Corpus       string          `json:"corpus"`
+	Query        string          `json:"query"`
+	Criteria     map[string]any  `json:"criteria"`
+	ProcTimeSecs float64         `json:"procTimeSecs"`
+	Cached       *bool           `json:"cached"`
+	Data         json.RawMessage `json:"data"`
+}
+
+func TestCollocationsResponseEnvelope(t *testing.T) {
+	conf := newTestConf(t)
+	conf.ResponseEnvelope = true
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"collocations": &results.Collocations{
+				Colls: []*mango.GoCollItem{{Word: "štěkat", Score: 9.1, Freq: 12}},
+			},
+		},
+	}
+	actions := &Actions{conf: conf, radapter: pub}
+	ctx, rec := newTestContext("/collocations/corp1?q=[lemma=\"pes\"]&measure=logDice")
+	actions.Collocations(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var ans testEnvelope
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Equal(t, "corp1", ans.Corpus)
+	assert.Equal(t, "[lemma=\"pes\"]", ans.Query)
+	assert.Equal(t, "logDice", ans.Criteria["measure"])
+	assert.Greater(t, ans.ProcTimeSecs, 0.0)
+	if assert.NotNil(t, ans.Cached) {
+		assert.False(t, *ans.Cached)
+	}
+	var data results.Collocations
+	assert.NoError(t, json.Unmarshal(ans.Data, &data))
+	assert.Len(t, data.Colls, 1)
+}
+
+func TestCollocationsNoEnvelope(t *testing.T) {
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"collocations": &results.Collocations{},
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	ctx, rec := newTestContext("/collocations/corp1?q=[lemma=\"pes\"]")
+	actions.Collocations(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var ans map[string]any
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.NotContains(t, ans, "procTimeSecs")
+	assert.Contains(t, ans, "colls")
+}
+
+func TestConcordanceResponseEnvelopeCached(t *testing.T) {
+	conf := newTestConf(t)
+	conf.ResponseEnvelope = true
+	cached := false
+	pub := &fakePublisher{
+		respond: func(query rdb.Query) results.SerializableResult {
+			return &results.Concordance{ConcSize: 1000, Cached: cached}
+		},
+	}
+	actions := &Actions{conf: conf, radapter: pub}
+
+	for _, cached = range []bool{false, true} {
+		ctx, rec := newTestContext("/concordance/corp1?q=[lemma=\"pes\"]&fromLine=20")
+		actions.Concordance(ctx)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var ans testEnvelope
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+		if assert.NotNil(t, ans.Cached) {
+			assert.Equal(t, cached, *ans.Cached)
+		}
+	}
+}
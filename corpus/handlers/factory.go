@@ -29,12 +29,14 @@ func NewActions(
 	conf *corpus.CorporaSetup,
 	radapter *rdb.Adapter,
 	infoProvider *infoload.Manatee,
+	ttNorms *corpus.TTNormsCache,
 	locales cnf.LocalesConf,
 ) *Actions {
 	return &Actions{
 		conf:         conf,
 		radapter:     radapter,
 		infoProvider: infoProvider,
+		ttNorms:      ttNorms,
 		locales:      locales,
 	}
 }
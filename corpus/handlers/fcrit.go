@@ -0,0 +1,119 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"fmt"
+	"mquery/corpus"
+	"net/http"
+
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+)
+
+type fcritHelpItem struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+type fcritHelpResponse struct {
+	Corpus string `json:"corpus"`
+
+	// Attrs are positional attributes usable in a criterion
+	Attrs []string `json:"attrs"`
+
+	// Structs are structures whose attributes (in the `struct.attr`
+	// form) can be used in a criterion to obtain text types frequencies
+	Structs []string `json:"structs"`
+
+	// Modifiers can be attached to an attribute (e.g. `word/i`)
+	Modifiers []fcritHelpItem `json:"modifiers"`
+
+	// Positions specify which token(s) of a concordance line
+	// the attribute values are taken from
+	Positions []fcritHelpItem `json:"positions"`
+
+	Examples []string `json:"examples"`
+}
+
+var (
+	fcritModifiers = []fcritHelpItem{
+		{Code: "i", Description: "ignore case (values are lowercased)"},
+		{Code: "r", Description: "retrograde (values are reversed)"},
+		{Code: "e", Description: "exact values (case-sensitive)"},
+	}
+
+	fcritPositions = []fcritHelpItem{
+		{Code: "0", Description: "the first token of the KWIC"},
+		{Code: "0~0>0", Description: "the whole KWIC"},
+		{Code: "-1<0", Description: "the first token to the left of the KWIC"},
+		{Code: "1>0", Description: "the first token to the right of the KWIC"},
+	}
+)
+
+// FreqCritHelp provides information needed to create valid frequency
+// criteria (the `fcrit` argument) for a corpus - i.e. available
+// attributes along with supported modifiers and positions.
+// The attributes are taken directly from the corpus.
+func (a *Actions) FreqCritHelp(ctx *gin.Context) {
+	corpusID := ctx.Param("corpusId")
+	info, err := a.infoProvider.LoadCorpusInfo(corpusID, a.locales.DefaultLocale())
+	if err == corpus.ErrNotFound {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusNotFound)
+		return
+
+	} else if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return
+	}
+	if err := info.Err(); err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return
+	}
+	ans := fcritHelpResponse{
+		Corpus:    corpusID,
+		Attrs:     make([]string, len(info.Data.AttrList)),
+		Structs:   make([]string, len(info.Data.StructList)),
+		Modifiers: fcritModifiers,
+		Positions: fcritPositions,
+		Examples:  []string{},
+	}
+	for i, attr := range info.Data.AttrList {
+		ans.Attrs[i] = attr.Name
+	}
+	for i, strct := range info.Data.StructList {
+		ans.Structs[i] = strct.Name
+	}
+	if len(ans.Attrs) > 0 {
+		ans.Examples = append(
+			ans.Examples,
+			fmt.Sprintf("%s 0~0>0", ans.Attrs[0]),
+			fmt.Sprintf("%s/i 0~0>0", ans.Attrs[0]),
+			fmt.Sprintf("%s 1>0", ans.Attrs[0]),
+		)
+		if len(ans.Attrs) > 1 {
+			ans.Examples = append(
+				ans.Examples, fmt.Sprintf("%s 0 %s 0", ans.Attrs[0], ans.Attrs[1]))
+		}
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, &ans)
+}
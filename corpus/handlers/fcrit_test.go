@@ -0,0 +1,79 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"mquery/corpus/baseinfo"
+	"mquery/corpus/infoload"
+	"mquery/results"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreqCritHelp(t *testing.T) {
+	conf := newTestConf(t)
+	assert.NoError(t, os.WriteFile(conf.GetRegistryPath("corp1"), []byte{}, 0644))
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"corpusInfo": &results.CorpusInfo{
+				Data: baseinfo.Corpus{
+					AttrList: []baseinfo.Item{
+						{Name: "word"}, {Name: "lemma"}, {Name: "tag"},
+					},
+					StructList: []baseinfo.Item{{Name: "doc"}},
+				},
+			},
+		},
+	}
+	actions := &Actions{
+		conf:         conf,
+		radapter:     pub,
+		infoProvider: infoload.NewManatee(pub, conf),
+	}
+
+	ctx, rec := newTestContext("/freq-crit-help/corp1")
+	actions.FreqCritHelp(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var ans fcritHelpResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Equal(t, "corp1", ans.Corpus)
+	assert.Equal(t, []string{"word", "lemma", "tag"}, ans.Attrs)
+	assert.Equal(t, []string{"doc"}, ans.Structs)
+	assert.Equal(t, fcritModifiers, ans.Modifiers)
+	assert.Contains(t, ans.Examples, "word/i 0~0>0")
+	assert.Contains(t, ans.Examples, "word 0 lemma 0")
+}
+
+func TestFreqCritHelpUnknownCorpus(t *testing.T) {
+	conf := newTestConf(t)
+	pub := &fakePublisher{}
+	actions := &Actions{
+		conf:         conf,
+		radapter:     pub,
+		infoProvider: infoload.NewManatee(pub, conf),
+	}
+	ctx, rec := newTestContext("/freq-crit-help/corp1")
+	actions.FreqCritHelp(ctx)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Empty(t, pub.queries)
+}
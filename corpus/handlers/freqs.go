@@ -26,11 +26,12 @@ import (
 	"mquery/rdb"
 	"mquery/results"
 	"net/http"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/czcorpus/cnc-gokit/unireq"
 	"github.com/czcorpus/cnc-gokit/uniresp"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
@@ -38,37 +39,92 @@ import (
 
 const (
 	defaultFreqCrit = "lemma/e 0~0>0"
+
+	// freqAttrCritTpl is a criterion template for the `attr` argument
+	freqAttrCritTpl = "%s/e 0~0>0"
 )
 
 func (a *Actions) FreqDistrib(ctx *gin.Context) {
+	t0 := time.Now()
 	queryProps := DetermineQueryProps(ctx, a.conf)
 	if queryProps.hasError() {
 		uniresp.RespondWithErrorJSON(ctx, queryProps.err, queryProps.status)
 		return
 	}
-	flimit := 1
-	if ctx.Request.URL.Query().Has("flimit") {
-		var err error
-		flimit, err = strconv.Atoi(ctx.Request.URL.Query().Get("flimit"))
-		if err != nil {
-			uniresp.WriteJSONErrorResponse(
-				ctx.Writer,
-				uniresp.NewActionErrorFrom(err),
-				http.StatusUnprocessableEntity,
-			)
-			return
-		}
+	flimit, ok := getFreqLimitArgOrFail(ctx, a.conf, queryProps.corpusConf)
+	if !ok {
+		return
+	}
+	excludedStructs, ok := getExcludedStructsOrFail(ctx, queryProps.corpusConf)
+	if !ok {
+		return
+	}
+	normBasis, ok := getFreqNormBasisOrFail(ctx, queryProps.corpusConf)
+	if !ok {
+		return
+	}
+	concRelative, ok := getConcRelativeFreqsArgOrFail(ctx)
+	if !ok {
+		return
+	}
+	offset, ok := unireq.GetURLIntArgOrFail(ctx, "offset", 0)
+	if !ok {
+		return
 	}
-	fcrit := ctx.Request.URL.Query().Get("fcrit")
-	if fcrit == "" {
-		fcrit = defaultFreqCrit
+	if offset < 0 {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid `offset` value %d (must be >= 0)", offset),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	itemsBudget, ok := unireq.GetURLIntArgOrFail(ctx, "itemsBudget", 0)
+	if !ok {
+		return
+	}
+	if itemsBudget < 0 || itemsBudget > a.conf.MaxFreqItems {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid `itemsBudget` value %d (must be between 0 and %d)", itemsBudget, a.conf.MaxFreqItems),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	drillDown, ok := unireq.GetURLBoolArgOrFail(ctx, "drillDown", false)
+	if !ok {
+		return
+	}
+	fcrit, ok := getFreqCritOrFail(ctx, queryProps.query)
+	if !ok {
+		return
 	}
 	corpusPath := a.conf.GetRegistryPath(queryProps.corpus)
+	if !validateFcritAttrsOrFail(ctx, corpusPath, fcrit) {
+		return
+	}
+	if normBasis == rdb.NormBasisItem && !strings.Contains(corpus.FreqCritAttr(fcrit), ".") {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("`norm=%s` requires a single-level criterion with a structural attribute", rdb.NormBasisItem),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	multivalueSep := queryProps.corpusConf.GetMultivalueSeparator(fcrit)
+	query := excludeStructsFromQuery(queryProps.query, excludedStructs)
 	args, err := json.Marshal(rdb.FreqDistribArgs{
 		CorpusPath: corpusPath,
-		Query:      queryProps.query,
+		SubcPath:   queryProps.subcPath,
+		Query:      query,
 		Crit:       fcrit,
 		FreqLimit:  flimit,
+		ItemsLimit: a.conf.MaxFreqItems,
+		NormBasis:  normBasis,
+		Offset:     offset,
+
+		MultivalueSeparator: multivalueSep,
+		ItemsBudget:         itemsBudget,
 	})
 	if err != nil {
 		uniresp.WriteJSONErrorResponse(
@@ -79,7 +135,7 @@ func (a *Actions) FreqDistrib(ctx *gin.Context) {
 		return
 	}
 
-	wait, err := a.radapter.PublishQuery(rdb.Query{
+	wait, err := a.radapter.PublishQueryCtx(ctx.Request.Context(), rdb.Query{
 		Func: "freqDistrib",
 		Args: args,
 	})
@@ -102,15 +158,29 @@ func (a *Actions) FreqDistrib(ctx *gin.Context) {
 		return
 	}
 	if err := result.Err(); err != nil {
-		uniresp.WriteJSONErrorResponse(
-			ctx.Writer,
-			uniresp.NewActionErrorFrom(err),
-			http.StatusInternalServerError,
-		)
+		writeResultError(ctx, err, &result, 0)
 		return
 	}
-	uniresp.WriteJSONResponse(
-		ctx.Writer,
+	if concRelative {
+		result.CalcConcPercentages()
+	}
+	// split values of multi-value attributes do not
+	// correspond to the original ones
+	if drillDown && multivalueSep == "" {
+		attachDrillDownQueries(&result, query, fcrit)
+	}
+	a.writeResult(
+		ctx,
+		t0,
+		queryProps,
+		map[string]any{
+			"fcrit":       fcrit,
+			"flimit":      flimit,
+			"norm":        normBasis,
+			"offset":      offset,
+			"itemsBudget": itemsBudget,
+		},
+		false,
 		&result,
 	)
 }
@@ -121,7 +191,22 @@ func (a *Actions) FreqDistribParallel(ctx *gin.Context) {
 		uniresp.RespondWithErrorJSON(ctx, queryProps.err, queryProps.status)
 		return
 	}
-	flimit := 1
+	flimit, ok := getFreqLimitArgOrFail(ctx, a.conf, queryProps.corpusConf)
+	if !ok {
+		return
+	}
+	excludedStructs, ok := getExcludedStructsOrFail(ctx, queryProps.corpusConf)
+	if !ok {
+		return
+	}
+	concRelative, ok := getConcRelativeFreqsArgOrFail(ctx)
+	if !ok {
+		return
+	}
+	sorting, ok := getFreqSortingOrFail(ctx)
+	if !ok {
+		return
+	}
 	maxItems := 0
 	within := ""
 	corpusPath := a.conf.GetRegistryPath(queryProps.corpus)
@@ -135,19 +220,6 @@ func (a *Actions) FreqDistribParallel(ctx *gin.Context) {
 		return
 	}
 
-	if ctx.Request.URL.Query().Has("flimit") {
-		var err error
-		flimit, err = strconv.Atoi(ctx.Request.URL.Query().Get("flimit"))
-		if err != nil {
-			uniresp.WriteJSONErrorResponse(
-				ctx.Writer,
-				uniresp.NewActionErrorFrom(err),
-				http.StatusUnprocessableEntity,
-			)
-			return
-		}
-	}
-
 	if ctx.Request.URL.Query().Has("maxItems") {
 		var err error
 		maxItems, err = strconv.Atoi(ctx.Request.URL.Query().Get("maxItems"))
@@ -191,15 +263,18 @@ func (a *Actions) FreqDistribParallel(ctx *gin.Context) {
 		}
 		q = fmt.Sprintf("%s within <%s %s=\"%s\" />", q, kv[0], kv[1], tmp[1])
 	}
-	mergedFreqLock := sync.Mutex{}
+	q = excludeStructsFromQuery(q, excludedStructs)
+	fcrit, ok := getFreqCritOrFail(ctx, queryProps.query)
+	if !ok {
+		return
+	}
+	if !validateFcritAttrsOrFail(ctx, corpusPath, fcrit) {
+		return
+	}
 	wg := sync.WaitGroup{}
 	wg.Add(len(sc.Subcorpora))
-	result := new(results.FreqDistrib)
-	result.Freqs = make([]*results.FreqDistribItem, 0)
-	fcrit := ctx.Request.URL.Query().Get("fcrit")
-	if fcrit == "" {
-		fcrit = defaultFreqCrit
-	}
+	merger := results.NewFreqDistribMerger()
+	var errs chunkErrors
 	for _, subc := range sc.Subcorpora {
 		args, err := json.Marshal(rdb.FreqDistribArgs{
 			CorpusPath: corpusPath,
@@ -207,7 +282,10 @@ func (a *Actions) FreqDistribParallel(ctx *gin.Context) {
 			Query:      q,
 			Crit:       fcrit,
 			FreqLimit:  flimit,
+			ItemsLimit: a.conf.MaxFreqItems,
 			MaxResults: maxItems,
+
+			MultivalueSeparator: queryProps.corpusConf.GetMultivalueSeparator(fcrit),
 		})
 		if err != nil {
 			uniresp.WriteJSONErrorResponse(
@@ -218,13 +296,14 @@ func (a *Actions) FreqDistribParallel(ctx *gin.Context) {
 			return
 		}
 
-		wait, err := a.radapter.PublishQuery(rdb.Query{
+		wait, err := a.radapter.PublishQueryCtx(ctx.Request.Context(), rdb.Query{
 			Func: "freqDistrib",
 			Args: args,
 		})
 		if err != nil {
-			// TODO
 			log.Error().Err(err).Msg("failed to publish query")
+			errs.add(err, http.StatusInternalServerError)
+			wg.Done()
 
 		} else {
 			go func() {
@@ -232,30 +311,34 @@ func (a *Actions) FreqDistribParallel(ctx *gin.Context) {
 				tmp := <-wait
 				resultNext, err := rdb.DeserializeFreqDistribResult(tmp)
 				if err != nil {
-					// TODO
 					log.Error().Err(err).Msg("failed to deserialize query")
+					errs.add(err, http.StatusInternalServerError)
+					return
 				}
 				if err := resultNext.Err(); err != nil {
-					// TODO
-					log.Error().Err(err).Msg("failed to deserialize query")
+					log.Error().Err(err).Msg("failed to calculate freq. distribution chunk")
+					errs.add(err, resultErrorStatus(&resultNext))
+					return
 				}
-				mergedFreqLock.Lock()
-				result.MergeWith(&resultNext)
-				mergedFreqLock.Unlock()
+				merger.Add(&resultNext)
 			}()
 		}
 	}
 	wg.Wait()
-	sort.SliceStable(
-		result.Freqs,
-		func(i, j int) bool {
-			return result.Freqs[i].Freq > result.Freqs[j].Freq
-		},
-	)
+	if status, err := errs.first(); err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), status)
+		return
+	}
 	cut := maxItems
 	if maxItems == 0 {
 		cut = 100 // TODO !!! (configured on worker, cannot import here)
 	}
-	result.Freqs = result.Freqs.Cut(cut)
+	result := merger.TopK(cut)
+	// the most frequent items are always selected first
+	sortFreqs(result.Freqs, corpusPath, sorting)
+	if concRelative {
+		result.CalcConcPercentages()
+	}
 	uniresp.WriteJSONResponse(ctx.Writer, result)
 }
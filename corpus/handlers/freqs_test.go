@@ -0,0 +1,479 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"mquery/corpus"
+	"mquery/merror"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func runTestFreqsHandler(
+	t *testing.T, handler func(*Actions, *gin.Context), url string, res *results.FreqDistrib,
+) int {
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{"freqDistrib": res},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	ctx, rec := newTestContext(url)
+	handler(actions, ctx)
+	return rec.Code
+}
+
+func TestFreqHandlersWorkerErrorStatus(t *testing.T) {
+	stubAttrChecks(t)
+	handlers := map[string]func(*Actions, *gin.Context){
+		"/freqs/corp1?q=[lemma=\"pes\"]":                      (*Actions).FreqDistrib,
+		"/text-types/corp1?q=[lemma=\"pes\"]&attr=doc.txtype": (*Actions).TextTypes,
+	}
+	for url, handler := range handlers {
+		status := runTestFreqsHandler(t, handler, url, &results.FreqDistrib{})
+		assert.Equal(t, http.StatusOK, status, url)
+
+		status = runTestFreqsHandler(
+			t, handler, url,
+			&results.FreqDistrib{Error: "unexpected token", ErrorType: results.ErrorTypeInput},
+		)
+		assert.Equal(t, http.StatusUnprocessableEntity, status, url)
+
+		status = runTestFreqsHandler(
+			t, handler, url, &results.FreqDistrib{Error: "failed to open corpus"})
+		assert.Equal(t, http.StatusInternalServerError, status, url)
+	}
+}
+
+func TestFreqDistribInvalidQuery(t *testing.T) {
+	stubAttrChecks(t)
+	var invalidQuery results.FreqDistrib
+	invalidQuery.SetError(merror.NewInputError("invalid query: unexpected token"))
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{"freqDistrib": &invalidQuery},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	ctx, rec := newTestContext("/freqs/corp1?q=[lemma=\"pes\"")
+	actions.FreqDistrib(ctx)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), "invalid query: unexpected token")
+}
+
+func TestFreqDistribExcludedStructs(t *testing.T) {
+	stubAttrChecks(t)
+	conf := newTestConf(t)
+	conf.Resources.Get("corp1").StructAttrs = []corpus.StructAttr{
+		{Name: "doc.id"}, {Name: "note.type"}, {Name: "head.level"},
+	}
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{"freqDistrib": &results.FreqDistrib{}},
+	}
+	actions := &Actions{conf: conf, radapter: pub}
+
+	ctx, rec := newTestContext("/freqs/corp1?q=[lemma=\"pes\"]")
+	actions.FreqDistrib(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ctx, rec = newTestContext(
+		"/freqs/corp1?q=[lemma=\"pes\"]&excludeStruct=note&excludeStruct=head")
+	actions.FreqDistrib(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var all, excluded rdb.FreqDistribArgs
+	pub.publishedArgs(t, 0, &all)
+	pub.publishedArgs(t, 1, &excluded)
+	assert.Equal(t, "[lemma=\"pes\"]", all.Query)
+	assert.Equal(t, "[lemma=\"pes\"] !within <note /> !within <head />", excluded.Query)
+
+	for _, strct := range []string{"foo", "note.type", "<note/>"} {
+		ctx, rec = newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&excludeStruct=" + url.QueryEscape(strct))
+		actions.FreqDistrib(ctx)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, strct)
+	}
+	assert.Len(t, pub.queries, 2)
+}
+
+func TestFreqDistribEmptyResult(t *testing.T) {
+	stubAttrChecks(t)
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"freqDistrib": &results.FreqDistrib{CorpusSize: 1000, SearchSize: 1000},
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	ctx, rec := newTestContext("/freqs/corp1?q=[lemma=\"xxxnonexistentxxx\"]")
+	actions.FreqDistrib(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var ans map[string]any
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Equal(t, float64(0), ans["concSize"])
+	assert.Equal(t, float64(1000), ans["corpusSize"])
+	assert.Equal(t, float64(1000), ans["searchSize"])
+	assert.Equal(t, []any{}, ans["freqs"])
+}
+
+func TestFreqDistribNormBasis(t *testing.T) {
+	stubAttrChecks(t)
+	conf := newTestConf(t)
+	conf.Resources.Get("corp1").StructAttrs = []corpus.StructAttr{{Name: "doc.id"}}
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{"freqDistrib": &results.FreqDistrib{}},
+	}
+	actions := &Actions{conf: conf, radapter: pub}
+
+	ctx, rec := newTestContext("/freqs/corp1?q=[lemma=\"pes\"]")
+	actions.FreqDistrib(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	for _, norm := range []string{"search", "corpus", "struct:doc"} {
+		ctx, rec = newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&norm=" + url.QueryEscape(norm))
+		actions.FreqDistrib(ctx)
+		assert.Equal(t, http.StatusOK, rec.Code, norm)
+	}
+	var dflt, corp, doc rdb.FreqDistribArgs
+	pub.publishedArgs(t, 0, &dflt)
+	pub.publishedArgs(t, 2, &corp)
+	pub.publishedArgs(t, 3, &doc)
+	assert.Equal(t, rdb.NormBasisSearch, dflt.NormBasis)
+	assert.Equal(t, rdb.NormBasisCorpus, corp.NormBasis)
+	assert.Equal(t, "struct:doc", doc.NormBasis)
+
+	for _, norm := range []string{"docs", "struct:p", "struct:<doc>"} {
+		ctx, rec = newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&norm=" + url.QueryEscape(norm))
+		actions.FreqDistrib(ctx)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, norm)
+	}
+	assert.Len(t, pub.queries, 4)
+}
+
+func TestFreqDistribMinFreqLimit(t *testing.T) {
+	stubAttrChecks(t)
+	conf := newTestConf(t)
+	conf.Resources.Get("corp1").MinFreqLimit = 50
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{"freqDistrib": &results.FreqDistrib{}},
+	}
+	actions := &Actions{conf: conf, radapter: pub}
+	ctx, rec := newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&flimit=1")
+	actions.FreqDistrib(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "50", rec.Header().Get(effectiveFreqLimitHeader))
+	var args rdb.FreqDistribArgs
+	pub.publishedArgs(t, 0, &args)
+	assert.Equal(t, 50, args.FreqLimit)
+}
+
+func TestFreqDistribRelativeToConc(t *testing.T) {
+	stubAttrChecks(t)
+	pub := &fakePublisher{
+		respond: func(query rdb.Query) results.SerializableResult {
+			return &results.FreqDistrib{
+				ConcSize: 40,
+				Freqs: results.FreqDistribItemList{
+					{Word: "pes", Freq: 30}, {Word: "kočka", Freq: 10},
+				},
+			}
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	concPcts := func(url string) []float64 {
+		ctx, rec := newTestContext(url)
+		actions.FreqDistrib(ctx)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var ans struct {
+			Freqs []struct {
+				ConcPct float64 `json:"concPct"`
+			} `json:"freqs"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+		pcts := make([]float64, len(ans.Freqs))
+		for i, item := range ans.Freqs {
+			pcts[i] = item.ConcPct
+		}
+		return pcts
+	}
+	assert.Equal(t, []float64{0, 0}, concPcts("/freqs/corp1?q=[lemma=\"pes\"]"))
+	assert.InDeltaSlice(
+		t, []float64{75, 25}, concPcts("/freqs/corp1?q=[lemma=\"pes\"]&relativeTo=conc"), 0.01)
+
+	ctx, rec := newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&relativeTo=corpus")
+	actions.FreqDistrib(ctx)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
+
+func TestFreqDistribCaptures(t *testing.T) {
+	stubAttrChecks(t)
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"freqDistrib": &results.FreqDistrib{
+				ConcSize: 7,
+				Freqs: results.FreqDistribItemList{
+					{Word: "pes\tštěkat", Tuple: []string{"pes", "štěkat"}, Freq: 5},
+					{Word: "kočka\tmňoukat", Tuple: []string{"kočka", "mňoukat"}, Freq: 2},
+				},
+			},
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	query := url.QueryEscape(`1:[tag="N.*"] 2:[tag="V.*"]`)
+
+	ctx, rec := newTestContext("/freqs/corp1?q=" + query + "&capture=1&capture=2")
+	actions.FreqDistrib(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var args rdb.FreqDistribArgs
+	pub.publishedArgs(t, 0, &args)
+	assert.Equal(t, "lemma 1:0 lemma 2:0", args.Crit)
+	var ans struct {
+		Freqs []struct {
+			Tuple []string `json:"tuple"`
+			Freq  int64    `json:"freq"`
+		} `json:"freqs"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	if assert.Len(t, ans.Freqs, 2) {
+		assert.Equal(t, []string{"pes", "štěkat"}, ans.Freqs[0].Tuple)
+		assert.Equal(t, []string{"kočka", "mňoukat"}, ans.Freqs[1].Tuple)
+	}
+
+	ctx, _ = newTestContext("/freqs/corp1?q=" + query + "&capture=2&captureAttr=word")
+	actions.FreqDistrib(ctx)
+	pub.publishedArgs(t, 1, &args)
+	assert.Equal(t, "word 2:0", args.Crit)
+}
+
+func TestFreqDistribCapturesInvalid(t *testing.T) {
+	stubAttrChecks(t)
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{"freqDistrib": &results.FreqDistrib{}},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	query := url.QueryEscape(`1:[tag="N.*"] 2:[tag="V.*"]`)
+	for args, status := range map[string]int{
+		"&capture=1&capture=3":         http.StatusUnprocessableEntity,
+		"&capture=1&fcrit=lemma+0~0>0": http.StatusBadRequest,
+	} {
+		ctx, rec := newTestContext("/freqs/corp1?q=" + query + args)
+		actions.FreqDistrib(ctx)
+		assert.Equal(t, status, rec.Code, args)
+	}
+	assert.Empty(t, pub.queries)
+}
+
+func TestFreqDistribAttr(t *testing.T) {
+	stubKnownAttrs(t, "word", "lemma")
+	pub := &fakePublisher{
+		respond: func(query rdb.Query) results.SerializableResult {
+			var args rdb.FreqDistribArgs
+			assert.NoError(t, json.Unmarshal(query.Args, &args))
+			return &results.FreqDistrib{
+				Fcrit: args.Crit,
+				Attr:  corpus.FreqCritAttr(args.Crit),
+			}
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	for i, attr := range []string{"word", "lemma"} {
+		ctx, rec := newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&attr=" + attr)
+		actions.FreqDistrib(ctx)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var args rdb.FreqDistribArgs
+		pub.publishedArgs(t, i, &args)
+		assert.Equal(t, attr+"/e 0~0>0", args.Crit)
+		assert.Equal(t, `[lemma="pes"]`, args.Query)
+		var ans map[string]any
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+		assert.Equal(t, attr, ans["attr"])
+	}
+
+	for url, status := range map[string]int{
+		"/freqs/corp1?q=[lemma=\"pes\"]&attr=tag":                      http.StatusUnprocessableEntity,
+		"/freqs/corp1?q=[lemma=\"pes\"]&attr=word&fcrit=lemma/e+0~0>0": http.StatusBadRequest,
+	} {
+		ctx, rec := newTestContext(url)
+		actions.FreqDistrib(ctx)
+		assert.Equal(t, status, rec.Code, url)
+	}
+	assert.Len(t, pub.queries, 2)
+}
+
+func TestFreqDistribMultivalueSeparator(t *testing.T) {
+	stubKnownAttrs(t, "word", "tag")
+	conf := newTestConf(t)
+	conf.Resources.Get("corp1").PosAttrs[2].MultivalueSeparator = "|"
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"freqDistrib": &results.FreqDistrib{},
+		},
+	}
+	actions := &Actions{conf: conf, radapter: pub}
+	for i, fcrit := range []string{"tag/e 0~0>0", "word/e 0~0>0", "word 0 tag 0"} {
+		ctx, rec := newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&fcrit=" + url.QueryEscape(fcrit))
+		actions.FreqDistrib(ctx)
+		assert.Equal(t, http.StatusOK, rec.Code, fcrit)
+		var args rdb.FreqDistribArgs
+		pub.publishedArgs(t, i, &args)
+		if i == 0 {
+			assert.Equal(t, "|", args.MultivalueSeparator, fcrit)
+
+		} else {
+			assert.Empty(t, args.MultivalueSeparator, fcrit)
+		}
+	}
+}
+
+// drillDownTestCorpus is a tiny corpus used to evaluate (very simple)
+// queries consisting just of attribute conditions
+var drillDownTestCorpus = []map[string]string{
+	{"word": "psa", "lemma": "pes", "genre": "fiction"},
+	{"word": "pes", "lemma": "pes", "genre": "fiction"},
+	{"word": "psa", "lemma": "pes", "genre": "news"},
+	{"word": "psi", "lemma": "pes", "genre": "news"},
+	{"word": "psa", "lemma": "pes", "genre": "news"},
+	{"word": "kočka", "lemma": "kočka", "genre": "news"},
+	{"word": "Ps.", "lemma": "pes", "genre": "sci.fi"},
+}
+
+var drillDownCondRegexp = regexp.MustCompile(`([\w.]+)="((?:[^"\\]|\\.)*)"`)
+
+// evalDrillDownTestQuery calculates a frequency distribution of
+// a query within drillDownTestCorpus. All the attribute conditions
+// found in the query (including the structural ones) must match.
+func evalDrillDownTestQuery(t *testing.T, query, crit string) results.FreqDistribItemList {
+	conds := make(map[string]*regexp.Regexp)
+	for _, m := range drillDownCondRegexp.FindAllStringSubmatch(query, -1) {
+		conds[m[1]] = regexp.MustCompile("^(?:" + m[2] + ")$")
+	}
+	attr := corpus.FreqCritAttr(crit)
+	attr = strings.TrimPrefix(attr, "doc.")
+	freqs := make(map[string]int64)
+	for _, token := range drillDownTestCorpus {
+		matches := true
+		for a, rgxp := range conds {
+			if !rgxp.MatchString(token[a]) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			freqs[token[attr]]++
+		}
+	}
+	ans := make(results.FreqDistribItemList, 0, len(freqs))
+	for w, f := range freqs {
+		ans = append(ans, &results.FreqDistribItem{Word: w, Freq: f})
+	}
+	return ans
+}
+
+func TestFreqDistribDrillDownReproducesItems(t *testing.T) {
+	stubKnownAttrs(t, "word", "lemma", "doc.genre")
+	pub := &fakePublisher{
+		respond: func(query rdb.Query) results.SerializableResult {
+			var args rdb.FreqDistribArgs
+			assert.NoError(t, json.Unmarshal(query.Args, &args))
+			return &results.FreqDistrib{Freqs: evalDrillDownTestQuery(t, args.Query, args.Crit)}
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	fetch := func(query, fcrit string, drillDown bool) results.FreqDistribItemList {
+		reqURL := "/freqs/corp1?q=" + url.QueryEscape(query) + "&fcrit=" + url.QueryEscape(fcrit)
+		if drillDown {
+			reqURL += "&drillDown=1"
+		}
+		ctx, rec := newTestContext(reqURL)
+		actions.FreqDistrib(ctx)
+		assert.Equal(t, http.StatusOK, rec.Code, reqURL)
+		var ans struct {
+			Freqs results.FreqDistribItemList `json:"freqs"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+		return ans.Freqs
+	}
+	for _, fcrit := range []string{"word/e 0~0>0", "doc.genre 0"} {
+		items := fetch(`[lemma="pes"]`, fcrit, true)
+		assert.NotEmpty(t, items)
+		for _, item := range items {
+			if !assert.NotEmpty(t, item.Query, item.Word) {
+				continue
+			}
+			// re-querying the item's query must produce just the item
+			again := fetch(item.Query, fcrit, false)
+			if assert.Len(t, again, 1, item.Query) {
+				assert.Equal(t, item.Word, again[0].Word)
+				assert.Equal(t, item.Freq, again[0].Freq)
+				assert.Empty(t, again[0].Query)
+			}
+		}
+	}
+}
+
+func TestFreqDistribItemsBudget(t *testing.T) {
+	stubKnownAttrs(t, "word")
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"freqDistrib": &results.FreqDistrib{Flimit: 8},
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+
+	ctx, rec := newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&attr=word&itemsBudget=50")
+	actions.FreqDistrib(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"flimit":8`)
+	var args rdb.FreqDistribArgs
+	pub.publishedArgs(t, 0, &args)
+	assert.Equal(t, 50, args.ItemsBudget)
+
+	// the budget is limited by the configured `maxFreqItems` (100)
+	for _, budget := range []string{"-1", "101", "foo"} {
+		ctx, rec = newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&attr=word&itemsBudget=" + budget)
+		actions.FreqDistrib(ctx)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, budget)
+	}
+	assert.Len(t, pub.queries, 1)
+}
+
+func TestFreqDistribItemNorm(t *testing.T) {
+	stubKnownAttrs(t, "word", "doc.genre")
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"freqDistrib": &results.FreqDistrib{},
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+
+	ctx, rec := newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&norm=item&fcrit=" + url.QueryEscape("doc.genre 0"))
+	actions.FreqDistrib(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var args rdb.FreqDistribArgs
+	pub.publishedArgs(t, 0, &args)
+	assert.Equal(t, rdb.NormBasisItem, args.NormBasis)
+
+	// positional attributes have no per-item norms
+	ctx, rec = newTestContext("/freqs/corp1?q=[lemma=\"pes\"]&norm=item&attr=word")
+	actions.FreqDistrib(ctx)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Len(t, pub.queries, 1)
+}
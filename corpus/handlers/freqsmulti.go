@@ -0,0 +1,167 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+
+	"github.com/czcorpus/cnc-gokit/collections"
+	"github.com/czcorpus/cnc-gokit/unireq"
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	dfltMultiFreqsMaxItems = 100
+)
+
+type multiCorpusFreqs struct {
+	Corpora  []string `json:"corpora"`
+	ConcSize int64    `json:"concSize"`
+
+	// CorpusSize is the combined size of all the corpora
+	CorpusSize int64                       `json:"corpusSize"`
+	Fcrit      string                      `json:"fcrit"`
+	Freqs      results.FreqDistribItemList `json:"freqs"`
+
+	// Truncated is true if there are more items than returned
+	Truncated bool `json:"truncated"`
+}
+
+// MultiCorpusFreqDistrib calculates a frequency distribution
+// of a query spanning multiple corpora. Distributions of individual
+// corpora are calculated concurrently and then summed. Relative
+// frequencies are calculated against the combined size of all
+// the corpora. Please note that distributions of individual corpora
+// are limited to the configured `maxFreqItems` most frequent items
+// (this is reported via the `truncated` flag).
+func (a *Actions) MultiCorpusFreqDistrib(ctx *gin.Context) {
+	corpora := ctx.QueryArray("corpus")
+	if len(corpora) == 0 {
+		uniresp.RespondWithErrorJSON(
+			ctx, errors.New("missing `corpus` argument"), http.StatusBadRequest)
+		return
+	}
+	for i, corpusID := range corpora {
+		if a.conf.Resources.Get(corpusID) == nil {
+			uniresp.RespondWithErrorJSON(
+				ctx, fmt.Errorf("corpus %s not found", corpusID), http.StatusNotFound)
+			return
+		}
+		if collections.SliceContains(corpora[:i], corpusID) {
+			uniresp.RespondWithErrorJSON(
+				ctx, fmt.Errorf("duplicate corpus %s", corpusID), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	query := ctx.Query("q")
+	if query == "" {
+		uniresp.RespondWithErrorJSON(
+			ctx, errors.New("missing `q` argument"), http.StatusBadRequest)
+		return
+	}
+	flimit, ok := getFreqLimitArgOrFail(ctx, a.conf, nil)
+	if !ok {
+		return
+	}
+	maxItems, ok := unireq.GetURLIntArgOrFail(ctx, "maxItems", dfltMultiFreqsMaxItems)
+	if !ok {
+		return
+	}
+	if maxItems < 1 {
+		uniresp.RespondWithErrorJSON(
+			ctx, errors.New("`maxItems` must be a positive number"), http.StatusUnprocessableEntity)
+		return
+	}
+	fcrit := ctx.DefaultQuery("fcrit", defaultFreqCrit)
+	for _, corpusID := range corpora {
+		if !validateFcritAttrsOrFail(ctx, a.conf.GetRegistryPath(corpusID), fcrit) {
+			return
+		}
+	}
+
+	waits := make([]<-chan *rdb.WorkerResult, len(corpora))
+	for i, corpusID := range corpora {
+		wait, err := a.publishJob(
+			ctx.Request.Context(),
+			"freqDistrib",
+			rdb.FreqDistribArgs{
+				CorpusPath: a.conf.GetRegistryPath(corpusID),
+				Query:      query,
+				Crit:       fcrit,
+				FreqLimit:  flimit,
+				ItemsLimit: a.conf.MaxFreqItems,
+				MaxResults: a.conf.MaxFreqItems,
+
+				MultivalueSeparator: a.conf.Resources.Get(corpusID).GetMultivalueSeparator(fcrit),
+			},
+		)
+		if err != nil {
+			uniresp.WriteJSONErrorResponse(
+				ctx.Writer,
+				uniresp.NewActionErrorFrom(err),
+				http.StatusInternalServerError,
+			)
+			return
+		}
+		waits[i] = wait
+	}
+
+	merged := &results.FreqDistrib{Fcrit: fcrit, Freqs: make(results.FreqDistribItemList, 0)}
+	for i, wait := range waits {
+		result, err := rdb.DeserializeFreqDistribResult(<-wait)
+		if err != nil {
+			uniresp.WriteJSONErrorResponse(
+				ctx.Writer,
+				uniresp.NewActionErrorFrom(err),
+				http.StatusInternalServerError,
+			)
+			return
+		}
+		if err := result.Err(); err != nil {
+			uniresp.WriteJSONErrorResponse(
+				ctx.Writer,
+				uniresp.NewActionError("failed to calculate freqs. of %s: %s", corpora[i], err),
+				resultErrorStatus(&result),
+			)
+			return
+		}
+		merged.MergeWithOtherCorpus(&result)
+	}
+	merged.Freqs.SortByFreq()
+	if len(merged.Freqs) > maxItems {
+		merged.Freqs = merged.Freqs[:maxItems]
+		merged.Truncated = true
+	}
+	uniresp.WriteJSONResponse(
+		ctx.Writer,
+		multiCorpusFreqs{
+			Corpora:    corpora,
+			ConcSize:   merged.ConcSize,
+			CorpusSize: merged.SearchSize,
+			Fcrit:      fcrit,
+			Freqs:      merged.Freqs,
+			Truncated:  merged.Truncated,
+		},
+	)
+}
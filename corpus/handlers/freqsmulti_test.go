@@ -0,0 +1,103 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"mquery/corpus"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiCorpusFreqDistribSum(t *testing.T) {
+	stubAttrChecks(t)
+	conf := newTestConf(t)
+	conf.Resources = append(conf.Resources, &corpus.CorpusSetup{ID: "corp2"})
+	fixtures := map[string]*results.FreqDistrib{
+		"corp1": {
+			ConcSize:   30,
+			CorpusSize: 1000000,
+			SearchSize: 1000000,
+			Freqs: results.FreqDistribItemList{
+				{Word: "pes", Freq: 20, Norm: 1000000, IPM: 20},
+				{Word: "kočka", Freq: 10, Norm: 1000000, IPM: 10},
+			},
+		},
+		"corp2": {
+			ConcSize:   50,
+			CorpusSize: 3000000,
+			SearchSize: 3000000,
+			Freqs: results.FreqDistribItemList{
+				{Word: "pes", Freq: 20, Norm: 3000000, IPM: 6.667},
+				{Word: "had", Freq: 30, Norm: 3000000, IPM: 10},
+			},
+		},
+	}
+	pub := &fakePublisher{
+		respond: func(query rdb.Query) results.SerializableResult {
+			var args rdb.FreqDistribArgs
+			assert.NoError(t, json.Unmarshal(query.Args, &args))
+			return fixtures[filepath.Base(args.CorpusPath)]
+		},
+	}
+	actions := &Actions{conf: conf, radapter: pub}
+	ctx, rec := newTestContext("/freqs-multi?q=[lemma=\"pes\"]&corpus=corp1&corpus=corp2")
+	actions.MultiCorpusFreqDistrib(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var ans multiCorpusFreqs
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Equal(t, []string{"corp1", "corp2"}, ans.Corpora)
+	assert.Equal(t, int64(80), ans.ConcSize)
+	assert.Equal(t, int64(4000000), ans.CorpusSize)
+	assert.Len(t, ans.Freqs, 3)
+	expected := map[string]int64{"pes": 40, "had": 30, "kočka": 10}
+	for i, item := range ans.Freqs {
+		assert.Equal(t, expected[item.Word], item.Freq, item.Word)
+		assert.Equal(t, int64(4000000), item.Norm)
+		assert.InDelta(t, float64(item.Freq)/4, item.IPM, 0.001)
+		if i > 0 {
+			assert.GreaterOrEqual(t, ans.Freqs[i-1].Freq, item.Freq)
+		}
+	}
+	assert.Len(t, pub.queries, 2)
+}
+
+func TestMultiCorpusFreqDistribInvalidCorpora(t *testing.T) {
+	stubAttrChecks(t)
+	pub := &fakePublisher{}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	statuses := map[string]int{
+		"":                           http.StatusBadRequest,
+		"&corpus=corp2":              http.StatusNotFound,
+		"&corpus=corp1&corpus=corp1": http.StatusUnprocessableEntity,
+		"&corpus=corp1&maxItems=0":   http.StatusUnprocessableEntity,
+	}
+	for args, status := range statuses {
+		ctx, rec := newTestContext("/freqs-multi?q=[lemma=\"pes\"]" + args)
+		actions.MultiCorpusFreqDistrib(ctx)
+		assert.Equal(t, status, rec.Code, args)
+	}
+	assert.Empty(t, pub.queries)
+}
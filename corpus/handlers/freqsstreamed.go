@@ -0,0 +1,179 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"mquery/corpus"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+
+	"github.com/czcorpus/cnc-gokit/unireq"
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	dfltStreamedFreqsMaxItems = 100
+	sseEventTotals            = "totals"
+)
+
+type freqsChunkResult struct {
+	result results.FreqDistrib
+	err    error
+}
+
+// writeSSEMessage writes a single "server-sent event". For an empty
+// `event`, a default (unnamed) event is written.
+func writeSSEMessage(ctx *gin.Context, event string, data any) error {
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if event != "" {
+		ctx.String(http.StatusOK, "event: %s\n", event)
+	}
+	ctx.String(http.StatusOK, "data: %s\n\n", dataJSON)
+	ctx.Writer.Flush()
+	return nil
+}
+
+// FreqDistribStreamed is a variant of FreqDistribParallel with the output
+// based on "server-sent events". Once a chunk of the split corpus is
+// calculated, its data are merged with the previous ones and the current
+// most frequent items are sent (as a default event with the StreamData
+// type). Once all the chunks are processed, a final `totals` event with
+// the complete result is sent. In case the client disconnects, the
+// remaining chunks are not waited for.
+func (a *Actions) FreqDistribStreamed(ctx *gin.Context) {
+	queryProps := DetermineQueryProps(ctx, a.conf)
+	if queryProps.hasError() {
+		uniresp.RespondWithErrorJSON(ctx, queryProps.err, queryProps.status)
+		return
+	}
+	flimit, ok := getFreqLimitArgOrFail(ctx, a.conf, queryProps.corpusConf)
+	if !ok {
+		return
+	}
+	maxItems, ok := unireq.GetURLIntArgOrFail(ctx, "maxItems", dfltStreamedFreqsMaxItems)
+	if !ok {
+		return
+	}
+	if maxItems < 1 {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid `maxItems` value (must be >= 1)"),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	fcrit, ok := getFreqCritOrFail(ctx, queryProps.query)
+	if !ok {
+		return
+	}
+	corpusPath := a.conf.GetRegistryPath(queryProps.corpus)
+	if !validateFcritAttrsOrFail(ctx, corpusPath, fcrit) {
+		return
+	}
+	sc, err := corpus.OpenSplitCorpus(a.conf.SplitCorporaDir, corpusPath)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer,
+			uniresp.NewActionErrorFrom(err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	ctx.Writer.Header().Set("Content-Type", "text/event-stream")
+	ctx.Writer.Header().Set("Cache-Control", "no-cache")
+	ctx.Writer.Header().Set("Connection", "keep-alive")
+	defer ctx.Writer.Flush()
+
+	// the channel is large enough for all the chunks so no goroutine
+	// remains blocked in case we stop reading (e.g. client disconnected)
+	chunks := make(chan freqsChunkResult, len(sc.Subcorpora))
+	for _, subc := range sc.Subcorpora {
+		wait, err := a.publishJob(
+			ctx.Request.Context(),
+			"freqDistrib",
+			rdb.FreqDistribArgs{
+				CorpusPath: corpusPath,
+				SubcPath:   subc,
+				Query:      queryProps.query,
+				Crit:       fcrit,
+				FreqLimit:  flimit,
+				ItemsLimit: a.conf.MaxFreqItems,
+				MaxResults: maxItems,
+
+				MultivalueSeparator: queryProps.corpusConf.GetMultivalueSeparator(fcrit),
+			},
+		)
+		if err != nil {
+			chunks <- freqsChunkResult{err: err}
+			continue
+		}
+		go func() {
+			result, err := rdb.DeserializeFreqDistribResult(<-wait)
+			if err == nil {
+				err = result.Err()
+			}
+			chunks <- freqsChunkResult{result: result, err: err}
+		}()
+	}
+
+	merger := results.NewFreqDistribMerger()
+	for i := 1; i <= len(sc.Subcorpora); i++ {
+		var chunk freqsChunkResult
+		select {
+		case chunk = <-chunks:
+		case <-ctx.Request.Context().Done():
+			log.Debug().Str("corpus", queryProps.corpus).Msg("client disconnected, stopping freqs stream")
+			return
+		}
+		if chunk.err != nil {
+			log.Error().Err(chunk.err).Msg("failed to calculate freq. distribution chunk")
+			a.writeStreamingError(ctx, chunk.err)
+			return
+		}
+		merger.Add(&chunk.result)
+		msg := StreamData{
+			Entries:  *merger.TopK(maxItems),
+			ChunkNum: i,
+			Total:    len(sc.Subcorpora),
+		}
+		if err := writeSSEMessage(ctx, "", msg); err != nil {
+			a.writeStreamingError(ctx, err)
+			return
+		}
+	}
+	final := merger.TopK(maxItems)
+	final.Fcrit = fcrit
+	msg := StreamData{
+		Entries:  *final,
+		ChunkNum: len(sc.Subcorpora),
+		Total:    len(sc.Subcorpora),
+	}
+	if err := writeSSEMessage(ctx, sseEventTotals, msg); err != nil {
+		a.writeStreamingError(ctx, err)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testSSEvent struct {
+	event string
+	data  string
+}
+
+// parseTestSSEvents parses a "server-sent events" stream
+func parseTestSSEvents(t *testing.T, body string) []testSSEvent {
+	assert.True(t, strings.HasSuffix(body, "\n\n"), "stream must end with an empty line")
+	ans := make([]testSSEvent, 0, 10)
+	for _, block := range strings.Split(strings.TrimSuffix(body, "\n\n"), "\n\n") {
+		var ev testSSEvent
+		for _, line := range strings.Split(block, "\n") {
+			key, value, ok := strings.Cut(line, ": ")
+			assert.True(t, ok, "invalid SSE line: %s", line)
+			switch key {
+			case "event":
+				ev.event = value
+			case "data":
+				ev.data = value
+			default:
+				assert.Fail(t, "unexpected SSE field", key)
+			}
+		}
+		ans = append(ans, ev)
+	}
+	return ans
+}
+
+// newTestStreamedFreqs creates a split corpus with three chunks,
+// each of them containing items `a` (freq. 1) and `b` (freq. 2)
+// and the first one also an item `c` (freq. 10)
+func newTestStreamedFreqs(t *testing.T) (*Actions, *fakePublisher) {
+	stubAttrChecks(t)
+	actions, pub, _ := newTestSplitCorpus(t, 3)
+	pub.respond = func(query rdb.Query) results.SerializableResult {
+		var args rdb.FreqDistribArgs
+		assert.NoError(t, json.Unmarshal(query.Args, &args))
+		ans := &results.FreqDistrib{
+			ConcSize: 3,
+			Freqs: results.FreqDistribItemList{
+				{Word: "b", Freq: 2},
+				{Word: "a", Freq: 1},
+			},
+		}
+		if filepath.Base(args.SubcPath) == "0.subc" {
+			ans.ConcSize += 10
+			ans.Freqs = append(results.FreqDistribItemList{{Word: "c", Freq: 10}}, ans.Freqs...)
+		}
+		return ans
+	}
+	return actions, pub
+}
+
+func TestFreqDistribStreamed(t *testing.T) {
+	actions, pub := newTestStreamedFreqs(t)
+	ctx, rec := newTestContext("/freqs-stream/corp1?q=[lemma=\"pes\"]&attr=lemma&maxItems=2")
+	actions.FreqDistribStreamed(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+	assert.Len(t, pub.queries, 3)
+
+	events := parseTestSSEvents(t, rec.Body.String())
+	if !assert.Len(t, events, 4) {
+		return
+	}
+	for i, ev := range events {
+		var data StreamData
+		assert.NoError(t, json.Unmarshal([]byte(ev.data), &data))
+		assert.Empty(t, data.Error)
+		assert.Equal(t, 3, data.Total)
+		assert.LessOrEqual(t, len(data.Entries.Freqs), 2)
+		if i < 3 {
+			assert.Empty(t, ev.event)
+			assert.Equal(t, i+1, data.ChunkNum)
+
+		} else {
+			assert.Equal(t, sseEventTotals, ev.event)
+			assert.Equal(t, 3, data.ChunkNum)
+			assert.Equal(t, int64(19), data.Entries.ConcSize)
+			assert.Equal(t, "lemma/e 0~0>0", data.Entries.Fcrit)
+			words := make(map[string]int64)
+			for _, item := range data.Entries.Freqs {
+				words[item.Word] = item.Freq
+			}
+			assert.Equal(t, map[string]int64{"c": 10, "b": 6}, words)
+		}
+	}
+}
+
+func TestFreqDistribStreamedWorkerError(t *testing.T) {
+	actions, pub := newTestStreamedFreqs(t)
+	pub.respond = func(query rdb.Query) results.SerializableResult {
+		return &results.FreqDistrib{Error: "failed to calculate freqs"}
+	}
+	ctx, rec := newTestContext("/freqs-stream/corp1?q=[lemma=\"pes\"]&attr=lemma")
+	actions.FreqDistribStreamed(ctx)
+	events := parseTestSSEvents(t, rec.Body.String())
+	if assert.Len(t, events, 1) {
+		assert.Contains(t, events[0].data, "failed to calculate freqs")
+	}
+}
+
+// silentPublisher accepts all the queries but never responds
+type silentPublisher struct {
+	fakePublisher
+}
+
+func (sp *silentPublisher) PublishQueryCtx(
+	ctx context.Context, query rdb.Query,
+) (<-chan *rdb.WorkerResult, error) {
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+	sp.queries = append(sp.queries, query)
+	return make(chan *rdb.WorkerResult), nil
+}
+
+func TestFreqDistribStreamedClientDisconnect(t *testing.T) {
+	stubAttrChecks(t)
+	actions, _, _ := newTestSplitCorpus(t, 3)
+	pub := &silentPublisher{}
+	actions.radapter = pub
+	ctx, rec := newTestContext("/freqs-stream/corp1?q=[lemma=\"pes\"]&attr=lemma")
+	reqCtx, cancel := context.WithCancel(context.Background())
+	ctx.Request = ctx.Request.WithContext(reqCtx)
+	cancel()
+	actions.FreqDistribStreamed(ctx)
+	assert.Len(t, pub.queries, 3)
+	assert.Empty(t, rec.Body.String())
+}
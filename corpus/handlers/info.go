@@ -49,6 +49,14 @@ type corplistResponse struct {
 type corpusInfoResponse struct {
 	Corpus *results.CorpusInfo `json:"corpus"`
 	Locale string              `json:"locale"`
+
+	// ConcMaxItems is the maximum number of lines
+	// returned by concordance endpoints
+	ConcMaxItems int `json:"concMaxItems"`
+
+	// DefaultCollMeasure is a collocation measure applied
+	// in case a client does not specify `measure`
+	DefaultCollMeasure string `json:"defaultCollMeasure"`
 }
 
 func getTranslation(data map[string]string, lang string) string {
@@ -84,6 +92,10 @@ func (a *Actions) CorpusInfo(ctx *gin.Context) {
 		Locale: lang,
 		Corpus: info,
 	}
+	if corpusConf := a.conf.Resources.Get(ctx.Param("corpusId")); corpusConf != nil {
+		ans.ConcMaxItems = concMaxItems(corpusConf)
+		ans.DefaultCollMeasure = a.conf.GetDefaultCollMeasure(corpusConf)
+	}
 	uniresp.WriteJSONResponse(ctx.Writer, ans)
 }
 
@@ -0,0 +1,79 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"mquery/cnf"
+	"mquery/corpus/infoload"
+	"mquery/mango"
+	"mquery/results"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorpusInfoConcMaxItems(t *testing.T) {
+	conf := newTestConf(t)
+	conf.Resources.Get("corp1").MaximumRecords = 5 * mango.MaxRecordsInternalLimit
+	assert.NoError(t, os.WriteFile(conf.GetRegistryPath("corp1"), []byte{}, 0644))
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"corpusInfo": &results.CorpusInfo{},
+		},
+	}
+	actions := &Actions{
+		conf:         conf,
+		radapter:     pub,
+		infoProvider: infoload.NewManatee(pub, conf),
+		locales:      cnf.LocalesConf{{Name: "en", IsDefault: true}},
+	}
+	ctx, rec := newTestContext("/info/corp1")
+	actions.CorpusInfo(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var ans map[string]any
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Equal(t, float64(mango.MaxRecordsInternalLimit), ans["concMaxItems"])
+}
+
+func TestCorpusInfoDefaultCollMeasure(t *testing.T) {
+	conf := newTestConf(t)
+	conf.DefaultCollMeasure = "logDice"
+	conf.Resources.Get("corp1").DefaultCollMeasure = "tScore"
+	assert.NoError(t, os.WriteFile(conf.GetRegistryPath("corp1"), []byte{}, 0644))
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"corpusInfo": &results.CorpusInfo{},
+		},
+	}
+	actions := &Actions{
+		conf:         conf,
+		radapter:     pub,
+		infoProvider: infoload.NewManatee(pub, conf),
+		locales:      cnf.LocalesConf{{Name: "en", IsDefault: true}},
+	}
+	ctx, rec := newTestContext("/info/corp1")
+	actions.CorpusInfo(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var ans map[string]any
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Equal(t, "tScore", ans["defaultCollMeasure"])
+}
@@ -0,0 +1,153 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"fmt"
+	"mquery/corpus"
+	"mquery/corpus/edit"
+	"net/http"
+
+	"github.com/czcorpus/cnc-gokit/unireq"
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+type prepStageStatus string
+
+const (
+	prepStageDone    prepStageStatus = "done"
+	prepStageSkipped prepStageStatus = "skipped"
+	prepStageFailed  prepStageStatus = "failed"
+
+	prepStageSplit        = "split"
+	prepStageCollFreqData = "collFreqData"
+)
+
+type prepStageReport struct {
+	Stage  string          `json:"stage"`
+	Status prepStageStatus `json:"status"`
+	Info   string          `json:"info,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+type corpusPrepReport struct {
+	Corpus string            `json:"corpus"`
+	Stages []prepStageReport `json:"stages"`
+	OK     bool              `json:"ok"`
+}
+
+func (r *corpusPrepReport) addStage(stage string, status prepStageStatus, info string, err error) {
+	item := prepStageReport{Stage: stage, Status: status, Info: info}
+	if err != nil {
+		item.Error = err.Error()
+		log.Error().Err(err).Str("corpus", r.Corpus).Str("stage", stage).Msg("corpus preparation failed")
+
+	} else {
+		log.Info().Str("corpus", r.Corpus).Str("stage", stage).Str("status", string(status)).Msg(info)
+	}
+	r.Stages = append(r.Stages, item)
+}
+
+// PrepareCorpus runs all the steps needed to make a corpus
+// ready for the parallel (`freqs2`, `text-types2`) and collocation
+// calculations - i.e. it splits the corpus into chunks and then
+// calculates frequency data of the chunks. Stages (or chunks) already
+// prepared are skipped so the action can be safely repeated e.g. after
+// a failure. The response reports a status of each stage.
+func (a *Actions) PrepareCorpus(ctx *gin.Context) {
+	corpusID := ctx.Param("corpusId")
+	corpPath := a.conf.GetRegistryPath(corpusID)
+	chunkSize, ok := unireq.GetURLIntArgOrFail(ctx, "chunkSize", int(a.conf.MultiprocChunkSize))
+	if !ok {
+		return
+	}
+	reqArgs, ok := a.getSplitCorpusArgsOrFail(ctx, corpusID)
+	if !ok {
+		return
+	}
+	report := corpusPrepReport{Corpus: corpusID, Stages: make([]prepStageReport, 0, 2)}
+
+	// stage 1: split
+	var corp *corpus.SplitCorpus
+	exists, err := edit.SplitCorpusExists(a.conf.SplitCorporaDir, corpPath)
+	if err != nil {
+		report.addStage(prepStageSplit, prepStageFailed, "", err)
+		uniresp.WriteJSONResponseWithStatus(ctx.Writer, http.StatusInternalServerError, report)
+		return
+	}
+	if exists {
+		corp, err = corpus.OpenSplitCorpus(a.conf.SplitCorporaDir, corpPath)
+		if err != nil {
+			report.addStage(prepStageSplit, prepStageFailed, "", err)
+			uniresp.WriteJSONResponseWithStatus(ctx.Writer, http.StatusInternalServerError, report)
+			return
+		}
+		report.addStage(
+			prepStageSplit, prepStageSkipped,
+			fmt.Sprintf("split corpus already exists (%d chunks)", len(corp.Subcorpora)), nil)
+
+	} else {
+		corp, err = edit.SplitCorpus(
+			a.conf.SplitCorporaDir, corpPath, int64(chunkSize), a.conf.MaxSplitChunks)
+		if err != nil {
+			report.addStage(prepStageSplit, prepStageFailed, "", err)
+			uniresp.WriteJSONResponseWithStatus(ctx.Writer, http.StatusInternalServerError, report)
+			return
+		}
+		report.addStage(
+			prepStageSplit, prepStageDone,
+			fmt.Sprintf("created %d chunks", len(corp.Subcorpora)), nil)
+	}
+
+	// stage 2: frequency data of the chunks
+	missing := make([]string, 0, len(corp.Subcorpora))
+	for _, subc := range corp.Subcorpora {
+		exists, err := edit.CollFreqDataExists(subc, reqArgs.Attrs, reqArgs.Structs)
+		if err != nil {
+			report.addStage(prepStageCollFreqData, prepStageFailed, "", err)
+			uniresp.WriteJSONResponseWithStatus(ctx.Writer, http.StatusInternalServerError, report)
+			return
+		}
+		if !exists {
+			missing = append(missing, subc)
+		}
+	}
+	if len(missing) == 0 {
+		report.addStage(
+			prepStageCollFreqData, prepStageSkipped, "frequency data of all the chunks already exist", nil)
+
+	} else {
+		numSkipped, err := a.calcCollFreqData(ctx.Request.Context(), corpPath, missing, reqArgs)
+		if err != nil {
+			report.addStage(prepStageCollFreqData, prepStageFailed, "", err)
+			uniresp.WriteJSONResponseWithStatus(ctx.Writer, http.StatusInternalServerError, report)
+			return
+		}
+		info := fmt.Sprintf(
+			"calculated data for %d of %d chunks", len(missing)-numSkipped, len(corp.Subcorpora))
+		if numSkipped > 0 {
+			info += fmt.Sprintf(" (%d chunks already being calculated by another request)", numSkipped)
+		}
+		report.addStage(prepStageCollFreqData, prepStageDone, info, nil)
+	}
+	report.OK = true
+	uniresp.WriteJSONResponse(ctx.Writer, report)
+}
@@ -0,0 +1,233 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestSplitCorpus creates a split corpus `corp1` with
+// `numChunks` chunks (without any frequency data)
+func newTestSplitCorpus(t *testing.T, numChunks int) (*Actions, *fakePublisher, []string) {
+	conf := newTestConf(t)
+	conf.SplitCorporaDir = filepath.Join(t.TempDir(), "split")
+	dir := filepath.Join(conf.SplitCorporaDir, "corp1")
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	chunks := make([]string, numChunks)
+	for i := range chunks {
+		chunks[i] = filepath.Join(dir, fmt.Sprintf("%d", i))
+		assert.NoError(t, os.WriteFile(chunks[i]+".subc", []byte{}, 0644))
+	}
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"calcCollFreqData": &results.CollFreqData{},
+		},
+	}
+	return &Actions{conf: conf, radapter: pub}, pub, chunks
+}
+
+// writeTestCollFreqData creates the frequency data files
+// of a chunk as if they were calculated by a worker
+func writeTestCollFreqData(t *testing.T, chunk string) {
+	for _, suff := range []string{".word.frq", ".lemma.frq", ".doc.token"} {
+		assert.NoError(t, os.WriteFile(chunk+suff, []byte{}, 0644))
+	}
+}
+
+func runTestPrepareCorpus(t *testing.T, actions *Actions) corpusPrepReport {
+	ctx, rec := newTestContext("/prepare/corp1")
+	actions.PrepareCorpus(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var ans corpusPrepReport
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	return ans
+}
+
+func stageStatuses(report corpusPrepReport) []string {
+	ans := make([]string, len(report.Stages))
+	for i, stage := range report.Stages {
+		ans[i] = fmt.Sprintf("%s:%s", stage.Stage, stage.Status)
+	}
+	return ans
+}
+
+func TestPrepareCorpusStages(t *testing.T) {
+	actions, pub, chunks := newTestSplitCorpus(t, 3)
+	writeTestCollFreqData(t, chunks[1])
+
+	report := runTestPrepareCorpus(t, actions)
+	assert.True(t, report.OK)
+	assert.Equal(
+		t,
+		[]string{"split:skipped", "collFreqData:done"},
+		stageStatuses(report),
+	)
+	published := make([]string, len(pub.queries))
+	for i := range pub.queries {
+		var args rdb.CalcCollFreqDataArgs
+		pub.publishedArgs(t, i, &args)
+		published[i] = args.SubcPath
+	}
+	assert.ElementsMatch(t, []string{chunks[0] + ".subc", chunks[2] + ".subc"}, published)
+	assert.Empty(t, pub.jobKeys)
+}
+
+func TestPrepareCorpusIdempotent(t *testing.T) {
+	actions, pub, chunks := newTestSplitCorpus(t, 2)
+	for _, chunk := range chunks {
+		writeTestCollFreqData(t, chunk)
+	}
+	for i := 0; i < 2; i++ {
+		report := runTestPrepareCorpus(t, actions)
+		assert.True(t, report.OK)
+		assert.Equal(
+			t,
+			[]string{"split:skipped", "collFreqData:skipped"},
+			stageStatuses(report),
+		)
+	}
+	assert.Empty(t, pub.queries)
+}
+
+func TestPrepareCorpusWorkerError(t *testing.T) {
+	actions, pub, _ := newTestSplitCorpus(t, 2)
+	pub.results["calcCollFreqData"] = &results.CollFreqData{Error: "mktokencov failed"}
+
+	ctx, rec := newTestContext("/prepare/corp1")
+	actions.PrepareCorpus(ctx)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	var report corpusPrepReport
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.False(t, report.OK)
+	assert.Equal(
+		t,
+		[]string{"split:skipped", "collFreqData:failed"},
+		stageStatuses(report),
+	)
+	assert.NotEmpty(t, report.Stages[1].Error)
+}
+
+// publishedCollFreqAttrs returns attributes of all the published
+// calcCollFreqData jobs
+func publishedCollFreqAttrs(t *testing.T, pub *fakePublisher) [][]string {
+	ans := make([][]string, len(pub.queries))
+	for i := range pub.queries {
+		var args rdb.CalcCollFreqDataArgs
+		pub.publishedArgs(t, i, &args)
+		ans[i] = args.Attrs
+	}
+	return ans
+}
+
+func TestPrepareCorpusDefaultCollFreqAttrs(t *testing.T) {
+	actions, pub, _ := newTestSplitCorpus(t, 2)
+	runTestPrepareCorpus(t, actions)
+	assert.Equal(
+		t,
+		[][]string{{"word", "lemma"}, {"word", "lemma"}},
+		publishedCollFreqAttrs(t, pub),
+	)
+}
+
+func TestPrepareCorpusConfiguredCollFreqAttrs(t *testing.T) {
+	actions, pub, _ := newTestSplitCorpus(t, 2)
+	actions.conf.Resources.Get("corp1").CollFreqDataAttrs = []string{"word", "lemma", "tag"}
+	runTestPrepareCorpus(t, actions)
+	assert.Equal(
+		t,
+		[][]string{{"word", "lemma", "tag"}, {"word", "lemma", "tag"}},
+		publishedCollFreqAttrs(t, pub),
+	)
+}
+
+func TestPrepareCorpusCollFreqAttrsFromBody(t *testing.T) {
+	actions, pub, _ := newTestSplitCorpus(t, 1)
+	actions.conf.Resources.Get("corp1").CollFreqDataAttrs = []string{"word", "lemma", "tag"}
+	ctx, rec := newTestContext("/prepare/corp1")
+	ctx.Request = httptest.NewRequest(
+		http.MethodPost, "/prepare/corp1", strings.NewReader(`{"attrs": ["tag"]}`))
+	actions.PrepareCorpus(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, [][]string{{"tag"}}, publishedCollFreqAttrs(t, pub))
+}
+
+func TestPrepareCorpusUnknownCollFreqAttr(t *testing.T) {
+	actions, pub, _ := newTestSplitCorpus(t, 1)
+	ctx, rec := newTestContext("/prepare/corp1")
+	ctx.Request = httptest.NewRequest(
+		http.MethodPost, "/prepare/corp1", strings.NewReader(`{"attrs": ["foo"]}`))
+	actions.PrepareCorpus(ctx)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Empty(t, pub.queries)
+}
+
+func TestPrepareCorpusSkipsRunningJobs(t *testing.T) {
+	actions, pub, chunks := newTestSplitCorpus(t, 3)
+	// jobs for the first two chunks are already being
+	// processed (e.g. due to a retried request)
+	for _, chunk := range chunks[:2] {
+		args := rdb.CalcCollFreqDataArgs{
+			CorpusPath: actions.conf.GetRegistryPath("corp1"),
+			SubcPath:   chunk + ".subc",
+			Attrs:      dfltSplitCollFreqAttrs,
+			Structs:    dfltSplitCollFreqStructs,
+		}
+		acquired, err := pub.AcquireJobKey(args.IdempotencyKey())
+		assert.NoError(t, err)
+		assert.True(t, acquired)
+	}
+	report := runTestPrepareCorpus(t, actions)
+	assert.True(t, report.OK)
+	assert.Equal(
+		t,
+		[]string{"split:skipped", "collFreqData:done"},
+		stageStatuses(report),
+	)
+	assert.Contains(t, report.Stages[1].Info, "calculated data for 1 of 3 chunks")
+	assert.Contains(t, report.Stages[1].Info, "2 chunks already being calculated")
+	if assert.Len(t, pub.queries, 1) {
+		var args rdb.CalcCollFreqDataArgs
+		pub.publishedArgs(t, 0, &args)
+		assert.Equal(t, chunks[2]+".subc", args.SubcPath)
+	}
+	// keys of the skipped jobs belong to the other request
+	assert.Len(t, pub.jobKeys, 2)
+}
+
+func TestSplitCorpusRepeated(t *testing.T) {
+	actions, pub, _ := newTestSplitCorpus(t, 2)
+	for i := 0; i < 2; i++ {
+		ctx, rec := newTestContext("/split/corp1")
+		actions.SplitCorpus(ctx)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	}
+	assert.Empty(t, pub.queries)
+	assert.Empty(t, pub.jobKeys)
+}
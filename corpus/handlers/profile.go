@@ -0,0 +1,125 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mquery/mango"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+
+	"github.com/czcorpus/cnc-gokit/unireq"
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	dfltProfileConcLines = 10
+)
+
+type concCollProfile struct {
+	Concordance  results.Concordance   `json:"concordance"`
+	Collocations *results.Collocations `json:"collocations"`
+}
+
+// publishJob marshals provided args and publishes a new query
+// to be processed by a worker.
+func (a *Actions) publishJob(
+	ctx context.Context, fn string, args any,
+) (<-chan *rdb.WorkerResult, error) {
+	rawArgs, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	return a.radapter.PublishQueryCtx(ctx, rdb.Query{
+		Func: fn,
+		Args: rawArgs,
+	})
+}
+
+// ConcCollProfile provides both a (short) sample concordance and
+// collocations of a searched expression in a single response. Both
+// jobs are published to the worker queue at once so they are processed
+// concurrently (in case a free worker is available). A failure of one
+// of the jobs does not prevent the other from being returned - the
+// error is reported via the `error` property of the respective section.
+// Only if both jobs fail, the handler responds with an error status.
+func (a *Actions) ConcCollProfile(ctx *gin.Context) {
+	queryProps := DetermineQueryProps(ctx, a.conf)
+	if queryProps.hasError() {
+		uniresp.RespondWithErrorJSON(ctx, queryProps.err, queryProps.status)
+		return
+	}
+	collArgs, ok := a.collArgsFromRequest(ctx, queryProps)
+	if !ok {
+		return
+	}
+	concArgs := a.concArgs(queryProps.corpusConf, queryProps.query)
+	concArgs.SubcPath = queryProps.subcPath
+	concLines, ok := unireq.GetURLIntArgOrFail(ctx, "concLines", dfltProfileConcLines)
+	if !ok {
+		return
+	}
+	if concLines < concArgs.MaxItems {
+		concArgs.MaxItems = concLines
+	}
+	if err := mango.ValidateConcordanceArgs(
+		concArgs.StartLine, concArgs.MaxItems, concArgs.MaxContext); err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	var ans concCollProfile
+	concWait, concErr := a.publishJob(ctx.Request.Context(), "concordance", concArgs)
+	collWait, collErr := a.publishJob(ctx.Request.Context(), "collocations", collArgs)
+
+	if concErr != nil {
+		ans.Concordance.Error = concErr.Error()
+
+	} else {
+		var err error
+		ans.Concordance, err = rdb.DeserializeConcordanceResult(<-concWait)
+		if err != nil {
+			ans.Concordance.Error = err.Error()
+		}
+	}
+	if collErr != nil {
+		ans.Collocations = &results.Collocations{Error: collErr.Error()}
+
+	} else {
+		colls, err := rdb.DeserializeCollocationsResult(<-collWait)
+		if err != nil {
+			colls.Error = err.Error()
+		}
+		ans.Collocations = &colls
+	}
+
+	if ans.Concordance.Err() != nil && ans.Collocations.Err() != nil {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			errors.Join(ans.Concordance.Err(), ans.Collocations.Err()),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, &ans)
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"mquery/mango"
+	"mquery/results"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testProfileResponse struct {
+	Concordance struct {
+		Lines    []json.RawMessage `json:"lines"`
+		ConcSize int               `json:"concSize"`
+		Error    string            `json:"error"`
+	} `json:"concordance"`
+	Collocations struct {
+		Colls []mango.GoCollItem `json:"colls"`
+		Error string             `json:"error"`
+	} `json:"collocations"`
+}
+
+func runTestProfile(t *testing.T, pub *fakePublisher) (testProfileResponse, int) {
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	ctx, rec := newTestContext("/conc-coll-profile/corp1?q=[lemma=\"pes\"]")
+	actions.ConcCollProfile(ctx)
+	var ans testProfileResponse
+	if rec.Code == http.StatusOK {
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	}
+	return ans, rec.Code
+}
+
+func testProfileConc() *results.Concordance {
+	return &results.Concordance{
+		Lines:    []results.ConcordanceLine{{TokenPos: 10}, {TokenPos: 20}},
+		ConcSize: 2,
+	}
+}
+
+func testProfileColls() *results.Collocations {
+	return &results.Collocations{
+		Colls: []*mango.GoCollItem{{Word: "štěkat", Score: 9.1, Freq: 12}},
+	}
+}
+
+func TestConcCollProfileBothSections(t *testing.T) {
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"concordance":  testProfileConc(),
+			"collocations": testProfileColls(),
+		},
+	}
+	ans, status := runTestProfile(t, pub)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, ans.Concordance.Lines, 2)
+	assert.Equal(t, 2, ans.Concordance.ConcSize)
+	assert.Equal(t, "", ans.Concordance.Error)
+	assert.Equal(t, []mango.GoCollItem{{Word: "štěkat", Score: 9.1, Freq: 12}}, ans.Collocations.Colls)
+	assert.Equal(t, "", ans.Collocations.Error)
+	assert.Len(t, pub.queries, 2)
+}
+
+func TestConcCollProfileFailedCollocations(t *testing.T) {
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"concordance":  testProfileConc(),
+			"collocations": &results.Collocations{Error: "colls failed"},
+		},
+	}
+	ans, status := runTestProfile(t, pub)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, ans.Concordance.Lines, 2)
+	assert.Equal(t, "colls failed", ans.Collocations.Error)
+}
+
+func TestConcCollProfileFailedConcordance(t *testing.T) {
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"collocations": testProfileColls(),
+		},
+		pubErrors: map[string]error{"concordance": errors.New("queue unavailable")},
+	}
+	ans, status := runTestProfile(t, pub)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "queue unavailable", ans.Concordance.Error)
+	assert.Len(t, ans.Collocations.Colls, 1)
+}
+
+func TestConcCollProfileBothFailed(t *testing.T) {
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"concordance":  &results.Concordance{Error: "conc failed"},
+			"collocations": &results.Collocations{Error: "colls failed"},
+		},
+	}
+	_, status := runTestProfile(t, pub)
+	assert.Equal(t, http.StatusInternalServerError, status)
+}
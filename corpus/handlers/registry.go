@@ -0,0 +1,105 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"fmt"
+	"mquery/corpus"
+	"net/http"
+
+	"github.com/czcorpus/cnc-gokit/fs"
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+type registryResponse struct {
+	Corpus   string                `json:"corpus"`
+	Registry *corpus.RegistryBlock `json:"registry"`
+}
+
+type citationResponse struct {
+	Corpus   string            `json:"corpus"`
+	Citation map[string]string `json:"citation"`
+}
+
+// CorpusRegistry shows a parsed registry file of a corpus
+// (for debugging of corpora configuration). Values of configured
+// sensitive keys (see CorporaSetup.RegistryRedactedKeys) are hidden.
+func (a *Actions) CorpusRegistry(ctx *gin.Context) {
+	corpusID := ctx.Param("corpusId")
+	regPath := a.conf.GetRegistryPath(corpusID)
+	exists, err := fs.IsFile(regPath)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("corpus %s not found", corpusID), http.StatusNotFound)
+		return
+	}
+	reg, err := corpus.ParseRegistry(regPath)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return
+	}
+	reg.Redact(a.conf.RegistryRedactedKeys)
+	uniresp.WriteJSONResponse(ctx.Writer, registryResponse{Corpus: corpusID, Registry: reg})
+}
+
+// CorpusCitation provides citation/attribution information about
+// a corpus as stored in its registry file. Citation fields and their
+// respective registry keys are configurable (see CorporaSetup.CitationFields).
+// Missing values are returned as empty strings except for `name`
+// and `url` which default to the configured corpus name and web URL.
+func (a *Actions) CorpusCitation(ctx *gin.Context) {
+	corpusID := ctx.Param("corpusId")
+	corpusConf := a.conf.Resources.Get(corpusID)
+	if corpusConf == nil {
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("corpus %s not found", corpusID), http.StatusNotFound)
+		return
+	}
+	corpusPath := a.conf.GetRegistryPath(corpusID)
+	ans := citationResponse{
+		Corpus:   corpusID,
+		Citation: make(map[string]string),
+	}
+	for field, key := range a.conf.CitationFields {
+		value, err := getCorpusConf(corpusPath, key)
+		if err != nil {
+			// Manatee reports unknown keys as errors
+			log.Debug().Err(err).Str("corpus", corpusID).Str("key", key).Msg("citation value not available")
+		}
+		ans.Citation[field] = value
+	}
+	if v, ok := ans.Citation["name"]; ok && v == "" {
+		ans.Citation["name"] = getTranslation(corpusConf.FullName, "en")
+		if ans.Citation["name"] == "" {
+			ans.Citation["name"] = corpusID
+		}
+	}
+	if v, ok := ans.Citation["url"]; ok && v == "" {
+		ans.Citation["url"] = corpusConf.WebURL
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, ans)
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"mquery/corpus"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorpusRegistry(t *testing.T) {
+	conf := newTestConf(t)
+	conf.RegistryRedactedKeys = []string{"PATH"}
+	assert.NoError(t, os.WriteFile(
+		conf.GetRegistryPath("corp1"),
+		[]byte("NAME \"Corpus 1\"\nPATH /var/corpora/corp1\nATTRIBUTE word\nSTRUCTURE doc {\n\tATTRIBUTE id\n}\n"),
+		0644,
+	))
+	actions := &Actions{conf: conf}
+	ctx, rec := newTestContext("/tools/registry/corp1")
+	actions.CorpusRegistry(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var ans registryResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Equal(t, "corp1", ans.Corpus)
+	assert.Equal(t, "Corpus 1", ans.Registry.Conf["NAME"])
+	assert.Equal(t, corpus.RegistryRedactedValue, ans.Registry.Conf["PATH"])
+	assert.Contains(t, ans.Registry.Attributes, "word")
+	assert.Contains(t, ans.Registry.Structures["doc"].Attributes, "id")
+}
+
+func TestCorpusRegistryNotFound(t *testing.T) {
+	actions := &Actions{conf: newTestConf(t)}
+	ctx, rec := newTestContext("/tools/registry/corp1")
+	actions.CorpusRegistry(ctx)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+// stubRegistryConf makes getCorpusConf read values from actual
+// registry files instead of Manatee
+func stubRegistryConf(t *testing.T) {
+	orig := getCorpusConf
+	getCorpusConf = func(corpusPath, prop string) (string, error) {
+		reg, err := corpus.ParseRegistry(corpusPath)
+		if err != nil {
+			return "", err
+		}
+		v, ok := reg.Conf[prop]
+		if !ok {
+			return "", fmt.Errorf("CorpInfoNotFound (%s)", prop)
+		}
+		return v, nil
+	}
+	t.Cleanup(func() { getCorpusConf = orig })
+}
+
+func TestCorpusCitation(t *testing.T) {
+	stubRegistryConf(t)
+	conf := newTestConf(t)
+	conf.CitationFields = corpus.DfltCitationFields
+	assert.NoError(t, os.WriteFile(
+		conf.GetRegistryPath("corp1"),
+		[]byte("NAME \"Corpus 1\"\nINFO \"A testing corpus\"\n"+
+			"INFOHREF \"https://example.org/corp1\"\nLANGUAGE \"Czech\"\n"+
+			"AUTHOR \"Jane Doe\"\nCITATION \"Doe, J.: Corpus 1. Prague, 2024.\"\n"),
+		0644,
+	))
+	actions := &Actions{conf: conf}
+	ctx, rec := newTestContext("/citation/corp1")
+	actions.CorpusCitation(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var ans citationResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Equal(t, "corp1", ans.Corpus)
+	assert.Equal(
+		t,
+		map[string]string{
+			"name":        "Corpus 1",
+			"description": "A testing corpus",
+			"url":         "https://example.org/corp1",
+			"language":    "Czech",
+			"author":      "Jane Doe",
+			"citation":    "Doe, J.: Corpus 1. Prague, 2024.",
+		},
+		ans.Citation,
+	)
+}
+
+func TestCorpusCitationDefaults(t *testing.T) {
+	stubRegistryConf(t)
+	conf := newTestConf(t)
+	conf.CitationFields = map[string]string{
+		"name": "NAME", "url": "INFOHREF", "author": "AUTHOR", "license": "LICENSE",
+	}
+	corpConf := conf.Resources.Get("corp1")
+	corpConf.FullName = map[string]string{"en": "Corpus One"}
+	corpConf.WebURL = "https://example.org/c1"
+	assert.NoError(t, os.WriteFile(
+		conf.GetRegistryPath("corp1"), []byte("LICENSE \"CC BY 4.0\"\n"), 0644))
+	actions := &Actions{conf: conf}
+	ctx, rec := newTestContext("/citation/corp1")
+	actions.CorpusCitation(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var ans citationResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Equal(
+		t,
+		map[string]string{
+			"name":    "Corpus One",
+			"url":     "https://example.org/c1",
+			"author":  "",
+			"license": "CC BY 4.0",
+		},
+		ans.Citation,
+	)
+}
+
+func TestCorpusCitationNotFound(t *testing.T) {
+	actions := &Actions{conf: newTestConf(t)}
+	ctx, rec := newTestContext("/citation/corp2")
+	ctx.Params = gin.Params{{Key: "corpusId", Value: "corp2"}}
+	actions.CorpusCitation(ctx)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
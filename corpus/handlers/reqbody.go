@@ -0,0 +1,103 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func init() {
+	// make validation errors refer to JSON field names
+	// instead of Go struct field names
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(fld reflect.StructField) string {
+			name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+func fieldErrorMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return fmt.Sprintf("field `%s` is required", fe.Field())
+	case "min":
+		return fmt.Sprintf("field `%s` must have at least %s item(s)", fe.Field(), fe.Param())
+	case "max":
+		return fmt.Sprintf("field `%s` must have at most %s item(s)", fe.Field(), fe.Param())
+	default:
+		return fmt.Sprintf("field `%s` failed on the `%s` validation", fe.Field(), fe.Tag())
+	}
+}
+
+// bindJSONBodyOrFail decodes request body into `obj` and validates
+// it based on the `binding` struct tags. In case the body is not
+// a valid JSON, status 400 is written. In case the body does not match
+// the expected structure, status 422 with a list of field-level errors
+// (in the `details` property) is written. In both cases,
+// the function returns false and the caller should just return.
+func bindJSONBodyOrFail(ctx *gin.Context, obj any) bool {
+	err := ctx.ShouldBindJSON(obj)
+	if err == nil {
+		return true
+	}
+	var verrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &verrs) {
+		details := make([]string, len(verrs))
+		for i, fe := range verrs {
+			details[i] = fieldErrorMessage(fe)
+		}
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer,
+			uniresp.NewActionError("invalid request body"),
+			http.StatusUnprocessableEntity,
+			details...,
+		)
+
+	} else if errors.As(err, &typeErr) {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer,
+			uniresp.NewActionError("invalid request body"),
+			http.StatusUnprocessableEntity,
+			fmt.Sprintf("field `%s` must be of type %s", typeErr.Field, typeErr.Type),
+		)
+
+	} else {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer,
+			uniresp.NewActionError("failed to parse request body: %s", err),
+			http.StatusBadRequest,
+		)
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type testErrorResponse struct {
+	Code    int      `json:"code"`
+	Error   string   `json:"error"`
+	Details []string `json:"details"`
+}
+
+func bindTestBody(t *testing.T, body string) (splitCorpusArgs, bool, testErrorResponse) {
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(rec)
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/split/corp1", strings.NewReader(body))
+	ctx.Request.Header.Set("Content-Type", "application/json")
+	var args splitCorpusArgs
+	ok := bindJSONBodyOrFail(ctx, &args)
+	var resp testErrorResponse
+	if !ok {
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	}
+	return args, ok, resp
+}
+
+func TestBindJSONBodyValid(t *testing.T) {
+	args, ok, _ := bindTestBody(t, `{"attrs": ["lemma"], "structs": ["doc", "text"]}`)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"lemma"}, args.Attrs)
+	assert.Equal(t, []string{"doc", "text"}, args.Structs)
+}
+
+func TestBindJSONBodyMissingField(t *testing.T) {
+	_, ok, resp := bindTestBody(t, `{"attrs": ["lemma"]}`)
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+	assert.Equal(t, "invalid request body", resp.Error)
+	assert.Equal(t, []string{"field `structs` is required"}, resp.Details)
+}
+
+func TestBindJSONBodyEmptyList(t *testing.T) {
+	_, ok, resp := bindTestBody(t, `{"attrs": [], "structs": ["doc"]}`)
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+	assert.Equal(t, []string{"field `attrs` must have at least 1 item(s)"}, resp.Details)
+}
+
+func TestBindJSONBodyInvalidType(t *testing.T) {
+	_, ok, resp := bindTestBody(t, `{"attrs": "lemma", "structs": ["doc"]}`)
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+	assert.Equal(t, []string{"field `attrs` must be of type []string"}, resp.Details)
+}
+
+func TestBindJSONBodyMalformed(t *testing.T) {
+	_, ok, resp := bindTestBody(t, `{"attrs": ["lemma"`)
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
@@ -0,0 +1,197 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"mquery/corpus"
+	"mquery/mango"
+	"mquery/rdb"
+	"net/http"
+
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	dfltSentenceStruct = "s"
+)
+
+type avgSentenceLength struct {
+	Corpus      string `json:"corpus"`
+	Struct      string `json:"struct"`
+	Tokens      int64  `json:"tokens"`
+	StructCount int64  `json:"structCount"`
+
+	// AvgLength is nil in case there are no structures
+	AvgLength *float64 `json:"avgLength"`
+}
+
+// concSizeOrFail publishes a `concSize` job and waits for its result.
+// In case of an error, the function writes an error response and
+// returns false.
+func (a *Actions) concSizeOrFail(ctx *gin.Context, corpusPath, query string) (int64, bool) {
+	wait, err := a.publishJob(
+		ctx.Request.Context(),
+		"concSize",
+		rdb.ConcSizeArgs{CorpusPath: corpusPath, Query: query},
+	)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return 0, false
+	}
+	result, err := rdb.DeserializeConcSizeResult(<-wait)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return 0, false
+	}
+	if err := result.Err(); err != nil {
+		writeResultError(ctx, err, &result, noUserQuery)
+		return 0, false
+	}
+	return result.ConcSize, true
+}
+
+// AvgSentenceLength calculates an average length (in tokens) of
+// sentences (or other segments) of a corpus or a subcorpus. The structure
+// can be specified via the `struct` argument. By default, the corpus
+// `viewContextStruct` (or `s` if not configured) is used.
+// Sizes of the whole corpus are obtained directly from corpus data while
+// subcorpora require searching for the structures.
+func (a *Actions) AvgSentenceLength(ctx *gin.Context) {
+	corpusID := ctx.Param("corpusId")
+	corpusConf := a.conf.Resources.Get(corpusID)
+	if corpusConf == nil {
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("corpus %s not found", corpusID), http.StatusNotFound)
+		return
+	}
+	dfltStruct := corpusConf.ViewContextStruct
+	if dfltStruct == "" {
+		dfltStruct = dfltSentenceStruct
+	}
+	strct := ctx.DefaultQuery("struct", dfltStruct)
+	corpusPath := a.conf.GetRegistryPath(corpusID)
+	exists, err := hasStruct(corpusPath, strct)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("corpus %s has no structure `%s` (use `struct` to specify one)", corpusID, strct),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	var ttCQL string
+	if subc := ctx.Query("subcorpus"); subc != "" {
+		ttCQL = corpus.SubcorpusToCQL(corpusConf.Subcorpora[subc].TextTypes)
+		if ttCQL == "" {
+			uniresp.RespondWithErrorJSON(
+				ctx, errors.New("invalid subcorpus specification"), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	subcPath, status, err := determineSubcPath(ctx, a.conf, corpusID)
+	if err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, status)
+		return
+	}
+	ans := avgSentenceLength{Corpus: corpusID, Struct: strct}
+	structQuery := fmt.Sprintf("<%s/>%s", strct, ttCQL)
+
+	switch {
+	case subcPath != "" && ttCQL != "":
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			errors.New("arguments `subc` and `subcorpus` cannot be combined"),
+			http.StatusUnprocessableEntity,
+		)
+		return
+
+	case subcPath != "":
+		// a frequency distribution provides both the number
+		// of matching structures and the subcorpus size
+		wait, err := a.publishJob(
+			ctx.Request.Context(),
+			"freqDistrib",
+			rdb.FreqDistribArgs{
+				CorpusPath: corpusPath,
+				SubcPath:   subcPath,
+				Query:      structQuery,
+				Crit:       "word 0",
+				FreqLimit:  1,
+				MaxResults: 1,
+			},
+		)
+		if err != nil {
+			uniresp.WriteJSONErrorResponse(
+				ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+			return
+		}
+		result, err := rdb.DeserializeFreqDistribResult(<-wait)
+		if err == nil {
+			err = result.Err()
+		}
+		if err != nil {
+			uniresp.WriteJSONErrorResponse(
+				ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+			return
+		}
+		ans.StructCount = result.ConcSize
+		ans.Tokens = result.SearchSize
+
+	case ttCQL != "":
+		var ok bool
+		ans.StructCount, ok = a.concSizeOrFail(ctx, corpusPath, structQuery)
+		if !ok {
+			return
+		}
+		ans.Tokens, ok = a.concSizeOrFail(ctx, corpusPath, "[]"+ttCQL)
+		if !ok {
+			return
+		}
+
+	default:
+		ans.Tokens, err = getCorpusSize(corpusPath)
+		if err != nil {
+			uniresp.WriteJSONErrorResponse(
+				ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+			return
+		}
+		size, err := mango.GetStructSize(corpusPath, strct)
+		if err != nil {
+			uniresp.WriteJSONErrorResponse(
+				ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+			return
+		}
+		ans.StructCount = int64(size)
+	}
+	if ans.StructCount > 0 {
+		avg := float64(ans.Tokens) / float64(ans.StructCount)
+		ans.AvgLength = &avg
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, ans)
+}
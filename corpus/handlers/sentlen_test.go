@@ -0,0 +1,137 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"mquery/corpus"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func runTestAvgSentenceLength(
+	t *testing.T, actions *Actions, url string,
+) (avgSentenceLength, int) {
+	ctx, rec := newTestContext(url)
+	actions.AvgSentenceLength(ctx)
+	var ans avgSentenceLength
+	if rec.Code == http.StatusOK {
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	}
+	return ans, rec.Code
+}
+
+func TestAvgSentenceLengthSubc(t *testing.T) {
+	stubAttrChecks(t)
+	conf := newTestConf(t)
+	stubCorpusSize(t, 1000)
+	writeTestSubc(t, conf, "sub1")
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"freqDistrib": &results.FreqDistrib{ConcSize: 40, SearchSize: 700},
+		},
+	}
+	actions := &Actions{conf: conf, radapter: pub}
+	ans, status := runTestAvgSentenceLength(t, actions, "/avg-sentence-length/corp1?subc=sub1")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "s", ans.Struct)
+	assert.Equal(t, int64(700), ans.Tokens)
+	assert.Equal(t, int64(40), ans.StructCount)
+	if assert.NotNil(t, ans.AvgLength) {
+		assert.Equal(t, 17.5, *ans.AvgLength)
+	}
+	var args rdb.FreqDistribArgs
+	pub.publishedArgs(t, 0, &args)
+	assert.Equal(t, "<s/>", args.Query)
+	assert.Equal(t, conf.GetSubcorpusPath("corp1", "sub1"), args.SubcPath)
+}
+
+func TestAvgSentenceLengthTextTypesSubcorpus(t *testing.T) {
+	stubAttrChecks(t)
+	conf := newTestConf(t)
+	conf.Resources.Get("corp1").ViewContextStruct = "p"
+	conf.Resources.Get("corp1").Subcorpora = map[string]corpus.Subcorpus{
+		"fiction": {TextTypes: corpus.TextTypes{"doc.genre": {"fiction"}}},
+	}
+	pub := &fakePublisher{
+		respond: func(query rdb.Query) results.SerializableResult {
+			var args rdb.ConcSizeArgs
+			assert.NoError(t, json.Unmarshal(query.Args, &args))
+			if strings.HasPrefix(args.Query, "<p/>") {
+				return &results.ConcSize{ConcSize: 8}
+			}
+			return &results.ConcSize{ConcSize: 100}
+		},
+	}
+	actions := &Actions{conf: conf, radapter: pub}
+	ans, status := runTestAvgSentenceLength(
+		t, actions, "/avg-sentence-length/corp1?subcorpus=fiction")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "p", ans.Struct)
+	assert.Equal(t, int64(100), ans.Tokens)
+	assert.Equal(t, int64(8), ans.StructCount)
+	if assert.NotNil(t, ans.AvgLength) {
+		assert.Equal(t, 12.5, *ans.AvgLength)
+	}
+	var args rdb.ConcSizeArgs
+	pub.publishedArgs(t, 1, &args)
+	assert.Equal(t, `[] within <doc genre="fiction" />`, args.Query)
+}
+
+func TestAvgSentenceLengthNoStructs(t *testing.T) {
+	stubAttrChecks(t)
+	conf := newTestConf(t)
+	stubCorpusSize(t, 1000)
+	writeTestSubc(t, conf, "sub1")
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"freqDistrib": &results.FreqDistrib{SearchSize: 700},
+		},
+	}
+	ans, status := runTestAvgSentenceLength(
+		t, &Actions{conf: conf, radapter: pub}, "/avg-sentence-length/corp1?subc=sub1")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, ans.AvgLength)
+}
+
+func TestAvgSentenceLengthMissingStruct(t *testing.T) {
+	stubKnownAttrs(t, "doc", "p")
+	pub := &fakePublisher{}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	_, status := runTestAvgSentenceLength(t, actions, "/avg-sentence-length/corp1")
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+	_, status = runTestAvgSentenceLength(t, actions, "/avg-sentence-length/corp1?struct=seg")
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+	assert.Empty(t, pub.queries)
+}
+
+func TestAvgSentenceLengthUnknownCorpus(t *testing.T) {
+	stubAttrChecks(t)
+	actions := &Actions{conf: newTestConf(t), radapter: &fakePublisher{}}
+	ctx, rec := newTestContext("/avg-sentence-length/corp2")
+	ctx.Params = gin.Params{{Key: "corpusId", Value: "corp2"}}
+	actions.AvgSentenceLength(ctx)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"mquery/rdb"
+	"net/http"
+
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	dfltWordListAttr = "word"
+)
+
+// FreqSpectrum calculates a frequency spectrum (i.e. how many
+// types occur exactly k times) for a positional attribute
+// of a corpus (or a subcorpus).
+func (a *Actions) FreqSpectrum(ctx *gin.Context) {
+	corpusID := ctx.Param("corpusId")
+	corpusConf := a.conf.Resources.Get(corpusID)
+	if corpusConf == nil {
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("corpus %s not found", corpusID), http.StatusNotFound)
+		return
+	}
+	attr := ctx.DefaultQuery("attr", dfltWordListAttr)
+	if corpusConf.GetPosAttr(attr).IsZero() {
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("unknown attribute `%s`", attr), http.StatusUnprocessableEntity)
+		return
+	}
+	subcPath, status, err := determineSubcPath(ctx, a.conf, corpusID)
+	if err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, status)
+		return
+	}
+
+	args, err := json.Marshal(rdb.FreqSpectrumArgs{
+		CorpusPath: a.conf.GetRegistryPath(corpusID),
+		SubcPath:   subcPath,
+		Attr:       attr,
+	})
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer,
+			uniresp.NewActionErrorFrom(err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	wait, err := a.radapter.PublishQueryCtx(ctx.Request.Context(), rdb.Query{
+		Func: "freqSpectrum",
+		Args: args,
+	})
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer,
+			uniresp.NewActionErrorFrom(err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	rawResult := <-wait
+	result, err := rdb.DeserializeFreqSpectrumResult(rawResult)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer,
+			uniresp.NewActionErrorFrom(err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	if err := result.Err(); err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer,
+			uniresp.NewActionErrorFrom(err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, &result)
+}
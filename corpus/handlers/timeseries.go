@@ -0,0 +1,120 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"mquery/rdb"
+	"net/http"
+	"strings"
+
+	"github.com/czcorpus/cnc-gokit/unireq"
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+)
+
+// FreqsTimeSeries calculates relative frequencies (ipm) of a searched
+// expression in individual years. The years are taken from a structural
+// attribute (`attr`) and each year's frequency is normalized by the
+// size of the year's data. The result is sorted by year.
+func (a *Actions) FreqsTimeSeries(ctx *gin.Context) {
+	queryProps := DetermineQueryProps(ctx, a.conf)
+	if queryProps.hasError() {
+		uniresp.RespondWithErrorJSON(ctx, queryProps.err, queryProps.status)
+		return
+	}
+	attr := ctx.Request.URL.Query().Get("attr")
+	if attr == "" {
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("missing attribute `attr`"), http.StatusBadRequest)
+		return
+	}
+	if len(strings.Split(attr, ".")) != 2 {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid attribute `%s` (must be `struct.attr`)", attr),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	if !validateAttrOrFail(ctx, a.conf.GetRegistryPath(queryProps.corpus), attr) {
+		return
+	}
+	flimit, ok := getFreqLimitArgOrFail(ctx, a.conf, queryProps.corpusConf)
+	if !ok {
+		return
+	}
+	fromYear, ok := unireq.GetURLIntArgOrFail(ctx, "fromYear", 0)
+	if !ok {
+		return
+	}
+	toYear, ok := unireq.GetURLIntArgOrFail(ctx, "toYear", 0)
+	if !ok {
+		return
+	}
+
+	args, err := json.Marshal(rdb.TimeSeriesArgs{
+		CorpusPath: a.conf.GetRegistryPath(queryProps.corpus),
+		Query:      queryProps.query,
+		Attr:       attr,
+		FreqLimit:  flimit,
+		FromYear:   fromYear,
+		ToYear:     toYear,
+	})
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer,
+			uniresp.NewActionErrorFrom(err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	wait, err := a.radapter.PublishQueryCtx(ctx.Request.Context(), rdb.Query{
+		Func: "timeSeries",
+		Args: args,
+	})
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer,
+			uniresp.NewActionErrorFrom(err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	rawResult := <-wait
+	result, err := rdb.DeserializeTimeSeriesResult(rawResult)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer,
+			uniresp.NewActionErrorFrom(err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	if err := result.Err(); err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer,
+			uniresp.NewActionErrorFrom(err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, &result)
+}
@@ -19,6 +19,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"math/rand"
@@ -139,7 +140,7 @@ func (a *Actions) filterByYearRange(inStream chan StreamData, fromYear, toYear i
 	return ans
 }
 
-func (a *Actions) streamCalc(query, attr, corpusID string, flimit, maxItems int) (chan StreamData, error) {
+func (a *Actions) streamCalc(ctx context.Context, query, attr, corpusID string, flimit, maxItems int) (chan StreamData, error) {
 	messageChannel := make(chan StreamData, 10)
 	corpusPath := a.conf.GetRegistryPath(corpusID)
 	sc, err := corpus.OpenSplitCorpus(a.conf.SplitCorporaDir, corpusPath)
@@ -166,6 +167,7 @@ func (a *Actions) streamCalc(query, attr, corpusID string, flimit, maxItems int)
 					Crit:        fmt.Sprintf("%s 0", attr),
 					IsTextTypes: true,
 					FreqLimit:   flimit,
+					ItemsLimit:  a.conf.MaxFreqItems,
 					MaxResults:  maxItems,
 				})
 				if err != nil {
@@ -177,7 +179,7 @@ func (a *Actions) streamCalc(query, attr, corpusID string, flimit, maxItems int)
 					return
 				}
 
-				wait, err := a.radapter.PublishQuery(rdb.Query{
+				wait, err := a.radapter.PublishQueryCtx(ctx, rdb.Query{
 					Func: "freqDistrib",
 					Args: args,
 				})
@@ -283,7 +285,7 @@ func (a *Actions) ttStreamedBase(ctx *gin.Context) (streamedFreqsBaseArgs, bool)
 		args.Attr = tmp[0]
 	}
 	var ok bool
-	args.Flimit, ok = unireq.GetURLIntArgOrFail(ctx, "flimit", 1)
+	args.Flimit, ok = getFreqLimitArgOrFail(ctx, a.conf, a.conf.Resources.Get(ctx.Param("corpusId")))
 	if !ok {
 		return args, false
 	}
@@ -307,7 +309,7 @@ func (a *Actions) TextTypesStreamed(ctx *gin.Context) {
 		return
 	}
 
-	calc, err := a.streamCalc(args.Q, args.Attr, ctx.Param("corpusId"), args.Flimit, args.MaxItems)
+	calc, err := a.streamCalc(ctx.Request.Context(), args.Q, args.Attr, ctx.Param("corpusId"), args.Flimit, args.MaxItems)
 	if err != nil {
 		a.writeStreamingError(ctx, err)
 		return
@@ -342,7 +344,7 @@ func (a *Actions) FreqsByYears(ctx *gin.Context) {
 		return
 	}
 
-	calc, err := a.streamCalc(args.Q, args.Attr, ctx.Param("corpusId"), args.Flimit, args.MaxItems)
+	calc, err := a.streamCalc(ctx.Request.Context(), args.Q, args.Attr, ctx.Param("corpusId"), args.Flimit, args.MaxItems)
 	if err != nil {
 		a.writeStreamingError(ctx, err)
 		return
@@ -0,0 +1,184 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"fmt"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+)
+
+type ttCrossTab struct {
+	Corpus string `json:"corpus"`
+	Attr1  string `json:"attr1"`
+	Attr2  string `json:"attr2"`
+
+	// Rows contains values of Attr1 (sorted)
+	Rows []string `json:"rows"`
+
+	// Cols contains values of Attr2 (sorted)
+	Cols []string `json:"cols"`
+
+	// Matrix contains frequencies where Matrix[i][j] is the number
+	// of matches within structures with Attr1 = Rows[i]
+	// and Attr2 = Cols[j]
+	Matrix [][]int64 `json:"matrix"`
+
+	RowTotals []int64 `json:"rowTotals"`
+	ColTotals []int64 `json:"colTotals"`
+	Total     int64   `json:"total"`
+	ConcSize  int64   `json:"concSize"`
+
+	// Truncated is true in case some less frequent combinations
+	// of values have been removed due to the configured limit
+	// (i.e. the matrix and the marginals may be incomplete)
+	Truncated bool `json:"truncated"`
+}
+
+// newTTCrossTab creates a matrix (along with marginals) out of
+// a two-level frequency distribution.
+func newTTCrossTab(freqs results.FreqDistribItemList) ttCrossTab {
+	ans := ttCrossTab{Rows: []string{}, Cols: []string{}}
+	rowIdx := make(map[string]int)
+	colIdx := make(map[string]int)
+	for _, item := range freqs {
+		if len(item.Tuple) != 2 {
+			continue
+		}
+		if _, ok := rowIdx[item.Tuple[0]]; !ok {
+			rowIdx[item.Tuple[0]] = 0
+			ans.Rows = append(ans.Rows, item.Tuple[0])
+		}
+		if _, ok := colIdx[item.Tuple[1]]; !ok {
+			colIdx[item.Tuple[1]] = 0
+			ans.Cols = append(ans.Cols, item.Tuple[1])
+		}
+	}
+	sort.Strings(ans.Rows)
+	sort.Strings(ans.Cols)
+	for i, v := range ans.Rows {
+		rowIdx[v] = i
+	}
+	for i, v := range ans.Cols {
+		colIdx[v] = i
+	}
+	ans.Matrix = make([][]int64, len(ans.Rows))
+	for i := range ans.Matrix {
+		ans.Matrix[i] = make([]int64, len(ans.Cols))
+	}
+	ans.RowTotals = make([]int64, len(ans.Rows))
+	ans.ColTotals = make([]int64, len(ans.Cols))
+	for _, item := range freqs {
+		if len(item.Tuple) != 2 {
+			continue
+		}
+		i, j := rowIdx[item.Tuple[0]], colIdx[item.Tuple[1]]
+		ans.Matrix[i][j] += item.Freq
+		ans.RowTotals[i] += item.Freq
+		ans.ColTotals[j] += item.Freq
+		ans.Total += item.Freq
+	}
+	return ans
+}
+
+// TextTypesCrossTab calculates frequencies of a query broken down
+// by values of two structural attributes (`attr1` and `attr2`, e.g.
+// `doc.genre` and `doc.period`) at once. The result is a matrix
+// of frequencies along with row and column totals.
+func (a *Actions) TextTypesCrossTab(ctx *gin.Context) {
+	queryProps := DetermineQueryProps(ctx, a.conf)
+	if queryProps.hasError() {
+		uniresp.RespondWithErrorJSON(ctx, queryProps.err, queryProps.status)
+		return
+	}
+	corpusPath := a.conf.GetRegistryPath(queryProps.corpus)
+	attrs := [2]string{ctx.Query("attr1"), ctx.Query("attr2")}
+	for i, attr := range attrs {
+		if attr == "" {
+			uniresp.RespondWithErrorJSON(
+				ctx, fmt.Errorf("missing argument `attr%d`", i+1), http.StatusBadRequest)
+			return
+		}
+		if !strings.Contains(attr, ".") {
+			uniresp.RespondWithErrorJSON(
+				ctx,
+				fmt.Errorf("invalid `attr%d` value `%s` (must be a structural attribute)", i+1, attr),
+				http.StatusUnprocessableEntity,
+			)
+			return
+		}
+		if !validateAttrOrFail(ctx, corpusPath, attr) {
+			return
+		}
+	}
+	if attrs[0] == attrs[1] {
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("`attr1` and `attr2` must differ"), http.StatusUnprocessableEntity)
+		return
+	}
+	flimit, ok := getFreqLimitArgOrFail(ctx, a.conf, queryProps.corpusConf)
+	if !ok {
+		return
+	}
+	query, queryPos, ok := matchStructQueryOrFail(ctx, corpusPath, queryProps.query)
+	if !ok {
+		return
+	}
+	wait, err := a.publishJob(
+		ctx.Request.Context(),
+		"freqDistrib",
+		rdb.FreqDistribArgs{
+			CorpusPath: corpusPath,
+			SubcPath:   queryProps.subcPath,
+			Query:      query,
+			Crit:       fmt.Sprintf("%s 0 %s 0", attrs[0], attrs[1]),
+			FreqLimit:  flimit,
+			ItemsLimit: a.conf.MaxFreqItems,
+			MaxResults: a.conf.MaxFreqItems,
+		},
+	)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return
+	}
+	result, err := rdb.DeserializeFreqDistribResult(<-wait)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return
+	}
+	if err := result.Err(); err != nil {
+		writeResultError(ctx, err, &result, queryPos)
+		return
+	}
+	ans := newTTCrossTab(result.Freqs)
+	ans.Corpus = queryProps.corpus
+	ans.Attr1 = attrs[0]
+	ans.Attr2 = attrs[1]
+	ans.ConcSize = result.ConcSize
+	ans.Truncated = result.Truncated
+	uniresp.WriteJSONResponse(ctx.Writer, ans)
+}
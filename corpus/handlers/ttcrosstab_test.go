@@ -0,0 +1,155 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCrossTabFreqs() results.FreqDistribItemList {
+	items := []struct {
+		genre, period string
+		freq          int64
+	}{
+		{"fiction", "1990s", 12},
+		{"fiction", "2000s", 30},
+		{"news", "1990s", 7},
+		{"news", "2010s", 21},
+		{"science", "2000s", 4},
+		{"science", "2010s", 9},
+	}
+	ans := make(results.FreqDistribItemList, len(items))
+	for i, item := range items {
+		ans[i] = &results.FreqDistribItem{
+			Word:  item.genre + "\t" + item.period,
+			Tuple: []string{item.genre, item.period},
+			Freq:  item.freq,
+		}
+	}
+	return ans
+}
+
+func TestNewTTCrossTabMarginals(t *testing.T) {
+	ans := newTTCrossTab(testCrossTabFreqs())
+	assert.Equal(t, []string{"fiction", "news", "science"}, ans.Rows)
+	assert.Equal(t, []string{"1990s", "2000s", "2010s"}, ans.Cols)
+	assert.Equal(t, [][]int64{{12, 30, 0}, {7, 0, 21}, {0, 4, 9}}, ans.Matrix)
+	assert.Equal(t, []int64{42, 28, 13}, ans.RowTotals)
+	assert.Equal(t, []int64{19, 34, 30}, ans.ColTotals)
+	assert.Equal(t, int64(83), ans.Total)
+
+	var rowSum, colSum int64
+	for i, row := range ans.Matrix {
+		var sum int64
+		for _, v := range row {
+			sum += v
+		}
+		assert.Equal(t, ans.RowTotals[i], sum)
+		rowSum += sum
+	}
+	for j := range ans.Cols {
+		var sum int64
+		for i := range ans.Rows {
+			sum += ans.Matrix[i][j]
+		}
+		assert.Equal(t, ans.ColTotals[j], sum)
+		colSum += sum
+	}
+	assert.Equal(t, ans.Total, rowSum)
+	assert.Equal(t, ans.Total, colSum)
+}
+
+func TestNewTTCrossTabEmpty(t *testing.T) {
+	ans := newTTCrossTab(results.FreqDistribItemList{})
+	assert.Empty(t, ans.Rows)
+	assert.Empty(t, ans.Matrix)
+	assert.Equal(t, int64(0), ans.Total)
+}
+
+func TestTextTypesCrossTab(t *testing.T) {
+	stubKnownAttrs(t, "doc.genre", "doc.period")
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"freqDistrib": &results.FreqDistrib{Freqs: testCrossTabFreqs(), ConcSize: 83},
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	ctx, rec := newTestContext(
+		"/text-types-crosstab/corp1?q=[lemma=\"pes\"]&attr1=doc.genre&attr2=doc.period")
+	actions.TextTypesCrossTab(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var ans ttCrossTab
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Equal(t, "doc.genre", ans.Attr1)
+	assert.Equal(t, "doc.period", ans.Attr2)
+	assert.Equal(t, []int64{42, 28, 13}, ans.RowTotals)
+	assert.Equal(t, []int64{19, 34, 30}, ans.ColTotals)
+	assert.Equal(t, int64(83), ans.Total)
+	var args rdb.FreqDistribArgs
+	pub.publishedArgs(t, 0, &args)
+	assert.Equal(t, "doc.genre 0 doc.period 0", args.Crit)
+}
+
+func TestTextTypesCrossTabMatchStruct(t *testing.T) {
+	stubKnownAttrs(t, "doc.genre", "doc.period", "s")
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"freqDistrib": &results.FreqDistrib{Freqs: testCrossTabFreqs()},
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	ctx, rec := newTestContext(
+		"/text-types-crosstab/corp1?q=[lemma=\"pes\"]&attr1=doc.genre&attr2=doc.period&matchStruct=s")
+	actions.TextTypesCrossTab(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var args rdb.FreqDistribArgs
+	pub.publishedArgs(t, 0, &args)
+	assert.Equal(t, `<s /> containing [lemma="pes"]`, args.Query)
+
+	ctx, rec = newTestContext(
+		"/text-types-crosstab/corp1?q=[lemma=\"pes\"]&attr1=doc.genre&attr2=doc.period&matchStruct=p")
+	actions.TextTypesCrossTab(ctx)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Len(t, pub.queries, 1)
+}
+
+func TestTextTypesCrossTabInvalidAttrs(t *testing.T) {
+	stubKnownAttrs(t, "word", "doc.genre", "doc.period")
+	pub := &fakePublisher{}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	for url, status := range map[string]int{
+		"/text-types-crosstab/corp1?q=[lemma=\"pes\"]&attr1=doc.genre":                  http.StatusBadRequest,
+		"/text-types-crosstab/corp1?q=[lemma=\"pes\"]&attr1=doc.genre&attr2=word":       http.StatusUnprocessableEntity,
+		"/text-types-crosstab/corp1?q=[lemma=\"pes\"]&attr1=doc.genre&attr2=doc.id":     http.StatusUnprocessableEntity,
+		"/text-types-crosstab/corp1?q=[lemma=\"pes\"]&attr1=doc.genre&attr2=doc.genre":  http.StatusUnprocessableEntity,
+		"/text-types-crosstab/corp1?q=[lemma=\"pes\"]&attr1=doc.period&attr2=doc.genre": http.StatusOK,
+	} {
+		pub.results = map[string]results.SerializableResult{"freqDistrib": &results.FreqDistrib{}}
+		ctx, rec := newTestContext(url)
+		actions.TextTypesCrossTab(ctx)
+		assert.Equal(t, status, rec.Code, url)
+	}
+	assert.Len(t, pub.queries, 1)
+}
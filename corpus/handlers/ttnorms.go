@@ -19,8 +19,12 @@
 package handlers
 
 import (
-	"mquery/mango"
+	"errors"
+	"fmt"
+	"mquery/merror"
 	"net/http"
+	"sort"
+	"strings"
 
 	"github.com/czcorpus/cnc-gokit/uniresp"
 	"github.com/gin-gonic/gin"
@@ -28,8 +32,12 @@ import (
 
 func (a *Actions) TextTypesNorms(ctx *gin.Context) {
 	corpusPath := a.conf.GetRegistryPath(ctx.Param("corpusId"))
-	ans, err := mango.GetTextTypesNorms(corpusPath, ctx.Request.URL.Query().Get("attr"))
-	if err != nil {
+	ans, err := a.ttNorms.Get(corpusPath, ctx.Request.URL.Query().Get("attr"))
+	if merror.IsInputError(err) {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusUnprocessableEntity)
+		return
+
+	} else if err != nil {
 		uniresp.WriteJSONErrorResponse(
 			ctx.Writer,
 			uniresp.NewActionErrorFrom(err),
@@ -39,3 +47,76 @@ func (a *Actions) TextTypesNorms(ctx *gin.Context) {
 	}
 	uniresp.WriteJSONResponse(ctx.Writer, ans)
 }
+
+type ttSizeItem struct {
+	Value string `json:"value"`
+	Size  int64  `json:"size"`
+}
+
+type ttSizes struct {
+	Attr  string       `json:"attr"`
+	Items []ttSizeItem `json:"items"`
+
+	// Total is the sum of all the sizes. For attributes
+	// covering the whole corpus, it equals CorpusSize.
+	Total      int64 `json:"total"`
+	CorpusSize int64 `json:"corpusSize"`
+}
+
+// TextTypesSizes provides sizes (in tokens) of all the values
+// of a structural attribute (e.g. `doc.genre`) sorted in descending
+// order. This is useful e.g. for corpus composition reports.
+func (a *Actions) TextTypesSizes(ctx *gin.Context) {
+	attr := ctx.Query("attr")
+	if attr == "" {
+		uniresp.RespondWithErrorJSON(
+			ctx, errors.New("missing attribute `attr`"), http.StatusBadRequest)
+		return
+	}
+	if len(strings.Split(attr, ".")) != 2 {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid attribute `%s` (must be `struct.attr`)", attr),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	corpusPath := a.conf.GetRegistryPath(ctx.Param("corpusId"))
+	if !validateAttrOrFail(ctx, corpusPath, attr) {
+		return
+	}
+	norms, err := a.ttNorms.Get(corpusPath, attr)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return
+	}
+	corpSize, err := getCorpusSize(corpusPath)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, newTTSizes(attr, norms, corpSize))
+}
+
+// newTTSizes creates sizes of structural attribute values sorted
+// by size in descending order (and by value in case of equal sizes)
+func newTTSizes(attr string, norms map[string]int64, corpSize int64) ttSizes {
+	ans := ttSizes{
+		Attr:       attr,
+		Items:      make([]ttSizeItem, 0, len(norms)),
+		CorpusSize: corpSize,
+	}
+	for value, size := range norms {
+		ans.Items = append(ans.Items, ttSizeItem{Value: value, Size: size})
+		ans.Total += size
+	}
+	sort.Slice(ans.Items, func(i, j int) bool {
+		if ans.Items[i].Size == ans.Items[j].Size {
+			return ans.Items[i].Value < ans.Items[j].Value
+		}
+		return ans.Items[i].Size > ans.Items[j].Size
+	})
+	return ans
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"mquery/corpus"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTTSizesExhaustiveAttr(t *testing.T) {
+	norms := map[string]int64{
+		"fiction":    500,
+		"news":       1200,
+		"poetry":     300,
+		"scientific": 300,
+	}
+	ans := newTTSizes("doc.genre", norms, 2300)
+	assert.Equal(t, ans.CorpusSize, ans.Total)
+	assert.Equal(
+		t,
+		[]ttSizeItem{
+			{Value: "news", Size: 1200},
+			{Value: "fiction", Size: 500},
+			{Value: "poetry", Size: 300},
+			{Value: "scientific", Size: 300},
+		},
+		ans.Items,
+	)
+}
+
+func TestNewTTSizesNonExhaustiveAttr(t *testing.T) {
+	ans := newTTSizes("doc.genre", map[string]int64{"news": 1200}, 2300)
+	assert.Equal(t, int64(1200), ans.Total)
+	assert.Equal(t, int64(2300), ans.CorpusSize)
+}
+
+func TestTextTypesSizes(t *testing.T) {
+	stubAttrChecks(t)
+	stubCorpusSize(t, 1000)
+	actions := &Actions{
+		conf: newTestConf(t),
+		ttNorms: corpus.NewTTNormsCache(
+			10,
+			func(corpusPath, attr string) (map[string]int64, error) {
+				return map[string]int64{"a": 10, "b": 30}, nil
+			},
+		),
+	}
+	ctx, rec := newTestContext("/text-types-sizes/corp1?attr=doc.genre")
+	actions.TextTypesSizes(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var ans ttSizes
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Equal(t, "doc.genre", ans.Attr)
+	assert.Equal(t, int64(40), ans.Total)
+	assert.Equal(t, int64(1000), ans.CorpusSize)
+	assert.Equal(t, []ttSizeItem{{Value: "b", Size: 30}, {Value: "a", Size: 10}}, ans.Items)
+}
+
+func TestTextTypesSizesInvalidAttr(t *testing.T) {
+	stubKnownAttrs(t, "doc.genre")
+	actions := &Actions{conf: newTestConf(t), ttNorms: corpus.NewTTNormsCache(10, nil)}
+	for url, status := range map[string]int{
+		"/text-types-sizes/corp1":                  http.StatusBadRequest,
+		"/text-types-sizes/corp1?attr=genre":       http.StatusUnprocessableEntity,
+		"/text-types-sizes/corp1?attr=doc.foo":     http.StatusUnprocessableEntity,
+		"/text-types-sizes/corp1?attr=doc.genre.x": http.StatusUnprocessableEntity,
+	} {
+		ctx, rec := newTestContext(url)
+		actions.TextTypesSizes(ctx)
+		assert.Equal(t, status, rec.Code, url)
+	}
+}
@@ -25,7 +25,6 @@ import (
 	"mquery/rdb"
 	"mquery/results"
 	"net/http"
-	"strconv"
 	"sync"
 
 	"github.com/czcorpus/cnc-gokit/uniresp"
@@ -84,18 +83,9 @@ func (a *Actions) TextTypesOverview(ctx *gin.Context) {
 		return
 	}
 
-	flimit := 1
-	if ctx.Request.URL.Query().Has("flimit") {
-		var err error
-		flimit, err = strconv.Atoi(ctx.Request.URL.Query().Get("flimit"))
-		if err != nil {
-			uniresp.WriteJSONErrorResponse(
-				ctx.Writer,
-				uniresp.NewActionErrorFrom(err),
-				http.StatusUnprocessableEntity,
-			)
-			return
-		}
+	flimit, ok := getFreqLimitArgOrFail(ctx, a.conf, queryProps.corpusConf)
+	if !ok {
+		return
 	}
 	corpusPath := a.conf.GetRegistryPath(queryProps.corpus)
 
@@ -108,10 +98,12 @@ func (a *Actions) TextTypesOverview(ctx *gin.Context) {
 	for _, attr := range queryProps.corpusConf.TTOverviewAttrs {
 		freqArgs := rdb.FreqDistribArgs{
 			CorpusPath:  corpusPath,
+			SubcPath:    queryProps.subcPath,
 			Query:       queryProps.query,
 			Crit:        fmt.Sprintf("%s 0", attr),
 			IsTextTypes: true,
 			FreqLimit:   flimit,
+			ItemsLimit:  a.conf.MaxFreqItems,
 		}
 
 		args, err := json.Marshal(freqArgs)
@@ -124,7 +116,7 @@ func (a *Actions) TextTypesOverview(ctx *gin.Context) {
 			return
 		}
 
-		wait, err := a.radapter.PublishQuery(rdb.Query{
+		wait, err := a.radapter.PublishQueryCtx(ctx.Request.Context(), rdb.Query{
 			Func: "freqDistrib",
 			Args: args,
 		})
@@ -0,0 +1,112 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"fmt"
+	"mquery/rdb"
+	"net/http"
+
+	"github.com/czcorpus/cnc-gokit/unireq"
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	dfltTTRWindowSize = 500
+	maxTTRWindowSize  = 10000
+	dfltTTRNumSamples = 100
+	maxTTRNumSamples  = 1000
+)
+
+// TypeTokenRatio calculates the type-token ratio (TTR) of a positional
+// attribute within a corpus or a subcorpus (`subc` argument) along with
+// its window-based variants (standardized TTR and moving-average TTR)
+// which are less dependent on the corpus size. The window-based variants
+// are calculated from regions sampled evenly across the (sub)corpus.
+func (a *Actions) TypeTokenRatio(ctx *gin.Context) {
+	corpusID := ctx.Param("corpusId")
+	corpusConf := a.conf.Resources.Get(corpusID)
+	if corpusConf == nil {
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("corpus %s not found", corpusID), http.StatusNotFound)
+		return
+	}
+	attr := ctx.DefaultQuery("attr", dfltWordListAttr)
+	if corpusConf.GetPosAttr(attr).IsZero() {
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("unknown attribute `%s`", attr), http.StatusUnprocessableEntity)
+		return
+	}
+	windowSize, ok := unireq.GetURLIntArgOrFail(ctx, "windowSize", dfltTTRWindowSize)
+	if !ok {
+		return
+	}
+	if windowSize < 1 || windowSize > maxTTRWindowSize {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid `windowSize` value (must be between 1 and %d)", maxTTRWindowSize),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	numSamples, ok := unireq.GetURLIntArgOrFail(ctx, "samples", dfltTTRNumSamples)
+	if !ok {
+		return
+	}
+	if numSamples < 1 || numSamples > maxTTRNumSamples {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid `samples` value (must be between 1 and %d)", maxTTRNumSamples),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	subcPath, status, err := determineSubcPath(ctx, a.conf, corpusID)
+	if err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, status)
+		return
+	}
+	wait, err := a.publishJob(
+		ctx.Request.Context(),
+		"typeTokenRatio",
+		rdb.TypeTokenRatioArgs{
+			CorpusPath: a.conf.GetRegistryPath(corpusID),
+			SubcPath:   subcPath,
+			Attr:       attr,
+			WindowSize: windowSize,
+			NumSamples: numSamples,
+		},
+	)
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return
+	}
+	result, err := rdb.DeserializeTypeTokenRatioResult(<-wait)
+	if err == nil {
+		err = result.Err()
+	}
+	if err != nil {
+		uniresp.WriteJSONErrorResponse(
+			ctx.Writer, uniresp.NewActionErrorFrom(err), http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, &result)
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypeTokenRatio(t *testing.T) {
+	ttr := 0.5
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"typeTokenRatio": &results.TypeTokenRatio{Attr: "lemma", Types: 4, Tokens: 8, TTR: &ttr},
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	ctx, rec := newTestContext("/ttr/corp1?attr=lemma&windowSize=100&samples=20")
+	actions.TypeTokenRatio(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"ttr":0.5`)
+	var args rdb.TypeTokenRatioArgs
+	pub.publishedArgs(t, 0, &args)
+	assert.Equal(t, "lemma", args.Attr)
+	assert.Equal(t, 100, args.WindowSize)
+	assert.Equal(t, 20, args.NumSamples)
+	assert.Empty(t, args.SubcPath)
+}
+
+func TestTypeTokenRatioInvalidArgs(t *testing.T) {
+	pub := &fakePublisher{}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	for _, url := range []string{
+		"/ttr/corp1?attr=foo",
+		"/ttr/corp1?windowSize=0",
+		"/ttr/corp1?windowSize=10001",
+		"/ttr/corp1?samples=0",
+	} {
+		ctx, rec := newTestContext(url)
+		actions.TypeTokenRatio(ctx)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, url)
+	}
+	assert.Empty(t, pub.queries)
+}
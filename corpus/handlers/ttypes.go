@@ -25,8 +25,8 @@ import (
 	"fmt"
 	"mquery/rdb"
 	"net/http"
-	"strconv"
 
+	"github.com/czcorpus/cnc-gokit/unireq"
 	"github.com/czcorpus/cnc-gokit/uniresp"
 	"github.com/gin-gonic/gin"
 )
@@ -47,31 +47,39 @@ func (a *Actions) TextTypes(ctx *gin.Context) {
 		)
 		return
 	}
-	flimit := 1
-	if ctx.Request.URL.Query().Has("flimit") {
-		var err error
-		flimit, err = strconv.Atoi(ctx.Request.URL.Query().Get("flimit"))
-		if err != nil {
-			uniresp.WriteJSONErrorResponse(
-				ctx.Writer,
-				uniresp.NewActionErrorFrom(err),
-				http.StatusUnprocessableEntity,
-			)
-			return
-		}
+	flimit, ok := getFreqLimitArgOrFail(ctx, a.conf, queryProps.corpusConf)
+	if !ok {
+		return
+	}
+	fmax, ok := unireq.GetURLIntArgOrFail(ctx, "fmax", 0)
+	if !ok {
+		return
+	}
+	if ctx.Request.URL.Query().Has("fmax") && fmax < flimit {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid `fmax` value %d (must be >= `flimit`)", fmax),
+			http.StatusUnprocessableEntity,
+		)
+		return
 	}
 	corpusPath := a.conf.GetRegistryPath(ctx.Param("corpusId"))
+	if !validateAttrOrFail(ctx, corpusPath, attr) {
+		return
+	}
+	query, queryPos, ok := matchStructQueryOrFail(ctx, corpusPath, queryProps.query)
+	if !ok {
+		return
+	}
 	freqArgs := rdb.FreqDistribArgs{
 		CorpusPath:  corpusPath,
-		Query:       queryProps.query,
+		SubcPath:    queryProps.subcPath,
+		Query:       query,
 		Crit:        fmt.Sprintf("%s 0", attr),
 		IsTextTypes: true,
 		FreqLimit:   flimit,
-	}
-
-	// TODO this probably needs some work
-	if ctx.Request.URL.Query().Has("subc") {
-		freqArgs.SubcPath = ctx.Request.URL.Query().Get("subc")
+		FreqMax:     fmax,
+		ItemsLimit:  a.conf.MaxFreqItems,
 	}
 
 	args, err := json.Marshal(freqArgs)
@@ -84,7 +92,7 @@ func (a *Actions) TextTypes(ctx *gin.Context) {
 		return
 	}
 
-	wait, err := a.radapter.PublishQuery(rdb.Query{
+	wait, err := a.radapter.PublishQueryCtx(ctx.Request.Context(), rdb.Query{
 		Func: "freqDistrib",
 		Args: args,
 	})
@@ -107,11 +115,7 @@ func (a *Actions) TextTypes(ctx *gin.Context) {
 		return
 	}
 	if err := result.Err(); err != nil {
-		uniresp.WriteJSONErrorResponse(
-			ctx.Writer,
-			uniresp.NewActionErrorFrom(err),
-			http.StatusInternalServerError,
-		)
+		writeResultError(ctx, err, &result, queryPos)
 		return
 	}
 	uniresp.WriteJSONResponse(
@@ -26,7 +26,6 @@ import (
 	"mquery/rdb"
 	"mquery/results"
 	"net/http"
-	"sort"
 	"sync"
 
 	"github.com/czcorpus/cnc-gokit/unireq"
@@ -49,7 +48,7 @@ func (a *Actions) TextTypesParallel(ctx *gin.Context) {
 		return
 	}
 
-	flimit, ok := unireq.GetURLIntArgOrFail(ctx, "flimit", 1)
+	flimit, ok := getFreqLimitArgOrFail(ctx, a.conf, a.conf.Resources.Get(ctx.Param("corpusId")))
 	if !ok {
 		return
 	}
@@ -57,13 +56,17 @@ func (a *Actions) TextTypesParallel(ctx *gin.Context) {
 	if !ok {
 		return
 	}
+	sorting, ok := getFreqSortingOrFail(ctx)
+	if !ok {
+		return
+	}
 
 	mergedFreqLock := sync.Mutex{}
 	wg := sync.WaitGroup{}
 	wg.Add(len(sc.Subcorpora))
 	result := new(results.FreqDistrib)
 	result.Freqs = make([]*results.FreqDistribItem, 0)
-	errs := make([]error, 0, len(sc.Subcorpora))
+	var errs chunkErrors
 	for _, subc := range sc.Subcorpora {
 		args, err := json.Marshal(rdb.FreqDistribArgs{
 			CorpusPath:  corpusPath,
@@ -72,6 +75,7 @@ func (a *Actions) TextTypesParallel(ctx *gin.Context) {
 			Crit:        fmt.Sprintf("%s 0", attr),
 			IsTextTypes: true,
 			FreqLimit:   flimit,
+			ItemsLimit:  a.conf.MaxFreqItems,
 			MaxResults:  maxItems,
 		})
 		if err != nil {
@@ -83,12 +87,12 @@ func (a *Actions) TextTypesParallel(ctx *gin.Context) {
 			return
 		}
 
-		wait, err := a.radapter.PublishQuery(rdb.Query{
+		wait, err := a.radapter.PublishQueryCtx(ctx.Request.Context(), rdb.Query{
 			Func: "freqDistrib",
 			Args: args,
 		})
 		if err != nil {
-			errs = append(errs, err)
+			errs.add(err, http.StatusInternalServerError)
 			log.Error().Err(err).Msg("failed to publish query")
 			wg.Done()
 
@@ -98,12 +102,14 @@ func (a *Actions) TextTypesParallel(ctx *gin.Context) {
 				tmp := <-wait
 				resultNext, err := rdb.DeserializeTextTypesResult(tmp)
 				if err != nil {
-					errs = append(errs, err)
+					errs.add(err, http.StatusInternalServerError)
 					log.Error().Err(err).Msg("failed to deserialize query")
+					return
 				}
-				if err := result.Err(); err != nil {
-					errs = append(errs, err)
-					log.Error().Err(err).Msg("failed to deserialize query")
+				if err := resultNext.Err(); err != nil {
+					errs.add(err, resultErrorStatus(&resultNext))
+					log.Error().Err(err).Msg("failed to calculate text types chunk")
+					return
 				}
 				mergedFreqLock.Lock()
 				result.MergeWith(&resultNext)
@@ -113,22 +119,19 @@ func (a *Actions) TextTypesParallel(ctx *gin.Context) {
 	}
 	wg.Wait()
 
-	if len(errs) > 0 {
+	if status, err := errs.first(); err != nil {
 		uniresp.WriteJSONErrorResponse(
-			ctx.Writer, uniresp.NewActionErrorFrom(errs[0]), http.StatusInternalServerError)
+			ctx.Writer, uniresp.NewActionErrorFrom(err), status)
 		return
 	}
 
-	sort.SliceStable(
-		result.Freqs,
-		func(i, j int) bool {
-			return result.Freqs[i].Freq > result.Freqs[j].Freq
-		},
-	)
+	result.Freqs.SortByFreq()
 	cut := maxItems
 	if maxItems == 0 {
 		cut = 100 // TODO !!! (configured on worker, cannot import here)
 	}
 	result.Freqs = result.Freqs.Cut(cut)
+	// the most frequent items are always selected first
+	sortFreqs(result.Freqs, corpusPath, sorting)
 	uniresp.WriteJSONResponse(ctx.Writer, result)
 }
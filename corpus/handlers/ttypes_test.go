@@ -0,0 +1,235 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"mquery/merror"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextTypesFreqMax(t *testing.T) {
+	stubKnownAttrs(t, "doc.genre")
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"freqDistrib": &results.FreqDistrib{},
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	for _, args := range []string{"flimit=3&fmax=40", "flimit=3&fmax=3", "flimit=3"} {
+		ctx, rec := newTestContext("/text-types/corp1?q=[lemma=\"pes\"]&attr=doc.genre&" + args)
+		actions.TextTypes(ctx)
+		assert.Equal(t, http.StatusOK, rec.Code, args)
+	}
+	var freqMax []int
+	for i := range pub.queries {
+		var args rdb.FreqDistribArgs
+		pub.publishedArgs(t, i, &args)
+		assert.Equal(t, 3, args.FreqLimit)
+		freqMax = append(freqMax, args.FreqMax)
+	}
+	assert.Equal(t, []int{40, 3, 0}, freqMax)
+
+	for _, args := range []string{"flimit=3&fmax=2", "fmax=0", "fmax=foo"} {
+		ctx, rec := newTestContext("/text-types/corp1?q=[lemma=\"pes\"]&attr=doc.genre&" + args)
+		actions.TextTypes(ctx)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, args)
+	}
+	assert.Len(t, pub.queries, 3)
+}
+
+// nestedTestToken is a token of a tiny corpus with nested
+// structures `doc` > `p` > `s`; structures are identified
+// by their numbers
+type nestedTestToken struct {
+	lemma string
+	doc   int
+	p     int
+	s     int
+}
+
+var (
+	nestedTestGenres = []string{"fiction", "news"}
+
+	nestedTestCorpus = []nestedTestToken{
+		{"pes", 0, 0, 0}, {"pes", 0, 0, 0}, {"štěkat", 0, 0, 0},
+		{"kočka", 0, 0, 1},
+		{"pes", 0, 1, 2},
+		{"pes", 1, 2, 3}, {"kočka", 1, 2, 3}, {"pes", 1, 2, 3}, {"pes", 1, 2, 3},
+		{"pes", 1, 2, 4},
+	}
+
+	nestedTestQueryRegexp = regexp.MustCompile(`^(?:<(\w+) /> containing )?\[lemma="(\w+)"\]$`)
+)
+
+// evalNestedTestQuery calculates frequencies of `doc.genre` values
+// for simple queries (optionally with `<struct /> containing`)
+// within nestedTestCorpus
+func evalNestedTestQuery(t *testing.T, query string) results.FreqDistribItemList {
+	srch := nestedTestQueryRegexp.FindStringSubmatch(query)
+	if !assert.NotNil(t, srch, query) {
+		return results.FreqDistribItemList{}
+	}
+	freqs := make(map[string]int64)
+	counted := make(map[int]bool)
+	for _, token := range nestedTestCorpus {
+		if token.lemma != srch[2] {
+			continue
+		}
+		var strctNum int
+		switch srch[1] {
+		case "":
+			strctNum = -1
+		case "doc":
+			strctNum = token.doc
+		case "p":
+			strctNum = token.p
+		case "s":
+			strctNum = token.s
+		}
+		if strctNum >= 0 {
+			if counted[strctNum] {
+				continue
+			}
+			counted[strctNum] = true
+		}
+		freqs[nestedTestGenres[token.doc]]++
+	}
+	ans := make(results.FreqDistribItemList, 0, len(freqs))
+	for w, f := range freqs {
+		ans = append(ans, &results.FreqDistribItem{Word: w, Freq: f})
+	}
+	return ans
+}
+
+func TestTextTypesMatchStruct(t *testing.T) {
+	stubKnownAttrs(t, "doc.genre", "doc", "p", "s")
+	pub := &fakePublisher{
+		respond: func(query rdb.Query) results.SerializableResult {
+			var args rdb.FreqDistribArgs
+			assert.NoError(t, json.Unmarshal(query.Args, &args))
+			return &results.FreqDistrib{Freqs: evalNestedTestQuery(t, args.Query)}
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	for _, tc := range []struct {
+		matchStruct string
+		expected    map[string]int64
+	}{
+		{"", map[string]int64{"fiction": 3, "news": 4}},
+		{"s", map[string]int64{"fiction": 2, "news": 2}},
+		{"p", map[string]int64{"fiction": 2, "news": 1}},
+		{"doc", map[string]int64{"fiction": 1, "news": 1}},
+	} {
+		reqURL := "/text-types/corp1?attr=doc.genre&q=" + url.QueryEscape(`[lemma="pes"]`)
+		if tc.matchStruct != "" {
+			reqURL += "&matchStruct=" + tc.matchStruct
+		}
+		ctx, rec := newTestContext(reqURL)
+		actions.TextTypes(ctx)
+		assert.Equal(t, http.StatusOK, rec.Code, tc.matchStruct)
+		var ans struct {
+			Freqs []struct {
+				Word string `json:"word"`
+				Freq int64  `json:"freq"`
+			} `json:"freqs"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+		freqs := make(map[string]int64)
+		for _, item := range ans.Freqs {
+			freqs[item.Word] = item.Freq
+		}
+		assert.Equal(t, tc.expected, freqs, tc.matchStruct)
+	}
+}
+
+func TestTextTypesMatchStructInvalid(t *testing.T) {
+	stubKnownAttrs(t, "doc.genre", "doc", "s")
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"freqDistrib": &results.FreqDistrib{},
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	for _, strct := range []string{"p", url.QueryEscape("s />"), "doc.genre"} {
+		ctx, rec := newTestContext(
+			"/text-types/corp1?attr=doc.genre&q=[lemma=\"pes\"]&matchStruct=" + strct)
+		actions.TextTypes(ctx)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, strct)
+	}
+	assert.Empty(t, pub.queries)
+}
+
+func TestTextTypesQueryErrorPosition(t *testing.T) {
+	stubKnownAttrs(t, "doc.genre", "s")
+	var errPos func(query string) int
+	pub := &fakePublisher{
+		respond: func(query rdb.Query) results.SerializableResult {
+			var args rdb.FreqDistribArgs
+			assert.NoError(t, json.Unmarshal(query.Args, &args))
+			var res results.FreqDistrib
+			res.SetError(merror.NewInputError(
+				fmt.Sprintf("Query syntax error at position %d", errPos(args.Query))))
+			return &res
+		},
+	}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	for _, tc := range []struct {
+		matchStruct string
+		errPos      func(query string) int
+		expected    *queryErrorInfo
+	}{
+		{
+			errPos:   func(query string) int { return len(query) },
+			expected: &queryErrorInfo{Offset: 12, Message: "Query syntax error at position 12"},
+		},
+		{
+			// the position is related to the query with the prepended structure
+			matchStruct: "s",
+			errPos:      func(query string) int { return len(query) },
+			expected:    &queryErrorInfo{Offset: 12, Message: "Query syntax error at position 29"},
+		},
+		{
+			// the position is within a part of the query added by MQuery
+			matchStruct: "s",
+			errPos:      func(query string) int { return 3 },
+		},
+	} {
+		errPos = tc.errPos
+		ctx, rec := newTestContext(
+			"/text-types/corp1?attr=doc.genre&q=" + url.QueryEscape(`[lemma="pes"`) +
+				"&matchStruct=" + tc.matchStruct)
+		actions.TextTypes(ctx)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, tc.matchStruct)
+		var ans struct {
+			QueryError *queryErrorInfo `json:"queryError"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+		assert.Equal(t, tc.expected, ans.QueryError, tc.matchStruct)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVocabSizeScope(t *testing.T) {
+	conf := newTestConf(t)
+	stubCorpusSize(t, 1000)
+	writeTestSubc(t, conf, "sub1")
+	pub := &fakePublisher{
+		results: map[string]results.SerializableResult{
+			"vocabSize": &results.VocabSize{Attr: "lemma", Value: 1234},
+		},
+	}
+	actions := &Actions{conf: conf, radapter: pub}
+
+	ctx, rec := newTestContext("/vocab-size/corp1?attr=lemma")
+	actions.VocabSize(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var ans map[string]any
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Equal(t, float64(1234), ans["value"])
+
+	ctx, rec = newTestContext("/vocab-size/corp1?attr=lemma&subc=sub1")
+	actions.VocabSize(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var whole, subc rdb.VocabSizeArgs
+	pub.publishedArgs(t, 0, &whole)
+	pub.publishedArgs(t, 1, &subc)
+	assert.Equal(t, "lemma", whole.Attr)
+	assert.Equal(t, "", whole.SubcPath)
+	assert.Equal(t, conf.GetSubcorpusPath("corp1", "sub1"), subc.SubcPath)
+}
+
+func TestVocabSizeUnknownAttr(t *testing.T) {
+	pub := &fakePublisher{}
+	actions := &Actions{conf: newTestConf(t), radapter: pub}
+	ctx, rec := newTestContext("/vocab-size/corp1?attr=foo")
+	actions.VocabSize(ctx)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Len(t, pub.queries, 0)
+}
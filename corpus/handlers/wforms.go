@@ -19,6 +19,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"mquery/rdb"
 	"mquery/results"
@@ -29,7 +30,7 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func (a *Actions) findLemmas(corpusID string, word string, pos string) ([]*results.LemmaItem, error) {
+func (a *Actions) findLemmas(ctx context.Context, corpusID string, word string, pos string) ([]*results.LemmaItem, error) {
 	q := "word=\"" + word + "\""
 	if len(pos) > 0 {
 		q += " & pos=\"" + pos + "\""
@@ -40,11 +41,12 @@ func (a *Actions) findLemmas(corpusID string, word string, pos string) ([]*resul
 		Query:      "[" + q + "]",
 		Crit:       "lemma 0~0>0 pos 0~0>0",
 		FreqLimit:  1,
+		ItemsLimit: a.conf.MaxFreqItems,
 	})
 	if err != nil {
 		return nil, err
 	}
-	wait, err := a.radapter.PublishQuery(rdb.Query{
+	wait, err := a.radapter.PublishQueryCtx(ctx, rdb.Query{
 		Func: "freqDistrib",
 		Args: args,
 	})
@@ -72,7 +74,7 @@ func (a *Actions) findLemmas(corpusID string, word string, pos string) ([]*resul
 	return ans, nil
 }
 
-func (a *Actions) findWordForms(corpusID string, lemma string, pos string) (*results.WordFormsItem, error) {
+func (a *Actions) findWordForms(ctx context.Context, corpusID string, lemma string, pos string) (*results.WordFormsItem, error) {
 	q := "lemma=\"" + lemma + "\"" // TODO hardcoded `lemma`
 	if len(pos) > 0 {
 		q += " & pos=\"" + pos + "\"" // TODO hardcoded `pos`
@@ -83,11 +85,12 @@ func (a *Actions) findWordForms(corpusID string, lemma string, pos string) (*res
 		Query:      "[" + q + "]",
 		Crit:       "word/i 0~0>0", // TODO hardcoded `word`
 		FreqLimit:  1,
+		ItemsLimit: a.conf.MaxFreqItems,
 	})
 	if err != nil {
 		return nil, err
 	}
-	wait, err := a.radapter.PublishQuery(rdb.Query{
+	wait, err := a.radapter.PublishQueryCtx(ctx, rdb.Query{
 		Func: "freqDistrib",
 		Args: args,
 	})
@@ -117,7 +120,7 @@ func (a *Actions) WordForms(ctx *gin.Context) {
 	word := ctx.Request.URL.Query().Get("word")
 	pos := ctx.Request.URL.Query().Get("pos")
 	if lemma != "" {
-		wordForms, err := a.findWordForms(ctx.Param("corpusId"), lemma, pos)
+		wordForms, err := a.findWordForms(ctx.Request.Context(), ctx.Param("corpusId"), lemma, pos)
 		if err != nil {
 			uniresp.WriteJSONErrorResponse(
 				ctx.Writer,
@@ -129,7 +132,7 @@ func (a *Actions) WordForms(ctx *gin.Context) {
 		ans = append(ans, wordForms)
 
 	} else if len(word) > 0 {
-		lemmas, err := a.findLemmas(ctx.Param("corpusId"), word, pos)
+		lemmas, err := a.findLemmas(ctx.Request.Context(), ctx.Param("corpusId"), word, pos)
 		if err != nil {
 			uniresp.WriteJSONErrorResponse(
 				ctx.Writer,
@@ -140,7 +143,7 @@ func (a *Actions) WordForms(ctx *gin.Context) {
 		}
 
 		for _, v := range lemmas {
-			wordForms, err := a.findWordForms(ctx.Param("corpusId"), v.Lemma, v.POS)
+			wordForms, err := a.findWordForms(ctx.Request.Context(), ctx.Param("corpusId"), v.Lemma, v.POS)
 			if err != nil {
 				uniresp.WriteJSONErrorResponse(
 					ctx.Writer,
@@ -0,0 +1,191 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"mquery/rdb"
+	"net/http"
+	"sort"
+
+	"github.com/czcorpus/cnc-gokit/collections"
+	"github.com/czcorpus/cnc-gokit/unireq"
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	dfltWordListMaxItems = 100
+)
+
+type aggregatedWordListItem struct {
+	Word string `json:"word"`
+	Freq int64  `json:"freq"`
+
+	// PerCorpus contains frequencies in individual corpora
+	// (filled in only if requested)
+	PerCorpus map[string]int64 `json:"perCorpus,omitempty"`
+}
+
+type aggregatedWordList struct {
+	Corpora []string                  `json:"corpora"`
+	Attr    string                    `json:"attr"`
+	Items   []*aggregatedWordListItem `json:"items"`
+
+	// Truncated is true if there are more items than returned
+	Truncated bool `json:"truncated"`
+}
+
+// MultiCorpusWordList creates a combined word list of multiple
+// corpora. Word lists of individual corpora are calculated
+// concurrently and then merged by summing frequencies of the same
+// values. All the corpora must support the requested attribute.
+// Please note that word lists of individual corpora are limited to
+// the configured `maxFreqItems` most frequent items so in case some
+// of them is truncated, the frequencies of the less frequent merged
+// items may be incomplete (this is reported via the `truncated` flag).
+func (a *Actions) MultiCorpusWordList(ctx *gin.Context) {
+	corpora := ctx.QueryArray("corpus")
+	if len(corpora) == 0 {
+		uniresp.RespondWithErrorJSON(
+			ctx, errors.New("missing `corpus` argument"), http.StatusBadRequest)
+		return
+	}
+	attr := ctx.DefaultQuery("attr", dfltWordListAttr)
+	for i, corpusID := range corpora {
+		corpusConf := a.conf.Resources.Get(corpusID)
+		if corpusConf == nil {
+			uniresp.RespondWithErrorJSON(
+				ctx, fmt.Errorf("corpus %s not found", corpusID), http.StatusNotFound)
+			return
+		}
+		if corpusConf.GetPosAttr(attr).IsZero() {
+			uniresp.RespondWithErrorJSON(
+				ctx,
+				fmt.Errorf("attribute `%s` not available in corpus %s", attr, corpusID),
+				http.StatusUnprocessableEntity,
+			)
+			return
+		}
+		if collections.SliceContains(corpora[:i], corpusID) {
+			uniresp.RespondWithErrorJSON(
+				ctx, fmt.Errorf("duplicate corpus %s", corpusID), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	minFreq, ok := unireq.GetURLIntArgOrFail(ctx, "minFreq", 1)
+	if !ok {
+		return
+	}
+	maxItems, ok := unireq.GetURLIntArgOrFail(ctx, "maxItems", dfltWordListMaxItems)
+	if !ok {
+		return
+	}
+	if minFreq < 1 || maxItems < 1 {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			errors.New("both `minFreq` and `maxItems` must be positive numbers"),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	perCorpus, ok := unireq.GetURLBoolArgOrFail(ctx, "perCorpus", false)
+	if !ok {
+		return
+	}
+
+	waits := make([]<-chan *rdb.WorkerResult, len(corpora))
+	for i, corpusID := range corpora {
+		wait, err := a.publishJob(
+			ctx.Request.Context(),
+			"wordList",
+			rdb.WordListArgs{
+				CorpusPath: a.conf.GetRegistryPath(corpusID),
+				Attr:       attr,
+				MinFreq:    minFreq,
+				MaxItems:   a.conf.MaxFreqItems,
+
+				MultivalueSeparator: a.conf.Resources.Get(corpusID).GetPosAttr(attr).MultivalueSeparator,
+			},
+		)
+		if err != nil {
+			uniresp.WriteJSONErrorResponse(
+				ctx.Writer,
+				uniresp.NewActionErrorFrom(err),
+				http.StatusInternalServerError,
+			)
+			return
+		}
+		waits[i] = wait
+	}
+
+	ans := aggregatedWordList{Corpora: corpora, Attr: attr}
+	merged := make(map[string]*aggregatedWordListItem)
+	for i, wait := range waits {
+		result, err := rdb.DeserializeWordListResult(<-wait)
+		if err != nil {
+			uniresp.WriteJSONErrorResponse(
+				ctx.Writer,
+				uniresp.NewActionErrorFrom(err),
+				http.StatusInternalServerError,
+			)
+			return
+		}
+		if err := result.Err(); err != nil {
+			uniresp.WriteJSONErrorResponse(
+				ctx.Writer,
+				uniresp.NewActionError("failed to get word list of %s: %s", corpora[i], err),
+				http.StatusInternalServerError,
+			)
+			return
+		}
+		ans.Truncated = ans.Truncated || result.Truncated
+		for _, item := range result.Items {
+			mItem, ok := merged[item.Word]
+			if !ok {
+				mItem = &aggregatedWordListItem{Word: item.Word}
+				if perCorpus {
+					mItem.PerCorpus = make(map[string]int64)
+				}
+				merged[item.Word] = mItem
+			}
+			mItem.Freq += item.Freq
+			if perCorpus {
+				mItem.PerCorpus[corpora[i]] = item.Freq
+			}
+		}
+	}
+
+	ans.Items = make([]*aggregatedWordListItem, 0, len(merged))
+	for _, item := range merged {
+		ans.Items = append(ans.Items, item)
+	}
+	sort.Slice(ans.Items, func(i, j int) bool {
+		if ans.Items[i].Freq == ans.Items[j].Freq {
+			return ans.Items[i].Word < ans.Items[j].Word
+		}
+		return ans.Items[i].Freq > ans.Items[j].Freq
+	})
+	if len(ans.Items) > maxItems {
+		ans.Items = ans.Items[:maxItems]
+		ans.Truncated = true
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, &ans)
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"mquery/corpus"
+	"mquery/rdb"
+	"mquery/results"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiCorpusWordListMerge(t *testing.T) {
+	conf := newTestConf(t)
+	conf.Resources = append(conf.Resources, &corpus.CorpusSetup{
+		ID:       "corp2",
+		PosAttrs: corpus.PosAttrList{{Name: "word"}, {Name: "lemma"}},
+	})
+	wordLists := map[string]*results.WordList{
+		conf.GetRegistryPath("corp1"): {Items: []results.WordListItem{
+			{Word: "pes", Freq: 10}, {Word: "kočka", Freq: 7}, {Word: "myš", Freq: 2},
+		}},
+		conf.GetRegistryPath("corp2"): {Items: []results.WordListItem{
+			{Word: "kočka", Freq: 8}, {Word: "pes", Freq: 1}, {Word: "kůň", Freq: 4},
+		}},
+	}
+	pub := &fakePublisher{
+		respond: func(query rdb.Query) results.SerializableResult {
+			var args rdb.WordListArgs
+			assert.NoError(t, json.Unmarshal(query.Args, &args))
+			return wordLists[args.CorpusPath]
+		},
+	}
+	actions := &Actions{conf: conf, radapter: pub}
+	ctx, rec := newTestContext("/word-list?corpus=corp1&corpus=corp2&attr=lemma&perCorpus=1")
+	actions.MultiCorpusWordList(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var ans aggregatedWordList
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Equal(t, []string{"corp1", "corp2"}, ans.Corpora)
+	assert.Equal(
+		t,
+		[]*aggregatedWordListItem{
+			{Word: "kočka", Freq: 15, PerCorpus: map[string]int64{"corp1": 7, "corp2": 8}},
+			{Word: "pes", Freq: 11, PerCorpus: map[string]int64{"corp1": 10, "corp2": 1}},
+			{Word: "kůň", Freq: 4, PerCorpus: map[string]int64{"corp2": 4}},
+			{Word: "myš", Freq: 2, PerCorpus: map[string]int64{"corp1": 2}},
+		},
+		ans.Items,
+	)
+	assert.False(t, ans.Truncated)
+
+	ctx, rec = newTestContext("/word-list?corpus=corp1&corpus=corp2&attr=lemma&maxItems=2")
+	actions.MultiCorpusWordList(ctx)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ans = aggregatedWordList{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Len(t, ans.Items, 2)
+	assert.Nil(t, ans.Items[0].PerCorpus)
+	assert.True(t, ans.Truncated)
+}
+
+func TestMultiCorpusWordListAttrMismatch(t *testing.T) {
+	conf := newTestConf(t)
+	conf.Resources = append(conf.Resources, &corpus.CorpusSetup{
+		ID:       "corp2",
+		PosAttrs: corpus.PosAttrList{{Name: "word"}, {Name: "lemma"}},
+	})
+	pub := &fakePublisher{}
+	actions := &Actions{conf: conf, radapter: pub}
+	ctx, rec := newTestContext("/word-list?corpus=corp1&corpus=corp2&attr=tag")
+	actions.MultiCorpusWordList(ctx)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Len(t, pub.queries, 0)
+}
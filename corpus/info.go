@@ -20,7 +20,6 @@ package corpus
 
 import (
 	"fmt"
-	"mquery/mango"
 )
 
 type DBInfo struct {
@@ -55,7 +54,7 @@ func LoadSizes(corpora []string, conf *CorporaSetup) (SizesInfo, error) {
 	ans := make(SizesInfo)
 	for _, corpusID := range corpora {
 		corpusPath := conf.GetRegistryPath(corpusID)
-		corpusSize, err := mango.GetCorpusSize(corpusPath)
+		corpusSize, err := GetCorpusSize(corpusPath)
 		if err != nil {
 			return ans, fmt.Errorf("failed to get corpora sizes: %w", err)
 		}
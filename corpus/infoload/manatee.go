@@ -24,6 +24,8 @@ import (
 	"mquery/corpus/baseinfo"
 	"mquery/rdb"
 	"mquery/results"
+	"strings"
+	"sync"
 
 	"github.com/czcorpus/cnc-gokit/fs"
 )
@@ -31,7 +33,15 @@ import (
 type Manatee struct {
 	conf         *corpus.CorporaSetup
 	queryHandler corpus.QueryHandler
-	cache        map[string]*results.CorpusInfo
+
+	// cache is accessed by concurrent handlers so
+	// it must be guarded by cacheLock
+	cache     map[string]*results.CorpusInfo
+	cacheLock sync.RWMutex
+
+	// loads coalesces concurrent loads of the same information
+	// (e.g. on a cold start) into a single worker query
+	loads corpus.CallGroup[*results.CorpusInfo]
 }
 
 func mergeConfigInfo(conf *corpus.CorpusSetup, info *results.CorpusInfo, lang string) {
@@ -68,11 +78,31 @@ func (kdb *Manatee) makeCacheKey(corpusId string, language string) string {
 }
 
 func (kdb *Manatee) LoadCorpusInfo(corpusId string, language string) (*results.CorpusInfo, error) {
+	kdb.cacheLock.RLock()
 	val, ok := kdb.cache[kdb.makeCacheKey(corpusId, language)]
+	kdb.cacheLock.RUnlock()
 	if ok {
 		return val, nil
 	}
+	return kdb.loads.Do(
+		kdb.makeCacheKey(corpusId, language),
+		func() (*results.CorpusInfo, error) {
+			// the information may have been loaded by a call
+			// finished after our cache lookup
+			kdb.cacheLock.RLock()
+			val, ok := kdb.cache[kdb.makeCacheKey(corpusId, language)]
+			kdb.cacheLock.RUnlock()
+			if ok {
+				return val, nil
+			}
+			return kdb.loadCorpusInfo(corpusId, language)
+		},
+	)
+}
 
+// loadCorpusInfo obtains corpus information from a worker
+// and stores it in the cache
+func (kdb *Manatee) loadCorpusInfo(corpusId string, language string) (*results.CorpusInfo, error) {
 	corpusPath := kdb.conf.GetRegistryPath(corpusId)
 	args, err := json.Marshal(rdb.CorpusInfoArgs{
 		CorpusPath: corpusPath,
@@ -104,10 +134,28 @@ func (kdb *Manatee) LoadCorpusInfo(corpusId string, language string) (*results.C
 		return nil, corpusInfo.Err()
 	}
 	mergeConfigInfo(kdb.conf.Resources.Get(corpusId), &corpusInfo, language)
+	kdb.cacheLock.Lock()
 	kdb.cache[kdb.makeCacheKey(corpusId, language)] = &corpusInfo
+	kdb.cacheLock.Unlock()
 	return &corpusInfo, nil
 }
 
+// InvalidateCorpus removes all the cached information (i.e. for all
+// the languages) about a corpus. It returns the number of removed entries.
+func (kdb *Manatee) InvalidateCorpus(corpusId string) int {
+	kdb.cacheLock.Lock()
+	defer kdb.cacheLock.Unlock()
+	var ans int
+	prefix := kdb.makeCacheKey(corpusId, "")
+	for k := range kdb.cache {
+		if strings.HasPrefix(k, prefix) {
+			delete(kdb.cache, k)
+			ans++
+		}
+	}
+	return ans
+}
+
 func NewManatee(
 	queryHandler corpus.QueryHandler,
 	conf *corpus.CorporaSetup,
@@ -0,0 +1,172 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package infoload
+
+import (
+	"mquery/corpus"
+	"mquery/rdb"
+	"mquery/results"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingQueryHandler responds to all the queries with
+// an empty corpus info and counts the published queries
+type countingQueryHandler struct {
+	numQueries atomic.Int64
+}
+
+func (qh *countingQueryHandler) PublishQuery(query rdb.Query) (<-chan *rdb.WorkerResult, error) {
+	qh.numQueries.Add(1)
+	res, err := rdb.CreateWorkerResult(&results.CorpusInfo{})
+	if err != nil {
+		return nil, err
+	}
+	ans := make(chan *rdb.WorkerResult, 1)
+	ans <- res
+	return ans, nil
+}
+
+func newTestManatee(t *testing.T, corpora ...string) (*Manatee, *countingQueryHandler) {
+	conf := &corpus.CorporaSetup{RegistryDir: t.TempDir()}
+	for _, corpusID := range corpora {
+		conf.Resources = append(conf.Resources, &corpus.CorpusSetup{ID: corpusID})
+		assert.NoError(
+			t,
+			os.WriteFile(filepath.Join(conf.RegistryDir, corpusID), []byte{}, 0644),
+		)
+	}
+	qh := &countingQueryHandler{}
+	return NewManatee(qh, conf), qh
+}
+
+func TestLoadCorpusInfoCached(t *testing.T) {
+	kdb, qh := newTestManatee(t, "corp1")
+	for i := 0; i < 3; i++ {
+		info, err := kdb.LoadCorpusInfo("corp1", "en")
+		assert.NoError(t, err)
+		assert.NotNil(t, info)
+	}
+	assert.Equal(t, int64(1), qh.numQueries.Load())
+	_, err := kdb.LoadCorpusInfo("corp1", "cs")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), qh.numQueries.Load())
+}
+
+func TestLoadCorpusInfoUnknownCorpus(t *testing.T) {
+	kdb, qh := newTestManatee(t, "corp1")
+	_, err := kdb.LoadCorpusInfo("corp2", "en")
+	assert.ErrorIs(t, err, corpus.ErrNotFound)
+	assert.Equal(t, int64(0), qh.numQueries.Load())
+}
+
+func TestInvalidateCorpus(t *testing.T) {
+	kdb, qh := newTestManatee(t, "corp1", "corp2")
+	for _, corpusID := range []string{"corp1", "corp2"} {
+		for _, lang := range []string{"en", "cs"} {
+			_, err := kdb.LoadCorpusInfo(corpusID, lang)
+			assert.NoError(t, err)
+		}
+	}
+	assert.Equal(t, 2, kdb.InvalidateCorpus("corp1"))
+	assert.Equal(t, 0, kdb.InvalidateCorpus("corp1"))
+	_, err := kdb.LoadCorpusInfo("corp2", "en")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), qh.numQueries.Load())
+	_, err = kdb.LoadCorpusInfo("corp1", "en")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), qh.numQueries.Load())
+}
+
+// TestManateeCacheConcurrentAccess is mostly useful
+// along with the race detector (go test -race)
+func TestManateeCacheConcurrentAccess(t *testing.T) {
+	corpora := []string{"corp1", "corp2", "corp3"}
+	langs := []string{"en", "cs"}
+	kdb, _ := newTestManatee(t, corpora...)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				corpusID := corpora[(i+j)%len(corpora)]
+				if j%25 == 0 {
+					kdb.InvalidateCorpus(corpusID)
+					continue
+				}
+				info, err := kdb.LoadCorpusInfo(corpusID, langs[j%len(langs)])
+				assert.NoError(t, err, "%s, iteration %d", corpusID, j)
+				assert.NotNil(t, info)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// blockingQueryHandler works like countingQueryHandler but
+// it does not respond until `release` is closed
+type blockingQueryHandler struct {
+	countingQueryHandler
+	release chan struct{}
+}
+
+func (qh *blockingQueryHandler) PublishQuery(query rdb.Query) (<-chan *rdb.WorkerResult, error) {
+	wait, err := qh.countingQueryHandler.PublishQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	ans := make(chan *rdb.WorkerResult, 1)
+	go func() {
+		<-qh.release
+		ans <- <-wait
+	}()
+	return ans, nil
+}
+
+func TestLoadCorpusInfoConcurrentCold(t *testing.T) {
+	kdb, _ := newTestManatee(t, "corp1")
+	qh := &blockingQueryHandler{release: make(chan struct{})}
+	kdb.queryHandler = qh
+	var wg sync.WaitGroup
+	infos := make([]*results.CorpusInfo, 100)
+	for i := range infos {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			infos[i], err = kdb.LoadCorpusInfo("corp1", "en")
+			assert.NoError(t, err)
+		}(i)
+	}
+	// let some of the callers join the unfinished load, the others
+	// will find the result in the cache
+	time.Sleep(10 * time.Millisecond)
+	close(qh.release)
+	wg.Wait()
+	assert.Equal(t, int64(1), qh.numQueries.Load())
+	for _, info := range infos {
+		assert.Same(t, infos[0], info)
+	}
+}
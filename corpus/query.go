@@ -20,9 +20,18 @@ package corpus
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 )
 
+var (
+	cqlTokenRegexp     = regexp.MustCompile(`\[([^\]]*)\]`)
+	cqlMatchAnyRegexp  = regexp.MustCompile(`^\s*!?\s*[\w.]+\s*=\s*"\.[*+]"\s*$`)
+	cqlRepetitionChars = " \t{},0123456789*+?"
+	cqlLabelRegexp     = regexp.MustCompile(`(?:^|[^\w"])(\d+):\s*\[`)
+	cqlSingleTokenRgxp = regexp.MustCompile(`^\s*\[([^\]]*)\]\s*$`)
+)
+
 func SubcorpusToCQL(tt TextTypes) string {
 	var buff strings.Builder
 	for attr, values := range tt {
@@ -34,3 +43,109 @@ func SubcorpusToCQL(tt TextTypes) string {
 	}
 	return buff.String()
 }
+
+// EscapeCQLRegexp escapes characters with a special meaning
+// in CQL attribute values (which are regular expressions)
+func EscapeCQLRegexp(v string) string {
+	var ans strings.Builder
+	for _, c := range v {
+		if strings.ContainsRune(`\".*+?|()[]{}^$`, c) {
+			ans.WriteRune('\\')
+		}
+		ans.WriteRune(c)
+	}
+	return ans.String()
+}
+
+// QueryLabels returns labels of all the labeled tokens
+// (e.g. `1:[lemma="dog"]`) found in a CQL query
+func QueryLabels(query string) []string {
+	ans := make([]string, 0, 2)
+	for _, m := range cqlLabelRegexp.FindAllStringSubmatch(query, -1) {
+		ans = append(ans, m[1])
+	}
+	return ans
+}
+
+// FreqCritAttr returns an attribute of a single-level
+// frequency criterion (e.g. `lemma` for `lemma/e 0~0>0`).
+// For multi-level criteria, an empty string is returned.
+func FreqCritAttr(crit string) string {
+	items := strings.Fields(crit)
+	if len(items) == 0 || len(items) > 2 {
+		return ""
+	}
+	return strings.SplitN(items[0], "/", 2)[0]
+}
+
+// DrillDownQuery creates a CQL query matching exactly the occurrences
+// of `query` counted in a frequency distribution item with the value
+// `value` of a single-level criterion `crit`. The second returned value
+// is false in case such a query cannot be created. Currently supported
+// are structural attributes (e.g. `doc.genre 0`) and positional attributes
+// of the whole KWIC (e.g. `lemma 0~0>0`) in case the query matches single
+// tokens. Criteria with flags other than `e` (e.g. `lemma/i`) are not
+// supported as their values do not match the original data exactly.
+func DrillDownQuery(query, crit, value string) (string, bool) {
+	items := strings.Fields(crit)
+	if len(items) == 0 || len(items) > 2 {
+		return "", false
+	}
+	attrFlags := strings.SplitN(items[0], "/", 2)
+	if len(attrFlags) == 2 && attrFlags[1] != "" && attrFlags[1] != "e" {
+		return "", false
+	}
+	attr := attrFlags[0]
+	if strct, sattr, ok := strings.Cut(attr, "."); ok {
+		return fmt.Sprintf(
+			`%s within <%s %s="%s" />`, query, strct, sattr, EscapeCQLRegexp(value)), true
+	}
+	if len(items) == 2 && items[1] != "0" && items[1] != "0~0>0" {
+		return "", false
+	}
+	srch := cqlSingleTokenRgxp.FindStringSubmatch(query)
+	if srch == nil || strings.Contains(value, " ") {
+		return "", false
+	}
+	cond := fmt.Sprintf(`%s="%s"`, attr, EscapeCQLRegexp(value))
+	if strings.TrimSpace(srch[1]) == "" {
+		return fmt.Sprintf("[%s]", cond), true
+	}
+	return fmt.Sprintf("[(%s) & %s]", srch[1], cond), true
+}
+
+// isMatchAnyToken tests whether a CQL token specification (i.e. the
+// contents of `[...]`) matches any token (e.g. `[]`, `[word=".*"]`).
+func isMatchAnyToken(spec string) bool {
+	if strings.TrimSpace(spec) == "" {
+		return true
+	}
+	for _, cond := range strings.Split(spec, "&") {
+		if !cqlMatchAnyRegexp.MatchString(cond) || strings.Contains(cond, "!") {
+			return false
+		}
+	}
+	return true
+}
+
+// IsMatchAllQuery tests (without evaluating the query) whether
+// a CQL query obviously matches any corpus position - i.e. it
+// consists only of tokens matching any token (e.g. `[]`, `[][]`,
+// `[lemma=".*"]+`). It is intended as a cheap guard against
+// ruinously expensive queries, not as a general estimation of
+// the query result size - any other query (including e.g. `within`
+// or alternatives) is reported as not matching all.
+func IsMatchAllQuery(query string) bool {
+	tokens := cqlTokenRegexp.FindAllStringSubmatch(query, -1)
+	if len(tokens) == 0 {
+		return false
+	}
+	for _, tok := range tokens {
+		if !isMatchAnyToken(tok[1]) {
+			return false
+		}
+	}
+	rest := cqlTokenRegexp.ReplaceAllString(query, "")
+	// e.g. `within`, `containing`, alternatives etc. make the query non-trivial
+	return strings.Trim(rest, cqlRepetitionChars) == ""
+}
@@ -0,0 +1,103 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package corpus
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsMatchAllQuery(t *testing.T) {
+	for _, q := range []string{
+		`[]`, `[][]`, `[] []{2,3}`, `[word=".*"]`, `[lemma=".+"]+`, `[word=".*" & tag=".*"]`,
+	} {
+		assert.True(t, IsMatchAllQuery(q), q)
+	}
+	for _, q := range []string{
+		`[lemma="pes"]`, `[word=".*"] [lemma="pes"]`, `[] within <doc genre="fiction" />`,
+		`[word!=".*"]`, `[word=".*" & tag="N.*"]`, `"pes"`, `[]|[lemma="pes"]`,
+	} {
+		assert.False(t, IsMatchAllQuery(q), q)
+	}
+}
+
+func TestQueryLabels(t *testing.T) {
+	assert.Equal(t, []string{"1", "2"}, QueryLabels(`1:[tag="N.*"] 2:[tag="V.*"]`))
+	assert.Equal(t, []string{"1", "12"}, QueryLabels(`1:[tag="N.*"] []{0,2} 12: [lemma="pes"]`))
+	assert.Equal(t, []string{}, QueryLabels(`[lemma="1:[a]"] [word="x"]`))
+	assert.Equal(t, []string{}, QueryLabels(`[lemma="pes"]`))
+}
+
+func TestFreqCritAttr(t *testing.T) {
+	assert.Equal(t, "lemma", FreqCritAttr("lemma/e 0~0>0"))
+	assert.Equal(t, "word", FreqCritAttr("word 0"))
+	assert.Equal(t, "doc.genre", FreqCritAttr("doc.genre 0"))
+	assert.Equal(t, "", FreqCritAttr("lemma 1:0 lemma 2:0"))
+	assert.Equal(t, "", FreqCritAttr(""))
+}
+
+func TestDrillDownQuery(t *testing.T) {
+	for _, tc := range []struct {
+		query, crit, value, expected string
+	}{
+		{`[lemma="pes"]`, "word/e 0~0>0", "psa", `[(lemma="pes") & word="psa"]`},
+		{`[lemma="pes"]`, "word 0", "psi", `[(lemma="pes") & word="psi"]`},
+		{`[]`, "lemma/e 0~0>0", "a.b", `[lemma="a\.b"]`},
+		{`[lemma="pes"]`, "doc.genre 0", "fiction", `[lemma="pes"] within <doc genre="fiction" />`},
+		{`[lemma="pes"] [lemma="štěkat"]`, "doc.genre 0", "news", `[lemma="pes"] [lemma="štěkat"] within <doc genre="news" />`},
+	} {
+		q, ok := DrillDownQuery(tc.query, tc.crit, tc.value)
+		assert.True(t, ok, tc.crit)
+		assert.Equal(t, tc.expected, q)
+	}
+}
+
+func TestDrillDownQueryUnsupported(t *testing.T) {
+	for _, tc := range [][3]string{
+		{`[lemma="pes"]`, "word/i 0~0>0", "psa"},
+		{`[lemma="pes"]`, "word 1", "psa"},
+		{`[lemma="pes"]`, "word 0 tag 0", "psa\tNN"},
+		{`[lemma="pes"] [lemma="štěkat"]`, "word 0~0>0", "psa"},
+		{`[lemma="pes"]`, "word 0~0>0", "New York"},
+		{`[lemma="pes"]`, "", "psa"},
+	} {
+		_, ok := DrillDownQuery(tc[0], tc[1], tc[2])
+		assert.False(t, ok, tc[1])
+	}
+}
+
+func TestDrillDownQueryValueMatchesExactly(t *testing.T) {
+	valueRgxp := regexp.MustCompile(`word="((?:[^"\\]|\\.)*)"`)
+	for _, value := range []string{"a.b", "C++", `"quoted"`, "(x)|y", "[abc]", "$5^2", `back\slash`, "?"} {
+		q, ok := DrillDownQuery("[]", "word 0", value)
+		assert.True(t, ok, value)
+		srch := valueRgxp.FindStringSubmatch(q)
+		if !assert.NotNil(t, srch, q) {
+			continue
+		}
+		rgxp, err := regexp.Compile("^(?:" + srch[1] + ")$")
+		if !assert.NoError(t, err, q) {
+			continue
+		}
+		assert.True(t, rgxp.MatchString(value), q)
+		assert.False(t, rgxp.MatchString(value+"x"), q)
+	}
+}
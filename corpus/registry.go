@@ -0,0 +1,163 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package corpus
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/czcorpus/cnc-gokit/collections"
+)
+
+const (
+	RegistryRedactedValue = "***"
+)
+
+var (
+	// DfltRegistryRedactedKeys are registry keys whose values are
+	// not exposed in case `registryRedactedKeys` is not configured
+	DfltRegistryRedactedKeys = []string{"PATH", "VERTICAL", "SUBCDEF", "SUBCBASE"}
+
+	// DfltCitationFields maps citation fields to registry keys
+	// in case `citationFields` is not configured
+	DfltCitationFields = map[string]string{
+		"name":        "NAME",
+		"description": "INFO",
+		"url":         "INFOHREF",
+		"language":    "LANGUAGE",
+		"author":      "AUTHOR",
+		"citation":    "CITATION",
+	}
+)
+
+// RegistryBlock is a parsed part of a Manatee registry file.
+// The top-level block represents the corpus, nested blocks
+// represent attributes and structures.
+type RegistryBlock struct {
+	Conf       map[string]string         `json:"conf"`
+	Attributes map[string]*RegistryBlock `json:"attributes,omitempty"`
+	Structures map[string]*RegistryBlock `json:"structures,omitempty"`
+}
+
+func newRegistryBlock() *RegistryBlock {
+	return &RegistryBlock{Conf: make(map[string]string)}
+}
+
+// addChild registers a nested block (e.g. `ATTRIBUTE word`)
+// and returns it
+func (rb *RegistryBlock) addChild(kind, name string) *RegistryBlock {
+	child := newRegistryBlock()
+	switch kind {
+	case "ATTRIBUTE":
+		if rb.Attributes == nil {
+			rb.Attributes = make(map[string]*RegistryBlock)
+		}
+		rb.Attributes[name] = child
+	case "STRUCTURE":
+		if rb.Structures == nil {
+			rb.Structures = make(map[string]*RegistryBlock)
+		}
+		rb.Structures[name] = child
+	}
+	return child
+}
+
+// Redact replaces values of all the `keys` (on all the levels)
+// with RegistryRedactedValue
+func (rb *RegistryBlock) Redact(keys []string) {
+	for k := range rb.Conf {
+		if collections.SliceContains(keys, k) {
+			rb.Conf[k] = RegistryRedactedValue
+		}
+	}
+	for _, child := range rb.Attributes {
+		child.Redact(keys)
+	}
+	for _, child := range rb.Structures {
+		child.Redact(keys)
+	}
+}
+
+// ParseRegistry parses a Manatee registry file. Only the
+// registry syntax is processed (i.e. no default values are
+// added and no corpus data are accessed).
+func ParseRegistry(path string) (*RegistryBlock, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse registry %s: %w", path, err)
+	}
+	defer f.Close()
+	root := newRegistryBlock()
+	stack := []*RegistryBlock{root}
+	var lastChild *RegistryBlock
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		opensBlock := strings.HasSuffix(line, "{")
+		if opensBlock {
+			line = strings.TrimSpace(strings.TrimSuffix(line, "{"))
+		}
+		switch {
+		case line == "" && opensBlock:
+			if lastChild == nil {
+				return nil, fmt.Errorf(
+					"failed to parse registry %s: unexpected `{` on line %d", path, lineNum)
+			}
+			stack = append(stack, lastChild)
+			lastChild = nil
+			continue
+		case line == "}":
+			if len(stack) == 1 {
+				return nil, fmt.Errorf(
+					"failed to parse registry %s: unexpected `}` on line %d", path, lineNum)
+			}
+			stack = stack[:len(stack)-1]
+			lastChild = nil
+			continue
+		}
+		key, value, _ := strings.Cut(line, " ")
+		value = strings.Trim(strings.TrimSpace(value), "\"")
+		curr := stack[len(stack)-1]
+		if key == "ATTRIBUTE" || key == "STRUCTURE" {
+			lastChild = curr.addChild(key, value)
+			if opensBlock {
+				stack = append(stack, lastChild)
+				lastChild = nil
+			}
+
+		} else {
+			curr.Conf[key] = value
+			lastChild = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse registry %s: %w", path, err)
+	}
+	if len(stack) > 1 {
+		return nil, fmt.Errorf("failed to parse registry %s: unclosed block", path)
+	}
+	return root, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package corpus
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testRegistry = `# test corpus
+NAME "Test corpus"
+PATH /var/corpora/data/corp1
+ENCODING "UTF-8"
+
+ATTRIBUTE word
+ATTRIBUTE lemma {
+	LOCALE "cs_CZ.UTF-8"
+}
+STRUCTURE doc
+{
+	ATTRIBUTE genre
+	ATTRIBUTE title {
+		MULTIVALUE yes
+	}
+}
+`
+
+func writeTestRegistry(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "corp1")
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0644))
+	return path
+}
+
+func TestParseRegistry(t *testing.T) {
+	reg, err := ParseRegistry(writeTestRegistry(t, testRegistry))
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		map[string]string{
+			"NAME":     "Test corpus",
+			"PATH":     "/var/corpora/data/corp1",
+			"ENCODING": "UTF-8",
+		},
+		reg.Conf,
+	)
+	assert.Len(t, reg.Attributes, 2)
+	assert.Equal(t, "cs_CZ.UTF-8", reg.Attributes["lemma"].Conf["LOCALE"])
+	assert.Empty(t, reg.Attributes["word"].Conf)
+	doc := reg.Structures["doc"]
+	if assert.NotNil(t, doc) {
+		assert.Len(t, doc.Attributes, 2)
+		assert.Equal(t, "yes", doc.Attributes["title"].Conf["MULTIVALUE"])
+	}
+}
+
+func TestParseRegistryInvalid(t *testing.T) {
+	for _, data := range []string{
+		"ATTRIBUTE word {\n",
+		"NAME corp1\n}\n",
+		"NAME corp1\n{\n",
+	} {
+		_, err := ParseRegistry(writeTestRegistry(t, data))
+		assert.Error(t, err, data)
+	}
+}
+
+func TestRegistryBlockRedact(t *testing.T) {
+	reg, err := ParseRegistry(writeTestRegistry(
+		t, testRegistry+"STRUCTURE p {\n\tPATH /var/corpora/p\n}\n"))
+	assert.NoError(t, err)
+	reg.Redact(DfltRegistryRedactedKeys)
+	assert.Equal(t, RegistryRedactedValue, reg.Conf["PATH"])
+	assert.Equal(t, RegistryRedactedValue, reg.Structures["p"].Conf["PATH"])
+	assert.Equal(t, "Test corpus", reg.Conf["NAME"])
+}
@@ -0,0 +1,125 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package corpus
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type corpusSizeFunc func(corpusPath string) (int64, error)
+
+// subcFileStamp identifies a version of a `.subc` file
+// validated against a corpus
+type subcFileStamp struct {
+	corpusPath string
+	modTime    time.Time
+	size       int64
+}
+
+// SubcValidations remembers successfully validated subcorpora (see
+// ValidateSubcorpus) so the validation (which requires the corpus size
+// and reading the whole `.subc` file) is not repeated with each
+// request. A validation is reused only as long as the `.subc` file has
+// the same modification time and size. As a recompiled corpus may have
+// a different size, validations of the corpus should be removed along
+// with other corpus caches (see RemoveCorpus).
+type SubcValidations struct {
+	validated     map[string]subcFileStamp
+	getCorpusSize corpusSizeFunc
+	lock          sync.Mutex
+}
+
+// Validate tests whether the subcorpus `subcPath` fits within the corpus
+// `corpusPath` unless the same version of the file has been already
+// successfully validated against the corpus. In case of a mismatch,
+// a wrapped ErrSubcorpusMismatch is returned.
+func (sv *SubcValidations) Validate(corpusPath, subcPath string) error {
+	info, err := os.Stat(subcPath)
+	if err != nil {
+		return fmt.Errorf("failed to validate subcorpus: %w", err)
+	}
+	stamp := subcFileStamp{
+		corpusPath: corpusPath,
+		modTime:    info.ModTime(),
+		size:       info.Size(),
+	}
+	sv.lock.Lock()
+	prev, ok := sv.validated[subcPath]
+	sv.lock.Unlock()
+	if ok && prev == stamp {
+		return nil
+	}
+	corpusSize, err := sv.getCorpusSize(corpusPath)
+	if err != nil {
+		return fmt.Errorf("failed to validate subcorpus: %w", err)
+	}
+	if err := ValidateSubcorpus(subcPath, corpusSize); err != nil {
+		return err
+	}
+	sv.lock.Lock()
+	sv.validated[subcPath] = stamp
+	sv.lock.Unlock()
+	return nil
+}
+
+// RemoveCorpus removes all the remembered validations of subcorpora
+// of a corpus. It returns the number of removed entries.
+func (sv *SubcValidations) RemoveCorpus(corpusPath string) int {
+	sv.lock.Lock()
+	defer sv.lock.Unlock()
+	var ans int
+	for subcPath, stamp := range sv.validated {
+		if stamp.corpusPath == corpusPath {
+			delete(sv.validated, subcPath)
+			ans++
+		}
+	}
+	return ans
+}
+
+// NewSubcValidations creates a new subcorpora validations cache.
+// In case `getCorpusSize` is nil, GetCorpusSize is used (a custom
+// function is mostly useful for testing).
+func NewSubcValidations(getCorpusSize corpusSizeFunc) *SubcValidations {
+	if getCorpusSize == nil {
+		getCorpusSize = GetCorpusSize
+	}
+	return &SubcValidations{
+		validated:     make(map[string]subcFileStamp),
+		getCorpusSize: getCorpusSize,
+	}
+}
+
+var subcValidations = NewSubcValidations(nil)
+
+// ValidateSubcorpusOnce works like ValidateSubcorpus but the validation
+// of an unchanged `.subc` file is performed only once (see SubcValidations).
+func ValidateSubcorpusOnce(corpusPath, subcPath string) error {
+	return subcValidations.Validate(corpusPath, subcPath)
+}
+
+// ForgetValidatedSubcorpora removes remembered validations of all the
+// subcorpora of a corpus (e.g. because the corpus has been recompiled).
+// It returns the number of removed entries.
+func ForgetValidatedSubcorpora(corpusPath string) int {
+	return subcValidations.RemoveCorpus(corpusPath)
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package corpus
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestSubcRanges(t *testing.T, path string, ranges ...[2]uint64) {
+	data := make([]byte, 0, subcRangeSize*len(ranges))
+	for _, rng := range ranges {
+		data = binary.LittleEndian.AppendUint64(data, rng[0])
+		data = binary.LittleEndian.AppendUint64(data, rng[1])
+	}
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+}
+
+// newTestSubcValidations creates validations with a fake corpus
+// of the size 1000 which counts corpus size calls
+func newTestSubcValidations() (*SubcValidations, *int) {
+	var numCalls int
+	return NewSubcValidations(func(corpusPath string) (int64, error) {
+		numCalls++
+		return 1000, nil
+	}), &numCalls
+}
+
+func TestValidateSubcorpus(t *testing.T) {
+	subcPath := filepath.Join(t.TempDir(), "sub1.subc")
+	writeTestSubcRanges(t, subcPath, [2]uint64{0, 10}, [2]uint64{20, 1000})
+	assert.NoError(t, ValidateSubcorpus(subcPath, 1000))
+	assert.ErrorIs(t, ValidateSubcorpus(subcPath, 999), ErrSubcorpusMismatch)
+
+	writeTestSubcRanges(t, subcPath, [2]uint64{20, 30}, [2]uint64{0, 10})
+	assert.ErrorIs(t, ValidateSubcorpus(subcPath, 1000), ErrSubcorpusMismatch)
+
+	assert.NoError(t, os.WriteFile(subcPath, []byte{1, 2, 3}, 0644))
+	assert.ErrorIs(t, ValidateSubcorpus(subcPath, 1000), ErrSubcorpusMismatch)
+}
+
+func TestSubcValidationsReused(t *testing.T) {
+	sv, numCalls := newTestSubcValidations()
+	subcPath := filepath.Join(t.TempDir(), "sub1.subc")
+	writeTestSubcRanges(t, subcPath, [2]uint64{0, 10})
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, sv.Validate("/var/registry/corp1", subcPath))
+	}
+	assert.Equal(t, 1, *numCalls)
+
+	// the same file validated against a different corpus
+	assert.NoError(t, sv.Validate("/var/registry/corp2", subcPath))
+	assert.Equal(t, 2, *numCalls)
+}
+
+func TestSubcValidationsChangedFile(t *testing.T) {
+	sv, numCalls := newTestSubcValidations()
+	subcPath := filepath.Join(t.TempDir(), "sub1.subc")
+	writeTestSubcRanges(t, subcPath, [2]uint64{0, 10})
+	assert.NoError(t, sv.Validate("/var/registry/corp1", subcPath))
+
+	writeTestSubcRanges(t, subcPath, [2]uint64{0, 10}, [2]uint64{20, 2000})
+	assert.ErrorIs(t, sv.Validate("/var/registry/corp1", subcPath), ErrSubcorpusMismatch)
+	assert.Equal(t, 2, *numCalls)
+	// failed validations are not remembered
+	assert.ErrorIs(t, sv.Validate("/var/registry/corp1", subcPath), ErrSubcorpusMismatch)
+	assert.Equal(t, 3, *numCalls)
+
+	// a file of the same size with a different modification time
+	writeTestSubcRanges(t, subcPath, [2]uint64{0, 10}, [2]uint64{20, 30})
+	assert.NoError(t, os.Chtimes(subcPath, time.Now(), time.Now().Add(time.Hour)))
+	assert.NoError(t, sv.Validate("/var/registry/corp1", subcPath))
+	assert.Equal(t, 4, *numCalls)
+}
+
+func TestSubcValidationsRemoveCorpus(t *testing.T) {
+	sv, numCalls := newTestSubcValidations()
+	dir := t.TempDir()
+	for _, name := range []string{"sub1.subc", "sub2.subc"} {
+		writeTestSubcRanges(t, filepath.Join(dir, name), [2]uint64{0, 10})
+		assert.NoError(t, sv.Validate("/var/registry/corp1", filepath.Join(dir, name)))
+	}
+	assert.Equal(t, 0, sv.RemoveCorpus("/var/registry/corp2"))
+	assert.Equal(t, 2, sv.RemoveCorpus("/var/registry/corp1"))
+	assert.NoError(t, sv.Validate("/var/registry/corp1", filepath.Join(dir, "sub1.subc")))
+	assert.Equal(t, 3, *numCalls)
+}
+
+func TestSubcValidationsMissingFile(t *testing.T) {
+	sv, numCalls := newTestSubcValidations()
+	assert.Error(t, sv.Validate("/var/registry/corp1", filepath.Join(t.TempDir(), "foo.subc")))
+	assert.Equal(t, 0, *numCalls)
+}
@@ -0,0 +1,125 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package corpus
+
+import (
+	"container/list"
+	"mquery/mango"
+	"strings"
+	"sync"
+)
+
+type ttNormsLoader func(corpusPath, attr string) (map[string]int64, error)
+
+type ttNormsCacheEntry struct {
+	key   string
+	norms map[string]int64
+}
+
+// TTNormsCache is a read-through cache of text types norms (i.e.
+// sizes of all the values of a structural attribute). The norms
+// depend only on corpus data so they can be cached until a corpus
+// is recompiled (see RemoveCorpus). The cache is bounded by the number
+// of cached (corpus, attribute) pairs and the least recently used
+// ones are removed first. The returned maps are shared among callers
+// so they must not be modified.
+type TTNormsCache struct {
+	entries    map[string]*list.Element
+	lru        *list.List
+	maxEntries int
+	load       ttNormsLoader
+	lock       sync.Mutex
+}
+
+func (nc *TTNormsCache) mkKey(corpusPath, attr string) string {
+	return corpusPath + "\x00" + attr
+}
+
+// Get returns text types norms of a structural attribute `attr`
+// (in the `struct.attr` form) either from the cache or, in case
+// they are not cached yet, loaded from the corpus.
+func (nc *TTNormsCache) Get(corpusPath, attr string) (map[string]int64, error) {
+	key := nc.mkKey(corpusPath, attr)
+	nc.lock.Lock()
+	if elm, ok := nc.entries[key]; ok {
+		nc.lru.MoveToFront(elm)
+		nc.lock.Unlock()
+		return elm.Value.(*ttNormsCacheEntry).norms, nil
+	}
+	nc.lock.Unlock()
+
+	// we do not keep the lock during the (possibly long) loading
+	// so that other attributes can be served in the meantime
+	norms, err := nc.load(corpusPath, attr)
+	if err != nil {
+		return norms, err
+	}
+	nc.lock.Lock()
+	defer nc.lock.Unlock()
+	if elm, ok := nc.entries[key]; ok {
+		// someone else has loaded the norms in the meantime
+		nc.lru.MoveToFront(elm)
+		return elm.Value.(*ttNormsCacheEntry).norms, nil
+	}
+	for nc.lru.Len() > 0 && nc.lru.Len() >= nc.maxEntries {
+		nc.remove(nc.lru.Back())
+	}
+	nc.entries[key] = nc.lru.PushFront(&ttNormsCacheEntry{key: key, norms: norms})
+	return norms, nil
+}
+
+// remove removes an entry; the caller is responsible
+// for locking the cache
+func (nc *TTNormsCache) remove(elm *list.Element) {
+	delete(nc.entries, elm.Value.(*ttNormsCacheEntry).key)
+	nc.lru.Remove(elm)
+}
+
+// RemoveCorpus removes all the cached norms of a corpus.
+// It returns the number of removed entries.
+func (nc *TTNormsCache) RemoveCorpus(corpusPath string) int {
+	nc.lock.Lock()
+	defer nc.lock.Unlock()
+	var ans int
+	prefix := corpusPath + "\x00"
+	for elm := nc.lru.Back(); elm != nil; {
+		prev := elm.Prev()
+		if strings.HasPrefix(elm.Value.(*ttNormsCacheEntry).key, prefix) {
+			nc.remove(elm)
+			ans++
+		}
+		elm = prev
+	}
+	return ans
+}
+
+// NewTTNormsCache creates a new text types norms cache. In case
+// `load` is nil, mango.GetTextTypesNorms is used to load the norms
+// (a custom function is mostly useful for testing).
+func NewTTNormsCache(maxEntries int, load ttNormsLoader) *TTNormsCache {
+	if load == nil {
+		load = mango.GetTextTypesNorms
+	}
+	return &TTNormsCache{
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		maxEntries: maxEntries,
+		load:       load,
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package corpus
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingTTNormsLoader returns a norms loader along with
+// a counter of its invocations
+func countingTTNormsLoader() (ttNormsLoader, *atomic.Int32) {
+	var numCalls atomic.Int32
+	load := func(corpusPath, attr string) (map[string]int64, error) {
+		numCalls.Add(1)
+		return map[string]int64{corpusPath + ":" + attr: 100}, nil
+	}
+	return load, &numCalls
+}
+
+func TestTTNormsCacheHit(t *testing.T) {
+	load, numCalls := countingTTNormsLoader()
+	cache := NewTTNormsCache(10, load)
+	norms, err := cache.Get("/var/registry/corp1", "doc.genre")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"/var/registry/corp1:doc.genre": 100}, norms)
+	norms, err = cache.Get("/var/registry/corp1", "doc.genre")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"/var/registry/corp1:doc.genre": 100}, norms)
+	assert.Equal(t, int32(1), numCalls.Load())
+
+	// a different attribute (or corpus) is loaded separately
+	_, err = cache.Get("/var/registry/corp1", "doc.author")
+	assert.NoError(t, err)
+	_, err = cache.Get("/var/registry/corp2", "doc.genre")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), numCalls.Load())
+}
+
+func TestTTNormsCacheEviction(t *testing.T) {
+	load, numCalls := countingTTNormsLoader()
+	cache := NewTTNormsCache(2, load)
+	cache.Get("/var/registry/corp1", "doc.genre")
+	cache.Get("/var/registry/corp1", "doc.author")
+	// doc.genre becomes the most recently used one
+	cache.Get("/var/registry/corp1", "doc.genre")
+	assert.Equal(t, int32(2), numCalls.Load())
+
+	// the least recently used entry (doc.author) is removed
+	cache.Get("/var/registry/corp1", "doc.year")
+	assert.Equal(t, int32(3), numCalls.Load())
+	cache.Get("/var/registry/corp1", "doc.genre")
+	assert.Equal(t, int32(3), numCalls.Load())
+	cache.Get("/var/registry/corp1", "doc.author")
+	assert.Equal(t, int32(4), numCalls.Load())
+	assert.Equal(t, 2, cache.lru.Len())
+	assert.Len(t, cache.entries, 2)
+}
+
+func TestTTNormsCacheRemoveCorpus(t *testing.T) {
+	load, numCalls := countingTTNormsLoader()
+	cache := NewTTNormsCache(10, load)
+	cache.Get("/var/registry/corp1", "doc.genre")
+	cache.Get("/var/registry/corp1", "doc.author")
+	cache.Get("/var/registry/corp10", "doc.genre")
+	assert.Equal(t, 2, cache.RemoveCorpus("/var/registry/corp1"))
+	assert.Equal(t, 0, cache.RemoveCorpus("/var/registry/corp1"))
+
+	cache.Get("/var/registry/corp10", "doc.genre")
+	assert.Equal(t, int32(3), numCalls.Load())
+	cache.Get("/var/registry/corp1", "doc.genre")
+	assert.Equal(t, int32(4), numCalls.Load())
+}
+
+func TestTTNormsCacheErrorNotCached(t *testing.T) {
+	var numCalls int
+	cache := NewTTNormsCache(10, func(corpusPath, attr string) (map[string]int64, error) {
+		numCalls++
+		return nil, errors.New("failed to open corpus")
+	})
+	for i := 0; i < 2; i++ {
+		_, err := cache.Get("/var/registry/corp1", "doc.genre")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 2, numCalls)
+	assert.Equal(t, 0, cache.lru.Len())
+}
+
+func TestTTNormsCacheConcurrentAccess(t *testing.T) {
+	load, numCalls := countingTTNormsLoader()
+	cache := NewTTNormsCache(2, load)
+	attrs := []string{"doc.genre", "doc.author", "doc.year"}
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			attr := attrs[i%len(attrs)]
+			norms, err := cache.Get("/var/registry/corp1", attr)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(100), norms["/var/registry/corp1:"+attr])
+			if i%10 == 0 {
+				cache.RemoveCorpus("/var/registry/corp1")
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, cache.lru.Len(), 2)
+	assert.Equal(t, cache.lru.Len(), len(cache.entries))
+	assert.GreaterOrEqual(t, numCalls.Load(), int32(len(attrs)))
+}
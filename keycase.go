@@ -0,0 +1,230 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mquery/cnf"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+//go:generate go run ./tools/jsonkeys/gen -o keycase_keys.go
+
+const (
+	keyCaseHeader = "X-Response-Key-Case"
+)
+
+// keyCaseWriter buffers a JSON response so its keys can be
+// converted once a handler is finished. Other responses (e.g.
+// XML or server-sent events) are written directly.
+type keyCaseWriter struct {
+	gin.ResponseWriter
+	buff   bytes.Buffer
+	status int
+	direct *bool
+}
+
+func (w *keyCaseWriter) isDirect() bool {
+	if w.direct == nil {
+		direct := !strings.Contains(w.Header().Get("Content-Type"), "application/json")
+		w.direct = &direct
+		if direct && w.status != 0 {
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+	}
+	return *w.direct
+}
+
+func (w *keyCaseWriter) WriteHeader(code int) {
+	if w.direct != nil && *w.direct {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+func (w *keyCaseWriter) Write(data []byte) (int, error) {
+	if w.isDirect() {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.buff.Write(data)
+}
+
+func (w *keyCaseWriter) WriteHeaderNow() {
+	if w.direct != nil && *w.direct {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *keyCaseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *keyCaseWriter) Flush() {
+	if w.isDirect() {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *keyCaseWriter) Status() int {
+	if w.direct == nil || !*w.direct {
+		if w.status != 0 {
+			return w.status
+		}
+	}
+	return w.ResponseWriter.Status()
+}
+
+// snakeToCamel converts e.g. `default_ref` to `defaultRef`
+func snakeToCamel(key string) string {
+	var ans strings.Builder
+	upper := false
+	for i, c := range key {
+		if c == '_' && i > 0 {
+			upper = true
+			continue
+		}
+		if upper {
+			ans.WriteRune(unicode.ToUpper(c))
+			upper = false
+
+		} else {
+			ans.WriteRune(c)
+		}
+	}
+	return ans.String()
+}
+
+// camelToSnake converts e.g. `concSize` to `conc_size`
+func camelToSnake(key string) string {
+	var ans strings.Builder
+	for i, c := range key {
+		if unicode.IsUpper(c) {
+			if i > 0 {
+				ans.WriteRune('_')
+			}
+			ans.WriteRune(unicode.ToLower(c))
+
+		} else {
+			ans.WriteRune(c)
+		}
+	}
+	return ans.String()
+}
+
+// convertKeys recursively converts keys of all the JSON objects
+// (including the nested ones) found in `data`. Only keys defined
+// via struct tags (see structJSONKeys) are converted - data-based
+// keys (e.g. attribute names, registry keys, corpora IDs) are kept
+// as they are.
+func convertKeys(data any, conv func(string) string) any {
+	switch tData := data.(type) {
+	case map[string]any:
+		ans := make(map[string]any, len(tData))
+		for k, v := range tData {
+			if structJSONKeys[k] {
+				k = conv(k)
+			}
+			ans[k] = convertKeys(v, conv)
+		}
+		return ans
+	case []any:
+		for i, v := range tData {
+			tData[i] = convertKeys(v, conv)
+		}
+		return tData
+	default:
+		return data
+	}
+}
+
+// ConvertJSONKeys converts keys of a JSON document to the required
+// case (cnf.KeyCaseCamel or cnf.KeyCaseSnake). Numbers are kept
+// in their original form. Only keys defined via struct tags are
+// converted (see convertKeys).
+func ConvertJSONKeys(src []byte, keyCase string) ([]byte, error) {
+	var conv func(string) string
+	switch keyCase {
+	case cnf.KeyCaseCamel:
+		conv = snakeToCamel
+	case cnf.KeyCaseSnake:
+		conv = camelToSnake
+	default:
+		return src, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(src))
+	dec.UseNumber()
+	var data any
+	if err := dec.Decode(&data); err != nil {
+		return src, err
+	}
+	return json.Marshal(convertKeys(data, conv))
+}
+
+// KeyCaseMiddleware converts keys of JSON responses to the case
+// requested via the X-Response-Key-Case header (`camel` or `snake`)
+// or, if not specified, the configured `responseKeyCase`. In case
+// neither is set, responses are written as they are.
+func KeyCaseMiddleware(conf *cnf.Conf) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		keyCase := ctx.GetHeader(keyCaseHeader)
+		if keyCase == "" {
+			keyCase = conf.ResponseKeyCase
+		}
+		if keyCase == "" {
+			ctx.Next()
+			return
+		}
+		if !cnf.IsValidKeyCase(keyCase) {
+			ctx.AbortWithStatusJSON(
+				http.StatusBadRequest,
+				gin.H{"error": "invalid " + keyCaseHeader + " value `" + keyCase + "`"},
+			)
+			return
+		}
+		origWriter := ctx.Writer
+		writer := &keyCaseWriter{ResponseWriter: origWriter}
+		ctx.Writer = writer
+		ctx.Next()
+		ctx.Writer = origWriter
+		if writer.direct != nil && *writer.direct {
+			return
+		}
+		data := writer.buff.Bytes()
+		if len(data) > 0 {
+			conv, err := ConvertJSONKeys(data, keyCase)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to convert response keys, using original ones")
+
+			} else {
+				data = conv
+			}
+			origWriter.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		}
+		if writer.status != 0 {
+			origWriter.WriteHeader(writer.status)
+		}
+		origWriter.Write(data)
+	}
+}
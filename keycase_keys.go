@@ -0,0 +1,278 @@
+// Code generated by "go run ./tools/jsonkeys/gen"; DO NOT EDIT.
+
+package main
+
+// structJSONKeys contains JSON keys defined via struct tags
+// (see KeyCaseMiddleware)
+var structJSONKeys = map[string]bool{
+	"approxWaitSecs":         true,
+	"args":                   true,
+	"article_ref":            true,
+	"attr":                   true,
+	"attr1":                  true,
+	"attr2":                  true,
+	"attrList":               true,
+	"attributes":             true,
+	"attrs":                  true,
+	"authHeaderName":         true,
+	"authTokens":             true,
+	"avgLength":              true,
+	"avgQueryTimeSecs":       true,
+	"begin":                  true,
+	"boundaries":             true,
+	"boundaryStruct":         true,
+	"buildDate":              true,
+	"cached":                 true,
+	"channel":                true,
+	"channelQuery":           true,
+	"channelResultPrefix":    true,
+	"chunkNum":               true,
+	"citation":               true,
+	"citationFields":         true,
+	"citationInfo":           true,
+	"claimedAt":              true,
+	"close":                  true,
+	"code":                   true,
+	"colTotals":              true,
+	"collAttrs":              true,
+	"collFreqDataAttrs":      true,
+	"collocations":           true,
+	"colls":                  true,
+	"cols":                   true,
+	"concCacheMaxLines":      true,
+	"concCacheTtlSecs":       true,
+	"concMaxItems":           true,
+	"concPct":                true,
+	"concSize":               true,
+	"concordance":            true,
+	"conf":                   true,
+	"contents":               true,
+	"contextAttrs":           true,
+	"contextStructs":         true,
+	"corpname":               true,
+	"corpora":                true,
+	"corpus":                 true,
+	"corpusName":             true,
+	"corpusPath":             true,
+	"corpusSize":             true,
+	"corsAllowedOrigins":     true,
+	"crit":                   true,
+	"criteria":               true,
+	"data":                   true,
+	"db":                     true,
+	"defaultCollMeasure":     true,
+	"defaultFreqLimit":       true,
+	"defaultSubc":            true,
+	"default_ref":            true,
+	"delete":                 true,
+	"density":                true,
+	"deprecated":             true,
+	"description":            true,
+	"detail":                 true,
+	"details":                true,
+	"doc":                    true,
+	"docAttr":                true,
+	"docStruct":              true,
+	"docUrlEn":               true,
+	"docUrlLocal":            true,
+	"end":                    true,
+	"entries":                true,
+	"errMsg":                 true,
+	"error":                  true,
+	"errorResponseFormat":    true,
+	"errorType":              true,
+	"examples":               true,
+	"examplesQueryTpl":       true,
+	"fcrit":                  true,
+	"featAttr":               true,
+	"flags":                  true,
+	"flimit":                 true,
+	"forms":                  true,
+	"freq":                   true,
+	"freqLimit":              true,
+	"freqMax":                true,
+	"freqs":                  true,
+	"fromPos":                true,
+	"fromYear":               true,
+	"fullName":               true,
+	"func":                   true,
+	"get":                    true,
+	"gitCommit":              true,
+	"gloss":                  true,
+	"glossAttr":              true,
+	"glossCorpusPath":        true,
+	"hideTokenPosRef":        true,
+	"highWaterMark":          true,
+	"hits":                   true,
+	"host":                   true,
+	"id":                     true,
+	"ident":                  true,
+	"in":                     true,
+	"info":                   true,
+	"ipm":                    true,
+	"isDefault":              true,
+	"isSubcorpus":            true,
+	"isTextTypes":            true,
+	"items":                  true,
+	"itemsBudget":            true,
+	"itemsLimit":             true,
+	"kwicAttrs":              true,
+	"kwicLen":                true,
+	"language":               true,
+	"lastCommit":             true,
+	"lastUpdate":             true,
+	"leftCtx":                true,
+	"lemma":                  true,
+	"lines":                  true,
+	"listenAddress":          true,
+	"listenPort":             true,
+	"locale":                 true,
+	"locales":                true,
+	"logDice":                true,
+	"logFile":                true,
+	"logLevel":               true,
+	"manateeVersion":         true,
+	"matrix":                 true,
+	"mattr":                  true,
+	"maxCollSrchRange":       true,
+	"maxContext":             true,
+	"maxDocs":                true,
+	"maxFreqItems":           true,
+	"maxItems":               true,
+	"maxResults":             true,
+	"maxSplitChunks":         true,
+	"maximumRecords":         true,
+	"measure":                true,
+	"measureCode":            true,
+	"measureLabel":           true,
+	"message":                true,
+	"minCoocFreq":            true,
+	"minFreq":                true,
+	"minFreqLimit":           true,
+	"minScore":               true,
+	"mktokencovPath":         true,
+	"modifiers":              true,
+	"multiprocChunkSize":     true,
+	"multivalueSeparator":    true,
+	"name":                   true,
+	"nextOffset":             true,
+	"norm":                   true,
+	"normBasis":              true,
+	"numSamples":             true,
+	"numStuckJobs":           true,
+	"numWorkers":             true,
+	"offset":                 true,
+	"ok":                     true,
+	"openapi":                true,
+	"operationId":            true,
+	"other_bibliography":     true,
+	"parameters":             true,
+	"parentAttr":             true,
+	"parentIdxAttr":          true,
+	"password":               true,
+	"paths":                  true,
+	"perCorpus":              true,
+	"port":                   true,
+	"pos":                    true,
+	"posAttr":                true,
+	"posAttrs":               true,
+	"posCategory":            true,
+	"positions":              true,
+	"post":                   true,
+	"privacyPolicy":          true,
+	"procTimeSecs":           true,
+	"publicUrl":              true,
+	"put":                    true,
+	"query":                  true,
+	"queryAnswerTimeoutSecs": true,
+	"queryError":             true,
+	"queryLemma":             true,
+	"queueHighWaterMark":     true,
+	"queueLength":            true,
+	"redis":                  true,
+	"ref":                    true,
+	"registry":               true,
+	"registryDir":            true,
+	"registryRedactedKeys":   true,
+	"rejectMatchAllQueries":  true,
+	"reported":               true,
+	"requeueStuckJobs":       true,
+	"requeued":               true,
+	"required":               true,
+	"resources":              true,
+	"responseEnvelope":       true,
+	"responseKeyCase":        true,
+	"resultAttrs":            true,
+	"resultType":             true,
+	"rightCtx":               true,
+	"rowTotals":              true,
+	"rows":                   true,
+	"sampleSeed":             true,
+	"sampleSize":             true,
+	"schema":                 true,
+	"score":                  true,
+	"searchSize":             true,
+	"serverReadTimeoutSecs":  true,
+	"serverWriteTimeoutSecs": true,
+	"servers":                true,
+	"shutdownGraceSecs":      true,
+	"size":                   true,
+	"sizeDependent":          true,
+	"slowQueryThresholdSecs": true,
+	"sortByIPM":              true,
+	"splitCorporaDir":        true,
+	"srchKeywords":           true,
+	"srchRange":              true,
+	"stage":                  true,
+	"stages":                 true,
+	"startLine":              true,
+	"status":                 true,
+	"strictFreqLimit":        true,
+	"stripControlChars":      true,
+	"strong":                 true,
+	"struct":                 true,
+	"structAttrs":            true,
+	"structCount":            true,
+	"structList":             true,
+	"structs":                true,
+	"structures":             true,
+	"sttr":                   true,
+	"stuckJobThresholdSecs":  true,
+	"subcPath":               true,
+	"subcorpora":             true,
+	"subcorporaDir":          true,
+	"syntaxConcordance":      true,
+	"text":                   true,
+	"textTypes":              true,
+	"timeZone":               true,
+	"title":                  true,
+	"toPos":                  true,
+	"toYear":                 true,
+	"tokenPos":               true,
+	"tokens":                 true,
+	"total":                  true,
+	"totalChunks":            true,
+	"traceContext":           true,
+	"truncated":              true,
+	"ttNormsCacheSize":       true,
+	"ttOverviewAttrs":        true,
+	"ttr":                    true,
+	"tuple":                  true,
+	"type":                   true,
+	"typeCount":              true,
+	"types":                  true,
+	"url":                    true,
+	"value":                  true,
+	"variants":               true,
+	"version":                true,
+	"viewContextStruct":      true,
+	"vocabSize":              true,
+	"webUrl":                 true,
+	"widgetEnabled":          true,
+	"window":                 true,
+	"windowSize":             true,
+	"word":                   true,
+	"words":                  true,
+	"workerId":               true,
+	"year":                   true,
+}
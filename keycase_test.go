@@ -0,0 +1,162 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"mquery/cnf"
+	"mquery/corpus"
+	"mquery/corpus/baseinfo"
+	"mquery/tools/jsonkeys"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// testKeyCaseResponse combines struct-defined keys in both
+// cases with data-based keys (registry keys, attribute names,
+// corpora IDs)
+type testKeyCaseResponse struct {
+	Corpus     string                       `json:"corpus"`
+	CorpusSize int64                        `json:"corpusSize"`
+	Registry   *corpus.RegistryBlock        `json:"registry"`
+	PerCorpus  map[string]int64             `json:"perCorpus"`
+	Citations  map[string]baseinfo.Citation `json:"citations"`
+}
+
+func newTestKeyCaseResponse() testKeyCaseResponse {
+	return testKeyCaseResponse{
+		Corpus:     "intercorp_v13_en",
+		CorpusSize: 1000,
+		Registry: &corpus.RegistryBlock{
+			Conf: map[string]string{"DEFAULTATTR": "word", "MAXCONTEXT": "50"},
+			Structures: map[string]*corpus.RegistryBlock{
+				"doc": {
+					Conf: map[string]string{"DEFAULTVALUE": "?"},
+					Attributes: map[string]*corpus.RegistryBlock{
+						"doc_id":   {Conf: map[string]string{"MULTIVALUE": "n"}},
+						"pubYear":  {Conf: map[string]string{}},
+						"txt_type": {Conf: map[string]string{}},
+					},
+				},
+			},
+		},
+		PerCorpus: map[string]int64{"intercorp_v13_en": 10, "syn2020": 7},
+		Citations: map[string]baseinfo.Citation{
+			"intercorp_v13_en": {DefaultRef: "Rosen, A.", ArticleRef: []string{}},
+		},
+	}
+}
+
+func convertTestResponse(t *testing.T, keyCase string) map[string]any {
+	src, err := json.Marshal(newTestKeyCaseResponse())
+	assert.NoError(t, err)
+	conv, err := ConvertJSONKeys(src, keyCase)
+	assert.NoError(t, err)
+	var ans map[string]any
+	assert.NoError(t, json.Unmarshal(conv, &ans))
+	return ans
+}
+
+func mapKeys(data any) []string {
+	ans := make([]string, 0)
+	for k := range data.(map[string]any) {
+		ans = append(ans, k)
+	}
+	sort.Strings(ans)
+	return ans
+}
+
+func TestConvertJSONKeysSnake(t *testing.T) {
+	ans := convertTestResponse(t, cnf.KeyCaseSnake)
+	assert.Equal(
+		t,
+		[]string{"citations", "corpus", "corpus_size", "per_corpus", "registry"},
+		mapKeys(ans),
+	)
+	assert.Equal(t, "intercorp_v13_en", ans["corpus"])
+	registry := ans["registry"].(map[string]any)
+	assert.Equal(t, []string{"DEFAULTATTR", "MAXCONTEXT"}, mapKeys(registry["conf"]))
+	doc := registry["structures"].(map[string]any)["doc"].(map[string]any)
+	assert.Equal(t, []string{"DEFAULTVALUE"}, mapKeys(doc["conf"]))
+	assert.Equal(t, []string{"doc_id", "pubYear", "txt_type"}, mapKeys(doc["attributes"]))
+	assert.Equal(t, []string{"intercorp_v13_en", "syn2020"}, mapKeys(ans["per_corpus"]))
+	citation := ans["citations"].(map[string]any)["intercorp_v13_en"]
+	assert.Equal(t, []string{"article_ref", "default_ref", "other_bibliography"}, mapKeys(citation))
+}
+
+func TestConvertJSONKeysCamel(t *testing.T) {
+	ans := convertTestResponse(t, cnf.KeyCaseCamel)
+	assert.Equal(
+		t,
+		[]string{"citations", "corpus", "corpusSize", "perCorpus", "registry"},
+		mapKeys(ans),
+	)
+	doc := ans["registry"].(map[string]any)["structures"].(map[string]any)["doc"].(map[string]any)
+	assert.Equal(t, []string{"doc_id", "pubYear", "txt_type"}, mapKeys(doc["attributes"]))
+	assert.Equal(t, []string{"intercorp_v13_en", "syn2020"}, mapKeys(ans["perCorpus"]))
+	citations := ans["citations"].(map[string]any)
+	assert.Equal(t, []string{"intercorp_v13_en"}, mapKeys(citations))
+	assert.Equal(
+		t,
+		[]string{"articleRef", "defaultRef", "otherBibliography"},
+		mapKeys(citations["intercorp_v13_en"]),
+	)
+}
+
+func TestKeyCaseMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(KeyCaseMiddleware(&cnf.Conf{ResponseKeyCase: cnf.KeyCaseSnake}))
+	engine.GET("/resp", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, newTestKeyCaseResponse())
+	})
+	for _, tc := range []struct {
+		header   string
+		expected []string
+	}{
+		{"", []string{"citations", "corpus", "corpus_size", "per_corpus", "registry"}},
+		{cnf.KeyCaseCamel, []string{"citations", "corpus", "corpusSize", "perCorpus", "registry"}},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/resp", nil)
+		if tc.header != "" {
+			req.Header.Set(keyCaseHeader, tc.header)
+		}
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var ans map[string]any
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+		assert.Equal(t, tc.expected, mapKeys(ans))
+		assert.Contains(t, ans["registry"].(map[string]any)["conf"], "DEFAULTATTR")
+	}
+}
+
+func TestStructJSONKeysUpToDate(t *testing.T) {
+	keys, err := jsonkeys.CollectModule(".")
+	assert.NoError(t, err)
+	known := make([]string, 0, len(structJSONKeys))
+	for k := range structJSONKeys {
+		known = append(known, k)
+	}
+	sort.Strings(known)
+	assert.Equal(t, keys, known, "structJSONKeys outdated, please run `go generate`")
+}
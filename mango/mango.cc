@@ -30,9 +30,34 @@
 #include <memory>
 #include <sstream>
 #include <map>
+#include <algorithm>
+#include <stdexcept>
+#include <sys/stat.h>
 
 using namespace std;
 
+/**
+ * QueryError signals that a query cannot be evaluated (e.g. due to
+ * a syntax error) so it can be distinguished from other Manatee errors.
+ */
+class QueryError : public std::runtime_error {
+public:
+    explicit QueryError(const char* msg) : std::runtime_error(msg) {}
+};
+
+/**
+ * eval_query evaluates a CQL query within a corpus (or a subcorpus)
+ * and throws QueryError in case the query is invalid
+ */
+static RangeStream* eval_query(const char* query, Corpus* corp) {
+    try {
+        return corp->filter_query(eval_cqpquery(query, corp));
+
+    } catch (std::exception &e) {
+        throw QueryError(e.what());
+    }
+}
+
 
 CorpusRetval open_corpus(const char* corpusPath) {
     string tmp(corpusPath);
@@ -87,17 +112,21 @@ ConcSizeRetVal concordance_size(const char* corpusPath, const char* query) {
     string cPath(corpusPath);
     ConcSizeRetVal ans;
     ans.err = nullptr;
+    ans.errorCode = 0;
     ans.value = 0;
     Corpus* corp = nullptr;
     Concordance* conc = nullptr;
     try {
         corp = new Corpus(cPath);
         ans.corpusSize = corp->size();
-        conc = new Concordance(
-            corp, corp->filter_query(eval_cqpquery(query, corp)));
+        conc = new Concordance(corp, eval_query(query, corp));
         conc->sync();
         ans.value = conc->size();
 
+    } catch (QueryError &e) {
+        ans.err = strdup(e.what());
+        ans.errorCode = 2;
+
     } catch (std::exception &e) {
         ans.err = strdup(e.what());
     }
@@ -156,7 +185,58 @@ FreqsRetval freq_dist_from_conc(CorpusV corpus, ConcV conc, char* fcrit, PosInt
 }
 
 
-FreqsRetval freq_dist(const char* corpusPath, const char* subcPath, const char* query, const char* fcrit, PosInt flimit) {
+/**
+ * @brief Keep only `maxItems` items with the highest frequencies.
+ * Items with equal frequencies are selected by their value so the
+ * result is stable (which is required for paging).
+ * The original order of the kept items is preserved. Norms are
+ * expected to be either empty or of the same size as freqs.
+ *
+ * @return true if some items were removed, false otherwise
+ */
+static bool keep_top_freqs(
+    vector<string>& words, vector<PosInt>& freqs, vector<PosInt>& norms, PosInt maxItems) {
+
+    if (maxItems <= 0 || freqs.size() <= static_cast<size_t>(maxItems)) {
+        return false;
+    }
+    vector<size_t> idx(freqs.size());
+    for (size_t i = 0; i < idx.size(); i++) {
+        idx[i] = i;
+    }
+    nth_element(
+        idx.begin(), idx.begin() + (maxItems - 1), idx.end(),
+        [&freqs, &words](size_t a, size_t b) {
+            if (freqs[a] != freqs[b]) {
+                return freqs[a] > freqs[b];
+            }
+            return words[a] < words[b];
+        });
+    idx.resize(maxItems);
+    sort(idx.begin(), idx.end());
+
+    vector<string> topWords;
+    vector<PosInt> topFreqs;
+    vector<PosInt> topNorms;
+    topWords.reserve(maxItems);
+    topFreqs.reserve(maxItems);
+    topNorms.reserve(norms.empty() ? 0 : maxItems);
+    for (size_t i : idx) {
+        topWords.push_back(words[i]);
+        topFreqs.push_back(freqs[i]);
+        if (!norms.empty()) {
+            topNorms.push_back(norms[i]);
+        }
+    }
+    words.swap(topWords);
+    freqs.swap(topFreqs);
+    norms.swap(topNorms);
+    return true;
+}
+
+FreqsRetval freq_dist(
+    const char* corpusPath, const char* subcPath, const char* query, const char* fcrit,
+    PosInt flimit, PosInt maxItems) {
     string cPath(corpusPath);
     try {
         Corpus* corp = new Corpus(cPath);
@@ -174,7 +254,7 @@ FreqsRetval freq_dist(const char* corpusPath, const char* subcPath, const char*
 
         if (subcPath && *subcPath != '\0') {
             subc = new SubCorpus(corp, subcPath);
-            conc = new Concordance(subc, subc->filter_query(eval_cqpquery(query, subc)));
+            conc = new Concordance(subc, eval_query(query, subc));
             conc->sync();
             subc->freq_dist(conc->RS(), fcrit, flimit, words, freqs, norms);
             concSize = conc->size();
@@ -182,13 +262,14 @@ FreqsRetval freq_dist(const char* corpusPath, const char* subcPath, const char*
             searchSize = subc->search_size();
 
         } else {
-            conc = new Concordance(corp, corp->filter_query(eval_cqpquery(query, corp)));
+            conc = new Concordance(corp, eval_query(query, corp));
             conc->sync();
             corp->freq_dist(conc->RS(), fcrit, flimit, words, freqs, norms);
             concSize = conc->size();
             corpSize = corp->size();
             searchSize = corp->size();
         }
+        bool truncated = keep_top_freqs(words, freqs, norms, maxItems);
         FreqsRetval ans {
             static_cast<void*>(xwords),
             static_cast<void*>(xfreqs),
@@ -196,13 +277,29 @@ FreqsRetval freq_dist(const char* corpusPath, const char* subcPath, const char*
             concSize,
             corpSize,
             searchSize,
-            nullptr
+            nullptr,
+            truncated ? 1 : 0,
+            0
         };
         delete conc;
         delete subc;
         delete corp;
         return ans;
 
+    } catch (QueryError &e) {
+        FreqsRetval ans {
+            nullptr,
+            nullptr,
+            nullptr,
+            0,
+            0,
+            0,
+            strdup(e.what()),
+            0,
+            2
+        };
+        return ans;
+
     } catch (std::exception &e) {
         FreqsRetval ans {
             nullptr,
@@ -218,30 +315,253 @@ FreqsRetval freq_dist(const char* corpusPath, const char* subcPath, const char*
 }
 
 /**
- * @brief Based on provided query, return at most `limit` sentences matching the query.
- *
- * @param corpusPath
- * @param query
- * @param attrs Positional attributes (comma-separated) to be attached to returned tokens
- * @param limit
- * @return KWICRowsRetval
+ * @brief Make sure the frequencies of an attribute are compiled
+ * for a subcorpus (Manatee stores them as `[subc path w/o suffix].[attr].frq`).
+ * Otherwise, Manatee would fail with a rather cryptic error (or it would
+ * compute the frequencies on the fly).
  */
-KWICRowsRetval conc_examples(
-    const char* corpusPath, const char* query, const char* attrs, PosInt fromLine, PosInt limit,
-        PosInt maxContext, const char* viewContextStruct) {
+static void ensure_subc_freqs(const char* subcPath, const char* attr) {
+    string basePath(subcPath);
+    size_t suffPos = basePath.rfind(".subc");
+    if (suffPos != string::npos && suffPos == basePath.size() - 5) {
+        basePath.erase(suffPos);
+    }
+    string frqPath = basePath + "." + attr + ".frq";
+    struct stat st;
+    if (stat(frqPath.c_str(), &st) != 0) {
+        throw std::runtime_error(
+            "subcorpus frequencies of `" + string(attr) + "` not compiled (missing " + frqPath + ")");
+    }
+}
 
+FreqsRetval word_list(const char* corpusPath, const char* subcPath, const char* attr, PosInt minFreq) {
     string cPath(corpusPath);
+    Corpus* corp = nullptr;
+    SubCorpus* subc = nullptr;
     try {
-        Corpus* corp = new Corpus(cPath);
-        Concordance* conc = new Concordance(
-            corp, corp->filter_query(eval_cqpquery(query, corp)));
-        conc->sync();
-        if (conc->size() == 0 && fromLine == 0) {
+        corp = new Corpus(cPath);
+        // the vectors are released (i.e. passed to the caller)
+        // only in case of success
+        std::unique_ptr<vector<string>> xwords(new vector<string>);
+        vector<string>& words = *xwords;
+        std::unique_ptr<vector<PosInt>> xfreqs(new vector<PosInt>);
+        vector<PosInt>& freqs = *xfreqs;
+        std::unique_ptr<vector<PosInt>> xnorms(new vector<PosInt>);
+        PosAttr* pattr;
+        PosInt searchSize;
+
+        if (subcPath && *subcPath != '\0') {
+            ensure_subc_freqs(subcPath, attr);
+            subc = new SubCorpus(corp, subcPath);
+            pattr = subc->get_attr(attr);
+            searchSize = subc->search_size();
+
+        } else {
+            pattr = corp->get_attr(attr);
+            searchSize = corp->size();
+        }
+        for (PosInt i = 0; i < pattr->id_range(); i++) {
+            PosInt freq = pattr->freq(i);
+            if (freq > 0 && freq >= minFreq) {
+                words.push_back(pattr->id2str(i));
+                freqs.push_back(freq);
+            }
+        }
+        FreqsRetval ans {
+            static_cast<void*>(xwords.release()),
+            static_cast<void*>(xfreqs.release()),
+            static_cast<void*>(xnorms.release()),
+            0,
+            corp->size(),
+            searchSize,
+            nullptr
+        };
+        delete subc;
+        delete corp;
+        return ans;
+
+    } catch (std::exception &e) {
+        delete subc;
+        delete corp;
+        FreqsRetval ans {
+            nullptr,
+            nullptr,
+            nullptr,
+            0,
+            0,
+            0,
+            strdup(e.what())
+        };
+        return ans;
+    }
+}
+
+CorpRegionRetval get_corp_region(const char* corpusPath, const char* attrs, PosInt fromPos, PosInt toPos) {
+    string cPath(corpusPath);
+    try {
+        std::unique_ptr<Corpus> corp(new Corpus(cPath));
+        vector<PosAttr*> pattrs;
+        std::istringstream attrsStream(attrs);
+        string attr;
+        while (std::getline(attrsStream, attr, ',')) {
+            pattrs.push_back(corp->get_attr(attr));
+        }
+        if (fromPos < 0) {
+            fromPos = 0;
+        }
+        if (toPos > corp->size()) {
+            toPos = corp->size();
+        }
+        // the vector is released (i.e. passed to the caller)
+        // only in case of success
+        std::unique_ptr<vector<string>> xvalues(new vector<string>);
+        if (toPos > fromPos) {
+            xvalues->reserve((toPos - fromPos) * pattrs.size());
+            vector<std::unique_ptr<TextIterator>> iters;
+            for (auto pattr : pattrs) {
+                iters.emplace_back(pattr->textat(fromPos));
+            }
+            for (PosInt pos = fromPos; pos < toPos; pos++) {
+                for (auto& it : iters) {
+                    xvalues->push_back(it->next());
+                }
+            }
+        }
+        CorpRegionRetval ans {
+            static_cast<void*>(xvalues.release()),
+            fromPos,
+            toPos,
+            nullptr
+        };
+        return ans;
+
+    } catch (std::exception &e) {
+        CorpRegionRetval ans {
+            nullptr,
+            0,
+            0,
+            strdup(e.what())
+        };
+        return ans;
+    }
+}
+
+/**
+ * ConcHandle keeps a compiled (and shuffled) concordance along
+ * with the corpus objects it depends on so it can be reused
+ * e.g. for paging.
+ */
+struct ConcHandle {
+    Corpus* corp;
+    SubCorpus* subc;
+    Concordance* conc;
+};
+
+static void delete_conc_handle(ConcHandle* h) {
+    delete h->conc;
+    delete h->subc;
+    delete h->corp;
+    delete h;
+}
+
+/**
+ * compile_conc_handle compiles a concordance and creates
+ * a handle for it (without shuffling/sampling the lines)
+ */
+static ConcHandle* compile_conc_handle(const char* corpusPath, const char* subcPath, const char* query) {
+    ConcHandle* h = new ConcHandle{nullptr, nullptr, nullptr};
+    try {
+        string cPath(corpusPath);
+        h->corp = new Corpus(cPath);
+        Corpus* srchCorp = h->corp;
+        if (subcPath && *subcPath != '\0') {
+            h->subc = new SubCorpus(h->corp, subcPath);
+            srchCorp = h->subc;
+        }
+        h->conc = new Concordance(srchCorp, eval_query(query, srchCorp));
+        h->conc->sync();
+
+    } catch (...) {
+        delete_conc_handle(h);
+        throw;
+    }
+    return h;
+}
+
+ConcHandleRetval open_concordance(const char* corpusPath, const char* subcPath, const char* query) {
+    ConcHandleRetval ans;
+    ans.value = nullptr;
+    ans.concSize = 0;
+    ans.err = nullptr;
+    ans.errorCode = 0;
+    try {
+        ConcHandle* h = compile_conc_handle(corpusPath, subcPath, query);
+        h->conc->shuffle();
+        ans.value = h;
+        ans.concSize = h->conc->size();
+
+    } catch (QueryError &e) {
+        ans.err = strdup(e.what());
+        ans.errorCode = 2;
+
+    } catch (std::exception &e) {
+        ans.err = strdup(e.what());
+    }
+    return ans;
+}
+
+ConcHandleRetval open_concordance_sample(
+    const char* corpusPath, const char* subcPath, const char* query,
+    PosInt sampleSize, unsigned int seed) {
+
+    ConcHandleRetval ans;
+    ans.value = nullptr;
+    ans.concSize = 0;
+    ans.err = nullptr;
+    ans.errorCode = 0;
+    try {
+        ConcHandle* h = compile_conc_handle(corpusPath, subcPath, query);
+        ans.concSize = h->conc->size();
+        if (sampleSize < ans.concSize) {
+            // Manatee selects the lines using the standard
+            // pseudo-random generator so seeding it makes
+            // the sample reproducible
+            srand(seed);
+            h->conc->reduce_lines(std::to_string(sampleSize).c_str());
+        }
+        ans.value = h;
+
+    } catch (QueryError &e) {
+        ans.err = strdup(e.what());
+        ans.errorCode = 2;
+
+    } catch (std::exception &e) {
+        ans.err = strdup(e.what());
+    }
+    return ans;
+}
+
+void close_concordance(ConcHandleV handle) {
+    delete_conc_handle((ConcHandle*)handle);
+}
+
+KWICRowsRetval conc_examples_from_handle(
+    ConcHandleV handle, const char* attrs, PosInt fromLine, PosInt limit,
+    PosInt maxContext, const char* viewContextStruct, PosInt ctxStructs,
+    const char* structs) {
+
+    ConcHandle* h = (ConcHandle*)handle;
+    Concordance* conc = h->conc;
+    try {
+        if (conc->size() == 0) {
+            // zero hits is a valid result (regardless of the
+            // requested line range)
             KWICRowsRetval ans {
                 nullptr,
                 0,
                 0,
-                nullptr
+                nullptr,
+                0
             };
             return ans;
         }
@@ -258,16 +578,19 @@ KWICRowsRetval conc_examples(
             };
             return ans;
         }
-        conc->shuffle();
         PosInt concSize = conc->size();
+        if (ctxStructs < 1) {
+            ctxStructs = 1;
+        }
+        std::string numStructs = std::to_string(ctxStructs);
         KWICLines* kl = new KWICLines(
-            corp,
+            h->corp,
             conc->RS(true, fromLine, fromLine+limit),
-            ("-1:"+std::string(viewContextStruct)).c_str(),
-            ("1:"+std::string(viewContextStruct)).c_str(),
+            ("-"+numStructs+":"+std::string(viewContextStruct)).c_str(),
+            (numStructs+":"+std::string(viewContextStruct)).c_str(),
             attrs,
             attrs,
-            "",
+            structs,
             "#",
             maxContext,
             false
@@ -309,6 +632,7 @@ KWICRowsRetval conc_examples(
                 break;
             }
         }
+        delete kl;
         // We've allocated memory for `limit` rows,
         // but it's possible that there is less rows
         // available so here we fill the remaining items
@@ -316,8 +640,6 @@ KWICRowsRetval conc_examples(
         for (int i2 = i; i2 < limit; i2++) {
             lines[i2] = strdup("");
         }
-        delete conc;
-        delete corp;
         KWICRowsRetval ans {
             lines,
             limit,
@@ -339,6 +661,38 @@ KWICRowsRetval conc_examples(
     }
 }
 
+/**
+ * @brief Based on provided query, return at most `limit` sentences matching the query.
+ *
+ * @param corpusPath
+ * @param subcPath a path to a subcorpus file; if empty, the whole corpus is searched
+ * @param query
+ * @param attrs Positional attributes (comma-separated) to be attached to returned tokens
+ * @param limit
+ * @return KWICRowsRetval
+ */
+KWICRowsRetval conc_examples(
+    const char* corpusPath, const char* subcPath, const char* query, const char* attrs,
+        PosInt fromLine, PosInt limit, PosInt maxContext, const char* viewContextStruct,
+        PosInt ctxStructs, const char* structs) {
+
+    ConcHandleRetval h = open_concordance(corpusPath, subcPath, query);
+    if (h.err != nullptr) {
+        KWICRowsRetval ans {
+            nullptr,
+            0,
+            0,
+            h.err,
+            h.errorCode
+        };
+        return ans;
+    }
+    KWICRowsRetval ans = conc_examples_from_handle(
+        h.value, attrs, fromLine, limit, maxContext, viewContextStruct, ctxStructs, structs);
+    close_concordance(h.value);
+    return ans;
+}
+
 /**
  * @brief This function frees all the allocated memory
  * for a concordance example. It is intended to be called
@@ -386,6 +740,38 @@ PosInt int_vector_get_size(MVector v) {
     return vectorObj->size();
 }
 
+PosInt* int_vector_get_data(MVector v) {
+    vector<PosInt>* vectorObj = (vector<PosInt>*)v;
+    return vectorObj->data();
+}
+
+const char** str_vector_get_data(MVector v) {
+    vector<string>* vectorObj = (vector<string>*)v;
+    const char** ans = (const char**) malloc(vectorObj->size() * sizeof(const char*));
+    for (size_t i = 0; i < vectorObj->size(); i++) {
+        ans[i] = vectorObj->at(i).c_str();
+    }
+    return ans;
+}
+
+MVector new_str_vector() {
+    return new vector<string>();
+}
+
+void str_vector_push(MVector v, const char* value) {
+    vector<string>* vectorObj = (vector<string>*)v;
+    vectorObj->push_back(value);
+}
+
+MVector new_int_vector() {
+    return new vector<PosInt>();
+}
+
+void int_vector_push(MVector v, PosInt value) {
+    vector<PosInt>* vectorObj = (vector<PosInt>*)v;
+    vectorObj->push_back(value);
+}
+
 CollsRetVal collocations(
     const char* corpusPath,
     const char* subcPath,
@@ -412,15 +798,15 @@ CollsRetVal collocations(
 
         if (subcPath && *subcPath != '\0') {
             subc = new SubCorpus(corp, subcPath);
-            conc = new Concordance(subc, subc->filter_query(eval_cqpquery(query, subc)));
+            conc = new Concordance(subc, eval_query(query, subc));
 
         } else {
-            conc = new Concordance(corp, corp->filter_query(eval_cqpquery(query, corp)));
+            conc = new Concordance(corp, eval_query(query, corp));
         }
         ans.corpusSize = corp->size();
         conc->sync();
         ans.concSize = conc->size();
-        ans.searchSize = corp->size();
+        ans.searchSize = subc != nullptr ? subc->search_size() : corp->size();
         ans.resultSize = 0;
         collocs = new CollocItems(conc, string(attrName), sortFunCode, minfreq, minbgr, fromw, tow, maxitems);
         CollItem* items = (CollItem*) malloc(maxitems * sizeof(CollItem));
@@ -553,6 +939,30 @@ CorpusSizeRetrval get_posattr_size(const char* corpus_path, const char* name) {
     return ans;
 }
 
+CorpusSizeRetrval get_subc_vocab_size(const char* corpus_path, const char* subc_path, const char* name) {
+    CorpusSizeRetrval ans;
+    ans.err = nullptr;
+    ans.value = 0;
+    Corpus* corp = nullptr;
+    SubCorpus* subc = nullptr;
+    try {
+        ensure_subc_freqs(subc_path, name);
+        corp = new Corpus(corpus_path);
+        subc = new SubCorpus(corp, subc_path);
+        PosAttr* pattr = subc->get_attr(name);
+        for (PosInt i = 0; i < pattr->id_range(); i++) {
+            if (pattr->freq(i) > 0) {
+                ans.value++;
+            }
+        }
+    } catch (std::exception &e) {
+        ans.err = strdup(e.what());
+    }
+    delete subc;
+    delete corp;
+    return ans;
+}
+
 CorpusSizeRetrval get_struct_size(const char* corpus_path, const char* name) {
     CorpusSizeRetrval ans;
     ans.err = nullptr;
@@ -566,3 +976,67 @@ CorpusSizeRetrval get_struct_size(const char* corpus_path, const char* name) {
     delete corp;
     return ans;
 }
+
+CorpusSizeRetrval get_struct_nums_at_positions(
+    const char* corpus_path, const char* name,
+    PosInt* positions, PosInt numPositions, PosInt* nums) {
+
+    CorpusSizeRetrval ans;
+    ans.err = nullptr;
+    ans.value = 0;
+    Corpus* corp = nullptr;
+    try {
+        corp = new Corpus(corpus_path);
+        Structure* strct = corp->get_struct(name);
+        for (PosInt i = 0; i < numPositions; i++) {
+            nums[i] = positions[i] < 0 ? -1 : strct->rng->num_at_pos(positions[i]);
+        }
+        ans.value = numPositions;
+    } catch (std::exception &e) {
+        ans.err = strdup(e.what());
+    }
+    delete corp;
+    return ans;
+}
+
+CorpusSizeRetrval get_struct_ranges(
+    const char* corpus_path, const char* name,
+    PosInt* nums, PosInt numNums, PosInt* begs, PosInt* ends) {
+
+    CorpusSizeRetrval ans;
+    ans.err = nullptr;
+    ans.value = 0;
+    Corpus* corp = nullptr;
+    try {
+        corp = new Corpus(corpus_path);
+        Structure* strct = corp->get_struct(name);
+        PosInt size = strct->size();
+        for (PosInt i = 0; i < numNums; i++) {
+            if (nums[i] < 0 || nums[i] >= size) {
+                begs[i] = -1;
+                ends[i] = -1;
+
+            } else {
+                begs[i] = strct->rng->beg_at(nums[i]);
+                ends[i] = strct->rng->end_at(nums[i]);
+            }
+        }
+        ans.value = numNums;
+    } catch (std::exception &e) {
+        ans.err = strdup(e.what());
+    }
+    delete corp;
+    return ans;
+}
+
+// Not all the Manatee builds export the version function
+// so it is linked weakly (i.e. it is NULL if not available).
+// The function is exported with C linkage.
+extern "C" const char *version() __attribute__((weak));
+
+const char* get_manatee_version() {
+    if (!version) {
+        return nullptr;
+    }
+    return version();
+}
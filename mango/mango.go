@@ -26,19 +26,32 @@ import "C"
 import (
 	"errors"
 	"fmt"
+	"mquery/merror"
 	"strings"
 	"unicode"
 	"unsafe"
 
 	"github.com/czcorpus/cnc-gokit/maths"
+	"golang.org/x/text/unicode/norm"
 )
 
 const (
 	MaxRecordsInternalLimit = 1000
+
+	// freqLevelSeparator separates values of individual
+	// criteria in multi-level frequency distributions
+	freqLevelSeparator = "\t"
+
+	// error codes reported by the C++ layer along with error messages
+	errCodeRowsRangeOutOfConc = 1
+	errCodeInvalidQuery       = 2
 )
 
 var (
-	ErrRowsRangeOutOfConc = errors.New("rows range is out of concordance size")
+	ErrRowsRangeOutOfConc = merror.NewInputError("rows range is out of concordance size")
+
+	// stripControlChars - see SetStripControlChars
+	stripControlChars bool
 )
 
 type GoVector struct {
@@ -52,6 +65,15 @@ type Freqs struct {
 	ConcSize   int64
 	CorpusSize int64
 	SearchSize int64
+
+	// Truncated is true if some items were removed
+	// due to an item limit
+	Truncated bool
+
+	// Tuples contains individual values of Words in case
+	// a multi-level criterion (e.g. `lemma 1:0 lemma 2:0`)
+	// is used. Otherwise, it is nil.
+	Tuples [][]string
 }
 
 // ---
@@ -70,6 +92,11 @@ type GoCollItem struct {
 	Word  string  `json:"word"`
 	Score float64 `json:"score"`
 	Freq  int64   `json:"freq"`
+
+	// Attrs contains the most frequent values of additional positional
+	// attributes of the collocate (e.g. its tag). It is filled in only
+	// if explicitly requested.
+	Attrs map[string]string `json:"attrs,omitempty"`
 }
 
 type GoColls struct {
@@ -79,6 +106,22 @@ type GoColls struct {
 	SearchSize int64
 }
 
+// importConcError converts an error reported by the C++ layer
+// while evaluating a query into a Go error. Invalid queries
+// are reported as merror.InputError. The C string is released.
+func importConcError(cErr *C.char, errorCode C.int) error {
+	defer C.free(unsafe.Pointer(cErr))
+	msg := C.GoString(cErr)
+	switch errorCode {
+	case errCodeRowsRangeOutOfConc:
+		return ErrRowsRangeOutOfConc
+	case errCodeInvalidQuery:
+		return merror.NewInputError("invalid query: %s", msg)
+	default:
+		return errors.New(msg)
+	}
+}
+
 func GetCorpusSize(corpusPath string) (int64, error) {
 	ans := C.get_corpus_size(C.CString(corpusPath))
 	if ans.err != nil {
@@ -93,9 +136,7 @@ func GetConcSize(corpusPath, query string) (GoConcSize, error) {
 	ans := C.concordance_size(C.CString(corpusPath), C.CString(query))
 	var ret GoConcSize
 	if ans.err != nil {
-		err := fmt.Errorf(C.GoString(ans.err))
-		defer C.free(unsafe.Pointer(ans.err))
-		return ret, err
+		return ret, importConcError(ans.err, ans.errorCode)
 	}
 	ret.CorpusSize = int64(ans.corpusSize)
 	ret.Value = int64(ans.value)
@@ -113,26 +154,135 @@ func CompileSubcFreqs(corpusPath, subcPath, attr string) error {
 	return nil
 }
 
+// ConcStructClass is a class of structure boundary items (e.g. `<s>`
+// or `</s>`) Manatee inserts into concordance lines in case
+// a boundary structure is requested
+const ConcStructClass = "strc"
+
+// ValidateConcordanceArgs tests numeric arguments of GetConcordance.
+// In case of an invalid value, merror.InputError is returned.
+func ValidateConcordanceArgs(fromLine, maxItems, maxContext int) error {
+	if fromLine < 0 {
+		return merror.NewInputError("invalid concordance start line %d", fromLine)
+	}
+	if maxItems < 0 {
+		return merror.NewInputError("invalid number of concordance lines %d", maxItems)
+	}
+	if maxItems > MaxRecordsInternalLimit {
+		return merror.NewInputError(
+			"number of concordance lines %d exceeds the limit %d", maxItems, MaxRecordsInternalLimit)
+	}
+	if maxContext < 0 {
+		return merror.NewInputError("invalid concordance context size %d", maxContext)
+	}
+	return nil
+}
+
+// GetConcordance returns concordance lines of `query`. In case
+// `subcPath` is non-empty, the search is restricted to the subcorpus.
+// The context of each line spans `ctxStructs` instances of
+// `viewContextStruct` on each side of KWIC (the one containing KWIC
+// included) but at most `maxContext` tokens. In case `boundaryStruct`
+// is non-empty, lines contain its boundaries (see ConcStructClass).
 func GetConcordance(
-	corpusPath, query string,
+	corpusPath, subcPath, query string,
 	attrs []string,
 	fromLine, maxItems, maxContext int,
 	viewContextStruct string,
+	ctxStructs int,
+	boundaryStruct string,
 ) (GoConcordance, error) {
+	if err := ValidateConcordanceArgs(fromLine, maxItems, maxContext); err != nil {
+		return GoConcordance{Lines: []string{}}, err
+	}
 	ans := C.conc_examples(
-		C.CString(corpusPath), C.CString(query), C.CString(strings.Join(attrs, ",")),
+		C.CString(corpusPath), C.CString(subcPath), C.CString(query), C.CString(strings.Join(attrs, ",")),
+		C.longlong(fromLine), C.longlong(maxItems), C.longlong(maxContext),
+		C.CString(viewContextStruct), C.longlong(ctxStructs), C.CString(boundaryStruct))
+	return importKWICRows(ans, maxItems)
+}
+
+// ConcHandle represents a compiled Manatee concordance which
+// can be used repeatedly to obtain concordance lines without
+// the need to evaluate the query again. The handle must be
+// released via Close().
+type ConcHandle struct {
+	value    C.ConcHandleV
+	ConcSize int
+}
+
+// Close releases all the Manatee objects referred by the handle.
+func (h *ConcHandle) Close() {
+	if h.value != nil {
+		C.close_concordance(h.value)
+		h.value = nil
+	}
+}
+
+// OpenConcordance compiles a concordance of `query` and returns
+// its handle. In case `subcPath` is non-empty, the search is
+// restricted to the subcorpus.
+func OpenConcordance(corpusPath, subcPath, query string) (*ConcHandle, error) {
+	ans := C.open_concordance(C.CString(corpusPath), C.CString(subcPath), C.CString(query))
+	if ans.err != nil {
+		return nil, importConcError(ans.err, ans.errorCode)
+	}
+	return &ConcHandle{value: ans.value, ConcSize: int(ans.concSize)}, nil
+}
+
+// OpenConcordanceSample compiles a concordance of `query` and
+// reduces it to a uniform random sample of `sampleSize` lines.
+// The same `seed` always produces the same sample. The ConcSize
+// of the returned handle is the size of the whole concordance.
+func OpenConcordanceSample(
+	corpusPath, subcPath, query string,
+	sampleSize int,
+	seed uint32,
+) (*ConcHandle, error) {
+	if sampleSize < 1 || sampleSize > MaxRecordsInternalLimit {
+		return nil, merror.NewInputError(
+			"invalid sample size %d (must be between 1 and %d)", sampleSize, MaxRecordsInternalLimit)
+	}
+	ans := C.open_concordance_sample(
+		C.CString(corpusPath), C.CString(subcPath), C.CString(query),
+		C.longlong(sampleSize), C.uint(seed))
+	if ans.err != nil {
+		return nil, importConcError(ans.err, ans.errorCode)
+	}
+	return &ConcHandle{value: ans.value, ConcSize: int(ans.concSize)}, nil
+}
+
+// GetConcordanceFromHandle works just like GetConcordance but
+// it uses an already compiled concordance.
+func GetConcordanceFromHandle(
+	handle *ConcHandle,
+	attrs []string,
+	fromLine, maxItems, maxContext int,
+	viewContextStruct string,
+	ctxStructs int,
+	boundaryStruct string,
+) (GoConcordance, error) {
+	if err := ValidateConcordanceArgs(fromLine, maxItems, maxContext); err != nil {
+		return GoConcordance{Lines: []string{}}, err
+	}
+	if handle.value == nil {
+		return GoConcordance{Lines: []string{}}, errors.New("concordance handle already closed")
+	}
+	ans := C.conc_examples_from_handle(
+		handle.value, C.CString(strings.Join(attrs, ",")),
 		C.longlong(fromLine), C.longlong(maxItems), C.longlong(maxContext),
-		C.CString(viewContextStruct))
+		C.CString(viewContextStruct), C.longlong(ctxStructs), C.CString(boundaryStruct))
+	return importKWICRows(ans, maxItems)
+}
+
+// importKWICRows converts C-level concordance rows into
+// GoConcordance and releases the C-allocated memory
+func importKWICRows(ans C.KWICRowsRetval, maxItems int) (GoConcordance, error) {
 	var ret GoConcordance
 	ret.Lines = make([]string, 0, maxItems)
 	ret.ConcSize = int(ans.concSize)
 	if ans.err != nil {
-		err := fmt.Errorf(C.GoString(ans.err))
-		defer C.free(unsafe.Pointer(ans.err))
-		if ans.errorCode == 1 {
-			return ret, ErrRowsRangeOutOfConc
-		}
-		return ret, err
+		return ret, importConcError(ans.err, ans.errorCode)
 
 	} else {
 		defer C.conc_examples_free(ans.value, C.int(ans.size))
@@ -149,59 +299,309 @@ func GetConcordance(
 	return ret, nil
 }
 
-func CalcFreqDist(corpusID, subcID, query, fcrit string, flimit int) (*Freqs, error) {
+// CalcFreqDist calculates a frequency distribution of a concordance
+// based on `fcrit`. In case `maxItems` > 0, at most `maxItems` most frequent
+// items are returned (already at the C level so no excessive Go data is
+// created) and the `Truncated` flag is set if some items were removed.
+// Please note that the order of the returned items is not defined.
+func CalcFreqDist(corpusID, subcID, query, fcrit string, flimit, maxItems int) (*Freqs, error) {
 	var ret Freqs
-	ans := C.freq_dist(C.CString(corpusID), C.CString(subcID), C.CString(query), C.CString(fcrit), C.longlong(flimit))
+	ans := C.freq_dist(
+		C.CString(corpusID), C.CString(subcID), C.CString(query), C.CString(fcrit),
+		C.longlong(flimit), C.longlong(maxItems))
 	defer func() { // the 'new' was called before any possible error so we have to do this
 		C.delete_int_vector(ans.freqs)
 		C.delete_int_vector(ans.norms)
 		C.delete_str_vector(ans.words)
 	}()
+	if ans.err != nil {
+		return &ret, importConcError(ans.err, ans.errorCode)
+	}
+	ret.Freqs = IntVectorToSlice(GoVector{ans.freqs})
+	ret.Norms = IntVectorToSlice(GoVector{ans.norms})
+	ret.Words = StrVectorToSlice(GoVector{ans.words})
+	if len(strings.Fields(fcrit)) > 2 {
+		ret.Tuples = strVectorToTuples(GoVector{ans.words})
+	}
+	ret.ConcSize = int64(ans.concSize)
+	ret.CorpusSize = int64(ans.corpusSize)
+	ret.SearchSize = int64(ans.searchSize)
+	ret.Truncated = ans.truncated == 1
+	return &ret, nil
+}
+
+// GetWordList returns all the values of a positional attribute `attr`
+// along with their frequencies (the `Norms` are not filled in).
+// In case `subcPath` is non-empty, the frequencies are related to the
+// subcorpus which requires its frequency data to be already compiled
+// (see CompileSubcFreqs).
+func GetWordList(corpusPath, subcPath, attr string, minFreq int) (*Freqs, error) {
+	var ret Freqs
+	ans := C.word_list(C.CString(corpusPath), C.CString(subcPath), C.CString(attr), C.longlong(minFreq))
+	defer func() {
+		C.delete_int_vector(ans.freqs)
+		C.delete_int_vector(ans.norms)
+		C.delete_str_vector(ans.words)
+	}()
 	if ans.err != nil {
 		err := fmt.Errorf(C.GoString(ans.err))
 		defer C.free(unsafe.Pointer(ans.err))
 		return &ret, err
 	}
 	ret.Freqs = IntVectorToSlice(GoVector{ans.freqs})
-	ret.Norms = IntVectorToSlice(GoVector{ans.norms})
 	ret.Words = StrVectorToSlice(GoVector{ans.words})
-	ret.ConcSize = int64(ans.concSize)
 	ret.CorpusSize = int64(ans.corpusSize)
 	ret.SearchSize = int64(ans.searchSize)
 	return &ret, nil
 }
 
+// WordListSizes provides sizes of data a word list
+// has been calculated from
+type WordListSizes struct {
+	CorpusSize int64
+	SearchSize int64
+}
+
+// ForEachWordListItem works like GetWordList but instead of creating
+// slices with all the values and frequencies, it calls `fn` for each
+// item. This significantly reduces memory usage for attributes with
+// huge lexicons in case a caller needs just a part of the list (e.g.
+// the most frequent items) or some aggregated values. In case `withWords`
+// is false, the values are not converted to Go strings at all and `fn`
+// obtains empty strings instead.
+func ForEachWordListItem(
+	corpusPath, subcPath, attr string,
+	minFreq int,
+	withWords bool,
+	fn func(word string, freq int64),
+) (WordListSizes, error) {
+	var ret WordListSizes
+	ans := C.word_list(C.CString(corpusPath), C.CString(subcPath), C.CString(attr), C.longlong(minFreq))
+	defer func() {
+		C.delete_int_vector(ans.freqs)
+		C.delete_int_vector(ans.norms)
+		C.delete_str_vector(ans.words)
+	}()
+	if ans.err != nil {
+		err := fmt.Errorf(C.GoString(ans.err))
+		defer C.free(unsafe.Pointer(ans.err))
+		return ret, err
+	}
+	words := GoVector{ans.words}
+	IntVectorForEach(GoVector{ans.freqs}, func(i int, freq int64) {
+		var word string
+		if withWords {
+			word = normalizeMultiword(
+				C.GoString(C.str_vector_get_element(words.v, C.int(i))))
+		}
+		fn(word, freq)
+	})
+	ret.CorpusSize = int64(ans.corpusSize)
+	ret.SearchSize = int64(ans.searchSize)
+	return ret, nil
+}
+
+// GetCorpRegion returns values of positional attributes `attrs` for all
+// the tokens in the corpus region [fromPos, toPos). Each item of the returned
+// slice represents a single token with attribute values in the same order as
+// in `attrs`. The range is clamped to the corpus size and the actual range
+// is returned along with the values.
+func GetCorpRegion(corpusPath string, attrs []string, fromPos, toPos int64) ([][]string, [2]int64, error) {
+	if len(attrs) == 0 {
+		return [][]string{}, [2]int64{}, errors.New("no attributes specified")
+	}
+	ans := C.get_corp_region(
+		C.CString(corpusPath), C.CString(strings.Join(attrs, ",")),
+		C.longlong(fromPos), C.longlong(toPos))
+	if ans.err != nil {
+		err := fmt.Errorf(C.GoString(ans.err))
+		defer C.free(unsafe.Pointer(ans.err))
+		return [][]string{}, [2]int64{}, err
+	}
+	defer C.delete_str_vector(ans.values)
+	values := StrVectorToSlice(GoVector{ans.values})
+	ret := make([][]string, 0, len(values)/len(attrs))
+	for i := 0; i+len(attrs) <= len(values); i += len(attrs) {
+		ret = append(ret, values[i:i+len(attrs)])
+	}
+	return ret, [2]int64{int64(ans.fromPos), int64(ans.toPos)}, nil
+}
+
+// SetStripControlChars enables (or disables) removal of zero-width
+// and bidi control characters (and NFC normalization) in strings
+// obtained from Manatee (see normalizeMultiword). The function is
+// expected to be called once, before any Manatee data are processed.
+func SetStripControlChars(v bool) {
+	stripControlChars = v
+}
+
+// isInvisibleControlChar tests for zero-width and bidi control
+// characters which some corpora contain in their data
+func isInvisibleControlChar(c rune) bool {
+	switch {
+	case c == '\u200B', c == '\u200C', c == '\u200D', c == '\u2060', c == '\uFEFF':
+		return true // zero-width space, (non)joiners, word joiner, BOM
+	case c == '\u200E', c == '\u200F', c == '\u061C':
+		return true // LRM, RLM, ALM
+	case c >= '\u202A' && c <= '\u202E', c >= '\u2066' && c <= '\u2069':
+		return true // bidi embeddings, overrides and isolates
+	}
+	return false
+}
+
 func normalizeMultiword(w string) string {
-	return strings.TrimSpace(strings.Map(func(c rune) rune {
+	ans := strings.TrimSpace(strings.Map(func(c rune) rune {
 		if unicode.IsSpace(c) {
 			return ' '
 		}
+		if stripControlChars && isInvisibleControlChar(c) {
+			return -1
+		}
 		return c
 	}, w))
+	if stripControlChars {
+		return norm.NFC.String(ans)
+	}
+	return ans
 }
 
-func StrVectorToSlice(vector GoVector) []string {
+// StrVectorForEach calls `fn` for each (normalized) item of a C string
+// vector. Unlike StrVectorToSlice, no Go slice for the whole vector
+// is created which is useful for huge vectors (e.g. word lists).
+func StrVectorForEach(vector GoVector, fn func(i int, v string)) {
+	items, release := strVectorData(vector)
+	defer release()
+	for i, cstr := range items {
+		fn(i, normalizeMultiword(C.GoString(cstr)))
+	}
+}
+
+// strVectorData obtains pointers to all the items of a C string
+// vector via a single cgo call (instead of one call per item which
+// is expensive for large vectors). The returned function must be
+// called once the items are no longer needed. The items themselves
+// are valid as long as the vector exists.
+func strVectorData(vector GoVector) ([]*C.char, func()) {
 	size := int(C.str_vector_get_size(vector.v))
-	slice := make([]string, size)
-	for i := 0; i < size; i++ {
-		cstr := C.str_vector_get_element(vector.v, C.int(i))
-		slice[i] = normalizeMultiword(C.GoString(cstr))
+	if size == 0 {
+		return []*C.char{}, func() {}
 	}
+	data := C.str_vector_get_data(vector.v)
+	return unsafe.Slice((**C.char)(unsafe.Pointer(data)), size),
+		func() { C.free(unsafe.Pointer(data)) }
+}
+
+func StrVectorToSlice(vector GoVector) []string {
+	slice := make([]string, int(C.str_vector_get_size(vector.v)))
+	StrVectorForEach(vector, func(i int, v string) {
+		slice[i] = v
+	})
 	return slice
 }
 
-func IntVectorToSlice(vector GoVector) []int64 {
+// strVectorToTuples splits values of a multi-level frequency
+// distribution (where Manatee separates individual levels by tabs)
+func strVectorToTuples(vector GoVector) [][]string {
+	items, release := strVectorData(vector)
+	defer release()
+	ans := make([][]string, len(items))
+	for i, cstr := range items {
+		ans[i] = strings.Split(C.GoString(cstr), freqLevelSeparator)
+		for j, v := range ans[i] {
+			ans[i][j] = normalizeMultiword(v)
+		}
+	}
+	return ans
+}
+
+// IntVectorForEach calls `fn` for each item of a C integer vector
+// without creating a Go slice for the whole vector.
+func IntVectorForEach(vector GoVector, fn func(i int, v int64)) {
 	size := int(C.int_vector_get_size(vector.v))
-	slice := make([]int64, size)
-	for i := 0; i < size; i++ {
-		v := C.int_vector_get_element(vector.v, C.int(i))
-		slice[i] = int64(v)
+	if size == 0 {
+		return
+	}
+	// the whole vector is read via a single cgo call
+	items := unsafe.Slice(C.int_vector_get_data(vector.v), size)
+	for i, v := range items {
+		fn(i, int64(v))
+	}
+}
+
+func IntVectorToSlice(vector GoVector) []int64 {
+	slice := make([]int64, int(C.int_vector_get_size(vector.v)))
+	IntVectorForEach(vector, func(i int, v int64) {
+		slice[i] = v
+	})
+	return slice
+}
+
+// newStrVector creates a C string vector containing `items`.
+// It is used to test the vector conversion functions without
+// Manatee. The returned function deletes the vector.
+func newStrVector(items []string) (GoVector, func()) {
+	v := C.new_str_vector()
+	for _, item := range items {
+		cItem := C.CString(item)
+		C.str_vector_push(v, cItem)
+		C.free(unsafe.Pointer(cItem))
+	}
+	return GoVector{v}, func() { C.delete_str_vector(v) }
+}
+
+// newIntVector creates a C integer vector containing `items`.
+// It is used to test the vector conversion functions without
+// Manatee. The returned function deletes the vector.
+func newIntVector(items []int64) (GoVector, func()) {
+	v := C.new_int_vector()
+	for _, item := range items {
+		C.int_vector_push(v, C.PosInt(item))
+	}
+	return GoVector{v}, func() { C.delete_int_vector(v) }
+}
+
+// strVectorToSliceByElement converts a C string vector using
+// one cgo call per item. It serves as a reference for StrVectorForEach.
+func strVectorToSliceByElement(vector GoVector) []string {
+	slice := make([]string, int(C.str_vector_get_size(vector.v)))
+	for i := range slice {
+		slice[i] = normalizeMultiword(
+			C.GoString(C.str_vector_get_element(vector.v, C.int(i))))
+	}
+	return slice
+}
+
+// intVectorToSliceByElement converts a C integer vector using
+// one cgo call per item. It serves as a reference for IntVectorForEach.
+func intVectorToSliceByElement(vector GoVector) []int64 {
+	slice := make([]int64, int(C.int_vector_get_size(vector.v)))
+	for i := range slice {
+		slice[i] = int64(C.int_vector_get_element(vector.v, C.int(i)))
 	}
 	return slice
 }
 
+// ValidateCollSrchRange tests whether a collocation search range
+// is valid (i.e. left <= right). In case `maxDist` > 0, both
+// values must be also within [-maxDist, maxDist]. In case of an invalid
+// range, merror.InputError is returned.
+func ValidateCollSrchRange(srchRange [2]int, maxDist int) error {
+	if srchRange[0] > srchRange[1] {
+		return merror.NewInputError(
+			"invalid collocation search range [%d, %d] (left > right)", srchRange[0], srchRange[1])
+	}
+	if maxDist > 0 && (srchRange[0] < -maxDist || srchRange[1] > maxDist) {
+		return merror.NewInputError(
+			"collocation search range [%d, %d] exceeds the limit of %d tokens",
+			srchRange[0], srchRange[1], maxDist)
+	}
+	return nil
+}
+
 // GetCollcations
 //
+// Supported measures (see also CollMeasureLabel):
+//
 // 't': 'T-score',
 // 'm': 'MI',
 // '3': 'MI3',
@@ -211,17 +611,28 @@ func IntVectorToSlice(vector GoVector) []int64 {
 // 'r': 'relative freq. [%]',
 // 'f': 'absolute freq.',
 // 'd': 'logDice'
+//
+// The `minFreq` argument specifies a minimum frequency of a collocate
+// candidate in the whole (sub)corpus while `minCoocFreq` specifies
+// a minimum frequency of the candidate's co-occurrence with the node
+// (i.e. within the `srchRange`).
 func GetCollcations(
 	corpusID, subcID, query string,
 	attrName string,
 	measure byte,
 	srchRange [2]int,
 	minFreq int64,
+	minCoocFreq int64,
 	maxItems int,
 ) (GoColls, error) {
+	// the configurable limit is applied by the API, here we just
+	// prevent Manatee from obtaining a nonsensical range
+	if err := ValidateCollSrchRange(srchRange, 0); err != nil {
+		return GoColls{}, err
+	}
 	colls := C.collocations(
 		C.CString(corpusID), C.CString(subcID), C.CString(query), C.CString(attrName),
-		C.char(measure), C.char(measure), C.longlong(minFreq), C.longlong(minFreq),
+		C.char(measure), C.char(measure), C.longlong(minFreq), C.longlong(minCoocFreq),
 		C.int(srchRange[0]), C.int(srchRange[1]), C.int(maxItems))
 	if colls.err != nil {
 		err := fmt.Errorf(C.GoString(colls.err))
@@ -250,7 +661,7 @@ func GetTextTypesNorms(corpusPath string, attr string) (map[string]int64, error)
 	ans := make(map[string]int64)
 	attrSplit := strings.Split(attr, ".")
 	if len(attrSplit) != 2 {
-		panic("invalid attribute format (must be `struct.attr`)")
+		return ans, merror.NewInputError("invalid attribute format (must be `struct.attr`)")
 	}
 	norms := C.get_attr_values_sizes(
 		C.CString(corpusPath), C.CString(attrSplit[0]), C.CString(attrSplit[1]))
@@ -268,7 +679,10 @@ func GetTextTypesNorms(corpusPath string, attr string) (map[string]int64, error)
 		if val.value == nil {
 			break
 		}
-		ans[C.GoString(val.value)] = int64(val.freq)
+		// values must be normalized the same way as the ones
+		// in frequency distributions so they can be matched
+		// (and values which become identical are merged)
+		ans[normalizeMultiword(C.GoString(val.value))] += int64(val.freq)
 	}
 
 	return ans, nil
@@ -296,6 +710,28 @@ func GetPosAttrSize(corpusPath string, name string) (int, error) {
 	return int(ans.value), nil
 }
 
+// GetVocabSize returns number of distinct values of a positional
+// attribute `attr`. The value is obtained from the attribute's lexicon
+// size (i.e. no enumeration of values is performed).
+func GetVocabSize(corpusPath, attr string) (int64, error) {
+	size, err := GetPosAttrSize(corpusPath, attr)
+	return int64(size), err
+}
+
+// GetSubcVocabSize returns number of distinct values of a positional
+// attribute `attr` occurring in a subcorpus. Unlike GetVocabSize, the
+// attribute's lexicon must be enumerated here and the subcorpus must
+// have its frequencies compiled (see CompileSubcFreqs).
+func GetSubcVocabSize(corpusPath, subcPath, attr string) (int64, error) {
+	ans := C.get_subc_vocab_size(C.CString(corpusPath), C.CString(subcPath), C.CString(attr))
+	if ans.err != nil {
+		err := fmt.Errorf(C.GoString(ans.err))
+		defer C.free(unsafe.Pointer(ans.err))
+		return 0, err
+	}
+	return int64(ans.value), nil
+}
+
 func GetStructSize(corpusPath string, name string) (int, error) {
 	ans := C.get_struct_size(C.CString(corpusPath), C.CString(name))
 	if ans.err != nil {
@@ -305,3 +741,121 @@ func GetStructSize(corpusPath string, name string) (int, error) {
 	}
 	return int(ans.value), nil
 }
+
+// GetStructNumsAtPositions returns, for each of the provided corpus
+// positions, a number of structure `name` the position belongs to.
+// For positions outside of any structure (or negative ones), -1 is returned.
+func GetStructNumsAtPositions(corpusPath, name string, positions []int64) ([]int64, error) {
+	if len(positions) == 0 {
+		return []int64{}, nil
+	}
+	size := C.size_t(len(positions)) * C.size_t(unsafe.Sizeof(C.PosInt(0)))
+	cPositions := (*C.PosInt)(C.malloc(size))
+	defer C.free(unsafe.Pointer(cPositions))
+	cNums := (*C.PosInt)(C.malloc(size))
+	defer C.free(unsafe.Pointer(cNums))
+	tmpPos := unsafe.Slice(cPositions, len(positions))
+	for i, pos := range positions {
+		tmpPos[i] = C.PosInt(pos)
+	}
+	ans := C.get_struct_nums_at_positions(
+		C.CString(corpusPath), C.CString(name), cPositions, C.PosInt(len(positions)), cNums)
+	if ans.err != nil {
+		err := fmt.Errorf(C.GoString(ans.err))
+		defer C.free(unsafe.Pointer(ans.err))
+		return nil, err
+	}
+	ret := make([]int64, len(positions))
+	for i, num := range unsafe.Slice(cNums, len(positions)) {
+		ret[i] = int64(num)
+	}
+	return ret, nil
+}
+
+// GetStructRanges returns, for each of the provided structure
+// numbers, the [begin, end) range of the respective structure `name`.
+// For invalid numbers (negative or out of range), {-1, -1} is returned.
+func GetStructRanges(corpusPath, name string, nums []int64) ([][2]int64, error) {
+	if len(nums) == 0 {
+		return [][2]int64{}, nil
+	}
+	size := C.size_t(len(nums)) * C.size_t(unsafe.Sizeof(C.PosInt(0)))
+	cNums := (*C.PosInt)(C.malloc(size))
+	defer C.free(unsafe.Pointer(cNums))
+	cBegs := (*C.PosInt)(C.malloc(size))
+	defer C.free(unsafe.Pointer(cBegs))
+	cEnds := (*C.PosInt)(C.malloc(size))
+	defer C.free(unsafe.Pointer(cEnds))
+	tmpNums := unsafe.Slice(cNums, len(nums))
+	for i, num := range nums {
+		tmpNums[i] = C.PosInt(num)
+	}
+	ans := C.get_struct_ranges(
+		C.CString(corpusPath), C.CString(name), cNums, C.PosInt(len(nums)), cBegs, cEnds)
+	if ans.err != nil {
+		err := fmt.Errorf(C.GoString(ans.err))
+		defer C.free(unsafe.Pointer(ans.err))
+		return nil, err
+	}
+	ret := make([][2]int64, len(nums))
+	ends := unsafe.Slice(cEnds, len(nums))
+	for i, beg := range unsafe.Slice(cBegs, len(nums)) {
+		ret[i] = [2]int64{int64(beg), int64(ends[i])}
+	}
+	return ret, nil
+}
+
+// corpusConfListContains tests whether a comma-separated list
+// stored in a corpus configuration item `prop` contains `name`
+func corpusConfListContains(corpusPath, prop, name string) (bool, error) {
+	items, err := GetCorpusConf(corpusPath, prop)
+	if err != nil {
+		return false, err
+	}
+	return confListContains(items, name), nil
+}
+
+// confListContains tests whether a comma-separated list
+// of a corpus configuration item contains `name`
+func confListContains(items, name string) bool {
+	for _, item := range strings.Split(items, ",") {
+		if item == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPosAttr tests whether a corpus contains positional attribute `name`
+func HasPosAttr(corpusPath, name string) (bool, error) {
+	return corpusConfListContains(corpusPath, "ATTRLIST", name)
+}
+
+// HasStruct tests whether a corpus contains structure `name`
+func HasStruct(corpusPath, name string) (bool, error) {
+	return corpusConfListContains(corpusPath, "STRUCTLIST", name)
+}
+
+// HasStructAttr tests whether a corpus contains structural
+// attribute `name` (in the `struct.attr` form)
+func HasStructAttr(corpusPath, name string) (bool, error) {
+	return corpusConfListContains(corpusPath, "STRUCTATTRLIST", name)
+}
+
+// IsAlignedWith tests whether a corpus is aligned with
+// corpus `name` (i.e. whether the corpus registry lists
+// `name` among its aligned corpora)
+func IsAlignedWith(corpusPath, name string) (bool, error) {
+	return corpusConfListContains(corpusPath, "ALIGNED", name)
+}
+
+// GetManateeVersion returns a version of the linked Manatee library.
+// In case the library does not provide its version, an empty string
+// is returned.
+func GetManateeVersion() string {
+	ans := C.get_manatee_version()
+	if ans == nil {
+		return ""
+	}
+	return C.GoString(ans)
+}
@@ -29,6 +29,7 @@ typedef void* KWICRowsV;
 typedef void* CollsV;
 typedef void* AttrValMap;
 typedef void* AttrValMapIterator;
+typedef void* ConcHandleV;
 
 
 typedef long long int PosInt;
@@ -64,6 +65,8 @@ typedef struct ConcSizeRetVal {
     PosInt value;
     PosInt corpusSize;
     const char * err;
+    // errorCode is set to 2 in case the query is invalid
+    int errorCode;
 } ConcSizeRetVal;
 
 typedef struct CompileFrqRetVal {
@@ -78,6 +81,11 @@ typedef struct FreqsRetval {
     PosInt corpusSize;
     PosInt searchSize;
     const char * err;
+    // truncated is set to 1 in case the result
+    // has been cut due to an item limit
+    int truncated;
+    // errorCode is set to 2 in case the query is invalid
+    int errorCode;
 } FreqsRetval;
 
 
@@ -101,6 +109,9 @@ typedef struct KWICRowsRetval {
     PosInt size;
     PosInt concSize;
     const char * err;
+    // errorCode is set to 1 in case the requested lines are
+    // out of the concordance range and to 2 in case the query
+    // is invalid
     int errorCode;
 } KWICRowsRetval;
 
@@ -137,9 +148,82 @@ PosInt int_vector_get_element(MVector v, int i);
 
 PosInt int_vector_get_size(MVector v);
 
+/**
+ * Return a pointer to the contiguous data of a vector of integers so
+ * the whole vector can be read at once. The pointer is valid until
+ * the vector is deleted.
+ */
+PosInt* int_vector_get_data(MVector v);
+
+/**
+ * Return a newly allocated array of pointers to the items of a vector
+ * of strings so the whole vector can be read at once. The pointers are
+ * valid until the vector is deleted. The array itself must be freed
+ * by the caller.
+ */
+const char** str_vector_get_data(MVector v);
+
+/**
+ * Create an empty vector of strings. It is mostly intended for testing
+ * the vector conversion functions; use delete_str_vector to free it.
+ */
+MVector new_str_vector();
+
+void str_vector_push(MVector v, const char* value);
+
+/**
+ * Create an empty vector of integers. It is mostly intended for testing
+ * the vector conversion functions; use delete_int_vector to free it.
+ */
+MVector new_int_vector();
+
+void int_vector_push(MVector v, PosInt value);
+
 FreqsRetval freq_dist_from_conc(CorpusV corpus, ConcV conc, char* fcrit, PosInt flimit);
 
-FreqsRetval freq_dist(const char* corpusPath, const char* subcPath, const char* query, const char* fcrit, PosInt flimit);
+/**
+ * @brief Calculate frequency distribution of a concordance based on `fcrit`.
+ * In case `maxItems` > 0, only `maxItems` most frequent items are returned
+ * and the `truncated` flag is set if some items have been removed.
+ */
+FreqsRetval freq_dist(
+    const char* corpusPath, const char* subcPath, const char* query, const char* fcrit,
+    PosInt flimit, PosInt maxItems);
+
+/**
+ * @brief Return all the values of a positional attribute along with their frequencies.
+ * The `norms` vector of the returned value is always empty. In case a subcorpus is
+ * specified, its frequencies must be already compiled (see `compile_subc_freqs`).
+ *
+ * @param corpusPath
+ * @param subcPath an optional subcorpus path (an empty string for the whole corpus)
+ * @param attr a positional attribute
+ * @param minFreq values with lower frequency are not included
+ * @return FreqsRetval
+ */
+FreqsRetval word_list(const char* corpusPath, const char* subcPath, const char* attr, PosInt minFreq);
+
+typedef struct CorpRegionRetval {
+    // values contains attribute values of all the tokens
+    // in the region (token by token, i.e. for attributes
+    // [a1, a2] the order is t1a1, t1a2, t2a1, t2a2,...)
+    MVector values;
+    PosInt fromPos;
+    PosInt toPos;
+    const char * err;
+} CorpRegionRetval;
+
+/**
+ * @brief Return values of positional attributes for a corpus region
+ * [fromPos, toPos). The range is clamped to the corpus size.
+ *
+ * @param corpusPath
+ * @param attrs Positional attributes (comma-separated)
+ * @param fromPos the first position of the region
+ * @param toPos the position right after the region
+ * @return CorpRegionRetval
+ */
+CorpRegionRetval get_corp_region(const char* corpusPath, const char* attrs, PosInt fromPos, PosInt toPos);
 
 /**
  * @brief Based on provided query, return at most `limit` sentences matching the query.
@@ -150,17 +234,78 @@ FreqsRetval freq_dist(const char* corpusPath, const char* subcPath, const char*
  * larger value.
  *
  * @param corpusPath
+ * @param subcPath a path to a subcorpus file; if empty, the whole corpus is searched
  * @param query
  * @param attrs Positional attributes (comma-separated) to be attached to returned tokens
  * @param limit
+ * @param maxContext max. number of context tokens on each side of KWIC
+ * @param viewContextStruct a structure the context is aligned to
+ * @param ctxStructs number of `viewContextStruct` instances the context spans
+ * on each side of KWIC (including the one containing KWIC); the context
+ * is still limited by `maxContext`
+ * @param structs structures (comma-separated) the boundaries of which are
+ * marked within lines (as `<struct>` and `</struct>` items of the `strc` class);
+ * if empty, no boundaries are marked
  * @return KWICRowsRetval
  */
 KWICRowsRetval conc_examples(
-    const char* corpusPath, const char*query, const char* attrs, PosInt fromLine, PosInt limit,
-    PosInt maxContext, const char* viewContextStruct);
+    const char* corpusPath, const char* subcPath, const char*query, const char* attrs,
+    PosInt fromLine, PosInt limit, PosInt maxContext, const char* viewContextStruct,
+    PosInt ctxStructs, const char* structs);
 
 void conc_examples_free(KWICRowsV value, int numItems);
 
+typedef struct ConcHandleRetval {
+    ConcHandleV value;
+    PosInt concSize;
+    const char * err;
+    // errorCode is set to 2 in case the query is invalid
+    int errorCode;
+} ConcHandleRetval;
+
+/**
+ * @brief Compile (and shuffle) a concordance and return a handle
+ * which can be used repeatedly to obtain concordance lines (see
+ * `conc_examples_from_handle`). The handle must be released
+ * using `close_concordance`.
+ *
+ * @param corpusPath
+ * @param subcPath a path to a subcorpus file; if empty, the whole corpus is searched
+ * @param query
+ * @return ConcHandleRetval
+ */
+ConcHandleRetval open_concordance(const char* corpusPath, const char* subcPath, const char* query);
+
+/**
+ * @brief Compile a concordance and reduce it to a uniform random sample
+ * of `sampleSize` lines (the original order of lines is preserved). For
+ * the same `seed`, the sample is always the same. The returned `concSize`
+ * is the size of the whole (non-reduced) concordance. The handle must be
+ * released using `close_concordance`.
+ *
+ * @param corpusPath
+ * @param subcPath a path to a subcorpus file; if empty, the whole corpus is searched
+ * @param query
+ * @param sampleSize
+ * @param seed
+ * @return ConcHandleRetval
+ */
+ConcHandleRetval open_concordance_sample(
+    const char* corpusPath, const char* subcPath, const char* query,
+    PosInt sampleSize, unsigned int seed);
+
+void close_concordance(ConcHandleV handle);
+
+/**
+ * @brief Return at most `limit` lines of a concordance referred by `handle`.
+ * The function works the same way as `conc_examples` but it does not compile
+ * the concordance.
+ */
+KWICRowsRetval conc_examples_from_handle(
+    ConcHandleV handle, const char* attrs, PosInt fromLine, PosInt limit,
+    PosInt maxContext, const char* viewContextStruct, PosInt ctxStructs,
+    const char* structs);
+
 CollsRetVal collocations(
     const char* corpusPath,
     const char* subcPath,
@@ -212,6 +357,45 @@ CorpusSizeRetrval get_posattr_size(const char* corpus_path, const char* name);
 
 CorpusSizeRetrval get_struct_size(const char* corpus_path, const char* name);
 
+/**
+ * @brief For each of the `numPositions` corpus positions, find a number
+ * (index) of structure `name` the position belongs to and write it to
+ * `nums` (which must be allocated by the caller). Positions outside
+ * of any structure get -1.
+ *
+ * @return CorpusSizeRetval with the number of processed positions
+ */
+CorpusSizeRetrval get_struct_nums_at_positions(
+    const char* corpus_path, const char* name,
+    PosInt* positions, PosInt numPositions, PosInt* nums);
+
+/**
+ * @brief For each of the `numNums` structure numbers, find the
+ * [begin, end) range of the respective structure `name` and write
+ * it to `begs` and `ends` (which must be allocated by the caller).
+ * Invalid numbers (negative or out of range) get -1 for both values.
+ *
+ * @return CorpusSizeRetval with the number of processed structures
+ */
+CorpusSizeRetrval get_struct_ranges(
+    const char* corpus_path, const char* name,
+    PosInt* nums, PosInt numNums, PosInt* begs, PosInt* ends);
+
+/**
+ * @brief Count distinct values of a positional attribute occurring
+ * within a subcorpus. The subcorpus must have its frequencies compiled
+ * (see compile_subc_freqs).
+ */
+CorpusSizeRetrval get_subc_vocab_size(const char* corpus_path, const char* subc_path, const char* name);
+
+/**
+ * @brief Get a version of the linked Manatee library.
+ *
+ * @return a static string (i.e. it must not be freed) or NULL
+ * in case the library does not provide the version
+ */
+const char* get_manatee_version();
+
 
 #ifdef __cplusplus
 }
@@ -0,0 +1,234 @@
+// Copyright 2019 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2019 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package mango
+
+import (
+	"fmt"
+	"mquery/merror"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateConcordanceArgs(t *testing.T) {
+	assert.NoError(t, ValidateConcordanceArgs(0, 10, 5))
+	for _, args := range [][3]int{{-1, 10, 5}, {0, -1, 5}, {0, 10, -1}, {0, MaxRecordsInternalLimit + 1, 5}} {
+		err := ValidateConcordanceArgs(args[0], args[1], args[2])
+		assert.Error(t, err)
+		assert.True(t, merror.IsInputError(err), "args %v", args)
+	}
+}
+
+func TestGetConcordanceNegativeArgs(t *testing.T) {
+	for _, args := range [][3]int{{-1, 10, 5}, {0, -1, 5}, {0, 10, -1}} {
+		var err error
+		assert.NotPanics(t, func() {
+			_, err = GetConcordance(
+				"/var/registry/corp1", "", "[word=\"pes\"]", []string{"word"},
+				args[0], args[1], args[2], "", 0, "")
+		})
+		assert.True(t, merror.IsInputError(err), "args %v", args)
+	}
+}
+
+func TestGetTextTypesNormsInvalidAttr(t *testing.T) {
+	var err error
+	assert.NotPanics(t, func() {
+		_, err = GetTextTypesNorms("/var/registry/corp1", "doc_id")
+	})
+	assert.True(t, merror.IsInputError(err))
+}
+
+func TestGetManateeVersionMissingSymbol(t *testing.T) {
+	// the Manatee library linked to the tests does not export
+	// the (weakly linked) version function
+	var ver string
+	assert.NotPanics(t, func() {
+		ver = GetManateeVersion()
+	})
+	assert.Equal(t, "", ver)
+}
+
+func TestValidateCollSrchRange(t *testing.T) {
+	assert.NoError(t, ValidateCollSrchRange([2]int{-5, 5}, 10))
+	assert.NoError(t, ValidateCollSrchRange([2]int{0, 0}, 10))
+	assert.NoError(t, ValidateCollSrchRange([2]int{-10, 10}, 10))
+	assert.NoError(t, ValidateCollSrchRange([2]int{-1000, 1000}, 0))
+
+	err := ValidateCollSrchRange([2]int{3, -3}, 10)
+	assert.Error(t, err)
+	assert.True(t, merror.IsInputError(err))
+
+	err = ValidateCollSrchRange([2]int{-11, 5}, 10)
+	assert.True(t, merror.IsInputError(err))
+	err = ValidateCollSrchRange([2]int{-5, 11}, 10)
+	assert.True(t, merror.IsInputError(err))
+}
+
+func TestGetCollocationsInvertedRange(t *testing.T) {
+	var err error
+	assert.NotPanics(t, func() {
+		_, err = GetCollcations(
+			"/var/registry/corp1", "", "[word=\"pes\"]", "lemma", 'd', [2]int{5, -5}, 3, 3, 10)
+	})
+	assert.True(t, merror.IsInputError(err))
+}
+
+func TestOpenConcordanceSampleInvalidSize(t *testing.T) {
+	for _, size := range []int{-1, 0, MaxRecordsInternalLimit + 1} {
+		var err error
+		assert.NotPanics(t, func() {
+			_, err = OpenConcordanceSample("/var/registry/corp1", "", "[word=\"pes\"]", size, 42)
+		})
+		assert.True(t, merror.IsInputError(err), "size %d", size)
+	}
+}
+
+func TestConfListContains(t *testing.T) {
+	assert.True(t, confListContains("word,lemma,tag", "lemma"))
+	assert.True(t, confListContains("word", "word"))
+	assert.False(t, confListContains("word,lemma,tag", "lem"))
+	assert.False(t, confListContains("word,lemma", "lemma,tag"))
+	assert.False(t, confListContains("", "word"))
+}
+
+// setTestStripControlChars sets the stripping of control
+// characters for the duration of a test
+func setTestStripControlChars(t *testing.T, v bool) {
+	orig := stripControlChars
+	SetStripControlChars(v)
+	t.Cleanup(func() {
+		SetStripControlChars(orig)
+	})
+}
+
+func TestNormalizeMultiwordControlChars(t *testing.T) {
+	setTestStripControlChars(t, true)
+	assert.Equal(t, "pes", normalizeMultiword("p\u200des"))
+	assert.Equal(t, "pes", normalizeMultiword("\u200epes\u200f"))
+	assert.Equal(t, "שלום עולם", normalizeMultiword("\u200fשלום\u200f עולם\u200f"))
+	assert.Equal(t, "new york", normalizeMultiword("\u202anew\tyork\u202c"))
+	// decomposed "é" (e + combining acute accent) is composed
+	assert.Equal(t, "café", normalizeMultiword("cafe\u0301"))
+}
+
+func TestNormalizeMultiwordControlCharsGrouping(t *testing.T) {
+	setTestStripControlChars(t, true)
+	words := []string{
+		"café",
+		"caf\u200dé",
+		"\u200ecafé",
+		"café\u200f",
+		"cafe\u0301",
+		"caffè",
+	}
+	groups := make(map[string]int)
+	for _, w := range words {
+		groups[normalizeMultiword(w)]++
+	}
+	assert.Equal(t, map[string]int{"café": 5, "caffè": 1}, groups)
+}
+
+func TestNormalizeMultiwordControlCharsDisabled(t *testing.T) {
+	setTestStripControlChars(t, false)
+	assert.Equal(t, "p\u200des", normalizeMultiword("p\u200des"))
+	assert.Equal(t, "\u200epes", normalizeMultiword(" \u200epes "))
+	assert.Equal(t, "cafe\u0301", normalizeMultiword("cafe\u0301"))
+}
+
+func TestStrVectorBatchedMatchesByElement(t *testing.T) {
+	items := []string{"pes", "", "kočka", "New York", "a\tb", "příliš žluťoučký"}
+	vector, release := newStrVector(items)
+	defer release()
+
+	byElement := strVectorToSliceByElement(vector)
+	assert.Len(t, byElement, len(items))
+	assert.Equal(t, byElement, StrVectorToSlice(vector))
+	var visited []int
+	StrVectorForEach(vector, func(i int, v string) {
+		visited = append(visited, i)
+		assert.Equal(t, byElement[i], v)
+	})
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5}, visited)
+	assert.Equal(t, []string{"a", "b"}, strVectorToTuples(vector)[4])
+}
+
+func TestIntVectorBatchedMatchesByElement(t *testing.T) {
+	items := []int64{0, 1, -7, 1 << 40, 42}
+	vector, release := newIntVector(items)
+	defer release()
+
+	assert.Equal(t, items, intVectorToSliceByElement(vector))
+	assert.Equal(t, items, IntVectorToSlice(vector))
+}
+
+func TestVectorsEmpty(t *testing.T) {
+	strVector, releaseStr := newStrVector(nil)
+	defer releaseStr()
+	intVector, releaseInt := newIntVector(nil)
+	defer releaseInt()
+
+	assert.Equal(t, []string{}, StrVectorToSlice(strVector))
+	assert.Equal(t, [][]string{}, strVectorToTuples(strVector))
+	assert.Equal(t, []int64{}, IntVectorToSlice(intVector))
+}
+
+func benchmarkWords(n int) []string {
+	ans := make([]string, n)
+	for i := range ans {
+		ans[i] = fmt.Sprintf("word%d", i)
+	}
+	return ans
+}
+
+func BenchmarkStrVectorByElement(b *testing.B) {
+	vector, release := newStrVector(benchmarkWords(100000))
+	defer release()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		strVectorToSliceByElement(vector)
+	}
+}
+
+func BenchmarkStrVectorBatched(b *testing.B) {
+	vector, release := newStrVector(benchmarkWords(100000))
+	defer release()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		StrVectorToSlice(vector)
+	}
+}
+
+func BenchmarkIntVectorByElement(b *testing.B) {
+	vector, release := newIntVector(make([]int64, 100000))
+	defer release()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		intVectorToSliceByElement(vector)
+	}
+}
+
+func BenchmarkIntVectorBatched(b *testing.B) {
+	vector, release := newIntVector(make([]int64, 100000))
+	defer release()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		IntVectorToSlice(vector)
+	}
+}
@@ -18,7 +18,13 @@
 
 package mango
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
 
 var (
 	collFunc = map[string]byte{
@@ -33,7 +39,37 @@ var (
 		"tScore":         't',
 	}
 
+	// collFuncLabels provides human-readable names of
+	// collocation measures (as used by Manatee/Bonito)
+	collFuncLabels = map[byte]string{
+		't': "T-score",
+		'm': "MI",
+		'3': "MI3",
+		'l': "log likelihood",
+		's': "min. sensitivity",
+		'p': "MI.log_f",
+		'r': "relative freq. [%]",
+		'f': "absolute freq.",
+		'd': "logDice",
+	}
+
+	// sizeDependentCollFuncs lists collocation measures which
+	// use the size of the searched data (corpus or subcorpus)
+	// for calculating expected co-occurrence frequencies so their
+	// scores are comparable only among results with similar sizes
+	sizeDependentCollFuncs = map[byte]bool{
+		't': true,
+		'm': true,
+		'3': true,
+		'l': true,
+		'p': true,
+	}
+
 	ErrUnsupportedValue = errors.New("unsupported value")
+
+	// queryErrorPosition matches a position of an error within
+	// a CQL query as reported by Manatee (e.g. `... at position 12`)
+	queryErrorPosition = regexp.MustCompile(`(?i)\bat (?:position|pos\.?)\s*(\d+)`)
 )
 
 func ImportCollMeasure(v string) (byte, error) {
@@ -52,3 +88,67 @@ func ExportCollMeasure(v byte) (string, error) {
 	}
 	return "", ErrUnsupportedValue
 }
+
+// CollMeasureLabel returns a human-readable name
+// of a collocation measure specified by its code
+func CollMeasureLabel(v byte) (string, error) {
+	label, ok := collFuncLabels[v]
+	if !ok {
+		return "", ErrUnsupportedValue
+	}
+	return label, nil
+}
+
+// IsSizeDependentCollMeasure tells whether a collocation measure
+// specified by its code depends on the size of the searched data.
+// Other measures (e.g. logDice, min. sensitivity or relative freq.)
+// are calculated just from the collocate, node and co-occurrence
+// frequencies.
+func IsSizeDependentCollMeasure(v byte) bool {
+	return sizeDependentCollFuncs[v]
+}
+
+// ParseTokenPosRef extracts an absolute corpus position of a KWIC
+// from a concordance line reference as produced by GetConcordance
+// (which always prepends the `#` reference to the configured ones).
+func ParseTokenPosRef(ref string) (int64, error) {
+	for _, item := range strings.Split(ref, ",") {
+		if strings.HasPrefix(item, "#") {
+			pos, err := strconv.ParseInt(item[1:], 10, 64)
+			if err != nil {
+				return -1, fmt.Errorf("invalid token position in ref `%s`: %w", ref, err)
+			}
+			return pos, nil
+		}
+	}
+	return -1, fmt.Errorf("no token position found in ref `%s`", ref)
+}
+
+// StripTokenPosRef removes the `#` reference (i.e. the KWIC
+// position) from a concordance line reference as produced
+// by GetConcordance. Other references are kept.
+func StripTokenPosRef(ref string) string {
+	items := strings.Split(ref, ",")
+	ans := make([]string, 0, len(items))
+	for _, item := range items {
+		if !strings.HasPrefix(item, "#") {
+			ans = append(ans, item)
+		}
+	}
+	return strings.Join(ans, ",")
+}
+
+// ParseQueryErrorPosition extracts a position (a byte offset) of
+// an error within a CQL query from an error message reported by Manatee.
+// In case the message contains no position, false is returned.
+func ParseQueryErrorPosition(msg string) (int, bool) {
+	srch := queryErrorPosition.FindStringSubmatch(msg)
+	if srch == nil {
+		return -1, false
+	}
+	pos, err := strconv.Atoi(srch[1])
+	if err != nil {
+		return -1, false
+	}
+	return pos, true
+}
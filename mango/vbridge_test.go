@@ -0,0 +1,115 @@
+// Copyright 2019 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2019 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package mango
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTokenPosRef(t *testing.T) {
+	pos, err := ParseTokenPosRef("#75308554")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(75308554), pos)
+
+	pos, err = ParseTokenPosRef("#1200,doc.id=foo,s.id=x12")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1200), pos)
+
+	pos, err = ParseTokenPosRef("doc.id=foo")
+	assert.Error(t, err)
+	assert.Equal(t, int64(-1), pos)
+
+	pos, err = ParseTokenPosRef("#foo")
+	assert.Error(t, err)
+	assert.Equal(t, int64(-1), pos)
+}
+
+func TestCollMeasureLabels(t *testing.T) {
+	expected := map[string]string{
+		"absFreq":        "absolute freq.",
+		"logLikelihood":  "log likelihood",
+		"logDice":        "logDice",
+		"minSensitivity": "min. sensitivity",
+		"mutualInfo":     "MI",
+		"mutualInfo3":    "MI3",
+		"mutualInfoLogF": "MI.log_f",
+		"relFreq":        "relative freq. [%]",
+		"tScore":         "T-score",
+	}
+	assert.Len(t, collFunc, len(expected))
+	for measure, label := range expected {
+		code, err := ImportCollMeasure(measure)
+		assert.NoError(t, err)
+		v, err := CollMeasureLabel(code)
+		assert.NoError(t, err)
+		assert.Equal(t, label, v, "measure %s", measure)
+	}
+	_, err := CollMeasureLabel('x')
+	assert.ErrorIs(t, err, ErrUnsupportedValue)
+}
+
+func TestIsSizeDependentCollMeasure(t *testing.T) {
+	for measure, expected := range map[string]bool{
+		"absFreq":        false,
+		"logDice":        false,
+		"minSensitivity": false,
+		"relFreq":        false,
+		"logLikelihood":  true,
+		"mutualInfo":     true,
+		"mutualInfo3":    true,
+		"mutualInfoLogF": true,
+		"tScore":         true,
+	} {
+		code, err := ImportCollMeasure(measure)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, IsSizeDependentCollMeasure(code), measure)
+	}
+}
+
+func TestStripTokenPosRef(t *testing.T) {
+	assert.Equal(t, "doc.id=foo,s.id=x12", StripTokenPosRef("#1200,doc.id=foo,s.id=x12"))
+	assert.Equal(t, "doc.id=foo", StripTokenPosRef("doc.id=foo,#1200"))
+	assert.Equal(t, "", StripTokenPosRef("#75308554"))
+	assert.Equal(t, "doc.id=foo", StripTokenPosRef("doc.id=foo"))
+}
+
+func TestParseQueryErrorPosition(t *testing.T) {
+	for msg, expected := range map[string]int{
+		"Query syntax error at position 7":                    7,
+		"unexpected character `]` at pos 12":                  12,
+		"CQL parsing error AT POSITION 0: missing attribute":  0,
+		"unexpected token at pos.3 (expected a string value)": 3,
+	} {
+		pos, ok := ParseQueryErrorPosition(msg)
+		assert.True(t, ok, msg)
+		assert.Equal(t, expected, pos, msg)
+	}
+	for _, msg := range []string{
+		"Query syntax error",
+		"unknown attribute `lema`",
+		"error at positions",
+		"",
+	} {
+		pos, ok := ParseQueryErrorPosition(msg)
+		assert.False(t, ok, msg)
+		assert.Equal(t, -1, pos, msg)
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package merror
+
+import (
+	"errors"
+	"fmt"
+)
+
+// InputError represents an error caused by invalid arguments
+// provided by a user (i.e. not an internal error). The HTTP layer
+// should respond with status 422 in such case.
+type InputError struct {
+	Msg string
+}
+
+func (err InputError) Error() string {
+	return err.Msg
+}
+
+// NewInputError creates a new InputError with a formatted message
+func NewInputError(msg string, args ...any) InputError {
+	return InputError{Msg: fmt.Sprintf(msg, args...)}
+}
+
+// IsInputError tests whether `err` is (or wraps) an InputError
+func IsInputError(err error) bool {
+	var inpErr InputError
+	return errors.As(err, &inpErr)
+}
@@ -21,10 +21,12 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -36,13 +38,16 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"mquery/cnf"
+	"mquery/corpus"
 	corpusActions "mquery/corpus/handlers"
 	"mquery/corpus/infoload"
 	"mquery/general"
+	"mquery/mango"
 	"mquery/monitoring"
 	monitoringActions "mquery/monitoring/handlers"
 	"mquery/openapi"
 	"mquery/rdb"
+	"mquery/tracing"
 	"mquery/worker"
 )
 
@@ -78,6 +83,19 @@ func additionalLogEvents() gin.HandlerFunc {
 	}
 }
 
+// jsonRecovery is a backstop for panics in API handlers. It works
+// just like gin.Recovery() but it responds with a JSON error.
+func jsonRecovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(ctx *gin.Context, recovered any) {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("internal server error: %v", recovered),
+			http.StatusInternalServerError,
+		)
+		ctx.Abort()
+	})
+}
+
 func CORSMiddleware(conf *cnf.Conf) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var allowedOrigin string
@@ -93,9 +111,10 @@ func CORSMiddleware(conf *cnf.Conf) gin.HandlerFunc {
 			ctx.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 			ctx.Writer.Header().Set(
 				"Access-Control-Allow-Headers",
-				"Content-Type, Content-Length, Accept-Encoding, Authorization, Accept, Origin, Cache-Control, X-Requested-With",
+				"Content-Type, Content-Length, Accept-Encoding, Authorization, Accept, Origin, Cache-Control, X-Requested-With, X-Response-Key-Case",
 			)
 			ctx.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+			ctx.Writer.Header().Set("Access-Control-Expose-Headers", "X-Effective-Flimit")
 		}
 
 		if ctx.Request.Method == "OPTIONS" {
@@ -116,6 +135,49 @@ func AuthRequired(conf *cnf.Conf) gin.HandlerFunc {
 	}
 }
 
+// noBackpressurePaths are paths not affected by BackpressureMiddleware
+var noBackpressurePaths = []string{"/", "/health", "/openapi", "/privacy-policy"}
+
+// queueLoadProvider provides information about the current load
+// of the query queue (it is implemented by rdb.Adapter)
+type queueLoadProvider interface {
+	GetQueueLoad(ctx context.Context) (rdb.QueueLoad, error)
+}
+
+// BackpressureMiddleware rejects requests with status 503 (and
+// the Retry-After header set) in case the query queue reaches
+// the configured high-water mark.
+func BackpressureMiddleware(conf *cnf.Conf, radapter queueLoadProvider) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if conf.Redis.QueueHighWaterMark == 0 ||
+			collections.SliceContains(noBackpressurePaths, ctx.FullPath()) {
+			ctx.Next()
+			return
+		}
+		load, err := radapter.GetQueueLoad(ctx.Request.Context())
+		if err != nil {
+			log.Error().Err(err).Msg("failed to determine queue load")
+			ctx.Next()
+			return
+		}
+		if load.IsOverloaded() {
+			retryAfter := int(math.Ceil(load.ApproxWaitSecs))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			ctx.Header("Retry-After", strconv.Itoa(retryAfter))
+			uniresp.RespondWithErrorJSON(
+				ctx,
+				fmt.Errorf("server is overloaded (%d queued queries), please try again later", load.QueueLength),
+				http.StatusServiceUnavailable,
+			)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
 func runApiServer(
 	conf *cnf.Conf,
 	syscallChan chan os.Signal,
@@ -128,23 +190,34 @@ func runApiServer(
 	}
 
 	engine := gin.New()
-	engine.Use(gin.Recovery())
+	engine.Use(jsonRecovery())
 	engine.Use(additionalLogEvents())
 	engine.Use(logging.GinMiddleware())
+	engine.Use(tracing.GinMiddleware())
 	engine.Use(uniresp.AlwaysJSONContentType())
+	engine.Use(KeyCaseMiddleware(conf))
+	engine.Use(ErrorFormatMiddleware(conf))
 	engine.Use(CORSMiddleware(conf))
+	engine.Use(BackpressureMiddleware(conf, radapter))
 	engine.NoMethod(uniresp.NoMethodHandler)
 	engine.NoRoute(uniresp.NotFoundHandler)
 
 	protected := engine.Group("/tools").Use(AuthRequired(conf))
 
+	admin := engine.Group("/admin").Use(AuthRequired(conf))
+
 	ceActions := corpusActions.NewActions(
-		conf.CorporaSetup, radapter, infoProvider, conf.Locales)
+		conf.CorporaSetup, radapter, infoProvider,
+		corpus.NewTTNormsCache(conf.CorporaSetup.TTNormsCacheSize, nil), conf.Locales)
 
 	engine.GET("/", mkServerInfo(conf))
 
 	engine.GET("/privacy-policy", mkPrivacyPolicy(conf))
 
+	engine.GET("/version", mkVersionInfo())
+
+	engine.GET("/health", mkHealth(radapter))
+
 	engine.GET("/openapi", openapi.MkHandleRequest(conf, cleanVersionInfo(version)))
 
 	protected.POST(
@@ -153,9 +226,21 @@ func runApiServer(
 	protected.DELETE(
 		"/split/:corpusId", ceActions.DeleteSplit)
 
+	protected.POST(
+		"/prepare/:corpusId", ceActions.PrepareCorpus)
+
+	protected.GET(
+		"/registry/:corpusId", ceActions.CorpusRegistry)
+
+	admin.POST(
+		"/corpus/:corpusId/invalidate", ceActions.InvalidateCorpusCaches)
+
 	engine.GET(
 		"/info/:corpusId", ceActions.CorpusInfo)
 
+	engine.GET(
+		"/citation/:corpusId", ceActions.CorpusCitation)
+
 	engine.GET(
 		"/corplist", ceActions.Corplist)
 
@@ -165,18 +250,42 @@ func runApiServer(
 	engine.GET(
 		"/freqs2/:corpusId", ceActions.FreqDistribParallel)
 
+	engine.GET(
+		"/freqs-multi", ceActions.MultiCorpusFreqDistrib)
+
+	engine.GET(
+		"/freq-crit-help/:corpusId", ceActions.FreqCritHelp)
+
 	engine.GET(
 		"/text-types-norms/:corpusId", ceActions.TextTypesNorms)
 
+	engine.GET(
+		"/text-types-sizes/:corpusId", ceActions.TextTypesSizes)
+
+	engine.GET(
+		"/doc-density/:corpusId", ceActions.DocDensity)
+
+	engine.GET(
+		"/avg-sentence-length/:corpusId", ceActions.AvgSentenceLength)
+
 	engine.GET(
 		"/text-types-streamed/:corpusId", ceActions.TextTypesStreamed)
 
+	engine.GET(
+		"/freqs-streamed/:corpusId", ceActions.FreqDistribStreamed)
+
 	engine.GET(
 		"/freqs-by-year-streamed/:corpusId", ceActions.FreqsByYears)
 
+	engine.GET(
+		"/freqs-time-series/:corpusId", ceActions.FreqsTimeSeries)
+
 	engine.GET(
 		"/text-types/:corpusId", ceActions.TextTypes)
 
+	engine.GET(
+		"/text-types-crosstab/:corpusId", ceActions.TextTypesCrossTab)
+
 	engine.GET(
 		"/text-types2/:corpusId", ceActions.TextTypesParallel)
 
@@ -186,15 +295,39 @@ func runApiServer(
 	engine.GET(
 		"/collocations/:corpusId", ceActions.Collocations)
 
+	engine.GET(
+		"/conc-coll-profile/:corpusId", ceActions.ConcCollProfile)
+
+	engine.GET(
+		"/cooc-matrix/:corpusId", ceActions.CoocMatrix)
+
 	engine.GET(
 		"/word-forms/:corpusId", ceActions.WordForms)
 
+	engine.GET(
+		"/freq-spectrum/:corpusId", ceActions.FreqSpectrum)
+
+	engine.GET(
+		"/vocab-size/:corpusId", ceActions.VocabSize)
+
+	engine.GET(
+		"/ttr/:corpusId", ceActions.TypeTokenRatio)
+
+	engine.GET(
+		"/word-list", ceActions.MultiCorpusWordList)
+
 	engine.GET(
 		"/conc-examples/:corpusId", ceActions.SyntaxConcordance) // TODO rename API endpoint (where is `syntax`?)
 
 	engine.GET(
 		"/concordance/:corpusId", ceActions.Concordance)
 
+	engine.GET(
+		"/widened-context/:corpusId", ceActions.WidenedContext)
+
+	engine.GET(
+		"/conc-line/:corpusId/:lineId", ceActions.ConcLineByID)
+
 	logger := monitoring.NewWorkerJobLogger(conf.TimezoneLocation())
 	logger.GoRunTimelineWriter()
 	monitoringActions := monitoringActions.NewActions(logger, conf.TimezoneLocation())
@@ -209,6 +342,10 @@ func runApiServer(
 		WriteTimeout: time.Duration(conf.ServerWriteTimeoutSecs) * time.Second,
 		ReadTimeout:  time.Duration(conf.ServerReadTimeoutSecs) * time.Second,
 	}
+	watchdogCtx, stopWatchdog := context.WithCancel(context.Background())
+	defer stopWatchdog()
+	go radapter.RunWatchdog(watchdogCtx)
+
 	go func() {
 		err := srv.ListenAndServe()
 		if err != nil {
@@ -219,19 +356,36 @@ func runApiServer(
 
 	select {
 	case <-exitEvent:
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(
+			context.Background(), time.Duration(conf.ShutdownGraceSecs)*time.Second)
 		defer cancel()
+		// first, we stop accepting new HTTP requests and wait for the running
+		// ones (which also means waiting for their worker results)
 		err := srv.Shutdown(ctx)
 		if err != nil {
 			log.Info().Err(err).Msg("Shutdown request error")
 		}
+		// then we wait for possible remaining queries (e.g. the ones
+		// published by background goroutines) and close Redis connection
+		if err := radapter.Shutdown(ctx); err != nil {
+			log.Error().Err(err).Msg("failed to shut down Redis adapter")
+		}
 	}
 }
 
 func runWorker(conf *cnf.Conf, workerID string, radapter *rdb.Adapter, exitEvent chan os.Signal) {
 	ch := radapter.Subscribe()
 	logger := monitoring.NewWorkerJobLogger(conf.TimezoneLocation())
-	w := worker.NewWorker(workerID, radapter, ch, exitEvent, logger)
+	concCache := worker.NewConcCache(
+		time.Duration(conf.CorporaSetup.ConcCacheTTLSecs)*time.Second,
+		conf.CorporaSetup.ConcCacheMaxLines,
+		nil,
+	)
+	ttNorms := corpus.NewTTNormsCache(conf.CorporaSetup.TTNormsCacheSize, nil)
+	w := worker.NewWorker(
+		workerID, radapter, ch, exitEvent, logger, concCache, ttNorms,
+		time.Duration(conf.CorporaSetup.SlowQueryThresholdSecs*float64(time.Second)),
+	)
 	w.Listen()
 }
 
@@ -287,6 +441,9 @@ func main() {
 	}
 	log.Info().Msg("Starting MQUERY")
 	cnf.ValidateAndDefaults(conf)
+	// both the server and workers read strings from Manatee
+	// so they must normalize them the same way
+	mango.SetStripControlChars(conf.CorporaSetup.StripControlChars)
 	syscallChan := make(chan os.Signal, 1)
 	signal.Notify(syscallChan, os.Interrupt)
 	signal.Notify(syscallChan, syscall.SIGTERM)
@@ -0,0 +1,131 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"mquery/cnf"
+	"mquery/rdb"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONRecovery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(jsonRecovery())
+	engine.GET("/panic", func(ctx *gin.Context) {
+		panic("invalid argument")
+	})
+	rec := httptest.NewRecorder()
+	assert.NotPanics(t, func() {
+		engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	})
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	var ans map[string]any
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Contains(t, ans["error"], "invalid argument")
+}
+
+type fakeQueueLoad struct {
+	load rdb.QueueLoad
+}
+
+func (fql *fakeQueueLoad) GetQueueLoad(ctx context.Context) (rdb.QueueLoad, error) {
+	return fql.load, nil
+}
+
+func TestBackpressureMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	conf := &cnf.Conf{Redis: &rdb.Conf{QueueHighWaterMark: 100}}
+	queue := &fakeQueueLoad{
+		load: rdb.QueueLoad{
+			QueueLength:    10,
+			NumWorkers:     4,
+			ApproxWaitSecs: 2.5,
+			HighWaterMark:  conf.Redis.QueueHighWaterMark,
+		},
+	}
+	engine := gin.New()
+	engine.Use(BackpressureMiddleware(conf, queue))
+	engine.GET("/concordance/:corpusId", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	engine.GET("/health", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	request := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+
+	rec := request("/concordance/corp1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// simulate a deep queue
+	queue.load.QueueLength = 250
+	queue.load.ApproxWaitSecs = 62.5
+	rec = request("/concordance/corp1")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "63", rec.Header().Get("Retry-After"))
+	rec = request("/health")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	queue.load.ApproxWaitSecs = 0
+	rec = request("/concordance/corp1")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	conf.Redis.QueueHighWaterMark = 0
+	rec = request("/concordance/corp1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestAuthRequired(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	conf := &cnf.Conf{AuthHeaderName: "X-Api-Key", AuthTokens: []string{"secret"}}
+	engine := gin.New()
+	var numCalls int
+	engine.Group("/tools").Use(AuthRequired(conf)).GET(
+		"/registry/:corpusId",
+		func(ctx *gin.Context) {
+			numCalls++
+			ctx.JSON(http.StatusOK, gin.H{"ok": true})
+		},
+	)
+	for token, status := range map[string]int{
+		"":       http.StatusUnauthorized,
+		"foo":    http.StatusUnauthorized,
+		"secret": http.StatusOK,
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/tools/registry/corp1", nil)
+		if token != "" {
+			req.Header.Set("X-Api-Key", token)
+		}
+		engine.ServeHTTP(rec, req)
+		assert.Equal(t, status, rec.Code, token)
+	}
+	assert.Equal(t, 1, numCalls)
+}
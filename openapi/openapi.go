@@ -142,6 +142,114 @@ func NewResponse(ver, url string) *Response {
 						Type: "string",
 					},
 				},
+				{
+					Name:        "subc",
+					In:          "query",
+					Description: "An ID of a Manatee subcorpus (a .subc file) to search in. If omitted, a configured default subcorpus may apply; use __full__ to search the whole corpus",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "string",
+					},
+				},
+				{
+					Name:        "kwicAttrs",
+					In:          "query",
+					Description: "A positional attribute to be attached to KWIC tokens (can be repeated). If omitted, all the attributes are attached.",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "string",
+					},
+				},
+				{
+					Name:        "contextAttrs",
+					In:          "query",
+					Description: "A positional attribute to be attached to context tokens (can be repeated). If omitted, all the attributes are attached.",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "string",
+					},
+				},
+				{
+					Name:        "fromLine",
+					In:          "query",
+					Description: "The first line of the returned page of the concordance (it cannot be combined with sample)",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "integer",
+					},
+				},
+				{
+					Name:        "sample",
+					In:          "query",
+					Description: "If set, a uniform random sample of the specified number of lines of the whole concordance is returned",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "integer",
+					},
+				},
+				{
+					Name:        "seed",
+					In:          "query",
+					Description: "A seed for the sample mode; the same seed always produces the same sample",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "integer",
+					},
+				},
+				{
+					Name:        "maxDocs",
+					In:          "query",
+					Description: "If set, only lines from the first maxDocs distinct documents are returned",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "integer",
+					},
+				},
+				{
+					Name:        "docStruct",
+					In:          "query",
+					Description: "A structure representing documents for maxDocs (default doc)",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "string",
+					},
+				},
+				{
+					Name:        "contextStructs",
+					In:          "query",
+					Description: "If set (1 to 5), the context spans the specified number of sentences on each side of KWIC",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "integer",
+					},
+				},
+				{
+					Name:        "markStruct",
+					In:          "query",
+					Description: "A structure (e.g. s) the boundaries of which are marked within lines",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "string",
+					},
+				},
+				{
+					Name:        "gloss",
+					In:          "query",
+					Description: "An ID of an aligned corpus the matching segments of which are attached to lines",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "string",
+					},
+				},
+				{
+					Name:        "glossAttr",
+					In:          "query",
+					Description: "A positional attribute of the aligned corpus used for glosses (default word)",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "string",
+					},
+				},
 			},
 		},
 	}
@@ -178,6 +286,24 @@ func NewResponse(ver, url string) *Response {
 						Type: "string",
 					},
 				},
+				{
+					Name:        "fmax",
+					In:          "query",
+					Description: "Maximum frequency of a value to be included (must be >= flimit)",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "integer",
+					},
+				},
+				{
+					Name:        "matchStruct",
+					In:          "query",
+					Description: "A structure whose instances containing the query matches are counted instead of the matches",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "string",
+					},
+				},
 			},
 		},
 	}
@@ -268,6 +394,15 @@ func NewResponse(ver, url string) *Response {
 						Type: "string",
 					},
 				},
+				{
+					Name:        "attr",
+					In:          "query",
+					Description: "a positional attribute (e.g. word, lemma, tag) of the whole KWIC the distribution is calculated for (cannot be combined with fcrit)",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "string",
+					},
+				},
 				{
 					Name:        "maxItems",
 					In:          "query",
@@ -280,7 +415,61 @@ func NewResponse(ver, url string) *Response {
 				{
 					Name:        "flimit",
 					In:          "query",
-					Description: "minimum frequency of result items to be included in the result set",
+					Description: "minimum frequency of result items to be included in the result set (must be >= 1)",
+				},
+				{
+					Name:        "excludeStruct",
+					In:          "query",
+					Description: "A structure whose tokens should not be counted (can be repeated)",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "string",
+					},
+				},
+				{
+					Name:        "norm",
+					In:          "query",
+					Description: "A basis of relative frequencies: `search` (default), `corpus`, `item` (per-item norms provided by Manatee; structural attributes only) or `struct:[structure]`",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "string",
+					},
+				},
+				{
+					Name:        "relativeTo",
+					In:          "query",
+					Description: "If `conc`, item frequencies are also expressed as percentages of the concordance size",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "string",
+					},
+				},
+				{
+					Name:        "offset",
+					In:          "query",
+					Description: "Number of the most frequent items to skip (see `nextOffset` in the response)",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "integer",
+					},
+				},
+				{
+					Name:        "itemsBudget",
+					In:          "query",
+					Description: "Maximum number of items of the whole distribution; `flimit` is raised automatically so the distribution fits",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "integer",
+					},
+				},
+				{
+					Name:        "drillDown",
+					In:          "query",
+					Description: "If `1`, each item contains a query matching the occurrences counted in the item (if supported by the criterion)",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "integer",
+					},
 				},
 			},
 		},
@@ -318,6 +507,15 @@ func NewResponse(ver, url string) *Response {
 						Type: "string",
 					},
 				},
+				{
+					Name:        "subc",
+					In:          "query",
+					Description: "An ID of a Manatee subcorpus (a .subc file) to search in. If omitted, a configured default subcorpus may apply; use __full__ to search the whole corpus",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "string",
+					},
+				},
 				{
 					Name:        "measure",
 					In:          "query",
@@ -354,6 +552,33 @@ func NewResponse(ver, url string) *Response {
 						Type: "integer",
 					},
 				},
+				{
+					Name:        "minFreq",
+					In:          "query",
+					Description: "the minimum frequency that a collocate candidate must have in the whole searched data. The argument is optional with default value equal to minCollFreq",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "integer",
+					},
+				},
+				{
+					Name:        "minScore",
+					In:          "query",
+					Description: "the minimum score (as calculated by the selected measure) of a collocate. The argument is optional with no limit by default",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "number",
+					},
+				},
+				{
+					Name:        "filter",
+					In:          "query",
+					Description: "a CQL structure expression (e.g. <doc genre=\"sport\"/>) limiting the searched expression occurrences collocations are calculated from",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "string",
+					},
+				},
 				{
 					Name:        "maxItems",
 					In:          "query",
@@ -363,6 +588,15 @@ func NewResponse(ver, url string) *Response {
 						Type: "integer",
 					},
 				},
+				{
+					Name:        "collAttrs",
+					In:          "query",
+					Description: "An additional positional attribute whose most frequent value should be attached to each collocate (can be repeated)",
+					Required:    false,
+					Schema: ParamSchema{
+						Type: "string",
+					},
+				},
 			},
 		},
 	}
@@ -0,0 +1,170 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mquery/cnf"
+	"mquery/rdb"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	problemContentType = "application/problem+json"
+
+	problemTypeInput       = "/problems/invalid-input"
+	problemTypeNotFound    = "/problems/not-found"
+	problemTypeTimeout     = "/problems/timeout"
+	problemTypeUnavailable = "/problems/unavailable"
+	problemTypeAuth        = "/problems/unauthorized"
+)
+
+// problemDetails is an error response as defined by RFC 7807
+type problemDetails struct {
+	Type    string   `json:"type"`
+	Title   string   `json:"title"`
+	Status  int      `json:"status"`
+	Detail  string   `json:"detail,omitempty"`
+	Details []string `json:"details,omitempty"`
+
+	// QueryError is an extension member describing a position
+	// of an error within a query (if known)
+	QueryError json.RawMessage `json:"queryError,omitempty"`
+}
+
+// problemType maps an error response to a problem type. In case
+// there is no specific type, `about:blank` is used (see RFC 7807).
+func problemType(status int, detail string) string {
+	switch {
+	case status == http.StatusBadRequest || status == http.StatusUnprocessableEntity:
+		return problemTypeInput
+	case status == http.StatusNotFound:
+		return problemTypeNotFound
+	case status == http.StatusUnauthorized:
+		return problemTypeAuth
+	case status == http.StatusGatewayTimeout ||
+		strings.HasPrefix(detail, rdb.ErrorResultTimeout.Error()):
+		return problemTypeTimeout
+	case status == http.StatusServiceUnavailable:
+		return problemTypeUnavailable
+	default:
+		return "about:blank"
+	}
+}
+
+// newProblemDetails converts an error response body (as written
+// by uniresp or gin.H{"error": ...}) into problem details
+func newProblemDetails(status int, body []byte) problemDetails {
+	var src struct {
+		Error      string          `json:"error"`
+		Details    []string        `json:"details"`
+		QueryError json.RawMessage `json:"queryError"`
+	}
+	// in case of an unexpected body, we still provide at least the status
+	json.Unmarshal(body, &src)
+	return problemDetails{
+		Type:       problemType(status, src.Error),
+		Title:      http.StatusText(status),
+		Status:     status,
+		Detail:     src.Error,
+		Details:    src.Details,
+		QueryError: src.QueryError,
+	}
+}
+
+// problemWriter buffers JSON error responses so they can be
+// converted once a handler is finished. Other responses are
+// written directly.
+type problemWriter struct {
+	gin.ResponseWriter
+	buff   bytes.Buffer
+	status int
+}
+
+func (w *problemWriter) isError() bool {
+	return w.status != 0
+}
+
+func (w *problemWriter) WriteHeader(code int) {
+	if code >= http.StatusBadRequest &&
+		strings.Contains(w.Header().Get("Content-Type"), "application/json") {
+		w.status = code
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *problemWriter) Write(data []byte) (int, error) {
+	if w.isError() {
+		return w.buff.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *problemWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *problemWriter) WriteHeaderNow() {
+	if !w.isError() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *problemWriter) Status() int {
+	if w.isError() {
+		return w.status
+	}
+	return w.ResponseWriter.Status()
+}
+
+// ErrorFormatMiddleware converts JSON error responses to RFC 7807
+// problem details (`application/problem+json`) in case it is configured
+// via `errorResponseFormat` or requested via the `Accept` header.
+func ErrorFormatMiddleware(conf *cnf.Conf) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if conf.ErrorResponseFormat != cnf.ErrorFormatProblem &&
+			!strings.Contains(ctx.GetHeader("Accept"), problemContentType) {
+			ctx.Next()
+			return
+		}
+		origWriter := ctx.Writer
+		writer := &problemWriter{ResponseWriter: origWriter}
+		ctx.Writer = writer
+		ctx.Next()
+		ctx.Writer = origWriter
+		if !writer.isError() {
+			return
+		}
+		data, err := json.Marshal(newProblemDetails(writer.status, writer.buff.Bytes()))
+		if err != nil {
+			// this should not happen; we keep the original response
+			data = writer.buff.Bytes()
+
+		} else {
+			origWriter.Header().Set("Content-Type", problemContentType)
+		}
+		origWriter.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		origWriter.WriteHeader(writer.status)
+		origWriter.Write(data)
+	}
+}
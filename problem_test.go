@@ -0,0 +1,141 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mquery/cnf"
+	"mquery/rdb"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestProblemEngine(conf *cnf.Conf) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(uniresp.AlwaysJSONContentType())
+	engine.Use(ErrorFormatMiddleware(conf))
+	engine.GET("/invalid", func(ctx *gin.Context) {
+		uniresp.RespondWithErrorJSON(
+			ctx, errors.New("invalid `flimit` value"), http.StatusUnprocessableEntity)
+	})
+	engine.GET("/missing", func(ctx *gin.Context) {
+		uniresp.RespondWithErrorJSON(ctx, errors.New("corpus not found"), http.StatusNotFound)
+	})
+	engine.GET("/ok", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+	return engine
+}
+
+func serveTestProblem(engine *gin.Engine, path, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestErrorFormatMiddlewareProblem(t *testing.T) {
+	engine := newTestProblemEngine(&cnf.Conf{ErrorResponseFormat: cnf.ErrorFormatProblem})
+	rec := serveTestProblem(engine, "/invalid", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Equal(t, problemContentType, rec.Header().Get("Content-Type"))
+	var ans map[string]any
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Equal(t, map[string]any{
+		"type":   problemTypeInput,
+		"title":  "Unprocessable Entity",
+		"status": float64(http.StatusUnprocessableEntity),
+		"detail": "invalid `flimit` value",
+	}, ans)
+
+	rec = serveTestProblem(engine, "/missing", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	var problem problemDetails
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problem))
+	assert.Equal(t, problemTypeNotFound, problem.Type)
+	assert.Equal(t, http.StatusNotFound, problem.Status)
+
+	// successful responses are not affected
+	rec = serveTestProblem(engine, "/ok", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"ok": true}`, rec.Body.String())
+}
+
+func TestErrorFormatMiddlewareAcceptHeader(t *testing.T) {
+	engine := newTestProblemEngine(&cnf.Conf{ErrorResponseFormat: cnf.ErrorFormatDefault})
+	rec := serveTestProblem(engine, "/invalid", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	var ans map[string]any
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	assert.Equal(t, "invalid `flimit` value", ans["error"])
+	assert.Equal(t, float64(http.StatusUnprocessableEntity), ans["code"])
+
+	rec = serveTestProblem(engine, "/invalid", "application/problem+json, application/json")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Equal(t, problemContentType, rec.Header().Get("Content-Type"))
+	var problem problemDetails
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problem))
+	assert.Equal(t, problemTypeInput, problem.Type)
+	assert.Equal(t, "invalid `flimit` value", problem.Detail)
+}
+
+func TestProblemType(t *testing.T) {
+	assert.Equal(t, problemTypeInput, problemType(http.StatusBadRequest, ""))
+	assert.Equal(t, problemTypeNotFound, problemType(http.StatusNotFound, ""))
+	assert.Equal(t, problemTypeAuth, problemType(http.StatusUnauthorized, ""))
+	assert.Equal(t, problemTypeTimeout, problemType(http.StatusGatewayTimeout, ""))
+	assert.Equal(
+		t,
+		problemTypeTimeout,
+		problemType(http.StatusInternalServerError, rdb.ErrorResultTimeout.Error()),
+	)
+	assert.Equal(t, problemTypeUnavailable, problemType(http.StatusServiceUnavailable, ""))
+	assert.Equal(t, "about:blank", problemType(http.StatusInternalServerError, "failed"))
+}
+
+func TestNewProblemDetailsUnexpectedBody(t *testing.T) {
+	problem := newProblemDetails(http.StatusBadGateway, []byte("<html>"))
+	assert.Equal(t, problemDetails{
+		Type:   "about:blank",
+		Title:  "Bad Gateway",
+		Status: http.StatusBadGateway,
+	}, problem)
+}
+
+func TestNewProblemDetailsQueryError(t *testing.T) {
+	problem := newProblemDetails(
+		http.StatusUnprocessableEntity,
+		[]byte(`{"code":422,"error":"syntax error at pos 3","queryError":{"offset":3,"message":"syntax error at pos 3"}}`),
+	)
+	assert.Equal(t, problemTypeInput, problem.Type)
+	assert.Equal(t, "syntax error at pos 3", problem.Detail)
+	assert.JSONEq(t, `{"offset":3,"message":"syntax error at pos 3"}`, string(problem.QueryError))
+
+	data, err := json.Marshal(newProblemDetails(http.StatusUnprocessableEntity, []byte(`{"error":"x"}`)))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "queryError")
+}
@@ -24,6 +24,10 @@ import (
 	"errors"
 	"fmt"
 	"mquery/results"
+	"mquery/tracing"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -34,21 +38,30 @@ import (
 const (
 	MsgNewQuery                = "newQuery"
 	MsgNewResult               = "newResult"
+	MsgInvalidateCorpusPrefix  = "invalidateCorpus:"
 	DefaultQueueKey            = "mqueryQueue"
 	DefaultResultChannelPrefix = "mqueryResults"
 	DefaultQueryChannel        = "mqueryQueries"
 	DefaultResultExpiration    = 10 * time.Minute
 	DefaultQueryAnswerTimeout  = 60 * time.Second
+	DefaultJobKeyPrefix        = "mqueryJob"
+	DefaultClaimsKey           = "mqueryClaims"
 )
 
 var (
-	ErrorEmptyQueue = errors.New("no queries in the queue")
+	ErrorEmptyQueue    = errors.New("no queries in the queue")
+	ErrorShuttingDown  = errors.New("adapter is shutting down")
+	ErrorResultTimeout = errors.New("worker result timeouted")
 )
 
 type Query struct {
 	Channel string          `json:"channel"`
 	Func    string          `json:"func"`
 	Args    json.RawMessage `json:"args"`
+
+	// TraceContext contains a serialized span context of the query
+	// publisher so the worker can attach its own spans to the trace
+	TraceContext map[string]string `json:"traceContext,omitempty"`
 }
 
 type CorpusInfoArgs struct {
@@ -56,6 +69,27 @@ type CorpusInfoArgs struct {
 	Language   string `json:"language"`
 }
 
+const (
+	// NormBasisSearch normalizes frequencies against the size
+	// of the searched data (i.e. a corpus or a subcorpus)
+	NormBasisSearch = "search"
+
+	// NormBasisCorpus normalizes frequencies against the size
+	// of the whole corpus (even if a subcorpus is searched)
+	NormBasisCorpus = "corpus"
+
+	// NormBasisStructPrefix is a prefix of a normalization basis
+	// specifying a structure (e.g. `struct:doc`) whose number
+	// of occurrences in the corpus is used for normalization
+	NormBasisStructPrefix = "struct:"
+
+	// NormBasisItem normalizes frequency of each item against its
+	// own norm as calculated by Manatee along with the distribution
+	// (e.g. the size of the respective text type within the searched
+	// data). This applies only to structural attributes.
+	NormBasisItem = "item"
+)
+
 type FreqDistribArgs struct {
 	CorpusPath  string `json:"corpusPath"`
 	SubcPath    string `json:"subcPath"`
@@ -64,6 +98,43 @@ type FreqDistribArgs struct {
 	IsTextTypes bool   `json:"isTextTypes"`
 	FreqLimit   int    `json:"freqLimit"`
 	MaxResults  int    `json:"maxResults"`
+
+	// ItemsLimit is an upper limit for number of items
+	// fetched from Manatee (regardless of MaxResults).
+	// Zero means no limit.
+	ItemsLimit int `json:"itemsLimit"`
+
+	// NormBasis specifies what relative frequencies are calculated
+	// against (see NormBasis* constants). Empty value is the same as
+	// NormBasisSearch. It is ignored in case of text types.
+	NormBasis string `json:"normBasis"`
+
+	// Offset specifies how many of the most frequent items
+	// are skipped (this allows paging through the whole
+	// distribution)
+	Offset int `json:"offset"`
+
+	// MultivalueSeparator, if non-empty, splits values of
+	// a single-level criterion into individual values which
+	// are counted separately
+	MultivalueSeparator string `json:"multivalueSeparator"`
+
+	// ItemsBudget (if positive) is a maximum number of items the whole
+	// distribution may contain. In case there are more items, FreqLimit
+	// is raised so the distribution fits (the applied value is reported
+	// in the result).
+	ItemsBudget int `json:"itemsBudget"`
+
+	// FreqMax (if positive) is a maximum frequency of an item
+	// (i.e. more frequent items are removed)
+	FreqMax int `json:"freqMax"`
+
+	// SortByIPM ranks items by their IPM instead of their frequency.
+	// This makes sense for text types where IPM reflects how densely
+	// the searched data occur within the respective text type. Items
+	// are ranked within the whole distribution (up to ItemsLimit) so
+	// the returned items are the ones with the highest IPM.
+	SortByIPM bool `json:"sortByIPM"`
 }
 
 type CollocationsArgs struct {
@@ -73,8 +144,78 @@ type CollocationsArgs struct {
 	Attr       string `json:"attr"`
 	Measure    string `json:"measure"`
 	SrchRange  [2]int `json:"srchRange"`
-	MinFreq    int64  `json:"minFreq"`
-	MaxItems   int    `json:"maxItems"`
+
+	// MinFreq is a minimum frequency of a collocate candidate
+	// in the whole searched data
+	MinFreq int64 `json:"minFreq"`
+
+	// MinCoocFreq is a minimum frequency of a collocate candidate
+	// co-occurrence with the searched expression
+	MinCoocFreq int64 `json:"minCoocFreq"`
+	MaxItems    int   `json:"maxItems"`
+
+	// CollAttrs are additional positional attributes whose most
+	// frequent values should be attached to each collocate
+	CollAttrs []string `json:"collAttrs"`
+
+	// MinScore is a minimum score (as calculated by Measure)
+	// of a collocate. Nil means no limit.
+	MinScore *float64 `json:"minScore"`
+}
+
+type FreqSpectrumArgs struct {
+	CorpusPath string `json:"corpusPath"`
+	SubcPath   string `json:"subcPath"`
+	Attr       string `json:"attr"`
+}
+
+type TimeSeriesArgs struct {
+	CorpusPath string `json:"corpusPath"`
+	Query      string `json:"query"`
+
+	// Attr is a structural attribute (`struct.attr`) with
+	// year values
+	Attr      string `json:"attr"`
+	FreqLimit int    `json:"freqLimit"`
+
+	// FromYear and ToYear limit the time range;
+	// zero means no limit
+	FromYear int `json:"fromYear"`
+	ToYear   int `json:"toYear"`
+}
+
+type VocabSizeArgs struct {
+	CorpusPath string `json:"corpusPath"`
+	SubcPath   string `json:"subcPath"`
+	Attr       string `json:"attr"`
+}
+
+type TypeTokenRatioArgs struct {
+	CorpusPath string `json:"corpusPath"`
+	SubcPath   string `json:"subcPath"`
+	Attr       string `json:"attr"`
+
+	// WindowSize is a number of tokens of a single window
+	// used to calculate standardized and moving-average TTR
+	WindowSize int `json:"windowSize"`
+
+	// NumSamples specifies how many regions of the corpus
+	// are sampled to calculate the window-based variants
+	NumSamples int `json:"numSamples"`
+}
+
+type WordListArgs struct {
+	CorpusPath string `json:"corpusPath"`
+	Attr       string `json:"attr"`
+	MinFreq    int    `json:"minFreq"`
+
+	// MaxItems specifies a max. number of the most
+	// frequent items to be returned
+	MaxItems int `json:"maxItems"`
+
+	// MultivalueSeparator, if non-empty, splits attribute
+	// values into individual values which are counted separately
+	MultivalueSeparator string `json:"multivalueSeparator"`
 }
 
 type ConcSizeArgs struct {
@@ -84,6 +225,7 @@ type ConcSizeArgs struct {
 
 type ConcordanceArgs struct {
 	CorpusPath        string   `json:"corpusPath"`
+	SubcPath          string   `json:"subcPath"`
 	Query             string   `json:"query"`
 	QueryLemma        string   `json:"queryLemma"`
 	Attrs             []string `json:"attrs"`
@@ -92,6 +234,63 @@ type ConcordanceArgs struct {
 	MaxContext        int      `json:"maxContext"`
 	ViewContextStruct string   `json:"viewContextStruct"`
 	ParentIdxAttr     string   `json:"parentIdxAttr"`
+
+	// ContextStructs is a number of ViewContextStruct instances
+	// the context spans on each side of KWIC (the one containing
+	// KWIC included). The context is still limited by MaxContext.
+	// Zero is treated as 1.
+	ContextStructs int `json:"contextStructs"`
+
+	// BoundaryStruct (if non-empty) is a structure (e.g. `s`) the
+	// boundaries of which are marked within lines
+	BoundaryStruct string `json:"boundaryStruct"`
+
+	// KWICAttrs (if non-empty) limits the attributes attached
+	// to KWIC tokens. The attributes must be also present in `Attrs`.
+	KWICAttrs []string `json:"kwicAttrs"`
+
+	// ContextAttrs (if non-empty) limits the attributes attached
+	// to context (i.e. non-KWIC) tokens. The attributes must be
+	// also present in `Attrs`.
+	ContextAttrs []string `json:"contextAttrs"`
+
+	// SampleSize (if positive) switches the concordance into the sample
+	// mode where a uniform random sample of lines of the whole concordance
+	// is returned (StartLine and MaxItems are ignored in such case)
+	SampleSize int `json:"sampleSize"`
+
+	// SampleSeed makes the sample reproducible
+	SampleSeed uint32 `json:"sampleSeed"`
+
+	// MaxDocs (if positive) limits the returned lines to hits from
+	// at most MaxDocs distinct documents (i.e. instances of DocStruct)
+	MaxDocs   int    `json:"maxDocs"`
+	DocStruct string `json:"docStruct"`
+
+	// HideTokenPosRef removes the `#` reference from line refs
+	// once line IDs are resolved
+	HideTokenPosRef bool `json:"hideTokenPosRef"`
+
+	// GlossCorpusPath (if non-empty) specifies an aligned corpus
+	// the matching segments of which are attached to lines
+	GlossCorpusPath string `json:"glossCorpusPath"`
+
+	// GlossAttr is a positional attribute of the aligned
+	// corpus used to produce the attached segments
+	GlossAttr string `json:"glossAttr"`
+}
+
+type CorpRegionArgs struct {
+	CorpusPath string   `json:"corpusPath"`
+	Attrs      []string `json:"attrs"`
+
+	// TokenPos is a position of the first KWIC token
+	TokenPos int64 `json:"tokenPos"`
+
+	// KWICLen is a number of KWIC tokens
+	KWICLen  int64 `json:"kwicLen"`
+	LeftCtx  int64 `json:"leftCtx"`
+	RightCtx int64 `json:"rightCtx"`
 }
 
 type CalcCollFreqDataArgs struct {
@@ -106,6 +305,18 @@ type CalcCollFreqDataArgs struct {
 	MktokencovPath string   `json:"mktokencovPath"`
 }
 
+// IdempotencyKey returns a key identifying the calculation
+// so that identical jobs can be detected (see Adapter.AcquireJobKey).
+func (args CalcCollFreqDataArgs) IdempotencyKey() string {
+	return fmt.Sprintf(
+		"calcCollFreqData:%s:%s:%s:%s",
+		args.CorpusPath,
+		args.SubcPath,
+		strings.Join(args.Attrs, ","),
+		strings.Join(args.Structs, ","),
+	)
+}
+
 func (q Query) ToJSON() (string, error) {
 	ans, err := json.Marshal(q)
 	if err != nil {
@@ -130,6 +341,22 @@ type Adapter struct {
 	channelQuery        string
 	channelResultPrefix string
 	queryAnswerTimeout  time.Duration
+
+	// pendingQueries tracks published queries still
+	// waiting for their results
+	pendingQueries sync.WaitGroup
+
+	shutdownLock sync.RWMutex
+	shuttingDown bool
+
+	// avgQueryTime is a moving average of time
+	// needed to obtain a query result
+	avgQueryTime  time.Duration
+	queryTimeLock sync.Mutex
+
+	// numStuckJobs is the number of stuck jobs detected
+	// by the watchdog of this instance (see RunWatchdog)
+	numStuckJobs atomic.Int64
 }
 
 func (a *Adapter) TestConnection(timeout time.Duration, cancel chan bool) error {
@@ -180,6 +407,34 @@ func (a *Adapter) SomeoneListens(query Query) (bool, error) {
 // is packed into the WorkerResult value. The error returned
 // by this method means that the publishing itself failed.
 func (a *Adapter) PublishQuery(query Query) (<-chan *WorkerResult, error) {
+	return a.PublishQueryCtx(context.Background(), query)
+}
+
+// addPendingQuery registers a new query waiting for its result
+// (the caller must call `a.pendingQueries.Done()` once the query
+// is finished). In case the adapter is shutting down, ErrorShuttingDown
+// is returned and nothing is registered.
+func (a *Adapter) addPendingQuery() error {
+	a.shutdownLock.RLock()
+	defer a.shutdownLock.RUnlock()
+	if a.shuttingDown {
+		return ErrorShuttingDown
+	}
+	a.pendingQueries.Add(1)
+	return nil
+}
+
+// PublishQueryCtx works just like PublishQuery but it also
+// attaches the query to a trace found in `ctx` (if any).
+// The created span covers both the publishing and the waiting
+// for the result.
+func (a *Adapter) PublishQueryCtx(ctx context.Context, query Query) (<-chan *WorkerResult, error) {
+	if err := a.addPendingQuery(); err != nil {
+		return nil, err
+	}
+
+	spanCtx, span := tracing.Start(ctx, "rdb.publish", tracing.AttrFunc.String(query.Func))
+	query.TraceContext = tracing.InjectContext(spanCtx)
 	query.Channel = fmt.Sprintf("%s:%s", a.channelResultPrefix, uuid.New().String())
 	log.Debug().
 		Str("channel", query.Channel).
@@ -189,20 +444,31 @@ func (a *Adapter) PublishQuery(query Query) (<-chan *WorkerResult, error) {
 
 	msg, err := query.ToJSON()
 	if err != nil {
+		tracing.EndSpan(span, err)
+		a.pendingQueries.Done()
 		return nil, err
 	}
 	sub := a.redis.Subscribe(a.ctx, query.Channel)
 
+	t0 := time.Now()
 	if err := a.redis.LPush(a.ctx, DefaultQueueKey, msg).Err(); err != nil {
+		sub.Close()
+		tracing.EndSpan(span, err)
+		a.pendingQueries.Done()
 		return nil, err
 	}
-	ans := make(chan *WorkerResult)
+	// the channel is buffered so the waiting goroutine below can always
+	// finish (and report itself as done) even if nobody reads the result
+	ans := make(chan *WorkerResult, 1)
 
 	// now we wait for response and send result via `ans`
 	go func() {
+		var resultErr error
 		defer func() {
 			sub.Close()
 			close(ans)
+			tracing.EndSpan(span, resultErr)
+			a.pendingQueries.Done()
 		}()
 
 		result := new(WorkerResult)
@@ -217,6 +483,7 @@ func (a *Adapter) PublishQuery(query Query) (<-chan *WorkerResult, error) {
 					Msg("received result")
 				cmd := a.redis.Get(a.ctx, item.Payload)
 				if cmd.Err() != nil {
+					resultErr = cmd.Err()
 					result.AttachValue(
 						&results.ErrorResult{
 							Func:  query.Func,
@@ -225,6 +492,8 @@ func (a *Adapter) PublishQuery(query Query) (<-chan *WorkerResult, error) {
 					)
 
 				} else {
+					a.recordQueryTime(time.Since(t0))
+					span.SetAttributes(tracing.AttrResultSize.Int(len(cmd.Val())))
 					err := json.Unmarshal([]byte(cmd.Val()), &result)
 					if err != nil {
 						result.AttachValue(&results.ErrorResult{Error: err.Error()})
@@ -234,8 +503,9 @@ func (a *Adapter) PublishQuery(query Query) (<-chan *WorkerResult, error) {
 				tmr.Stop()
 				return
 			case <-tmr.C:
+				resultErr = fmt.Errorf("%w (%v)", ErrorResultTimeout, DefaultQueryAnswerTimeout)
 				result.AttachValue(&results.ErrorResult{
-					Error: fmt.Sprintf("worker result timeouted (%v)", DefaultQueryAnswerTimeout),
+					Error: resultErr.Error(),
 				})
 				ans <- result
 				return
@@ -246,6 +516,29 @@ func (a *Adapter) PublishQuery(query Query) (<-chan *WorkerResult, error) {
 	return ans, a.redis.Publish(a.ctx, a.channelQuery, MsgNewQuery).Err()
 }
 
+// AcquireJobKey registers a job identified by `key` as running.
+// In case the job is already registered (i.e. an identical job is
+// being processed), false is returned. The registration expires
+// after the query answer timeout so a job whose publisher crashed
+// does not block identical jobs forever.
+func (a *Adapter) AcquireJobKey(key string) (bool, error) {
+	cmd := a.redis.SetNX(
+		a.ctx, fmt.Sprintf("%s:%s", DefaultJobKeyPrefix, key), time.Now().Unix(), a.queryAnswerTimeout)
+	if cmd.Err() != nil {
+		return false, fmt.Errorf("failed to acquire job key: %w", cmd.Err())
+	}
+	return cmd.Val(), nil
+}
+
+// ReleaseJobKey removes a registration of a running job
+// (see AcquireJobKey)
+func (a *Adapter) ReleaseJobKey(key string) error {
+	if err := a.redis.Del(a.ctx, fmt.Sprintf("%s:%s", DefaultJobKeyPrefix, key)).Err(); err != nil {
+		return fmt.Errorf("failed to release job key: %w", err)
+	}
+	return nil
+}
+
 // DequeueQuery looks for a query queued for processing.
 // In case nothing is found, ErrorEmptyQueue is returned
 // as an error.
@@ -267,8 +560,8 @@ func (a *Adapter) DequeueQuery() (Query, error) {
 
 // PublishResult sends notification via Redis PUBSUB mechanism
 // and also stores the result so a notified listener can retrieve
-// it.
-func (a *Adapter) PublishResult(channelName string, value *WorkerResult) error {
+// it. The query claim of the worker `workerID` is released.
+func (a *Adapter) PublishResult(channelName, workerID string, value *WorkerResult) error {
 	log.Debug().
 		Str("channel", channelName).
 		Str("resultType", value.ResultType.String()).
@@ -278,9 +571,51 @@ func (a *Adapter) PublishResult(channelName string, value *WorkerResult) error {
 		return fmt.Errorf("failed to serialize result: %w", err)
 	}
 	a.redis.Set(a.ctx, channelName, string(data), DefaultResultExpiration)
+	if err := a.releaseQueryClaim(channelName, workerID); err != nil {
+		log.Error().Err(err).Str("channel", channelName).Msg("failed to release query claim")
+	}
 	return a.redis.Publish(a.ctx, channelName, channelName).Err()
 }
 
+// Shutdown stops accepting new queries (PublishQuery will return
+// ErrorShuttingDown), waits for results of all the pending queries
+// and closes the Redis connection. The waiting is bounded by `ctx`.
+// Results of pending queries are delivered to their respective
+// channels as usual.
+func (a *Adapter) Shutdown(ctx context.Context) error {
+	a.shutdownLock.Lock()
+	a.shuttingDown = true
+	a.shutdownLock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		a.pendingQueries.Wait()
+		close(done)
+	}()
+	var ans error
+	select {
+	case <-done:
+		log.Info().Msg("all pending queries finished")
+	case <-ctx.Done():
+		ans = fmt.Errorf("failed to wait for pending queries: %w", ctx.Err())
+	}
+	if err := a.redis.Close(); err != nil && ans == nil {
+		ans = fmt.Errorf("failed to close Redis connection: %w", err)
+	}
+	return ans
+}
+
+// PublishCorpusInvalidation notifies all the workers listening
+// to the query channel that their cached data (e.g. compiled concordances)
+// related to the corpus `corpusPath` are stale and must be removed.
+func (a *Adapter) PublishCorpusInvalidation(corpusPath string) error {
+	err := a.redis.Publish(a.ctx, a.channelQuery, MsgInvalidateCorpusPrefix+corpusPath).Err()
+	if err != nil {
+		return fmt.Errorf("failed to publish corpus invalidation: %w", err)
+	}
+	return nil
+}
+
 // Subscribe subscribes to query queue.
 func (a *Adapter) Subscribe() <-chan *redis.Message {
 	sub := a.redis.Subscribe(a.ctx, a.channelQuery)
@@ -299,7 +634,7 @@ func NewAdapter(conf *Conf) *Adapter {
 			Msg("Redis channel for results not specified, using default")
 	}
 	if chQuery == "" {
-		chQuery := DefaultQueryChannel
+		chQuery = DefaultQueryChannel
 		log.Warn().
 			Str("channel", chQuery).
 			Msg("Redis channel for queries not specified, using default")
@@ -0,0 +1,102 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package rdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestAdapter creates an adapter with a Redis client which
+// is never connected (the client connects lazily)
+func newTestAdapter() *Adapter {
+	return &Adapter{
+		ctx:   context.Background(),
+		conf:  &Conf{Host: "127.0.0.1", Port: 1},
+		redis: redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"}),
+	}
+}
+
+func TestShutdownDeliversPendingResults(t *testing.T) {
+	adapter := newTestAdapter()
+	assert.NoError(t, adapter.addPendingQuery())
+	ans := make(chan *WorkerResult, 1)
+	go func() {
+		defer adapter.pendingQueries.Done()
+		time.Sleep(50 * time.Millisecond)
+		ans <- &WorkerResult{ResultType: "concSize"}
+		close(ans)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, adapter.Shutdown(ctx))
+	select {
+	case res := <-ans:
+		assert.NotNil(t, res)
+		assert.Equal(t, "concSize", res.ResultType.String())
+	default:
+		t.Error("pending result not delivered before shutdown finished")
+	}
+}
+
+func TestShutdownRejectsNewQueries(t *testing.T) {
+	adapter := newTestAdapter()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, adapter.Shutdown(ctx))
+	_, err := adapter.PublishQuery(Query{Func: "concSize"})
+	assert.ErrorIs(t, err, ErrorShuttingDown)
+}
+
+func TestShutdownGracePeriodExpires(t *testing.T) {
+	adapter := newTestAdapter()
+	assert.NoError(t, adapter.addPendingQuery())
+	defer adapter.pendingQueries.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := adapter.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestCalcCollFreqDataIdempotencyKey(t *testing.T) {
+	args := CalcCollFreqDataArgs{
+		CorpusPath:     "/var/registry/syn2020",
+		SubcPath:       "/var/split/syn2020/0.subc",
+		Attrs:          []string{"word", "lemma"},
+		Structs:        []string{"doc"},
+		MktokencovPath: "/usr/bin/mktokencov",
+	}
+	same := args
+	same.MktokencovPath = "/usr/local/bin/mktokencov"
+	assert.Equal(t, args.IdempotencyKey(), same.IdempotencyKey())
+
+	otherSubc := args
+	otherSubc.SubcPath = "/var/split/syn2020/1.subc"
+	otherAttrs := args
+	otherAttrs.Attrs = []string{"word"}
+	for _, other := range []CalcCollFreqDataArgs{otherSubc, otherAttrs} {
+		assert.NotEqual(t, args.IdempotencyKey(), other.IdempotencyKey())
+	}
+}
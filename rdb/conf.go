@@ -28,6 +28,21 @@ type Conf struct {
 	ChannelQuery           string `json:"channelQuery"`
 	ChannelResultPrefix    string `json:"channelResultPrefix"`
 	QueryAnswerTimeoutSecs int    `json:"queryAnswerTimeoutSecs"`
+
+	// QueueHighWaterMark is a maximum number of queued queries.
+	// If reached, the server rejects new requests with status 503
+	// (instead of enqueueing them and possibly timeouting).
+	// Zero means no limit.
+	QueueHighWaterMark int `json:"queueHighWaterMark"`
+
+	// StuckJobThresholdSecs specifies how long a worker may process
+	// a query before the job is reported as stuck. Zero disables
+	// the watchdog.
+	StuckJobThresholdSecs int `json:"stuckJobThresholdSecs"`
+
+	// RequeueStuckJobs enables re-queuing of stuck jobs (so another
+	// worker can process them). Each job is re-queued at most once.
+	RequeueStuckJobs bool `json:"requeueStuckJobs"`
 }
 
 func (conf *Conf) ServerInfo() string {
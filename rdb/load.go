@@ -0,0 +1,101 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package rdb
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	// queryTimeSmoothing is a weight of the latest query
+	// in the (exponential) moving average of query times
+	queryTimeSmoothing = 0.2
+)
+
+// QueueLoad describes the current load of workers
+type QueueLoad struct {
+
+	// QueueLength is the number of queries waiting for a worker
+	QueueLength int64 `json:"queueLength"`
+
+	// NumWorkers is the number of workers listening for queries
+	NumWorkers int64 `json:"numWorkers"`
+
+	// AvgQueryTimeSecs is a moving average of the time between
+	// publishing a query and receiving its result (as observed
+	// by this server instance)
+	AvgQueryTimeSecs float64 `json:"avgQueryTimeSecs"`
+
+	// ApproxWaitSecs is an estimated time a newly published
+	// query waits before a worker starts to process it
+	ApproxWaitSecs float64 `json:"approxWaitSecs"`
+
+	// HighWaterMark is the configured maximum queue length
+	// (zero means no limit)
+	HighWaterMark int `json:"highWaterMark"`
+
+	// NumStuckJobs is the number of stuck jobs detected
+	// by the watchdog of this server instance
+	NumStuckJobs int64 `json:"numStuckJobs"`
+}
+
+// IsOverloaded tests whether the queue exceeds the configured
+// high-water mark (in which case new queries should be rejected).
+func (ql QueueLoad) IsOverloaded() bool {
+	return ql.HighWaterMark > 0 && ql.QueueLength >= int64(ql.HighWaterMark)
+}
+
+func (a *Adapter) recordQueryTime(t time.Duration) {
+	a.queryTimeLock.Lock()
+	defer a.queryTimeLock.Unlock()
+	if a.avgQueryTime == 0 {
+		a.avgQueryTime = t
+		return
+	}
+	a.avgQueryTime = time.Duration(
+		queryTimeSmoothing*float64(t) + (1-queryTimeSmoothing)*float64(a.avgQueryTime))
+}
+
+// GetQueueLoad returns information about the current load
+// of the query queue and workers.
+func (a *Adapter) GetQueueLoad(ctx context.Context) (QueueLoad, error) {
+	ans := QueueLoad{
+		HighWaterMark: a.conf.QueueHighWaterMark,
+		NumStuckJobs:  a.numStuckJobs.Load(),
+	}
+	qLen, err := a.redis.LLen(ctx, DefaultQueueKey).Result()
+	if err != nil {
+		return ans, fmt.Errorf("failed to get queue length: %w", err)
+	}
+	ans.QueueLength = qLen
+	numSub, err := a.redis.PubSubNumSub(ctx, a.channelQuery).Result()
+	if err != nil {
+		return ans, fmt.Errorf("failed to get number of workers: %w", err)
+	}
+	ans.NumWorkers = numSub[a.channelQuery]
+	a.queryTimeLock.Lock()
+	ans.AvgQueryTimeSecs = a.avgQueryTime.Seconds()
+	a.queryTimeLock.Unlock()
+	if ans.NumWorkers > 0 {
+		ans.ApproxWaitSecs = float64(ans.QueueLength) / float64(ans.NumWorkers) * ans.AvgQueryTimeSecs
+	}
+	return ans, nil
+}
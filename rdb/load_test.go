@@ -0,0 +1,41 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package rdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueLoadIsOverloaded(t *testing.T) {
+	assert.False(t, QueueLoad{QueueLength: 1000}.IsOverloaded())
+	assert.False(t, QueueLoad{QueueLength: 99, HighWaterMark: 100}.IsOverloaded())
+	assert.True(t, QueueLoad{QueueLength: 100, HighWaterMark: 100}.IsOverloaded())
+	assert.True(t, QueueLoad{QueueLength: 5000, HighWaterMark: 100}.IsOverloaded())
+}
+
+func TestRecordQueryTime(t *testing.T) {
+	adapter := newTestAdapter()
+	adapter.recordQueryTime(10 * time.Second)
+	assert.Equal(t, 10*time.Second, adapter.avgQueryTime)
+	adapter.recordQueryTime(20 * time.Second)
+	assert.Equal(t, 12*time.Second, adapter.avgQueryTime)
+}
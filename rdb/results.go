@@ -65,6 +65,60 @@ func DeserializeTextTypesResult(w *WorkerResult) (results.FreqDistrib, error) {
 	return ans, nil
 }
 
+func DeserializeFreqSpectrumResult(w *WorkerResult) (results.FreqSpectrum, error) {
+	var ans results.FreqSpectrum
+	err := json.Unmarshal(w.Value, &ans)
+	if err != nil {
+		return ans, fmt.Errorf("failed to deserialize FreqSpectrum: %w", err)
+	}
+	return ans, nil
+}
+
+func DeserializeTimeSeriesResult(w *WorkerResult) (results.TimeSeries, error) {
+	var ans results.TimeSeries
+	err := json.Unmarshal(w.Value, &ans)
+	if err != nil {
+		return ans, fmt.Errorf("failed to deserialize TimeSeries: %w", err)
+	}
+	return ans, nil
+}
+
+func DeserializeCorpRegionResult(w *WorkerResult) (results.CorpRegion, error) {
+	var ans results.CorpRegion
+	err := json.Unmarshal(w.Value, &ans)
+	if err != nil {
+		return ans, fmt.Errorf("failed to deserialize CorpRegion: %w", err)
+	}
+	return ans, nil
+}
+
+func DeserializeVocabSizeResult(w *WorkerResult) (results.VocabSize, error) {
+	var ans results.VocabSize
+	err := json.Unmarshal(w.Value, &ans)
+	if err != nil {
+		return ans, fmt.Errorf("failed to deserialize VocabSize: %w", err)
+	}
+	return ans, nil
+}
+
+func DeserializeTypeTokenRatioResult(w *WorkerResult) (results.TypeTokenRatio, error) {
+	var ans results.TypeTokenRatio
+	err := json.Unmarshal(w.Value, &ans)
+	if err != nil {
+		return ans, fmt.Errorf("failed to deserialize TypeTokenRatio: %w", err)
+	}
+	return ans, nil
+}
+
+func DeserializeWordListResult(w *WorkerResult) (results.WordList, error) {
+	var ans results.WordList
+	err := json.Unmarshal(w.Value, &ans)
+	if err != nil {
+		return ans, fmt.Errorf("failed to deserialize WordList: %w", err)
+	}
+	return ans, nil
+}
+
 func DeserializeConcSizeResult(w *WorkerResult) (results.ConcSize, error) {
 	var ans results.ConcSize
 	err := json.Unmarshal(w.Value, &ans)
@@ -0,0 +1,283 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package rdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	watchdogMinInterval = 5 * time.Second
+)
+
+// queryClaim describes a query taken from the queue by a worker
+// which has not published its result yet
+type queryClaim struct {
+	WorkerID  string    `json:"workerId"`
+	ClaimedAt time.Time `json:"claimedAt"`
+	Query     Query     `json:"query"`
+
+	// Requeued is true in case the query has been already
+	// re-queued by the watchdog
+	Requeued bool `json:"requeued"`
+
+	// Reported is true in case the watchdog has already
+	// reported the claim as stuck
+	Reported bool `json:"reported"`
+}
+
+// updateClaimScript atomically replaces (ARGV[3] is non-empty) or removes
+// (ARGV[3] is empty) a query claim ARGV[1] in case it still equals ARGV[2].
+// This prevents concurrent watchdogs and workers from overwriting each
+// other's changes.
+var updateClaimScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+if ARGV[3] == "" then
+	redis.call("HDEL", KEYS[1], ARGV[1])
+else
+	redis.call("HSET", KEYS[1], ARGV[1], ARGV[3])
+end
+return 1
+`)
+
+// newQueryClaim creates a claim of `query` by a worker `workerID`.
+// In case the query has been already re-queued by the watchdog
+// (i.e. `prevClaim` - a previous raw claim - has the flag set), the new
+// claim keeps the flag so the query is not re-queued repeatedly.
+func newQueryClaim(query Query, workerID string, now time.Time, prevClaim string) queryClaim {
+	ans := queryClaim{
+		WorkerID:  workerID,
+		ClaimedAt: now,
+		Query:     query,
+	}
+	var prev queryClaim
+	if prevClaim != "" && json.Unmarshal([]byte(prevClaim), &prev) == nil {
+		ans.Requeued = prev.Requeued
+	}
+	return ans
+}
+
+// ClaimQuery registers a query as being processed by a worker
+// `workerID`. The claim is released once the worker publishes
+// the query result (see PublishResult). Claims are used by
+// the watchdog to detect stuck workers (see RunWatchdog).
+func (a *Adapter) ClaimQuery(query Query, workerID string) error {
+	prevClaim, err := a.redis.HGet(a.ctx, DefaultClaimsKey, query.Channel).Result()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("failed to claim query: %w", err)
+	}
+	data, err := json.Marshal(newQueryClaim(query, workerID, time.Now(), prevClaim))
+	if err != nil {
+		return fmt.Errorf("failed to claim query: %w", err)
+	}
+	if err := a.redis.HSet(a.ctx, DefaultClaimsKey, query.Channel, string(data)).Err(); err != nil {
+		return fmt.Errorf("failed to claim query: %w", err)
+	}
+	return nil
+}
+
+// updateClaim replaces a claim in case it has not been changed since
+// it was loaded (`origClaim`). In case `claim` is nil, the claim is removed.
+// The returned value tells whether the claim has been updated.
+func (a *Adapter) updateClaim(channel, origClaim string, claim *queryClaim) (bool, error) {
+	var data []byte
+	if claim != nil {
+		var err error
+		data, err = json.Marshal(claim)
+		if err != nil {
+			return false, err
+		}
+	}
+	ans, err := updateClaimScript.Run(
+		a.ctx, a.redis, []string{DefaultClaimsKey}, channel, origClaim, string(data)).Int()
+	return ans == 1, err
+}
+
+// releaseQueryClaim removes a claim of a query by a worker `workerID`.
+// Claims of other workers (e.g. a claim of a re-queued query
+// by another worker) are kept.
+func (a *Adapter) releaseQueryClaim(channel, workerID string) error {
+	rawClaim, err := a.redis.HGet(a.ctx, DefaultClaimsKey, channel).Result()
+	if err == redis.Nil {
+		return nil
+
+	} else if err != nil {
+		return err
+	}
+	var claim queryClaim
+	if json.Unmarshal([]byte(rawClaim), &claim) == nil && claim.WorkerID != workerID {
+		return nil
+	}
+	_, err = a.updateClaim(channel, rawClaim, nil)
+	return err
+}
+
+// requeueQuery pushes a claimed query back to the queue
+func (a *Adapter) requeueQuery(query Query) error {
+	msg, err := query.ToJSON()
+	if err != nil {
+		return err
+	}
+	// workers pop queries from the right side so the query is processed first
+	if err := a.redis.RPush(a.ctx, DefaultQueueKey, msg).Err(); err != nil {
+		return err
+	}
+	return a.redis.Publish(a.ctx, a.channelQuery, MsgNewQuery).Err()
+}
+
+// claimCheck describes what the watchdog should do with a claim
+type claimCheck struct {
+
+	// report is true in case the claim is stuck and
+	// it has not been reported yet
+	report bool
+
+	// remove is true in case nobody waits for the query result anymore
+	remove bool
+
+	// requeue is true in case the query should be processed again
+	requeue bool
+}
+
+// markClaim marks the checked claim as reported
+// and (if applicable) as re-queued
+func (c claimCheck) markClaim(claim queryClaim) queryClaim {
+	claim.Reported = true
+	claim.Requeued = claim.Requeued || c.requeue
+	return claim
+}
+
+// checkClaim evaluates a claim `age` time after the query has been
+// claimed by a worker. Each stuck claim is reported and re-queued
+// (in case `requeueStuck` is true) at most once.
+func checkClaim(
+	claim queryClaim,
+	age, threshold, answerTimeout time.Duration,
+	requeueStuck bool,
+) claimCheck {
+	if age < threshold {
+		return claimCheck{}
+	}
+	ans := claimCheck{report: !claim.Reported}
+	if age > answerTimeout {
+		// the publisher does not wait anymore
+		ans.remove = true
+		return ans
+	}
+	ans.requeue = requeueStuck && !claim.Requeued
+	return ans
+}
+
+// checkClaims reports (and possibly re-queues) all the claims older
+// than the configured threshold. Claims of queries nobody waits for
+// anymore are removed. To prevent more watchdogs (i.e. server instances)
+// from re-queuing the same query, only the one which actually updates
+// the claim proceeds.
+func (a *Adapter) checkClaims(threshold time.Duration) error {
+	claims, err := a.redis.HGetAll(a.ctx, DefaultClaimsKey).Result()
+	if err != nil {
+		return fmt.Errorf("failed to load query claims: %w", err)
+	}
+	for channel, rawClaim := range claims {
+		var claim queryClaim
+		if err := json.Unmarshal([]byte(rawClaim), &claim); err != nil {
+			log.Error().Err(err).Str("channel", channel).Msg("removing invalid query claim")
+			a.updateClaim(channel, rawClaim, nil)
+			continue
+		}
+		age := time.Since(claim.ClaimedAt)
+		check := checkClaim(claim, age, threshold, a.queryAnswerTimeout, a.conf.RequeueStuckJobs)
+		if check.report {
+			a.numStuckJobs.Add(1)
+			log.Warn().
+				Str("suspectWorker", claim.WorkerID).
+				Str("func", claim.Query.Func).
+				Str("channel", channel).
+				Float64("ageSecs", age.Seconds()).
+				Msg("watchdog found a stuck job")
+		}
+		if check.remove {
+			a.updateClaim(channel, rawClaim, nil)
+			continue
+		}
+		if !check.report && !check.requeue {
+			continue
+		}
+		claim = check.markClaim(claim)
+		updated, err := a.updateClaim(channel, rawClaim, &claim)
+		if err != nil {
+			log.Error().Err(err).Str("channel", channel).Msg("failed to update query claim")
+			continue
+		}
+		if !updated || !check.requeue {
+			continue
+		}
+		if err := a.requeueQuery(claim.Query); err != nil {
+			log.Error().Err(err).Str("channel", channel).Msg("failed to re-queue stuck job")
+
+		} else {
+			log.Warn().
+				Str("suspectWorker", claim.WorkerID).
+				Str("channel", channel).
+				Msg("stuck job re-queued")
+		}
+	}
+	return nil
+}
+
+// RunWatchdog periodically checks queries claimed by workers and
+// reports the ones not finished within the configured
+// `stuckJobThresholdSecs` (optionally, they are also re-queued so
+// another worker can process them). The function blocks until `ctx`
+// is cancelled. In case the threshold is not configured, the function
+// returns immediately.
+func (a *Adapter) RunWatchdog(ctx context.Context) {
+	if a.conf.StuckJobThresholdSecs <= 0 {
+		return
+	}
+	threshold := time.Duration(a.conf.StuckJobThresholdSecs) * time.Second
+	interval := threshold / 2
+	if interval < watchdogMinInterval {
+		interval = watchdogMinInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	log.Info().
+		Float64("thresholdSecs", threshold.Seconds()).
+		Bool("requeue", a.conf.RequeueStuckJobs).
+		Msg("starting stuck jobs watchdog")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.checkClaims(threshold); err != nil {
+				log.Error().Err(err).Msg("watchdog failed to check query claims")
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package rdb
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testStuckThreshold = 10 * time.Second
+	testAnswerTimeout  = 60 * time.Second
+)
+
+// simulateWatchdog evaluates a claim of a job nobody answers at
+// the provided ages, updating the claim the same way checkClaims does.
+// It returns the numbers of reports and re-queuings and whether the
+// claim has been removed.
+func simulateWatchdog(claim *queryClaim, ages []time.Duration, requeue bool) (int, int, bool) {
+	var numReports, numRequeues int
+	for _, age := range ages {
+		check := checkClaim(*claim, age, testStuckThreshold, testAnswerTimeout, requeue)
+		if check.report {
+			numReports++
+		}
+		if check.remove {
+			return numReports, numRequeues, true
+		}
+		if check.report || check.requeue {
+			*claim = check.markClaim(*claim)
+		}
+		if check.requeue {
+			numRequeues++
+		}
+	}
+	return numReports, numRequeues, false
+}
+
+func TestCheckClaimBelowThreshold(t *testing.T) {
+	check := checkClaim(queryClaim{}, 5*time.Second, testStuckThreshold, testAnswerTimeout, true)
+	assert.Equal(t, claimCheck{}, check)
+}
+
+func TestWatchdogFiresForStuckJob(t *testing.T) {
+	claim := queryClaim{WorkerID: "w1", Query: Query{Channel: "ch1", Func: "freqDistrib"}}
+	ages := []time.Duration{2 * time.Second, 7 * time.Second, 12 * time.Second, 17 * time.Second, 22 * time.Second}
+	numReports, numRequeues, removed := simulateWatchdog(&claim, ages, false)
+	assert.Equal(t, 1, numReports)
+	assert.Equal(t, 0, numRequeues)
+	assert.False(t, removed)
+	assert.True(t, claim.Reported)
+	assert.False(t, claim.Requeued)
+}
+
+func TestWatchdogRequeuesStuckJobOnce(t *testing.T) {
+	query := Query{Channel: "ch1", Func: "freqDistrib"}
+	claim := queryClaim{WorkerID: "w1", Query: query}
+	numReports, numRequeues, _ := simulateWatchdog(
+		&claim, []time.Duration{12 * time.Second, 17 * time.Second}, true)
+	assert.Equal(t, 1, numReports)
+	assert.Equal(t, 1, numRequeues)
+
+	// another worker claims the re-queued query and gets stuck as well
+	prevClaim, err := json.Marshal(claim)
+	assert.NoError(t, err)
+	claim = newQueryClaim(query, "w2", time.Now(), string(prevClaim))
+	assert.True(t, claim.Requeued)
+	assert.False(t, claim.Reported)
+	numReports, numRequeues, _ = simulateWatchdog(
+		&claim, []time.Duration{12 * time.Second, 17 * time.Second}, true)
+	assert.Equal(t, 1, numReports)
+	assert.Equal(t, 0, numRequeues)
+}
+
+func TestWatchdogRemovesExpiredClaim(t *testing.T) {
+	claim := queryClaim{WorkerID: "w1"}
+	numReports, numRequeues, removed := simulateWatchdog(
+		&claim, []time.Duration{30 * time.Second, 61 * time.Second, 70 * time.Second}, true)
+	assert.Equal(t, 1, numReports)
+	assert.Equal(t, 1, numRequeues)
+	assert.True(t, removed)
+
+	// a claim found only after the timeout is still reported
+	check := checkClaim(queryClaim{}, 90*time.Second, testStuckThreshold, testAnswerTimeout, true)
+	assert.Equal(t, claimCheck{report: true, remove: true}, check)
+}
+
+func TestNewQueryClaim(t *testing.T) {
+	query := Query{Channel: "ch1", Func: "concordance"}
+	now := time.Now()
+	claim := newQueryClaim(query, "w1", now, "")
+	assert.Equal(t, queryClaim{WorkerID: "w1", ClaimedAt: now, Query: query}, claim)
+	assert.False(t, newQueryClaim(query, "w1", now, "{invalid").Requeued)
+	assert.False(t, newQueryClaim(query, "w2", now, `{"workerId":"w1","reported":true}`).Reported)
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package results
+
+import (
+	"encoding/xml"
+	"io"
+	"sort"
+
+	"github.com/czcorpus/mquery-common/concordance"
+)
+
+type xmlToken struct {
+	Attrs []xml.Attr `xml:",any,attr"`
+	Word  string     `xml:",chardata"`
+}
+
+type xmlConcLine struct {
+	XMLName  xml.Name   `xml:"line"`
+	ID       string     `xml:"id,attr,omitempty"`
+	TokenPos int64      `xml:"tokenPos,attr"`
+	Ref      string     `xml:"ref,attr,omitempty"`
+	Left     []xmlToken `xml:"left>w"`
+	KWIC     []xmlToken `xml:"kwic>w"`
+	Right    []xmlToken `xml:"right>w"`
+	Gloss    *string    `xml:"gloss,omitempty"`
+
+	Boundaries []StructBoundary `xml:"boundary,omitempty"`
+}
+
+type xmlConcordance struct {
+	XMLName  xml.Name      `xml:"concordance"`
+	Corpus   string        `xml:"corpus,attr"`
+	ConcSize int           `xml:"concSize,attr"`
+	Lines    []xmlConcLine `xml:"line"`
+}
+
+// splitKWIC splits a line into its left context, KWIC
+// (i.e. the `strong` tokens) and right context
+func splitKWIC(line concordance.Line) (left, kwic, right concordance.TokenSlice) {
+	for i, token := range line.Text {
+		if token.Strong {
+			if kwic == nil {
+				left = line.Text[:i]
+			}
+			kwic = line.Text[len(left) : i+1]
+
+		} else if kwic != nil {
+			right = line.Text[i:]
+			break
+		}
+	}
+	if kwic == nil {
+		left = line.Text
+	}
+	return
+}
+
+func exportXMLTokens(tokens concordance.TokenSlice) []xmlToken {
+	ans := make([]xmlToken, len(tokens))
+	for i, token := range tokens {
+		attrNames := make([]string, 0, len(token.Attrs))
+		for name := range token.Attrs {
+			attrNames = append(attrNames, name)
+		}
+		sort.Strings(attrNames)
+		ans[i].Word = token.Word
+		ans[i].Attrs = make([]xml.Attr, len(attrNames))
+		for j, name := range attrNames {
+			ans[i].Attrs[j] = xml.Attr{Name: xml.Name{Local: name}, Value: token.Attrs[name]}
+		}
+	}
+	return ans
+}
+
+// WriteXML writes the concordance as an XML document where each
+// line consists of `left`, `kwic` and `right` elements containing
+// tokens (`w` elements with positional attributes as XML attributes).
+func (res *Concordance) WriteXML(w io.Writer, corpusID string) error {
+	data := xmlConcordance{
+		Corpus:   corpusID,
+		ConcSize: res.ConcSize,
+		Lines:    make([]xmlConcLine, len(res.Lines)),
+	}
+	for i, line := range res.Lines {
+		left, kwic, right := splitKWIC(line.Line)
+		data.Lines[i] = xmlConcLine{
+			ID:       line.ID,
+			TokenPos: line.TokenPos,
+			Ref:      line.Ref,
+			Left:     exportXMLTokens(left),
+			KWIC:     exportXMLTokens(kwic),
+			Right:    exportXMLTokens(right),
+			Gloss:    line.Gloss,
+
+			Boundaries: line.Boundaries,
+		}
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(data)
+}
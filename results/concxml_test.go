@@ -0,0 +1,166 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package results
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/czcorpus/mquery-common/concordance"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestXMLConcordance() *Concordance {
+	gloss := `"Tom & Jerry" <translation>`
+	mkToken := func(word, lemma string, strong bool) *concordance.Token {
+		return &concordance.Token{
+			Word:   word,
+			Strong: strong,
+			Attrs:  map[string]string{"lemma": lemma, "tag": `N"&<>`},
+		}
+	}
+	return &Concordance{
+		ConcSize: 2,
+		Lines: []ConcordanceLine{
+			{
+				Line: concordance.Line{
+					Text: concordance.TokenSlice{
+						mkToken("A", "a", false),
+						mkToken("<b>", "<b>", true),
+						mkToken("&", "&amp;", true),
+						mkToken(`"quoted"`, "'", false),
+					},
+					Ref: `#10 doc.title="R&D <2024>"`,
+				},
+				TokenPos: 10,
+				ID:       "corp1:10",
+				Gloss:    &gloss,
+			},
+			{
+				Line: concordance.Line{
+					Text: concordance.TokenSlice{mkToken("x", "x", false)},
+				},
+				TokenPos: -1,
+			},
+		},
+	}
+}
+
+// decodedXMLLine contains an XML concordance line
+// parsed back to (simplified) Go structures
+type decodedXMLLine struct {
+	ID       string `xml:"id,attr"`
+	TokenPos int64  `xml:"tokenPos,attr"`
+	Ref      string `xml:"ref,attr"`
+	Left     []struct {
+		Lemma string `xml:"lemma,attr"`
+		Tag   string `xml:"tag,attr"`
+		Word  string `xml:",chardata"`
+	} `xml:"left>w"`
+	KWIC []struct {
+		Lemma string `xml:"lemma,attr"`
+		Word  string `xml:",chardata"`
+	} `xml:"kwic>w"`
+	Right []struct {
+		Lemma string `xml:"lemma,attr"`
+		Word  string `xml:",chardata"`
+	} `xml:"right>w"`
+	Gloss string `xml:"gloss"`
+}
+
+func TestConcordanceWriteXMLWellFormed(t *testing.T) {
+	var buff bytes.Buffer
+	assert.NoError(t, newTestXMLConcordance().WriteXML(&buff, "corp1"))
+	assert.True(t, strings.HasPrefix(buff.String(), xml.Header))
+	dec := xml.NewDecoder(bytes.NewReader(buff.Bytes()))
+	dec.Strict = true
+	for {
+		_, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			break
+		}
+	}
+	assert.NotContains(t, buff.String(), "<b>")
+}
+
+func TestConcordanceWriteXMLEscaping(t *testing.T) {
+	var buff bytes.Buffer
+	assert.NoError(t, newTestXMLConcordance().WriteXML(&buff, "corp1"))
+	var ans struct {
+		Corpus   string           `xml:"corpus,attr"`
+		ConcSize int              `xml:"concSize,attr"`
+		Lines    []decodedXMLLine `xml:"line"`
+	}
+	assert.NoError(t, xml.Unmarshal(buff.Bytes(), &ans))
+	assert.Equal(t, "corp1", ans.Corpus)
+	assert.Equal(t, 2, ans.ConcSize)
+	if !assert.Len(t, ans.Lines, 2) {
+		return
+	}
+	line := ans.Lines[0]
+	assert.Equal(t, "corp1:10", line.ID)
+	assert.Equal(t, int64(10), line.TokenPos)
+	assert.Equal(t, `#10 doc.title="R&D <2024>"`, line.Ref)
+	if assert.Len(t, line.Left, 1) {
+		assert.Equal(t, "A", line.Left[0].Word)
+		assert.Equal(t, `N"&<>`, line.Left[0].Tag)
+	}
+	if assert.Len(t, line.KWIC, 2) {
+		assert.Equal(t, "<b>", line.KWIC[0].Word)
+		assert.Equal(t, "&", line.KWIC[1].Word)
+		assert.Equal(t, "&amp;", line.KWIC[1].Lemma)
+	}
+	if assert.Len(t, line.Right, 1) {
+		assert.Equal(t, `"quoted"`, line.Right[0].Word)
+		assert.Equal(t, "'", line.Right[0].Lemma)
+	}
+	assert.Equal(t, `"Tom & Jerry" <translation>`, line.Gloss)
+
+	// a line without KWIC has only the left context
+	assert.Len(t, ans.Lines[1].Left, 1)
+	assert.Empty(t, ans.Lines[1].KWIC)
+	assert.Empty(t, ans.Lines[1].Right)
+}
+
+func TestConcordanceWriteXMLBoundaries(t *testing.T) {
+	conc := newTestXMLConcordance()
+	conc.Lines[0].Boundaries = []StructBoundary{
+		{Pos: 0, Struct: "s"},
+		{Pos: 4, Struct: "s", Close: true},
+	}
+	var buff bytes.Buffer
+	assert.NoError(t, conc.WriteXML(&buff, "corp1"))
+	var ans struct {
+		Lines []struct {
+			Boundaries []StructBoundary `xml:"boundary"`
+		} `xml:"line"`
+	}
+	assert.NoError(t, xml.Unmarshal(buff.Bytes(), &ans))
+	if !assert.Len(t, ans.Lines, 2) {
+		return
+	}
+	assert.Equal(t, conc.Lines[0].Boundaries, ans.Lines[0].Boundaries)
+	assert.Empty(t, ans.Lines[1].Boundaries)
+}
@@ -20,6 +20,15 @@ package results
 
 import "errors"
 
+// ErrorType specifies a kind of an error reported by a worker
+// so the API can respond with a proper HTTP status. An empty
+// value means an internal error.
+type ErrorType string
+
+const (
+	ErrorTypeInput ErrorType = "input"
+)
+
 type ErrorResult struct {
 	Func  string `json:"func"`
 	Error string `json:"error"`
@@ -21,21 +21,33 @@ package results
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"mquery/corpus/baseinfo"
 	"mquery/mango"
+	"mquery/merror"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/czcorpus/mquery-common/concordance"
+	"golang.org/x/text/collate"
 )
 
 const (
-	ResultTypeConcordance   = "conc"
-	ResultTypeConcSize      = "concSize"
-	ResultTypeCollocations  = "coll"
-	ResultTypeCollFreqData  = "collFreqData"
-	ResultTypeFreqs         = "freqs"
-	ResultTypeMultipleFreqs = "multipleFreqs"
-	ResultTypeCorpusInfo    = "corpusInfo"
-	ResultTypeError         = "error"
+	ResultTypeConcordance    = "conc"
+	ResultTypeConcSize       = "concSize"
+	ResultTypeCollocations   = "coll"
+	ResultTypeCollFreqData   = "collFreqData"
+	ResultTypeFreqs          = "freqs"
+	ResultTypeMultipleFreqs  = "multipleFreqs"
+	ResultTypeFreqSpectrum   = "freqSpectrum"
+	ResultTypeTimeSeries     = "timeSeries"
+	ResultTypeCorpRegion     = "corpRegion"
+	ResultTypeVocabSize      = "vocabSize"
+	ResultTypeTypeTokenRatio = "typeTokenRatio"
+	ResultTypeWordList       = "wordList"
+	ResultTypeCorpusInfo     = "corpusInfo"
+	ResultTypeError          = "error"
 )
 
 type ResultType string
@@ -58,11 +70,71 @@ func (flist FreqDistribItemList) Cut(maxItems int) FreqDistribItemList {
 	return flist
 }
 
+// SortByFreq sorts items by frequency in descending order.
+// Items with the same frequency are sorted by their values
+// so the order is always the same for the same data.
+func (flist FreqDistribItemList) SortByFreq() {
+	sort.Slice(flist, func(i, j int) bool {
+		if flist[i].Freq == flist[j].Freq {
+			return flist[i].Word < flist[j].Word
+		}
+		return flist[i].Freq > flist[j].Freq
+	})
+}
+
+// SortByIPM sorts items by their relative frequency (i.e. Freq
+// relative to Norm) in descending order. Items with the same
+// relative frequency are sorted by their values. Please note that
+// the value is calculated directly from Freq and Norm so the order
+// is not affected by the limited precision of IPM.
+func (flist FreqDistribItemList) SortByIPM() {
+	relFreq := func(item *FreqDistribItem) float64 {
+		if item.Norm == 0 {
+			return 0
+		}
+		return float64(item.Freq) / float64(item.Norm)
+	}
+	sort.Slice(flist, func(i, j int) bool {
+		fi, fj := relFreq(flist[i]), relFreq(flist[j])
+		if fi == fj {
+			return flist[i].Word < flist[j].Word
+		}
+		return fi > fj
+	})
+}
+
+// SortByValue sorts items by their values using `coll` (which
+// makes the order locale-aware). In case `desc` is true, the order
+// is reversed. Items with equal values keep their original order.
+func (flist FreqDistribItemList) SortByValue(coll *collate.Collator, desc bool) {
+	sort.SliceStable(flist, func(i, j int) bool {
+		cmp := coll.CompareString(flist[i].Word, flist[j].Word)
+		if desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+}
+
 type FreqDistribItem struct {
 	Word string  `json:"word"`
 	Freq int64   `json:"freq"`
 	Norm int64   `json:"norm"`
 	IPM  float32 `json:"ipm"`
+
+	// ConcPct is a percentage of the item frequency
+	// relative to the concordance size (filled in only
+	// if requested - see FreqDistrib.CalcConcPercentages)
+	ConcPct float32 `json:"concPct,omitempty"`
+
+	// Tuple contains individual values of Word in case
+	// a multi-level criterion (e.g. capture-based one) is used
+	Tuple []string `json:"tuple,omitempty"`
+
+	// Query is a CQL query matching exactly the occurrences
+	// counted in the item (filled in only if requested - this
+	// allows clients to fetch respective concordance lines)
+	Query string `json:"query,omitempty"`
 }
 
 type WordFormsItem struct {
@@ -99,12 +171,35 @@ type FreqDistrib struct {
 	// a client) in case a default criterion is applied.
 	Fcrit string
 
+	// Attr is an attribute the `Word` values of the items belong
+	// to. It is empty in case of multi-level criteria.
+	Attr string
+
 	// ExamplesQueryTpl provides a (CQL) query template
 	// for obtaining examples matching words from the `Freqs`
 	// atribute (one by one).
 	ExamplesQueryTpl string
 
+	// Truncated is true if the result has been cut
+	// due to an item limit (i.e. there are more items)
+	Truncated bool
+
+	// NextOffset is an offset of the next page of items
+	// (filled in only if Truncated is true)
+	NextOffset int
+
+	// NormBasis specifies what the relative frequencies
+	// have been calculated against (empty for text types
+	// where each item has its own norm)
+	NormBasis string
+
+	// Flimit is the applied minimum frequency of items. It may be
+	// higher than the requested one in case an items budget is applied.
+	Flimit int
+
 	Error string
+
+	ErrorType ErrorType
 }
 
 func (res *FreqDistrib) Err() error {
@@ -119,27 +214,72 @@ func (res *FreqDistrib) Type() ResultType {
 }
 
 func (res *FreqDistrib) MarshalJSON() ([]byte, error) {
+	freqs := res.Freqs
+	if freqs == nil {
+		// make sure clients always obtain an array (even for empty results)
+		freqs = make(FreqDistribItemList, 0)
+	}
 	return json.Marshal(struct {
 		ConcSize         int64               `json:"concSize"`
 		CorpusSize       int64               `json:"corpusSize"`
 		SearchSize       int64               `json:"searchSize"`
 		Freqs            FreqDistribItemList `json:"freqs"`
 		Fcrit            string              `json:"fcrit"`
+		Attr             string              `json:"attr,omitempty"`
 		ExamplesQueryTpl string              `json:"examplesQueryTpl,omitempty"`
+		Truncated        bool                `json:"truncated"`
+		NextOffset       int                 `json:"nextOffset,omitempty"`
+		NormBasis        string              `json:"normBasis,omitempty"`
+		Flimit           int                 `json:"flimit,omitempty"`
 		ResultType       ResultType          `json:"resultType"`
 		Error            string              `json:"error,omitempty"`
+		ErrorType        ErrorType           `json:"errorType,omitempty"`
 	}{
 		ConcSize:         res.ConcSize,
 		CorpusSize:       res.CorpusSize,
 		SearchSize:       res.SearchSize,
-		Freqs:            res.Freqs,
+		Freqs:            freqs,
 		Fcrit:            res.Fcrit,
+		Attr:             res.Attr,
 		ExamplesQueryTpl: res.ExamplesQueryTpl,
+		Truncated:        res.Truncated,
+		NextOffset:       res.NextOffset,
+		NormBasis:        res.NormBasis,
+		Flimit:           res.Flimit,
 		ResultType:       res.Type(),
 		Error:            res.Error,
+		ErrorType:        res.ErrorType,
 	})
 }
 
+// CalcConcPercentages calculates frequencies of the items as
+// percentages of the concordance size. In case of merged results,
+// the function must be called after the merging so the total
+// concordance size is used.
+func (res *FreqDistrib) CalcConcPercentages() {
+	if res.ConcSize == 0 {
+		return
+	}
+	for _, item := range res.Freqs {
+		item.ConcPct = float32(item.Freq) / float32(res.ConcSize) * 100
+	}
+}
+
+// SetError sets the error message along with the error type
+// (based on the type of the provided error)
+func (res *FreqDistrib) SetError(err error) {
+	res.Error = err.Error()
+	if merror.IsInputError(err) {
+		res.ErrorType = ErrorTypeInput
+	}
+}
+
+// IsInputError tests whether the reported error has been
+// caused by invalid input arguments
+func (res *FreqDistrib) IsInputError() bool {
+	return res.ErrorType == ErrorTypeInput
+}
+
 func (res *FreqDistrib) FindItem(w string) *FreqDistribItem {
 	for _, v := range res.Freqs {
 		if v.Word == w {
@@ -153,11 +293,14 @@ func (res *FreqDistrib) MergeWith(other *FreqDistrib) {
 	res.ConcSize += other.ConcSize
 	res.CorpusSize = other.CorpusSize // always the same value but to resolve possible initial 0
 	res.ExamplesQueryTpl = ""         // we cannot merge two CQL queries so we remove it
+	res.Truncated = res.Truncated || other.Truncated
 	for _, v2 := range other.Freqs {
 		v1 := res.FindItem(v2.Word)
 		if v1 != nil {
 			v1.Freq += v2.Freq
-			v1.IPM = float32(v1.Freq) / float32(v1.Norm) * 1e6
+			if v1.Norm > 0 {
+				v1.IPM = float32(v1.Freq) / float32(v1.Norm) * 1e6
+			}
 
 		} else {
 			// orig IPM should be OK for the first item so no need to set it here
@@ -166,12 +309,330 @@ func (res *FreqDistrib) MergeWith(other *FreqDistrib) {
 	}
 }
 
+// MergeWithOtherCorpus merges a freq. distribution calculated
+// on a different corpus. Unlike MergeWith (intended for chunks of
+// the same corpus), corpus and search sizes are summed and relative
+// frequencies of all the items are recalculated against the combined
+// search size.
+func (res *FreqDistrib) MergeWithOtherCorpus(other *FreqDistrib) {
+	corpusSize := res.CorpusSize + other.CorpusSize
+	searchSize := res.SearchSize + other.SearchSize
+	res.MergeWith(other)
+	res.CorpusSize = corpusSize
+	res.SearchSize = searchSize
+	for _, item := range res.Freqs {
+		item.Norm = searchSize
+		item.IPM = 0
+		if searchSize > 0 {
+			item.IPM = float32(item.Freq) / float32(searchSize) * 1e6
+		}
+	}
+}
+
+// ----
+
+type FreqSpectrumItem struct {
+
+	// Freq is a frequency (k) shared by all the types in the item
+	Freq int64 `json:"freq"`
+
+	// TypeCount is a number of types occurring exactly `Freq` times
+	TypeCount int64 `json:"typeCount"`
+}
+
+type FreqSpectrum struct {
+	Attr string
+
+	// VocabSize is a number of distinct types (i.e. the sum
+	// of all the `TypeCount` values)
+	VocabSize int64
+
+	CorpusSize int64
+
+	SearchSize int64
+
+	Items []FreqSpectrumItem
+
+	Error string
+}
+
+func (res *FreqSpectrum) Err() error {
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+	return nil
+}
+
+func (res *FreqSpectrum) Type() ResultType {
+	return ResultTypeFreqSpectrum
+}
+
+func (res *FreqSpectrum) MarshalJSON() ([]byte, error) {
+	return json.Marshal(
+		struct {
+			Attr       string             `json:"attr"`
+			VocabSize  int64              `json:"vocabSize"`
+			CorpusSize int64              `json:"corpusSize"`
+			SearchSize int64              `json:"searchSize"`
+			Items      []FreqSpectrumItem `json:"items"`
+			ResultType ResultType         `json:"resultType"`
+			Error      string             `json:"error,omitempty"`
+		}{
+			Attr:       res.Attr,
+			VocabSize:  res.VocabSize,
+			CorpusSize: res.CorpusSize,
+			SearchSize: res.SearchSize,
+			Items:      res.Items,
+			ResultType: res.Type(),
+			Error:      res.Error,
+		},
+	)
+}
+
+// ----
+
+// VocabSize represents number of distinct values
+// of a positional attribute
+type VocabSize struct {
+	Attr string
+
+	// IsSubcorpus specifies whether the value is related
+	// to a subcorpus
+	IsSubcorpus bool
+
+	Value int64
+
+	Error string
+}
+
+func (res *VocabSize) Err() error {
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+	return nil
+}
+
+func (res *VocabSize) Type() ResultType {
+	return ResultTypeVocabSize
+}
+
+func (res *VocabSize) MarshalJSON() ([]byte, error) {
+	return json.Marshal(
+		struct {
+			Attr        string     `json:"attr"`
+			IsSubcorpus bool       `json:"isSubcorpus"`
+			Value       int64      `json:"value"`
+			ResultType  ResultType `json:"resultType"`
+			Error       string     `json:"error,omitempty"`
+		}{
+			Attr:        res.Attr,
+			IsSubcorpus: res.IsSubcorpus,
+			Value:       res.Value,
+			ResultType:  res.Type(),
+			Error:       res.Error,
+		},
+	)
+}
+
+// ----
+
+// TypeTokenRatio represents lexical diversity measures of a corpus
+// (or a subcorpus) based on the ratio of types and tokens.
+// The ratios are nil in case they cannot be calculated (e.g. an empty
+// subcorpus or a subcorpus smaller than a window).
+type TypeTokenRatio struct {
+	Attr string
+
+	IsSubcorpus bool
+
+	Types int64
+
+	Tokens int64
+
+	TTR *float64
+
+	// STTR is a standardized TTR - i.e. an average TTR
+	// of (sampled) non-overlapping windows of the size WindowSize
+	STTR *float64
+
+	// MATTR is a moving-average TTR - i.e. an average TTR
+	// of windows of the size WindowSize sliding by a single token
+	// within the sampled regions
+	MATTR *float64
+
+	WindowSize int
+
+	// NumSamples is a number of actually sampled regions
+	NumSamples int
+
+	Error string
+}
+
+func (res *TypeTokenRatio) Err() error {
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+	return nil
+}
+
+func (res *TypeTokenRatio) Type() ResultType {
+	return ResultTypeTypeTokenRatio
+}
+
+func (res *TypeTokenRatio) MarshalJSON() ([]byte, error) {
+	return json.Marshal(
+		struct {
+			Attr        string     `json:"attr"`
+			IsSubcorpus bool       `json:"isSubcorpus"`
+			Types       int64      `json:"types"`
+			Tokens      int64      `json:"tokens"`
+			TTR         *float64   `json:"ttr"`
+			STTR        *float64   `json:"sttr"`
+			MATTR       *float64   `json:"mattr"`
+			WindowSize  int        `json:"windowSize"`
+			NumSamples  int        `json:"numSamples"`
+			ResultType  ResultType `json:"resultType"`
+			Error       string     `json:"error,omitempty"`
+		}{
+			Attr:        res.Attr,
+			IsSubcorpus: res.IsSubcorpus,
+			Types:       res.Types,
+			Tokens:      res.Tokens,
+			TTR:         res.TTR,
+			STTR:        res.STTR,
+			MATTR:       res.MATTR,
+			WindowSize:  res.WindowSize,
+			NumSamples:  res.NumSamples,
+			ResultType:  res.Type(),
+			Error:       res.Error,
+		},
+	)
+}
+
+// ----
+
+type WordListItem struct {
+	Word string `json:"word"`
+	Freq int64  `json:"freq"`
+}
+
+// WordList represents values of a positional attribute
+// sorted by their frequencies in descending order
+type WordList struct {
+	Attr string
+
+	CorpusSize int64
+
+	Items []WordListItem
+
+	// Truncated is true if the list has been cut
+	// due to an item limit (i.e. there are more items)
+	Truncated bool
+
+	Error string
+}
+
+func (res *WordList) Err() error {
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+	return nil
+}
+
+func (res *WordList) Type() ResultType {
+	return ResultTypeWordList
+}
+
+func (res *WordList) MarshalJSON() ([]byte, error) {
+	return json.Marshal(
+		struct {
+			Attr       string         `json:"attr"`
+			CorpusSize int64          `json:"corpusSize"`
+			Items      []WordListItem `json:"items"`
+			Truncated  bool           `json:"truncated"`
+			ResultType ResultType     `json:"resultType"`
+			Error      string         `json:"error,omitempty"`
+		}{
+			Attr:       res.Attr,
+			CorpusSize: res.CorpusSize,
+			Items:      res.Items,
+			Truncated:  res.Truncated,
+			ResultType: res.Type(),
+			Error:      res.Error,
+		},
+	)
+}
+
+// ----
+
+type TimeSeriesItem struct {
+	Year int `json:"year"`
+
+	// Freq is an absolute frequency of the searched expression
+	// within the year
+	Freq int64 `json:"freq"`
+
+	// Norm is a size (in tokens) of the year's data
+	Norm int64 `json:"norm"`
+
+	// IPM is a relative frequency; for years with zero `Norm`,
+	// the value is 0
+	IPM float32 `json:"ipm"`
+}
+
+// TimeSeries represents relative frequencies of a searched
+// expression in individual years.
+type TimeSeries struct {
+	Attr string
+
+	ConcSize int64
+
+	CorpusSize int64
+
+	// Items are sorted by year in ascending order
+	Items []TimeSeriesItem
+
+	Error string
+}
+
+func (res *TimeSeries) Err() error {
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+	return nil
+}
+
+func (res *TimeSeries) Type() ResultType {
+	return ResultTypeTimeSeries
+}
+
+func (res *TimeSeries) MarshalJSON() ([]byte, error) {
+	return json.Marshal(
+		struct {
+			Attr       string           `json:"attr"`
+			ConcSize   int64            `json:"concSize"`
+			CorpusSize int64            `json:"corpusSize"`
+			Items      []TimeSeriesItem `json:"items"`
+			ResultType ResultType       `json:"resultType"`
+			Error      string           `json:"error,omitempty"`
+		}{
+			Attr:       res.Attr,
+			ConcSize:   res.ConcSize,
+			CorpusSize: res.CorpusSize,
+			Items:      res.Items,
+			ResultType: res.Type(),
+			Error:      res.Error,
+		},
+	)
+}
+
 // ----
 
 type ConcSize struct {
 	ConcSize   int64
 	CorpusSize int64
 	Error      string
+	ErrorType  ErrorType
 }
 
 func (res *ConcSize) Err() error {
@@ -192,15 +653,32 @@ func (res *ConcSize) MarshalJSON() ([]byte, error) {
 			CorpusSize int64      `json:"corpusSize"`
 			ResultType ResultType `json:"resultType"`
 			Error      string     `json:"error,omitempty"`
+			ErrorType  ErrorType  `json:"errorType,omitempty"`
 		}{
 			ConcSize:   res.ConcSize,
 			CorpusSize: res.CorpusSize,
 			ResultType: res.Type(),
 			Error:      res.Error,
+			ErrorType:  res.ErrorType,
 		},
 	)
 }
 
+// SetError sets the error message along with the error type
+// (based on the type of the provided error)
+func (res *ConcSize) SetError(err error) {
+	res.Error = err.Error()
+	if merror.IsInputError(err) {
+		res.ErrorType = ErrorTypeInput
+	}
+}
+
+// IsInputError tests whether the reported error has been
+// caused by invalid input arguments (e.g. an invalid query)
+func (res *ConcSize) IsInputError() bool {
+	return res.ErrorType == ErrorTypeInput
+}
+
 // ----
 
 type Collocations struct {
@@ -209,8 +687,19 @@ type Collocations struct {
 	SearchSize int64
 	Colls      []*mango.GoCollItem
 	Measure    string
-	SrchRange  [2]int
-	Error      string
+
+	// MeasureCode is a Manatee code of the applied measure (e.g. `d`)
+	MeasureCode string
+
+	// MeasureLabel is a human-readable name of the applied measure
+	MeasureLabel string
+
+	// SizeDependent specifies whether the applied measure uses
+	// the size of the searched data (SearchSize) so the scores
+	// should be interpreted with respect to it
+	SizeDependent bool
+	SrchRange     [2]int
+	Error         string
 }
 
 func (res *Collocations) Err() error {
@@ -227,21 +716,29 @@ func (res *Collocations) Type() ResultType {
 func (res *Collocations) MarshalJSON() ([]byte, error) {
 	return json.Marshal(
 		struct {
-			CorpusSize int64               `json:"corpusSize"`
-			SearchSize int64               `json:"searchSize"`
-			Colls      []*mango.GoCollItem `json:"colls"`
-			ResultType ResultType          `json:"resultType"`
-			Measure    string              `json:"measure"`
-			SrchRange  [2]int              `json:"srchRange"`
-			Error      string              `json:"error,omitempty"`
+			ConcSize      int64               `json:"concSize"`
+			CorpusSize    int64               `json:"corpusSize"`
+			SearchSize    int64               `json:"searchSize"`
+			Colls         []*mango.GoCollItem `json:"colls"`
+			ResultType    ResultType          `json:"resultType"`
+			Measure       string              `json:"measure"`
+			MeasureCode   string              `json:"measureCode"`
+			MeasureLabel  string              `json:"measureLabel"`
+			SizeDependent bool                `json:"sizeDependent"`
+			SrchRange     [2]int              `json:"srchRange"`
+			Error         string              `json:"error,omitempty"`
 		}{
-			CorpusSize: res.CorpusSize,
-			SearchSize: res.SearchSize,
-			Colls:      res.Colls,
-			ResultType: res.Type(),
-			Measure:    res.Measure,
-			SrchRange:  res.SrchRange,
-			Error:      res.Error,
+			ConcSize:      res.ConcSize,
+			CorpusSize:    res.CorpusSize,
+			SearchSize:    res.SearchSize,
+			Colls:         res.Colls,
+			ResultType:    res.Type(),
+			Measure:       res.Measure,
+			MeasureCode:   res.MeasureCode,
+			MeasureLabel:  res.MeasureLabel,
+			SizeDependent: res.SizeDependent,
+			SrchRange:     res.SrchRange,
+			Error:         res.Error,
 		},
 	)
 }
@@ -265,10 +762,82 @@ func (res *CollFreqData) Type() ResultType {
 
 // ----
 
+// ConcordanceLine is a parsed concordance line extended
+// by additional information about the line's KWIC
+type ConcordanceLine struct {
+	concordance.Line
+
+	// TokenPos is an absolute corpus position of the first
+	// KWIC token. In case the position cannot be determined,
+	// -1 is used.
+	TokenPos int64 `json:"tokenPos"`
+
+	// ID is a stable identifier of the line derived from
+	// the corpus and the KWIC position so the same hit has
+	// always the same ID (regardless of a query or paging).
+	// It is empty in case the position cannot be determined.
+	ID string `json:"id,omitempty"`
+
+	// Gloss is a text of the aligned corpus segment matching
+	// the line's KWIC (e.g. a translation). It is nil in case
+	// no gloss has been requested or in case there is no aligned
+	// segment for the line.
+	Gloss *string `json:"gloss,omitempty"`
+
+	// Boundaries contains boundaries of a requested structure
+	// (e.g. sentences) within the line. It is nil in case
+	// no boundaries have been requested.
+	Boundaries []StructBoundary `json:"boundaries,omitempty"`
+}
+
+// StructBoundary is an opening or closing tag of a structure
+// within a concordance line. Pos is an index of a token (within
+// the line's Text) the tag precedes; for tags at the end of
+// the line, it equals the number of tokens.
+type StructBoundary struct {
+	Pos    int    `json:"pos" xml:"pos,attr"`
+	Struct string `json:"struct" xml:"struct,attr"`
+	Close  bool   `json:"close" xml:"close,attr"`
+}
+
+// NewConcordanceLine creates a ConcordanceLine with the KWIC
+// position resolved from the line's `#` reference
+func NewConcordanceLine(corpusID string, line concordance.Line) ConcordanceLine {
+	pos, err := mango.ParseTokenPosRef(line.Ref)
+	if err != nil {
+		return ConcordanceLine{Line: line, TokenPos: -1}
+	}
+	return ConcordanceLine{
+		Line:     line,
+		TokenPos: pos,
+		ID:       fmt.Sprintf("%s:%d", corpusID, pos),
+	}
+}
+
+// ParseConcordanceLineID parses a concordance line ID
+// (see ConcordanceLine.ID) into a corpus ID and a token position
+func ParseConcordanceLineID(id string) (string, int64, error) {
+	idx := strings.LastIndex(id, ":")
+	if idx < 1 {
+		return "", -1, fmt.Errorf("invalid line ID `%s`", id)
+	}
+	pos, err := strconv.ParseInt(id[idx+1:], 10, 64)
+	if err != nil || pos < 0 {
+		return "", -1, fmt.Errorf("invalid line ID `%s`", id)
+	}
+	return id[:idx], pos, nil
+}
+
 type Concordance struct {
-	Lines    []concordance.Line
+	Lines    []ConcordanceLine
 	ConcSize int
-	Error    string
+
+	// Cached specifies whether the worker used an already
+	// compiled concordance (e.g. when paging)
+	Cached bool
+
+	Error     string
+	ErrorType ErrorType
 }
 
 func (res *Concordance) Err() error {
@@ -283,21 +852,45 @@ func (res *Concordance) Type() ResultType {
 }
 
 func (res Concordance) MarshalJSON() ([]byte, error) {
+	lines := res.Lines
+	if lines == nil {
+		// make sure clients always obtain an array (even for empty results)
+		lines = make([]ConcordanceLine, 0)
+	}
 	return json.Marshal(
 		struct {
-			Lines      []concordance.Line `json:"lines"`
-			ConcSize   int                `json:"concSize"`
-			ResultType ResultType         `json:"resultType"`
-			Error      string             `json:"error,omitempty"`
+			Lines      []ConcordanceLine `json:"lines"`
+			ConcSize   int               `json:"concSize"`
+			Cached     bool              `json:"cached,omitempty"`
+			ResultType ResultType        `json:"resultType"`
+			Error      string            `json:"error,omitempty"`
+			ErrorType  ErrorType         `json:"errorType,omitempty"`
 		}{
-			Lines:      res.Lines,
+			Lines:      lines,
 			ConcSize:   res.ConcSize,
+			Cached:     res.Cached,
 			ResultType: res.Type(),
 			Error:      res.Error,
+			ErrorType:  res.ErrorType,
 		},
 	)
 }
 
+// SetError sets the error message along with the error type
+// (based on the type of the provided error)
+func (res *Concordance) SetError(err error) {
+	res.Error = err.Error()
+	if merror.IsInputError(err) {
+		res.ErrorType = ErrorTypeInput
+	}
+}
+
+// IsInputError tests whether the reported error has been
+// caused by invalid input arguments (e.g. an invalid query)
+func (res *Concordance) IsInputError() bool {
+	return res.ErrorType == ErrorTypeInput
+}
+
 // --------
 
 type CorpusInfo struct {
@@ -328,3 +921,53 @@ func (res CorpusInfo) MarshalJSON() ([]byte, error) {
 		Error:      res.Error,
 	})
 }
+
+// ----
+
+// CorpRegion represents a continuous corpus region
+// around a KWIC (with KWIC tokens marked as `strong`)
+type CorpRegion struct {
+	Text concordance.TokenSlice
+
+	// TokenPos is a position of the first KWIC token
+	TokenPos int64
+
+	// FromPos is the first position of the region
+	FromPos int64
+
+	// ToPos is the position right after the region
+	ToPos int64
+
+	Error string
+}
+
+func (res *CorpRegion) Err() error {
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+	return nil
+}
+
+func (res *CorpRegion) Type() ResultType {
+	return ResultTypeCorpRegion
+}
+
+func (res *CorpRegion) MarshalJSON() ([]byte, error) {
+	return json.Marshal(
+		struct {
+			Text       concordance.TokenSlice `json:"text"`
+			TokenPos   int64                  `json:"tokenPos"`
+			FromPos    int64                  `json:"fromPos"`
+			ToPos      int64                  `json:"toPos"`
+			ResultType ResultType             `json:"resultType"`
+			Error      string                 `json:"error,omitempty"`
+		}{
+			Text:       res.Text,
+			TokenPos:   res.TokenPos,
+			FromPos:    res.FromPos,
+			ToPos:      res.ToPos,
+			ResultType: res.Type(),
+			Error:      res.Error,
+		},
+	)
+}
@@ -0,0 +1,324 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package results
+
+import (
+	"encoding/json"
+	"errors"
+	"mquery/merror"
+	"testing"
+
+	"github.com/czcorpus/mquery-common/concordance"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+func TestConcordanceLinePositionRoundTrip(t *testing.T) {
+	parser := concordance.NewLineParser([]string{"word", "lemma"})
+	lines := parser.Parse([]string{
+		"#1200 a {} /a attr velký {} /velký attr pes {coll} /pes attr",
+	})
+	line := NewConcordanceLine("syn2020", lines[0])
+	assert.Equal(t, int64(1200), line.TokenPos)
+	assert.Equal(t, "syn2020:1200", line.ID)
+
+	corpusID, pos, err := ParseConcordanceLineID(line.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "syn2020", corpusID)
+	assert.Equal(t, line.TokenPos, pos)
+}
+
+func TestConcordanceLineUnknownPosition(t *testing.T) {
+	line := NewConcordanceLine("syn2020", concordance.Line{Ref: "doc.id=foo"})
+	assert.Equal(t, int64(-1), line.TokenPos)
+	assert.Equal(t, "", line.ID)
+}
+
+func TestConcordanceLineIDStableAcrossPages(t *testing.T) {
+	parser := concordance.NewLineParser([]string{"word"})
+	// the same concordance fetched as two overlapping pages
+	page1 := parser.Parse([]string{
+		"#10 pes {} attr",
+		"#25 pes {} attr",
+		"#31 pes {} attr",
+	})
+	page2 := parser.Parse([]string{
+		"#25 pes {} attr",
+		"#31 pes {} attr",
+		"#47 pes {} attr",
+	})
+	ids1 := make(map[int64]string)
+	for _, line := range page1 {
+		cline := NewConcordanceLine("syn2020", line)
+		ids1[cline.TokenPos] = cline.ID
+	}
+	var numShared int
+	for _, line := range page2 {
+		cline := NewConcordanceLine("syn2020", line)
+		if id, ok := ids1[cline.TokenPos]; ok {
+			assert.Equal(t, id, cline.ID)
+			numShared++
+		}
+	}
+	assert.Equal(t, 2, numShared)
+
+	other := NewConcordanceLine("syn2015", page1[0])
+	assert.NotEqual(t, ids1[10], other.ID)
+}
+
+func TestFreqDistribMergeKeepsTruncated(t *testing.T) {
+	res := &FreqDistrib{Freqs: FreqDistribItemList{{Word: "a", Freq: 2, Norm: 10}}}
+	res.MergeWith(&FreqDistrib{
+		Freqs:     FreqDistribItemList{{Word: "a", Freq: 3, Norm: 10}},
+		Truncated: true,
+	})
+	assert.True(t, res.Truncated)
+	assert.Equal(t, int64(5), res.Freqs[0].Freq)
+
+	res.MergeWith(&FreqDistrib{})
+	assert.True(t, res.Truncated)
+}
+
+func TestCollocationsMeasureInResponse(t *testing.T) {
+	res := &Collocations{Measure: "minSensitivity", MeasureCode: "s", MeasureLabel: "min. sensitivity"}
+	data, err := json.Marshal(res)
+	assert.NoError(t, err)
+	var ans map[string]any
+	assert.NoError(t, json.Unmarshal(data, &ans))
+	assert.Equal(t, "minSensitivity", ans["measure"])
+	assert.Equal(t, "s", ans["measureCode"])
+	assert.Equal(t, "min. sensitivity", ans["measureLabel"])
+}
+
+func TestCalcConcPercentagesMergedChunks(t *testing.T) {
+	chunk1 := &FreqDistrib{
+		ConcSize: 60,
+		Freqs: FreqDistribItemList{
+			{Word: "pes", Freq: 30}, {Word: "kočka", Freq: 20}, {Word: "myš", Freq: 10},
+		},
+	}
+	chunk2 := &FreqDistrib{
+		ConcSize: 40,
+		Freqs: FreqDistribItemList{
+			{Word: "pes", Freq: 15}, {Word: "had", Freq: 25},
+		},
+	}
+	res := &FreqDistrib{}
+	res.MergeWith(chunk1)
+	res.MergeWith(chunk2)
+	res.CalcConcPercentages()
+
+	assert.Equal(t, int64(100), res.ConcSize)
+	var total float64
+	for _, item := range res.Freqs {
+		total += float64(item.ConcPct)
+	}
+	assert.InDelta(t, 100.0, total, 0.01)
+	assert.InDelta(t, 45.0, res.FindItem("pes").ConcPct, 0.01)
+	assert.InDelta(t, 25.0, res.FindItem("had").ConcPct, 0.01)
+}
+
+func TestCalcConcPercentagesEmptyConc(t *testing.T) {
+	res := &FreqDistrib{Freqs: FreqDistribItemList{{Word: "pes", Freq: 0}}}
+	res.CalcConcPercentages()
+	assert.Equal(t, float32(0), res.Freqs[0].ConcPct)
+}
+
+func TestParseConcordanceLineIDRoundTrip(t *testing.T) {
+	parser := concordance.NewLineParser([]string{"word"})
+	for _, corpusID := range []string{"syn2020", "intercorp_v16ud_cs", "ns:corp"} {
+		for _, line := range parser.Parse([]string{"#0 pes {} attr", "#1234567 pes {} attr"}) {
+			cline := NewConcordanceLine(corpusID, line)
+			parsedCorpusID, tokenPos, err := ParseConcordanceLineID(cline.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, corpusID, parsedCorpusID)
+			assert.Equal(t, cline.TokenPos, tokenPos)
+		}
+	}
+}
+
+func TestParseConcordanceLineIDInvalid(t *testing.T) {
+	for _, id := range []string{"", "syn2020", ":10", "syn2020:", "syn2020:foo", "syn2020:-1"} {
+		_, _, err := ParseConcordanceLineID(id)
+		assert.Error(t, err, id)
+	}
+}
+
+func TestConcordanceSetError(t *testing.T) {
+	var invalid Concordance
+	invalid.SetError(merror.NewInputError("invalid query: unexpected token"))
+	assert.True(t, invalid.IsInputError())
+	assert.Error(t, invalid.Err())
+
+	var failed Concordance
+	failed.SetError(errors.New("failed to open corpus"))
+	assert.False(t, failed.IsInputError())
+	assert.Error(t, failed.Err())
+
+	var size ConcSize
+	size.SetError(merror.NewInputError("invalid query: unexpected token"))
+	assert.True(t, size.IsInputError())
+}
+
+func TestConcordanceEmptyJSON(t *testing.T) {
+	data, err := json.Marshal(Concordance{})
+	assert.NoError(t, err)
+	var ans map[string]any
+	assert.NoError(t, json.Unmarshal(data, &ans))
+	assert.Equal(t, []any{}, ans["lines"])
+	assert.Equal(t, float64(0), ans["concSize"])
+	assert.NotContains(t, ans, "error")
+	assert.NotContains(t, ans, "errorType")
+}
+
+func TestConcordanceLineGlossJSON(t *testing.T) {
+	gloss := "The old dog barks"
+	data, err := json.Marshal(Concordance{
+		Lines: []ConcordanceLine{{TokenPos: 2, Gloss: &gloss}, {TokenPos: 5}},
+	})
+	assert.NoError(t, err)
+	var ans struct {
+		Lines []map[string]any `json:"lines"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &ans))
+	assert.Len(t, ans.Lines, 2)
+	assert.Equal(t, gloss, ans.Lines[0]["gloss"])
+	assert.NotContains(t, ans.Lines[1], "gloss")
+}
+
+func TestCollocationsSizesInResponse(t *testing.T) {
+	res := &Collocations{
+		ConcSize:      420,
+		CorpusSize:    1000000,
+		SearchSize:    25000,
+		Measure:       "tScore",
+		SizeDependent: true,
+	}
+	data, err := json.Marshal(res)
+	assert.NoError(t, err)
+	var ans map[string]any
+	assert.NoError(t, json.Unmarshal(data, &ans))
+	assert.Equal(t, float64(420), ans["concSize"])
+	assert.Equal(t, float64(1000000), ans["corpusSize"])
+	assert.Equal(t, float64(25000), ans["searchSize"])
+	assert.Equal(t, true, ans["sizeDependent"])
+}
+
+func TestFreqDistribItemListSortByValue(t *testing.T) {
+	words := func(flist FreqDistribItemList) []string {
+		ans := make([]string, len(flist))
+		for i, item := range flist {
+			ans[i] = item.Word
+		}
+		return ans
+	}
+	newList := func() FreqDistribItemList {
+		return FreqDistribItemList{
+			{Word: "žába", Freq: 1},
+			{Word: "chata", Freq: 2},
+			{Word: "čaj", Freq: 3},
+			{Word: "hrad", Freq: 4},
+			{Word: "cesta", Freq: 5},
+			{Word: "dům", Freq: 6},
+			{Word: "zima", Freq: 7},
+		}
+	}
+
+	flist := newList()
+	flist.SortByValue(collate.New(language.Czech), false)
+	assert.Equal(
+		t,
+		[]string{"cesta", "čaj", "dům", "hrad", "chata", "zima", "žába"},
+		words(flist),
+	)
+
+	flist = newList()
+	flist.SortByValue(collate.New(language.Czech), true)
+	assert.Equal(
+		t,
+		[]string{"žába", "zima", "chata", "hrad", "dům", "čaj", "cesta"},
+		words(flist),
+	)
+
+	flist = newList()
+	flist.SortByValue(collate.New(language.Und), false)
+	assert.Equal(
+		t,
+		[]string{"čaj", "cesta", "chata", "dům", "hrad", "žába", "zima"},
+		words(flist),
+	)
+}
+
+func TestFreqDistribItemListSortByValueIsStable(t *testing.T) {
+	flist := FreqDistribItemList{
+		{Word: "b", Freq: 1},
+		{Word: "a", Freq: 2},
+		{Word: "b", Freq: 3},
+		{Word: "a", Freq: 4},
+	}
+	flist.SortByValue(collate.New(language.Und), false)
+	freqs := make([]int64, len(flist))
+	for i, item := range flist {
+		freqs[i] = item.Freq
+	}
+	assert.Equal(t, []int64{2, 4, 1, 3}, freqs)
+}
+
+func TestFreqDistribItemListSortByIPM(t *testing.T) {
+	flist := FreqDistribItemList{
+		{Word: "d1", Freq: 50, Norm: 50000},
+		{Word: "d2", Freq: 30, Norm: 3000},
+		{Word: "d3", Freq: 8, Norm: 400},
+		{Word: "d4", Freq: 0, Norm: 0},
+		{Word: "d5", Freq: 1, Norm: 10},
+		{Word: "d0", Freq: 60, Norm: 6000},
+	}
+	flist.SortByIPM()
+	words := make([]string, len(flist))
+	for i, item := range flist {
+		words[i] = item.Word
+	}
+	// items with the same relative frequency are sorted by value
+	// and items without a norm go last
+	assert.Equal(t, []string{"d5", "d3", "d0", "d2", "d1", "d4"}, words)
+}
+
+func TestConcordanceLineBoundariesJSON(t *testing.T) {
+	data, err := json.Marshal(Concordance{
+		Lines: []ConcordanceLine{
+			{TokenPos: 2, Boundaries: []StructBoundary{{Pos: 0, Struct: "s"}, {Pos: 3, Struct: "s", Close: true}}},
+			{TokenPos: 5},
+		},
+	})
+	assert.NoError(t, err)
+	var ans struct {
+		Lines []map[string]any `json:"lines"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &ans))
+	assert.Equal(
+		t,
+		[]any{
+			map[string]any{"pos": float64(0), "struct": "s", "close": false},
+			map[string]any{"pos": float64(3), "struct": "s", "close": true},
+		},
+		ans.Lines[0]["boundaries"],
+	)
+	assert.NotContains(t, ans.Lines[1], "boundaries")
+}
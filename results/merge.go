@@ -0,0 +1,131 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package results
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// freqItemHeap is a min-heap of freq. items (by their frequency)
+type freqItemHeap []*FreqDistribItem
+
+func (h freqItemHeap) Len() int { return len(h) }
+
+// ranksLower tests whether item `a` should be placed after
+// item `b` in a result sorted by frequency
+func ranksLower(a, b *FreqDistribItem) bool {
+	if a.Freq == b.Freq {
+		return a.Word > b.Word
+	}
+	return a.Freq < b.Freq
+}
+
+func (h freqItemHeap) Less(i, j int) bool { return ranksLower(h[i], h[j]) }
+
+func (h freqItemHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *freqItemHeap) Push(x any) {
+	*h = append(*h, x.(*FreqDistribItem))
+}
+
+func (h *freqItemHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// FreqDistribMerger incrementally merges frequency distributions
+// calculated for individual chunks of a corpus. Items are indexed
+// by their value so merging is linear in the size of the added
+// distribution. The merger itself does not drop any items (an item
+// outside the top ones after merging some chunks may still get among
+// them after merging the other chunks) so its memory is bounded only
+// by the size of the added distributions. I.e. it relies on the worker
+// truncating each chunk result to the requested number of items
+// (rdb.FreqDistribArgs.MaxResults) in which case the merger holds
+// at most `numChunks * maxItems` items. The final top-K items
+// are then selected using a bounded heap instead of sorting all
+// the merged items. The merger is safe for concurrent use.
+type FreqDistribMerger struct {
+	result *FreqDistrib
+	index  map[string]*FreqDistribItem
+	lock   sync.Mutex
+}
+
+// Add merges `other` distribution into the merger.
+// The items of `other` may be modified by subsequent
+// merging so they should not be used afterwards.
+func (m *FreqDistribMerger) Add(other *FreqDistrib) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.result.ConcSize += other.ConcSize
+	m.result.CorpusSize = other.CorpusSize // always the same value but to resolve possible initial 0
+	m.result.Truncated = m.result.Truncated || other.Truncated
+	// all the chunks are calculated using the same criterion
+	m.result.Fcrit = other.Fcrit
+	m.result.Attr = other.Attr
+	for _, v2 := range other.Freqs {
+		v1, ok := m.index[v2.Word]
+		if ok {
+			v1.Freq += v2.Freq
+			if v1.Norm > 0 {
+				v1.IPM = float32(v1.Freq) / float32(v1.Norm) * 1e6
+			}
+
+		} else {
+			// orig IPM should be OK for the first item so no need to set it here
+			m.index[v2.Word] = v2
+		}
+	}
+}
+
+// TopK returns the merged distribution with at most `k` most
+// frequent items sorted by frequency in descending order.
+// Items with the same frequency are sorted by their value.
+func (m *FreqDistribMerger) TopK(k int) *FreqDistrib {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h := make(freqItemHeap, 0, k)
+	for _, item := range m.index {
+		if len(h) < k {
+			heap.Push(&h, item)
+
+		} else if k > 0 && ranksLower(h[0], item) {
+			h[0] = item
+			heap.Fix(&h, 0)
+		}
+	}
+	ans := *m.result
+	ans.Freqs = make(FreqDistribItemList, len(h))
+	for i := len(h) - 1; i >= 0; i-- {
+		ans.Freqs[i] = heap.Pop(&h).(*FreqDistribItem)
+	}
+	return &ans
+}
+
+func NewFreqDistribMerger() *FreqDistribMerger {
+	return &FreqDistribMerger{
+		result: &FreqDistrib{Freqs: make(FreqDistribItemList, 0)},
+		index:  make(map[string]*FreqDistribItem),
+	}
+}
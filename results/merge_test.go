@@ -0,0 +1,123 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package results
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mkTestChunks(rnd *rand.Rand, numChunks, numWords int) []*FreqDistrib {
+	ans := make([]*FreqDistrib, numChunks)
+	for i := range ans {
+		chunk := &FreqDistrib{ConcSize: 1000, CorpusSize: 1000000}
+		for j := 0; j < numWords; j++ {
+			if rnd.Intn(3) == 0 {
+				continue
+			}
+			chunk.Freqs = append(chunk.Freqs, &FreqDistribItem{
+				Word: fmt.Sprintf("w%03d", j),
+				Freq: int64(rnd.Intn(50) + 1),
+				Norm: 1000000,
+			})
+		}
+		ans[i] = chunk
+	}
+	return ans
+}
+
+// fullMergeTopK merges all the items first and then sorts
+// and truncates the result
+func fullMergeTopK(chunks []*FreqDistrib, k int) []FreqDistribItem {
+	merged := make(map[string]int64)
+	for _, chunk := range chunks {
+		for _, item := range chunk.Freqs {
+			merged[item.Word] += item.Freq
+		}
+	}
+	ans := make([]FreqDistribItem, 0, len(merged))
+	for w, f := range merged {
+		ans = append(ans, FreqDistribItem{Word: w, Freq: f})
+	}
+	sort.Slice(ans, func(i, j int) bool {
+		if ans[i].Freq == ans[j].Freq {
+			return ans[i].Word < ans[j].Word
+		}
+		return ans[i].Freq > ans[j].Freq
+	})
+	if len(ans) > k {
+		ans = ans[:k]
+	}
+	return ans
+}
+
+func TestFreqDistribMergerTopKMatchesFullMerge(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	for _, k := range []int{0, 1, 10, 50, 500} {
+		chunks := mkTestChunks(rnd, 8, 200)
+		expected := fullMergeTopK(chunks, k)
+
+		merger := NewFreqDistribMerger()
+		for _, chunk := range chunks {
+			merger.Add(chunk)
+		}
+		result := merger.TopK(k)
+		assert.Equal(t, int64(8000), result.ConcSize)
+		if !assert.Len(t, result.Freqs, len(expected), "k = %d", k) {
+			continue
+		}
+		for i, item := range result.Freqs {
+			assert.Equal(t, expected[i].Word, item.Word, "k = %d, i = %d", k, i)
+			assert.Equal(t, expected[i].Freq, item.Freq, "k = %d, i = %d", k, i)
+		}
+	}
+}
+
+func TestFreqDistribMergerIPM(t *testing.T) {
+	merger := NewFreqDistribMerger()
+	merger.Add(&FreqDistrib{Freqs: FreqDistribItemList{{Word: "a", Freq: 1, Norm: 1000000, IPM: 1}}})
+	merger.Add(&FreqDistrib{Freqs: FreqDistribItemList{{Word: "a", Freq: 2, Norm: 1000000, IPM: 2}}})
+	result := merger.TopK(10)
+	assert.Equal(t, int64(3), result.Freqs[0].Freq)
+	assert.InDelta(t, 3.0, result.Freqs[0].IPM, 0.001)
+}
+
+func TestFreqDistribMergerKeepsAttr(t *testing.T) {
+	merger := NewFreqDistribMerger()
+	merger.Add(&FreqDistrib{
+		Fcrit: "word/e 0~0>0",
+		Attr:  "word",
+		Freqs: FreqDistribItemList{{Word: "psa", Freq: 2}, {Word: "pes", Freq: 1}},
+	})
+	merger.Add(&FreqDistrib{
+		Fcrit: "word/e 0~0>0",
+		Attr:  "word",
+		Freqs: FreqDistribItemList{{Word: "pes", Freq: 4}},
+	})
+	result := merger.TopK(10)
+	assert.Equal(t, "word", result.Attr)
+	assert.Equal(t, "word/e 0~0>0", result.Fcrit)
+	assert.Equal(t, "pes", result.Freqs[0].Word)
+	assert.Equal(t, int64(5), result.Freqs[0].Freq)
+	assert.Equal(t, "psa", result.Freqs[1].Word)
+}
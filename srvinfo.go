@@ -20,6 +20,8 @@ package main
 import (
 	"errors"
 	"mquery/cnf"
+	"mquery/mango"
+	"mquery/rdb"
 	"net/http"
 
 	"github.com/czcorpus/cnc-gokit/uniresp"
@@ -40,6 +42,34 @@ func mkServerInfo(conf *cnf.Conf) func(ctx *gin.Context) {
 	}
 }
 
+// getManateeVersion obtains the Manatee version (the function
+// is replaceable so the handler can be tested without the library)
+var getManateeVersion = mango.GetManateeVersion
+
+type versionInfo struct {
+	Version    string `json:"version"`
+	BuildDate  string `json:"buildDate"`
+	LastCommit string `json:"lastCommit"`
+
+	// ManateeVersion is nil in case the linked Manatee
+	// library does not provide its version
+	ManateeVersion *string `json:"manateeVersion"`
+}
+
+func mkVersionInfo() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		ans := versionInfo{
+			Version:    cleanVersionInfo(version),
+			BuildDate:  cleanVersionInfo(buildDate),
+			LastCommit: cleanVersionInfo(gitCommit),
+		}
+		if v := getManateeVersion(); v != "" {
+			ans.ManateeVersion = &v
+		}
+		uniresp.WriteJSONResponse(ctx.Writer, ans)
+	}
+}
+
 func mkPrivacyPolicy(conf *cnf.Conf) func(ctx *gin.Context) {
 	return func(ctx *gin.Context) {
 		if len(conf.PrivacyPolicy.Contents) == 0 {
@@ -54,3 +84,17 @@ func mkPrivacyPolicy(conf *cnf.Conf) func(ctx *gin.Context) {
 		}
 	}
 }
+
+func mkHealth(radapter *rdb.Adapter) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		load, err := radapter.GetQueueLoad(ctx.Request.Context())
+		if err != nil {
+			uniresp.RespondWithErrorJSON(ctx, err, http.StatusServiceUnavailable)
+			return
+		}
+		ans := make(map[string]any)
+		ans["ok"] = load.NumWorkers > 0 && !load.IsOverloaded()
+		ans["queue"] = load
+		uniresp.WriteJSONResponse(ctx.Writer, ans)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func getVersionInfo(t *testing.T, manateeVersion string) map[string]any {
+	orig := getManateeVersion
+	getManateeVersion = func() string { return manateeVersion }
+	t.Cleanup(func() { getManateeVersion = orig })
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/version", mkVersionInfo())
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var ans map[string]any
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ans))
+	return ans
+}
+
+func TestVersionInfo(t *testing.T) {
+	ans := getVersionInfo(t, "2.225.8")
+	for _, k := range []string{"version", "buildDate", "lastCommit", "manateeVersion"} {
+		assert.Contains(t, ans, k)
+	}
+	assert.Equal(t, cleanVersionInfo(version), ans["version"])
+	assert.Equal(t, cleanVersionInfo(buildDate), ans["buildDate"])
+	assert.Equal(t, cleanVersionInfo(gitCommit), ans["lastCommit"])
+	assert.Equal(t, "2.225.8", ans["manateeVersion"])
+}
+
+func TestVersionInfoManateeVersionUnavailable(t *testing.T) {
+	ans := getVersionInfo(t, "")
+	assert.Contains(t, ans, "version")
+	assert.Contains(t, ans, "manateeVersion")
+	assert.Nil(t, ans["manateeVersion"])
+}
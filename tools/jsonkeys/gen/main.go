@@ -0,0 +1,58 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// gen writes JSON keys defined via struct tags (see package jsonkeys)
+// into a Go source file of the main package. Run it from the module
+// root via `go generate`.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"mquery/tools/jsonkeys"
+	"os"
+)
+
+func main() {
+	output := flag.String("o", "keycase_keys.go", "output file")
+	flag.Parse()
+	keys, err := jsonkeys.CollectModule(".")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var src bytes.Buffer
+	src.WriteString("// Code generated by \"go run ./tools/jsonkeys/gen\"; DO NOT EDIT.\n\n")
+	src.WriteString("package main\n\n")
+	src.WriteString("// structJSONKeys contains JSON keys defined via struct tags\n")
+	src.WriteString("// (see KeyCaseMiddleware)\n")
+	src.WriteString("var structJSONKeys = map[string]bool{\n")
+	for _, k := range keys {
+		fmt.Fprintf(&src, "\t%q: true,\n", k)
+	}
+	src.WriteString("}\n")
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*output, formatted, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
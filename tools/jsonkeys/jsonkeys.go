@@ -0,0 +1,116 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package jsonkeys collects JSON object keys defined via `json`
+// struct tags in Go source files. The keys are used to convert
+// the case of JSON response keys without touching data-based
+// keys (see KeyCaseMiddleware).
+package jsonkeys
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ResponsePackages are packages outside of MQuery providing
+// types used in API responses
+var ResponsePackages = []string{
+	"github.com/czcorpus/mquery-common/concordance",
+}
+
+func collectFile(path string, keys map[string]bool) error {
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.SkipObjectResolution)
+	if err != nil {
+		return fmt.Errorf("failed to collect JSON keys: %w", err)
+	}
+	ast.Inspect(file, func(node ast.Node) bool {
+		st, ok := node.(*ast.StructType)
+		if !ok {
+			return true
+		}
+		for _, field := range st.Fields.List {
+			if field.Tag == nil {
+				continue
+			}
+			tag, err := strconv.Unquote(field.Tag.Value)
+			if err != nil {
+				continue
+			}
+			name, _, _ := strings.Cut(reflect.StructTag(tag).Get("json"), ",")
+			if name != "" && name != "-" {
+				keys[name] = true
+			}
+		}
+		return true
+	})
+	return nil
+}
+
+// Collect returns sorted unique JSON keys defined via struct tags
+// (including tags of anonymous structs) in non-test Go files found
+// in `dirs` and their subdirectories.
+func Collect(dirs ...string) ([]string, error) {
+	keys := make(map[string]bool)
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if path != dir && (strings.HasPrefix(d.Name(), ".") || d.Name() == "testdata") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if filepath.Ext(path) != ".go" || strings.HasSuffix(path, "_test.go") {
+				return nil
+			}
+			return collectFile(path, keys)
+		})
+		if err != nil {
+			return []string{}, err
+		}
+	}
+	ans := make([]string, 0, len(keys))
+	for k := range keys {
+		ans = append(ans, k)
+	}
+	sort.Strings(ans)
+	return ans, nil
+}
+
+// CollectModule returns JSON keys defined in the module located
+// in `rootDir` along with the ones defined in ResponsePackages.
+func CollectModule(rootDir string) ([]string, error) {
+	dirs := []string{rootDir}
+	for _, pkg := range ResponsePackages {
+		p, err := build.Import(pkg, rootDir, build.FindOnly)
+		if err != nil {
+			return []string{}, fmt.Errorf("failed to collect JSON keys: %w", err)
+		}
+		dirs = append(dirs, p.Dir)
+	}
+	return Collect(dirs...)
+}
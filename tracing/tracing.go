@@ -0,0 +1,119 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package tracing provides OpenTelemetry spans for the individual
+// stages of query processing (API handler, query publishing,
+// worker, Manatee call). By default, the global OpenTelemetry
+// tracer provider is used which is a no-op unless an application
+// (or a test) injects a real one via SetTracerProvider.
+package tracing
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	instrumentationName = "mquery"
+
+	AttrCorpus     = attribute.Key("mquery.corpus")
+	AttrFunc       = attribute.Key("mquery.func")
+	AttrResultSize = attribute.Key("mquery.result.size")
+	AttrConcCached = attribute.Key("mquery.conc.cached")
+)
+
+var propagator = propagation.TraceContext{}
+
+// SetTracerProvider injects a tracer provider used for all
+// the mquery spans.
+func SetTracerProvider(tp trace.TracerProvider) {
+	otel.SetTracerProvider(tp)
+}
+
+// Tracer returns the mquery tracer based on the currently
+// injected tracer provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start starts a new span as a child of a span found in `ctx` (if any).
+func Start(
+	ctx context.Context, name string, attrs ...attribute.KeyValue,
+) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan ends the span and records possible error.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InjectContext serializes the span context found in `ctx` so
+// it can be passed to a worker along with a query. In case there
+// is no valid span, nil is returned.
+func InjectContext(ctx context.Context) map[string]string {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier
+}
+
+// ExtractContext creates a context with the span context
+// serialized by InjectContext.
+func ExtractContext(data map[string]string) context.Context {
+	ctx := context.Background()
+	if len(data) == 0 {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier(data))
+}
+
+// GinMiddleware creates a span for each API request. Handlers
+// can access the span (e.g. to create child spans) via
+// the `ctx.Request.Context()`.
+func GinMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		spanCtx, span := Start(
+			ctx.Request.Context(),
+			"handler "+ctx.FullPath(),
+			AttrCorpus.String(ctx.Param("corpusId")),
+		)
+		ctx.Request = ctx.Request.WithContext(spanCtx)
+		ctx.Next()
+		span.SetAttributes(
+			attribute.Int("http.status_code", ctx.Writer.Status()),
+			AttrResultSize.Int(ctx.Writer.Size()),
+		)
+		if ctx.Writer.Status() >= 500 {
+			span.SetStatus(codes.Error, "")
+		}
+		span.End()
+	}
+}
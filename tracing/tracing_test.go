@@ -0,0 +1,104 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package tracing provides OpenTelemetry spans for the individual
+// stages of query processing (API handler, query publishing,
+// worker, Manatee call). By default, the global OpenTelemetry
+// tracer provider is used which is a no-op unless an application
+// (or a test) injects a real one via SetTracerProvider.
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func findSpan(spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	for _, span := range spans {
+		if span.Name() == name {
+			return span
+		}
+	}
+	return nil
+}
+
+// TestFreqQuerySpanHierarchy simulates all the stages of a freq.
+// query (API handler, query publishing, worker, Manatee call) including
+// passing the trace context to a worker via serialized query
+func TestFreqQuerySpanHierarchy(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	SetTracerProvider(tp)
+	defer tp.Shutdown(context.Background())
+
+	var queryTraceCtx map[string]string
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(GinMiddleware())
+	engine.GET("/freqs/:corpusId", func(ctx *gin.Context) {
+		pubCtx, pubSpan := Start(ctx.Request.Context(), "rdb.publish", AttrFunc.String("freqDistrib"))
+		queryTraceCtx = InjectContext(pubCtx)
+		// worker side
+		wCtx, wSpan := Start(
+			ExtractContext(queryTraceCtx), "worker.freqDistrib", AttrFunc.String("freqDistrib"))
+		_, mSpan := Start(wCtx, "mango.CalcFreqDist", AttrCorpus.String("/var/registry/corp1"))
+		mSpan.SetAttributes(AttrResultSize.Int(42))
+		EndSpan(mSpan, nil)
+		EndSpan(wSpan, nil)
+		EndSpan(pubSpan, nil)
+		ctx.String(http.StatusOK, "ok")
+	})
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/freqs/corp1", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEmpty(t, queryTraceCtx)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 4)
+	handler := findSpan(spans, "handler /freqs/:corpusId")
+	publish := findSpan(spans, "rdb.publish")
+	worker := findSpan(spans, "worker.freqDistrib")
+	mango := findSpan(spans, "mango.CalcFreqDist")
+	if !assert.NotNil(t, handler) || !assert.NotNil(t, publish) ||
+		!assert.NotNil(t, worker) || !assert.NotNil(t, mango) {
+		return
+	}
+	assert.False(t, handler.Parent().IsValid())
+	assert.Equal(t, handler.SpanContext().SpanID(), publish.Parent().SpanID())
+	assert.Equal(t, publish.SpanContext().SpanID(), worker.Parent().SpanID())
+	assert.True(t, worker.Parent().IsRemote())
+	assert.Equal(t, worker.SpanContext().SpanID(), mango.Parent().SpanID())
+	for _, span := range spans {
+		assert.Equal(t, handler.SpanContext().TraceID(), span.SpanContext().TraceID())
+	}
+	assert.Contains(t, handler.Attributes(), AttrCorpus.String("corp1"))
+	assert.Contains(t, worker.Attributes(), AttrFunc.String("freqDistrib"))
+	assert.Contains(t, mango.Attributes(), AttrResultSize.Int(42))
+}
+
+func TestNoopWithoutValidSpan(t *testing.T) {
+	assert.Nil(t, InjectContext(context.Background()))
+	assert.Equal(t, context.Background(), ExtractContext(nil))
+}
@@ -19,11 +19,18 @@
 package worker
 
 import (
+	"container/heap"
 	"fmt"
+	"mquery/corpus"
 	"mquery/mango"
+	"mquery/merror"
+	"mquery/rdb"
 	"mquery/results"
 	"sort"
+	"strconv"
 	"strings"
+
+	"github.com/czcorpus/mquery-common/concordance"
 )
 
 // CompileFreqResult merges three vectors holding words, freqs and norms
@@ -41,9 +48,17 @@ func CompileFreqResult(
 	if maxItems < lenLimit {
 		lenLimit = maxItems
 	}
-	ans := make([]*results.FreqDistribItem, len(freqs.Freqs))
+	ans := make([]*results.FreqDistribItem, 0, len(freqs.Freqs))
+	// values normalized by mango may become identical (e.g. after
+	// removal of control characters) so we have to merge them
+	index := make(map[string]*results.FreqDistribItem)
 	isTT := len(norms) > 0
-	for i, _ := range ans {
+	for i := range freqs.Freqs {
+		if item, ok := index[freqs.Words[i]]; ok {
+			item.Freq += freqs.Freqs[i]
+			item.IPM = calcIPM(item.Freq, item.Norm)
+			continue
+		}
 		var norm int64
 		if isTT {
 			var ok bool
@@ -55,18 +70,475 @@ func CompileFreqResult(
 		} else {
 			norm = corpSize
 		}
-		ans[i] = &results.FreqDistribItem{
+		item := &results.FreqDistribItem{
 			Freq: freqs.Freqs[i],
 			Norm: norm,
-			IPM:  float32(freqs.Freqs[i]) / float32(norm) * 1e6,
+			IPM:  calcIPM(freqs.Freqs[i], norm),
 			Word: freqs.Words[i],
 		}
+		if freqs.Tuples != nil {
+			item.Tuple = freqs.Tuples[i]
+		}
+		index[item.Word] = item
+		ans = append(ans, item)
+	}
+	if len(ans) < lenLimit {
+		lenLimit = len(ans)
 	}
-	sort.Slice(ans, func(i, j int) bool { return ans[i].Freq > ans[j].Freq })
+	results.FreqDistribItemList(ans).SortByFreq()
 	return ans[:lenLimit], nil
 }
 
+// CompileFreqSpectrum calculates a "frequency of frequencies" list
+// out of provided word-list frequencies. The items are sorted by
+// frequency in ascending order.
+func CompileFreqSpectrum(freqs []int64) []results.FreqSpectrumItem {
+	counts := make(map[int64]int64)
+	for _, f := range freqs {
+		counts[f]++
+	}
+	ans := make([]results.FreqSpectrumItem, 0, len(counts))
+	for f, cnt := range counts {
+		ans = append(ans, results.FreqSpectrumItem{Freq: f, TypeCount: cnt})
+	}
+	sort.Slice(ans, func(i, j int) bool { return ans[i].Freq < ans[j].Freq })
+	return ans
+}
+
+// CompileWordList creates a word list out of provided word-list
+// frequencies. The items are sorted by frequency in descending order
+// (and by value in case of equal frequencies). In case maxItems > 0,
+// at most maxItems items are returned and the second returned value
+// specifies whether some items have been removed.
+func CompileWordList(words []string, freqs []int64, maxItems int) ([]results.WordListItem, bool) {
+	ans := make([]results.WordListItem, len(words))
+	for i, w := range words {
+		ans[i] = results.WordListItem{Word: w, Freq: freqs[i]}
+	}
+	sort.Slice(ans, func(i, j int) bool {
+		if ans[i].Freq == ans[j].Freq {
+			return ans[i].Word < ans[j].Word
+		}
+		return ans[i].Freq > ans[j].Freq
+	})
+	if maxItems > 0 && len(ans) > maxItems {
+		return ans[:maxItems], true
+	}
+	return ans, false
+}
+
+// wordListHeap is a min-heap of word list items where the top item
+// is the "worst" one according to the CompileWordList ordering
+type wordListHeap []results.WordListItem
+
+func (h wordListHeap) Len() int { return len(h) }
+
+func (h wordListHeap) Less(i, j int) bool {
+	if h[i].Freq == h[j].Freq {
+		return h[i].Word > h[j].Word
+	}
+	return h[i].Freq < h[j].Freq
+}
+
+func (h wordListHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *wordListHeap) Push(x any) { *h = append(*h, x.(results.WordListItem)) }
+
+func (h *wordListHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// wordListTopK collects at most `maxItems` best items of a word list
+// (in the CompileWordList ordering) without keeping the whole list
+// in memory. This is suitable for attributes with huge lexicons
+// processed via mango.ForEachWordListItem.
+type wordListTopK struct {
+	items     wordListHeap
+	maxItems  int
+	truncated bool
+}
+
+func (tk *wordListTopK) Add(word string, freq int64) {
+	item := results.WordListItem{Word: word, Freq: freq}
+	if len(tk.items) < tk.maxItems {
+		heap.Push(&tk.items, item)
+		return
+	}
+	tk.truncated = true
+	worst := tk.items[0]
+	if freq > worst.Freq || freq == worst.Freq && word < worst.Word {
+		tk.items[0] = item
+		heap.Fix(&tk.items, 0)
+	}
+}
+
+// Result returns the collected items sorted by frequency in descending
+// order (and by value in case of equal frequencies) and a flag specifying
+// whether some items have been removed.
+func (tk *wordListTopK) Result() ([]results.WordListItem, bool) {
+	ans := make([]results.WordListItem, len(tk.items))
+	for i := len(ans) - 1; i >= 0; i-- {
+		ans[i] = heap.Pop(&tk.items).(results.WordListItem)
+	}
+	return ans, tk.truncated
+}
+
+// structSizeFunc returns the number of structure instances
+// in a corpus (see mango.GetStructSize)
+type structSizeFunc func(corpusPath, name string) (int, error)
+
+// structNumsFunc returns numbers of structures `name` the provided
+// positions belong to (see mango.GetStructNumsAtPositions)
+type structNumsFunc func(corpusPath, name string, positions []int64) ([]int64, error)
+
+// structRangesFunc returns [begin, end) ranges of structures `name`
+// with the provided numbers (see mango.GetStructRanges)
+type structRangesFunc func(corpusPath, name string, nums []int64) ([][2]int64, error)
+
+// corpusConfFunc returns a value of a corpus configuration
+// item (see mango.GetCorpusConf)
+type corpusConfFunc func(corpusPath string, prop string) (string, error)
+
+// corpRegionFunc returns values of attributes `attrs` for
+// the tokens [fromPos, toPos) (see mango.GetCorpRegion)
+type corpRegionFunc func(corpusPath string, attrs []string, fromPos, toPos int64) ([][]string, [2]int64, error)
+
+// determineFreqNorm returns a value relative frequencies
+// of a (non-text types) frequency distribution are calculated
+// against based on the requested normalization basis.
+func determineFreqNorm(
+	args rdb.FreqDistribArgs, freqs *mango.Freqs, getStructSize structSizeFunc,
+) (int64, error) {
+	switch {
+	case args.NormBasis == "" || args.NormBasis == rdb.NormBasisSearch:
+		return freqs.SearchSize, nil
+	case args.NormBasis == rdb.NormBasisCorpus:
+		return freqs.CorpusSize, nil
+	case strings.HasPrefix(args.NormBasis, rdb.NormBasisStructPrefix):
+		size, err := getStructSize(
+			args.CorpusPath, strings.TrimPrefix(args.NormBasis, rdb.NormBasisStructPrefix))
+		return int64(size), err
+	default:
+		return 0, merror.NewInputError("invalid normalization basis `%s`", args.NormBasis)
+	}
+}
+
+// itemFreqNorms provides per-item norms as calculated by Manatee
+// along with a frequency distribution. Norms of items with identical
+// (normalized) values are summed as the items are merged (see
+// CompileFreqResult).
+func itemFreqNorms(freqs *mango.Freqs) (map[string]int64, error) {
+	if len(freqs.Norms) != len(freqs.Words) {
+		return nil, merror.NewInputError("item norms are not available for the criterion")
+	}
+	ans := make(map[string]int64, len(freqs.Words))
+	for i, w := range freqs.Words {
+		ans[w] += freqs.Norms[i]
+	}
+	return ans, nil
+}
+
+func calcIPM(freq, norm int64) float32 {
+	if norm == 0 {
+		return 0
+	}
+	return float32(freq) / float32(norm) * 1e6
+}
+
+// CompileTimeSeries creates a list of per-year frequencies. All the years
+// found in `norms` (and matching the `fromYear`, `toYear` range - zero means
+// no limit) are included, i.e. also the ones where the searched expression
+// does not occur. Values which cannot be parsed as years are skipped.
+func CompileTimeSeries(
+	freqs *mango.Freqs,
+	norms map[string]int64,
+	fromYear, toYear int,
+) []results.TimeSeriesItem {
+	yearFreqs := make(map[string]int64)
+	for i, w := range freqs.Words {
+		yearFreqs[w] = freqs.Freqs[i]
+	}
+	ans := make([]results.TimeSeriesItem, 0, len(norms))
+	for value, norm := range norms {
+		year, err := strconv.Atoi(value)
+		if err != nil {
+			continue
+		}
+		if fromYear > 0 && year < fromYear || toYear > 0 && year > toYear {
+			continue
+		}
+		ans = append(ans, results.TimeSeriesItem{
+			Year: year,
+			Freq: yearFreqs[value],
+			Norm: norm,
+			IPM:  calcIPM(yearFreqs[value], norm),
+		})
+	}
+	sort.Slice(ans, func(i, j int) bool { return ans[i].Year < ans[j].Year })
+	return ans
+}
+
 func extractAttrFromTTCrit(crit string) string {
 	tmp := strings.Split(crit, " ")
 	return tmp[0]
 }
+
+func selectTokenAttrs(token *concordance.Token, attrs []string) {
+	if len(attrs) == 0 || token.HasError() {
+		return
+	}
+	selected := make(map[string]string)
+	for _, attr := range attrs {
+		if v, ok := token.Attrs[attr]; ok {
+			selected[attr] = v
+		}
+	}
+	token.Attrs = selected
+}
+
+// compileConcLines creates result concordance lines (including their IDs)
+// out of parsed lines. Optional `boundaries` must be aligned with `lines`.
+// In case `hideTokenPosRef` is true, the `#` reference is removed from
+// line refs once the IDs are created.
+func compileConcLines(
+	corpusID string,
+	lines []concordance.Line,
+	boundaries [][]results.StructBoundary,
+	hideTokenPosRef bool,
+) []results.ConcordanceLine {
+	ans := make([]results.ConcordanceLine, len(lines))
+	for i, line := range lines {
+		ans[i] = results.NewConcordanceLine(corpusID, line)
+		if hideTokenPosRef {
+			ans[i].Ref = mango.StripTokenPosRef(line.Ref)
+		}
+		if boundaries != nil {
+			ans[i].Boundaries = boundaries[i]
+		}
+	}
+	return ans
+}
+
+// filterPositionAttrs limits attributes of KWIC tokens (i.e. the `strong` ones)
+// to `kwicAttrs` and attributes of context tokens to `contextAttrs`.
+// An empty list means that the respective tokens keep all their attributes.
+func filterPositionAttrs(lines []concordance.Line, kwicAttrs, contextAttrs []string) {
+	for _, line := range lines {
+		for _, token := range line.Text {
+			if token.Strong {
+				selectTokenAttrs(token, kwicAttrs)
+
+			} else {
+				selectTokenAttrs(token, contextAttrs)
+			}
+		}
+	}
+}
+
+// splitMultivalueFreqs splits values packing multiple values
+// into a single attribute value (e.g. `a|b|c`) using the separator
+// `sep`. The frequencies of the same individual values are summed
+// and the values with the resulting frequency below `freqLimit` are
+// removed. Empty values are ignored. Please note that the order of
+// the returned items is not defined.
+func splitMultivalueFreqs(freqs *mango.Freqs, sep string, freqLimit int) *mango.Freqs {
+	counts := make(map[string]int64)
+	order := make([]string, 0, len(freqs.Words))
+	for i, w := range freqs.Words {
+		for _, v := range strings.Split(w, sep) {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+			if _, ok := counts[v]; !ok {
+				order = append(order, v)
+			}
+			counts[v] += freqs.Freqs[i]
+		}
+	}
+	ans := *freqs
+	ans.Words = make([]string, 0, len(order))
+	ans.Freqs = make([]int64, 0, len(order))
+	ans.Norms = nil
+	ans.Tuples = nil
+	for _, v := range order {
+		if counts[v] >= int64(freqLimit) {
+			ans.Words = append(ans.Words, v)
+			ans.Freqs = append(ans.Freqs, counts[v])
+		}
+	}
+	return &ans
+}
+
+// fitFreqsToBudget raises `freqLimit` (in case it is needed) so that
+// at most `budget` items of the distribution remain. The items below
+// the new limit are removed. In case the distribution has been obtained
+// with an items limit, the limit must be greater than `budget` (so it is
+// known whether the whole distribution fits). The returned value is the
+// applied freq. limit.
+func fitFreqsToBudget(freqs *mango.Freqs, budget, freqLimit int) (*mango.Freqs, int) {
+	if len(freqs.Freqs) <= budget {
+		return freqs, freqLimit
+	}
+	sorted := make([]int64, len(freqs.Freqs))
+	copy(sorted, freqs.Freqs)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+	// all the items more frequent than the first item
+	// not fitting the budget are kept
+	newLimit := int(sorted[budget]) + 1
+	if newLimit <= freqLimit {
+		return freqs, freqLimit
+	}
+	ans := *freqs
+	ans.Words = make([]string, 0, budget)
+	ans.Freqs = make([]int64, 0, budget)
+	ans.Norms = nil
+	ans.Tuples = nil
+	for i, f := range freqs.Freqs {
+		if f < int64(newLimit) {
+			continue
+		}
+		ans.Words = append(ans.Words, freqs.Words[i])
+		ans.Freqs = append(ans.Freqs, f)
+		if i < len(freqs.Norms) {
+			ans.Norms = append(ans.Norms, freqs.Norms[i])
+		}
+		if freqs.Tuples != nil {
+			ans.Tuples = append(ans.Tuples, freqs.Tuples[i])
+		}
+	}
+	return &ans, newLimit
+}
+
+// filterFreqsAbove removes items with their frequency above `freqMax`.
+// The order of the remaining items is preserved.
+func filterFreqsAbove(freqs *mango.Freqs, freqMax int) *mango.Freqs {
+	ans := *freqs
+	ans.Words = make([]string, 0, len(freqs.Words))
+	ans.Freqs = make([]int64, 0, len(freqs.Freqs))
+	ans.Norms = nil
+	ans.Tuples = nil
+	for i, f := range freqs.Freqs {
+		if f > int64(freqMax) {
+			continue
+		}
+		ans.Words = append(ans.Words, freqs.Words[i])
+		ans.Freqs = append(ans.Freqs, f)
+		if i < len(freqs.Norms) {
+			ans.Norms = append(ans.Norms, freqs.Norms[i])
+		}
+		if freqs.Tuples != nil {
+			ans.Tuples = append(ans.Tuples, freqs.Tuples[i])
+		}
+	}
+	return &ans
+}
+
+// filterCollsByScore removes collocates with their score below `minScore`.
+// The order of the remaining items is preserved.
+func filterCollsByScore(colls []*mango.GoCollItem, minScore float64) []*mango.GoCollItem {
+	ans := make([]*mango.GoCollItem, 0, len(colls))
+	for _, coll := range colls {
+		if coll.Score >= minScore {
+			ans = append(ans, coll)
+		}
+	}
+	return ans
+}
+
+// freqDistFunc calculates a frequency distribution (see mango.CalcFreqDist)
+type freqDistFunc func(corpusID, subcID, query, fcrit string, flimit, maxItems int) (*mango.Freqs, error)
+
+// collsFunc calculates collocations (see mango.GetCollcations)
+type collsFunc func(
+	corpusID, subcID, query string,
+	attrName string,
+	measure byte,
+	srchRange [2]int,
+	minFreq int64,
+	minCoocFreq int64,
+	maxItems int,
+) (mango.GoColls, error)
+
+// attachCollAttrs finds the most frequent value of each of
+// the `args.CollAttrs` attributes for each collocate. This requires
+// one additional frequency distribution calculation (via `calcFreqs`)
+// per collocate and attribute so it should be used with a reasonable
+// number of collocates.
+func attachCollAttrs(
+	args rdb.CollocationsArgs, colls []*mango.GoCollItem, calcFreqs freqDistFunc,
+) error {
+	for _, coll := range colls {
+		coll.Attrs = make(map[string]string)
+		query := fmt.Sprintf("[%s=\"%s\"]", args.Attr, corpus.EscapeCQLRegexp(coll.Word))
+		for _, attr := range args.CollAttrs {
+			freqs, err := calcFreqs(
+				args.CorpusPath, args.SubcPath, query, attr+" 0~0>0", 1, 1)
+			if err != nil {
+				return fmt.Errorf(
+					"failed to determine `%s` of collocate %s: %w", attr, coll.Word, err)
+			}
+			if len(freqs.Words) > 0 {
+				coll.Attrs[attr] = freqs.Words[0]
+			}
+		}
+	}
+	return nil
+}
+
+// extractStructBoundaries removes structure boundary items (see
+// mango.ConcStructClass) from a raw concordance line so the line can
+// be parsed by concordance.LineParser. The removed boundaries are
+// returned along with positions of tokens they precede.
+func extractStructBoundaries(line string) (string, []results.StructBoundary) {
+	items := strings.Fields(line)
+	ans := make([]string, 0, len(items))
+	boundaries := make([]results.StructBoundary, 0, 4)
+	var numTokens int
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		if i > 0 && strings.HasPrefix(item, "<") && i+1 < len(items) &&
+			items[i+1] == mango.ConcStructClass {
+			name := strings.Trim(item, "</>")
+			boundaries = append(boundaries, results.StructBoundary{
+				Pos:    numTokens,
+				Struct: name,
+				Close:  strings.HasPrefix(item, "</"),
+			})
+			i++
+			continue
+		}
+		// each token ends with the `attr` class preceded by its attributes
+		if item == "attr" && i > 0 && strings.HasPrefix(items[i-1], "/") {
+			numTokens++
+		}
+		ans = append(ans, item)
+	}
+	return strings.Join(ans, " "), boundaries
+}
+
+// compileRegionTokens converts attribute values of a corpus region
+// starting at `fromPos` (as returned by mango.GetCorpRegion) into
+// concordance tokens. Tokens within [kwicPos, kwicPos+kwicLen)
+// are marked as `strong`.
+func compileRegionTokens(
+	values [][]string, attrs []string, fromPos, kwicPos, kwicLen int64,
+) concordance.TokenSlice {
+	ans := make(concordance.TokenSlice, len(values))
+	for i, tokValues := range values {
+		pos := fromPos + int64(i)
+		token := &concordance.Token{
+			Word:   tokValues[0],
+			Strong: pos >= kwicPos && pos < kwicPos+kwicLen,
+			Attrs:  make(map[string]string),
+		}
+		for j, attr := range attrs[1:] {
+			token.Attrs[attr] = tokValues[j+1]
+		}
+		ans[i] = token
+	}
+	return ans
+}
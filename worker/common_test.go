@@ -0,0 +1,530 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"mquery/mango"
+	"mquery/merror"
+	"mquery/rdb"
+	"mquery/results"
+	"testing"
+
+	"github.com/czcorpus/mquery-common/concordance"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileFreqSpectrumSumsToVocabSize(t *testing.T) {
+	// word list frequencies of a tiny fixture
+	freqs := []int64{1, 1, 1, 2, 2, 3, 7, 7, 1, 12}
+	spectrum := CompileFreqSpectrum(freqs)
+	var types, tokens int64
+	for i, item := range spectrum {
+		types += item.TypeCount
+		tokens += item.Freq * item.TypeCount
+		if i > 0 {
+			assert.Less(t, spectrum[i-1].Freq, item.Freq)
+		}
+	}
+	assert.Equal(t, int64(len(freqs)), types)
+	assert.Equal(t, int64(37), tokens)
+	assert.Equal(t, int64(4), spectrum[0].TypeCount)
+}
+
+func TestCompileFreqSpectrumEmpty(t *testing.T) {
+	assert.Equal(t, 0, len(CompileFreqSpectrum([]int64{})))
+}
+
+func TestFilterPositionAttrs(t *testing.T) {
+	parser := concordance.NewLineParser([]string{"word", "lemma", "tag"})
+	lines := parser.Parse([]string{
+		"#10 velký {} /velký/AA attr pes {col0 coll} /pes/NN attr štěká {} /štěkat/VB attr",
+	})
+	filterPositionAttrs(lines, []string{"tag"}, []string{"lemma"})
+	tokens := lines[0].Text
+	assert.True(t, tokens[1].Strong)
+	assert.Equal(t, map[string]string{"tag": "NN"}, tokens[1].Attrs)
+	assert.Equal(t, map[string]string{"lemma": "velký"}, tokens[0].Attrs)
+	assert.Equal(t, map[string]string{"lemma": "štěkat"}, tokens[2].Attrs)
+	assert.Equal(t, "pes", tokens[1].Word)
+}
+
+func TestFilterPositionAttrsKeepAll(t *testing.T) {
+	parser := concordance.NewLineParser([]string{"word", "lemma", "tag"})
+	lines := parser.Parse([]string{
+		"#10 velký {} /velký/AA attr pes {col0 coll} /pes/NN attr",
+	})
+	filterPositionAttrs(lines, []string{"lemma"}, nil)
+	assert.Equal(t, map[string]string{"lemma": "velký", "tag": "AA"}, lines[0].Text[0].Attrs)
+	assert.Equal(t, map[string]string{"lemma": "pes"}, lines[0].Text[1].Attrs)
+}
+
+func TestCompileTimeSeries(t *testing.T) {
+	freqs := &mango.Freqs{
+		Words: []string{"2001", "1999", "2003"},
+		Freqs: []int64{20, 5, 3},
+	}
+	norms := map[string]int64{
+		"1999": 1000000, "2000": 500000, "2001": 2000000, "2002": 0, "2003": 0, "n/a": 300,
+	}
+	items := CompileTimeSeries(freqs, norms, 0, 0)
+	assert.Equal(
+		t,
+		[]results.TimeSeriesItem{
+			{Year: 1999, Freq: 5, Norm: 1000000, IPM: 5},
+			{Year: 2000, Freq: 0, Norm: 500000, IPM: 0},
+			{Year: 2001, Freq: 20, Norm: 2000000, IPM: 10},
+			{Year: 2002, Freq: 0, Norm: 0, IPM: 0},
+			{Year: 2003, Freq: 3, Norm: 0, IPM: 0},
+		},
+		items,
+	)
+
+	items = CompileTimeSeries(freqs, norms, 2000, 2001)
+	assert.Len(t, items, 2)
+	assert.Equal(t, 2000, items[0].Year)
+	assert.Equal(t, 2001, items[1].Year)
+}
+
+func TestCompileFreqResultCapped(t *testing.T) {
+	freqs := &mango.Freqs{
+		Words: []string{"a", "b", "c", "d", "e"},
+		Freqs: []int64{3, 10, 1, 7, 5},
+	}
+	items, err := CompileFreqResult(freqs, 1000, 3, nil)
+	assert.NoError(t, err)
+	assert.Len(t, items, 3)
+	assert.Equal(t, "b", items[0].Word)
+	assert.Equal(t, "d", items[1].Word)
+	assert.Equal(t, "e", items[2].Word)
+	assert.Equal(t, int64(1000), items[0].Norm)
+
+	items, err = CompileFreqResult(freqs, 1000, 10, nil)
+	assert.NoError(t, err)
+	assert.Len(t, items, 5)
+}
+
+func TestCompileFreqResultTuples(t *testing.T) {
+	freqs := &mango.Freqs{
+		Words:  []string{"kočka\tmňoukat", "pes\tštěkat"},
+		Freqs:  []int64{2, 5},
+		Tuples: [][]string{{"kočka", "mňoukat"}, {"pes", "štěkat"}},
+	}
+	items, err := CompileFreqResult(freqs, 1000, 10, nil)
+	assert.NoError(t, err)
+	if assert.Len(t, items, 2) {
+		assert.Equal(t, []string{"pes", "štěkat"}, items[0].Tuple)
+		assert.Equal(t, []string{"kočka", "mňoukat"}, items[1].Tuple)
+	}
+
+	// tuples must stay aligned with the remaining items
+	filtered := filterFreqsAbove(freqs, 3)
+	assert.Equal(t, [][]string{{"kočka", "mňoukat"}}, filtered.Tuples)
+}
+
+func TestCompileRegionTokensMatchesKWIC(t *testing.T) {
+	attrs := []string{"word", "lemma"}
+	// a tiny "corpus" with positions 0..7
+	corp := [][]string{
+		{"Starý", "starý"}, {"pes", "pes"}, {"hlasitě", "hlasitě"}, {"štěká", "štěkat"},
+		{"na", "na"}, {"pošťáka", "pošťák"}, {"každé", "každý"}, {"ráno", "ráno"},
+	}
+	parser := concordance.NewLineParser(attrs)
+	orig := results.NewConcordanceLine(
+		"corp1",
+		parser.Parse([]string{
+			"#3 hlasitě {} /hlasitě attr štěká {col0 coll} /štěkat attr na {} /na attr",
+		})[0],
+	)
+	// widen the context to 3 tokens on both sides (clamped at the corpus end)
+	fromPos, toPos := orig.TokenPos-3, orig.TokenPos+1+3
+	if toPos > int64(len(corp)) {
+		toPos = int64(len(corp))
+	}
+	tokens := compileRegionTokens(corp[fromPos:toPos], attrs, fromPos, orig.TokenPos, 1)
+
+	assert.Len(t, tokens, 7)
+	var kwic []string
+	for _, tok := range tokens {
+		if tok.Strong {
+			kwic = append(kwic, tok.Word)
+		}
+	}
+	var origKWIC []string
+	for _, tok := range orig.Text {
+		if tok.Strong {
+			origKWIC = append(origKWIC, tok.Word)
+		}
+	}
+	assert.Equal(t, origKWIC, kwic)
+	assert.Equal(t, "štěkat", tokens[3].Attrs["lemma"])
+	assert.Equal(t, "pes", tokens[1].Word)
+}
+
+func TestCompileConcLinesHideTokenPosRef(t *testing.T) {
+	parser := concordance.NewLineParser([]string{"word", "lemma"})
+	lines := parser.Parse([]string{
+		"#3,doc.id=d1 hlasitě {} /hlasitě attr štěká {col0 coll} /štěkat attr",
+		"#12,doc.id=d2 pes {} /pes attr",
+	})
+	boundaries := [][]results.StructBoundary{nil, {{Struct: "s", Pos: 1}}}
+
+	visible := compileConcLines("corp1", lines, boundaries, false)
+	assert.Equal(t, "#3,doc.id=d1", visible[0].Ref)
+	assert.Equal(t, "#12,doc.id=d2", visible[1].Ref)
+
+	hidden := compileConcLines("corp1", lines, boundaries, true)
+	assert.Len(t, hidden, 2)
+	for i, line := range hidden {
+		assert.NotContains(t, line.Ref, "#")
+		// the position is still available for line IDs
+		assert.Equal(t, visible[i].ID, line.ID)
+		assert.Equal(t, visible[i].TokenPos, line.TokenPos)
+		assert.Equal(t, boundaries[i], line.Boundaries)
+	}
+	assert.Equal(t, "doc.id=d1", hidden[0].Ref)
+	assert.Equal(t, "corp1:3", hidden[0].ID)
+	assert.Equal(t, int64(12), hidden[1].TokenPos)
+}
+
+func TestFilterCollsByScore(t *testing.T) {
+	// logDice scores as provided by Manatee (sorted by the score)
+	colls := []*mango.GoCollItem{
+		{Word: "štěkat", Score: 10.2, Freq: 15},
+		{Word: "hlídací", Score: 9.1, Freq: 4},
+		{Word: "kočka", Score: 7.0, Freq: 30},
+		{Word: "velký", Score: 6.99, Freq: 120},
+		{Word: "být", Score: 2.3, Freq: 800},
+	}
+	ans := filterCollsByScore(colls, 7.0)
+	words := make([]string, len(ans))
+	for i, coll := range ans {
+		words[i] = coll.Word
+		assert.GreaterOrEqual(t, coll.Score, 7.0)
+	}
+	assert.Equal(t, []string{"štěkat", "hlídací", "kočka"}, words)
+	assert.Len(t, filterCollsByScore(colls, -100), 5)
+	assert.Empty(t, filterCollsByScore(colls, 14))
+}
+
+func TestAttachCollAttrs(t *testing.T) {
+	tags := map[string]string{
+		`[lemma="štěkat"]`: "VB",
+		`[lemma="pes\.x"]`: "NN",
+	}
+	var calls []string
+	calcFreqs := func(corpusID, subcID, query, fcrit string, flimit, maxItems int) (*mango.Freqs, error) {
+		calls = append(calls, query+"|"+fcrit)
+		assert.Equal(t, "/subc/sub1.subc", subcID)
+		if fcrit != "tag 0~0>0" {
+			return &mango.Freqs{}, nil
+		}
+		return &mango.Freqs{Words: []string{tags[query]}, Freqs: []int64{5}}, nil
+	}
+	colls := []*mango.GoCollItem{{Word: "štěkat"}, {Word: "pes.x"}}
+	args := rdb.CollocationsArgs{
+		CorpusPath: "/var/registry/corp1",
+		SubcPath:   "/subc/sub1.subc",
+		Attr:       "lemma",
+		CollAttrs:  []string{"tag", "word"},
+	}
+	assert.NoError(t, attachCollAttrs(args, colls, calcFreqs))
+	assert.Equal(t, map[string]string{"tag": "VB"}, colls[0].Attrs)
+	assert.Equal(t, map[string]string{"tag": "NN"}, colls[1].Attrs)
+	assert.Len(t, calls, 4)
+}
+
+func TestAttachCollAttrsWordAndLemma(t *testing.T) {
+	words := map[string]string{
+		`[lemma="pes"]`:    "psa",
+		`[lemma="štěkat"]`: "štěká",
+	}
+	calcFreqs := func(corpusID, subcID, query, fcrit string, flimit, maxItems int) (*mango.Freqs, error) {
+		assert.Equal(t, "word 0~0>0", fcrit)
+		return &mango.Freqs{Words: []string{words[query]}, Freqs: []int64{12}}, nil
+	}
+	colls := []*mango.GoCollItem{{Word: "pes"}, {Word: "štěkat"}}
+	args := rdb.CollocationsArgs{Attr: "lemma", CollAttrs: []string{"word"}}
+	assert.NoError(t, attachCollAttrs(args, colls, calcFreqs))
+	for i, lemma := range []string{"pes", "štěkat"} {
+		assert.Equal(t, lemma, colls[i].Word)
+		assert.Equal(t, map[string]string{"word": words[`[lemma="`+lemma+`"]`]}, colls[i].Attrs)
+	}
+}
+
+func TestAttachCollAttrsError(t *testing.T) {
+	calcFreqs := func(corpusID, subcID, query, fcrit string, flimit, maxItems int) (*mango.Freqs, error) {
+		return &mango.Freqs{}, errors.New("failed")
+	}
+	colls := []*mango.GoCollItem{{Word: "štěkat"}}
+	args := rdb.CollocationsArgs{Attr: "lemma", CollAttrs: []string{"tag"}}
+	assert.Error(t, attachCollAttrs(args, colls, calcFreqs))
+}
+
+func TestCompileWordList(t *testing.T) {
+	items, truncated := CompileWordList(
+		[]string{"b", "a", "c", "d"}, []int64{3, 3, 10, 1}, 3)
+	assert.True(t, truncated)
+	assert.Equal(
+		t,
+		[]results.WordListItem{{Word: "c", Freq: 10}, {Word: "a", Freq: 3}, {Word: "b", Freq: 3}},
+		items,
+	)
+	items, truncated = CompileWordList([]string{"a"}, []int64{1}, 0)
+	assert.False(t, truncated)
+	assert.Len(t, items, 1)
+}
+
+func TestDetermineFreqNorm(t *testing.T) {
+	freqs := &mango.Freqs{
+		Words:      []string{"pes", "kočka"},
+		Freqs:      []int64{300, 100},
+		CorpusSize: 1000000,
+		SearchSize: 200000,
+	}
+	structSize := func(corpusPath, name string) (int, error) {
+		if name == "doc" {
+			return 5000, nil
+		}
+		return 0, errors.New("unknown structure")
+	}
+	relFreqs := func(normBasis string) []float32 {
+		args := rdb.FreqDistribArgs{CorpusPath: "/corpora/corp1", NormBasis: normBasis}
+		norm, err := determineFreqNorm(args, freqs, structSize)
+		assert.NoError(t, err)
+		items, err := CompileFreqResult(freqs, norm, 10, nil)
+		assert.NoError(t, err)
+		ans := make([]float32, len(items))
+		for i, item := range items {
+			ans[i] = item.IPM
+		}
+		return ans
+	}
+	assert.Equal(t, relFreqs(""), relFreqs(rdb.NormBasisSearch))
+	assert.InDeltaSlice(t, []float32{1500, 500}, relFreqs(rdb.NormBasisSearch), 0.01)
+	assert.InDeltaSlice(t, []float32{300, 100}, relFreqs(rdb.NormBasisCorpus), 0.01)
+	assert.InDeltaSlice(t, []float32{60000, 20000}, relFreqs(rdb.NormBasisStructPrefix+"doc"), 0.01)
+
+	_, err := determineFreqNorm(
+		rdb.FreqDistribArgs{NormBasis: rdb.NormBasisStructPrefix + "foo"}, freqs, structSize)
+	assert.Error(t, err)
+	_, err = determineFreqNorm(rdb.FreqDistribArgs{NormBasis: "docs"}, freqs, structSize)
+	assert.Error(t, err)
+}
+
+func TestCompileFreqResultStableOrder(t *testing.T) {
+	norms := map[string]int64{"fiction": 1000, "news": 2000, "poetry": 500, "science": 800}
+	words := []string{"fiction", "news", "poetry", "science"}
+	freqs := []int64{10, 20, 10, 20}
+	var prev []string
+	for i := 0; i < 10; i++ {
+		// rotate the input to simulate a different order of items
+		// as provided by Manatee (or merged from a map)
+		rotWords := append(append([]string{}, words[i%4:]...), words[:i%4]...)
+		rotFreqs := append(append([]int64{}, freqs[i%4:]...), freqs[:i%4]...)
+		items, err := CompileFreqResult(
+			&mango.Freqs{Words: rotWords, Freqs: rotFreqs}, 0, 10, norms)
+		assert.NoError(t, err)
+		values := make([]string, len(items))
+		for j, item := range items {
+			values[j] = item.Word
+		}
+		if prev != nil {
+			assert.Equal(t, prev, values)
+		}
+		prev = values
+	}
+	assert.Equal(t, []string{"news", "science", "fiction", "poetry"}, prev)
+}
+
+func TestSplitMultivalueFreqs(t *testing.T) {
+	freqs := &mango.Freqs{
+		Words:      []string{"a|b", "b", "c| ", "a|c"},
+		Freqs:      []int64{3, 2, 1, 4},
+		Norms:      []int64{10, 10, 10, 10},
+		CorpusSize: 1000,
+	}
+	ans := splitMultivalueFreqs(freqs, "|", 1)
+	counts := make(map[string]int64)
+	for i, w := range ans.Words {
+		counts[w] = ans.Freqs[i]
+	}
+	assert.Equal(t, map[string]int64{"a": 7, "b": 5, "c": 5}, counts)
+	assert.Nil(t, ans.Norms)
+	assert.Equal(t, int64(1000), ans.CorpusSize)
+
+	ans = splitMultivalueFreqs(freqs, "|", 6)
+	assert.Equal(t, []string{"a"}, ans.Words)
+	assert.Equal(t, []int64{7}, ans.Freqs)
+}
+
+func TestFitFreqsToBudget(t *testing.T) {
+	freqs := &mango.Freqs{
+		Words:  []string{"a", "b", "c", "d", "e"},
+		Freqs:  []int64{8, 10, 5, 8, 3},
+		Norms:  []int64{100, 100, 100, 100, 100},
+		Tuples: [][]string{{"a"}, {"b"}, {"c"}, {"d"}, {"e"}},
+	}
+	ans, flimit := fitFreqsToBudget(freqs, 3, 1)
+	assert.Equal(t, 6, flimit)
+	assert.Equal(t, []string{"a", "b", "d"}, ans.Words)
+	assert.Equal(t, []int64{8, 10, 8}, ans.Freqs)
+	assert.Equal(t, []int64{100, 100, 100}, ans.Norms)
+	assert.Equal(t, [][]string{{"a"}, {"b"}, {"d"}}, ans.Tuples)
+
+	// items with equal freqs are either all kept or all removed
+	ans, flimit = fitFreqsToBudget(freqs, 2, 1)
+	assert.Equal(t, 9, flimit)
+	assert.Equal(t, []string{"b"}, ans.Words)
+
+	// a distribution fitting the budget is kept as it is
+	ans, flimit = fitFreqsToBudget(freqs, 5, 2)
+	assert.Equal(t, 2, flimit)
+	assert.Same(t, freqs, ans)
+
+	// the requested limit is never lowered
+	ans, flimit = fitFreqsToBudget(freqs, 3, 7)
+	assert.Equal(t, 7, flimit)
+	assert.Same(t, freqs, ans)
+}
+
+func TestItemFreqNorms(t *testing.T) {
+	norms, err := itemFreqNorms(&mango.Freqs{
+		Words: []string{"fiction", "news", "fiction"},
+		Freqs: []int64{3, 2, 1},
+		Norms: []int64{1000, 500, 200},
+	})
+	assert.NoError(t, err)
+	// merged values have their norms summed
+	assert.Equal(t, map[string]int64{"fiction": 1200, "news": 500}, norms)
+
+	_, err = itemFreqNorms(&mango.Freqs{Words: []string{"pes"}, Freqs: []int64{3}})
+	assert.True(t, merror.IsInputError(err))
+}
+
+func TestFilterFreqsAbove(t *testing.T) {
+	freqs := &mango.Freqs{
+		Words:      []string{"a", "b", "c", "d"},
+		Freqs:      []int64{50, 10, 11, 3},
+		Norms:      []int64{1, 2, 3, 4},
+		CorpusSize: 1000,
+	}
+	ans := filterFreqsAbove(freqs, 10)
+	assert.Equal(t, []string{"b", "d"}, ans.Words)
+	assert.Equal(t, []int64{10, 3}, ans.Freqs)
+	assert.Equal(t, []int64{2, 4}, ans.Norms)
+	assert.Equal(t, int64(1000), ans.CorpusSize)
+	assert.Len(t, freqs.Words, 4)
+}
+
+// testWordListSize is a size of a lexicon used to
+// compare word list processing variants
+const testWordListSize = 200000
+
+func newTestWordListSource(size int) ([]string, []int64) {
+	words := make([]string, size)
+	freqs := make([]int64, size)
+	for i := range words {
+		words[i] = fmt.Sprintf("w%07d", (i*7919)%size)
+		freqs[i] = int64((i*104729)%1000 + 1)
+	}
+	return words, freqs
+}
+
+func TestWordListTopKMatchesCompileWordList(t *testing.T) {
+	words, freqs := newTestWordListSource(5000)
+	for _, maxItems := range []int{1, 10, 100, 5000, 6000} {
+		expected, expectedTrunc := CompileWordList(words, freqs, maxItems)
+		topK := wordListTopK{maxItems: maxItems}
+		for i, w := range words {
+			topK.Add(w, freqs[i])
+		}
+		items, truncated := topK.Result()
+		assert.Equal(t, expected, items, "maxItems %d", maxItems)
+		assert.Equal(t, expectedTrunc, truncated, "maxItems %d", maxItems)
+	}
+}
+
+// BenchmarkWordListMaterialized simulates processing of a word list
+// converted to Go slices as a whole (see mango.GetWordList)
+func BenchmarkWordListMaterialized(b *testing.B) {
+	words, freqs := newTestWordListSource(testWordListSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		wlWords := make([]string, 0, len(words))
+		wlFreqs := make([]int64, 0, len(freqs))
+		for i, w := range words {
+			wlWords = append(wlWords, w)
+			wlFreqs = append(wlFreqs, freqs[i])
+		}
+		CompileWordList(wlWords, wlFreqs, 100)
+	}
+}
+
+// BenchmarkWordListStreamed simulates processing of a word list
+// item by item (see mango.ForEachWordListItem)
+func BenchmarkWordListStreamed(b *testing.B) {
+	words, freqs := newTestWordListSource(testWordListSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		topK := wordListTopK{maxItems: 100}
+		for i, w := range words {
+			topK.Add(w, freqs[i])
+		}
+		topK.Result()
+	}
+}
+
+func TestExtractStructBoundaries(t *testing.T) {
+	line, boundaries := extractStructBoundaries(
+		"#10 <s> strc Starý {} /starý attr pes {col0 coll} /pes attr . {} /. attr " +
+			"</s> strc <s> strc Psi {} /pes attr </s> strc")
+	assert.Equal(
+		t,
+		"#10 Starý {} /starý attr pes {col0 coll} /pes attr . {} /. attr Psi {} /pes attr",
+		line,
+	)
+	assert.Equal(
+		t,
+		[]results.StructBoundary{
+			{Pos: 0, Struct: "s"},
+			{Pos: 3, Struct: "s", Close: true},
+			{Pos: 3, Struct: "s"},
+			{Pos: 4, Struct: "s", Close: true},
+		},
+		boundaries,
+	)
+	// the cleaned line is parseable and the positions
+	// refer to the parsed tokens
+	parsed := concordance.NewLineParser([]string{"word", "lemma"}).Parse([]string{line})[0]
+	assert.Empty(t, parsed.ErrMsg)
+	assert.Len(t, parsed.Text, 4)
+	assert.Equal(t, "Psi", parsed.Text[boundaries[2].Pos].Word)
+	assert.True(t, parsed.Text[1].Strong)
+}
+
+func TestExtractStructBoundariesNoTags(t *testing.T) {
+	orig := "#10 velký {} /velký attr pes {col0 coll} /pes attr"
+	line, boundaries := extractStructBoundaries(orig)
+	assert.Equal(t, orig, line)
+	assert.Empty(t, boundaries)
+}
@@ -0,0 +1,172 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"container/list"
+	"mquery/mango"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type concOpener func(corpusPath, subcPath, query string) (*mango.ConcHandle, error)
+
+type concCacheEntry struct {
+	key        string
+	handle     *mango.ConcHandle
+	lastAccess time.Time
+}
+
+// ConcCache keeps recently used compiled concordances so that
+// subsequent requests for different pages of the same concordance
+// do not have to evaluate the query again. Only the query (and
+// corpus/subcorpus) matters for the cache key as attributes,
+// structures and references are applied once lines are read.
+// The cache is bounded by the total number of concordance lines
+// (which is what the memory consumption of a Manatee concordance
+// depends on) and entries not accessed for `ttl` are removed.
+type ConcCache struct {
+	entries  map[string]*list.Element
+	lru      *list.List
+	ttl      time.Duration
+	maxLines int
+	numLines int
+	open     concOpener
+	lock     sync.Mutex
+}
+
+func (cc *ConcCache) mkKey(corpusPath, subcPath, query string) string {
+	return strings.Join([]string{corpusPath, subcPath, query}, "\x00")
+}
+
+// Use calls `fn` with a compiled concordance - either a cached
+// one or a newly compiled one. The handle is valid only within `fn`
+// (it must not be closed or stored by `fn`). The first returned value
+// specifies whether the concordance has been found in the cache.
+func (cc *ConcCache) Use(
+	corpusPath, subcPath, query string,
+	fn func(handle *mango.ConcHandle) error,
+) (bool, error) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	key := cc.mkKey(corpusPath, subcPath, query)
+	now := time.Now()
+	if elm, ok := cc.entries[key]; ok {
+		entry := elm.Value.(*concCacheEntry)
+		if now.Sub(entry.lastAccess) <= cc.ttl {
+			entry.lastAccess = now
+			cc.lru.MoveToFront(elm)
+			return true, fn(entry.handle)
+		}
+		cc.remove(elm)
+	}
+	handle, err := cc.open(corpusPath, subcPath, query)
+	if err != nil {
+		return false, err
+	}
+	if handle.ConcSize > cc.maxLines {
+		// too large to be cached
+		defer handle.Close()
+		return false, fn(handle)
+	}
+	for cc.lru.Len() > 0 && cc.numLines+handle.ConcSize > cc.maxLines {
+		cc.remove(cc.lru.Back())
+	}
+	elm := cc.lru.PushFront(&concCacheEntry{key: key, handle: handle, lastAccess: now})
+	cc.entries[key] = elm
+	cc.numLines += handle.ConcSize
+	return false, fn(handle)
+}
+
+// RemoveExpired closes and removes all the entries
+// not accessed for the configured TTL.
+func (cc *ConcCache) RemoveExpired() {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	now := time.Now()
+	for elm := cc.lru.Back(); elm != nil; {
+		prev := elm.Prev()
+		if now.Sub(elm.Value.(*concCacheEntry).lastAccess) > cc.ttl {
+			cc.remove(elm)
+		}
+		elm = prev
+	}
+}
+
+// remove closes and removes an entry; the caller is responsible
+// for locking the cache
+func (cc *ConcCache) remove(elm *list.Element) {
+	entry := elm.Value.(*concCacheEntry)
+	entry.handle.Close()
+	cc.numLines -= entry.handle.ConcSize
+	delete(cc.entries, entry.key)
+	cc.lru.Remove(elm)
+}
+
+// RemoveCorpus closes and removes all the entries (i.e. including
+// the subcorpora ones) of a corpus. It returns the number of removed
+// entries.
+func (cc *ConcCache) RemoveCorpus(corpusPath string) int {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	var ans int
+	prefix := corpusPath + "\x00"
+	for elm := cc.lru.Back(); elm != nil; {
+		prev := elm.Prev()
+		if strings.HasPrefix(elm.Value.(*concCacheEntry).key, prefix) {
+			cc.remove(elm)
+			ans++
+		}
+		elm = prev
+	}
+	return ans
+}
+
+func (cc *ConcCache) removeAll() {
+	for cc.lru.Len() > 0 {
+		cc.remove(cc.lru.Back())
+	}
+}
+
+// Close closes all the cached concordances
+func (cc *ConcCache) Close() {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	cc.removeAll()
+	log.Debug().Msg("concordance cache closed")
+}
+
+// NewConcCache creates a new concordance cache. In case `open`
+// is nil, mango.OpenConcordance is used to compile concordances
+// (a custom function is mostly useful for testing).
+func NewConcCache(ttl time.Duration, maxLines int, open concOpener) *ConcCache {
+	if open == nil {
+		open = mango.OpenConcordance
+	}
+	return &ConcCache{
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		ttl:      ttl,
+		maxLines: maxLines,
+		open:     open,
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"mquery/mango"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingOpener creates fake concordances of the provided size
+// and counts how many times a concordance has been compiled
+type countingOpener struct {
+	concSize int
+	numCalls int
+}
+
+func (co *countingOpener) open(corpusPath, subcPath, query string) (*mango.ConcHandle, error) {
+	co.numCalls++
+	return &mango.ConcHandle{ConcSize: co.concSize}, nil
+}
+
+func TestConcCachePaging(t *testing.T) {
+	opener := &countingOpener{concSize: 1000}
+	cache := NewConcCache(time.Minute, 10000, opener.open)
+	defer cache.Close()
+
+	var pages []*mango.ConcHandle
+	for page := 0; page < 3; page++ {
+		cached, err := cache.Use(
+			"/corpora/syn2020", "", "[lemma=\"pes\"]",
+			func(handle *mango.ConcHandle) error {
+				pages = append(pages, handle)
+				return nil
+			},
+		)
+		assert.NoError(t, err)
+		assert.Equal(t, page > 0, cached)
+	}
+	assert.Equal(t, 1, opener.numCalls)
+	assert.Same(t, pages[0], pages[1])
+	assert.Same(t, pages[0], pages[2])
+
+	// a different subcorpus means a different concordance
+	cached, err := cache.Use(
+		"/corpora/syn2020", "/subc/foo.subc", "[lemma=\"pes\"]",
+		func(handle *mango.ConcHandle) error { return nil },
+	)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, 2, opener.numCalls)
+}
+
+func TestConcCacheLimits(t *testing.T) {
+	opener := &countingOpener{concSize: 600}
+	cache := NewConcCache(time.Minute, 1000, opener.open)
+	defer cache.Close()
+	use := func(query string) bool {
+		cached, err := cache.Use(
+			"/corpora/syn2020", "", query,
+			func(handle *mango.ConcHandle) error { return nil },
+		)
+		assert.NoError(t, err)
+		return cached
+	}
+	assert.False(t, use("[word=\"a\"]"))
+	// does not fit along with the first one
+	assert.False(t, use("[word=\"b\"]"))
+	assert.True(t, use("[word=\"b\"]"))
+	assert.False(t, use("[word=\"a\"]"))
+	assert.Equal(t, 3, opener.numCalls)
+
+	// too large to be cached at all
+	opener.concSize = 2000
+	assert.False(t, use("[word=\"c\"]"))
+	assert.False(t, use("[word=\"c\"]"))
+	assert.Equal(t, 5, opener.numCalls)
+
+	cache.ttl = 0
+	opener.concSize = 10
+	assert.False(t, use("[word=\"d\"]"))
+	time.Sleep(time.Millisecond)
+	cache.RemoveExpired()
+	assert.Empty(t, cache.entries)
+	assert.Equal(t, 0, cache.numLines)
+}
+
+func TestConcCacheRemoveCorpus(t *testing.T) {
+	opener := &countingOpener{concSize: 100}
+	cache := NewConcCache(time.Minute, 10000, opener.open)
+	defer cache.Close()
+	use := func(corpusPath, query string) bool {
+		cached, err := cache.Use(
+			corpusPath, "", query,
+			func(handle *mango.ConcHandle) error { return nil },
+		)
+		assert.NoError(t, err)
+		return cached
+	}
+	use("/corpora/syn2020", "[lemma=\"pes\"]")
+	use("/corpora/syn2020", "[lemma=\"kočka\"]")
+	use("/corpora/syn2020x", "[lemma=\"pes\"]")
+	assert.Equal(t, 3, opener.numCalls)
+
+	assert.Equal(t, 2, cache.RemoveCorpus("/corpora/syn2020"))
+	assert.False(t, use("/corpora/syn2020", "[lemma=\"pes\"]"))
+	assert.True(t, use("/corpora/syn2020x", "[lemma=\"pes\"]"))
+	assert.Equal(t, 4, opener.numCalls)
+	assert.Equal(t, 0, cache.RemoveCorpus("/corpora/syn2015"))
+}
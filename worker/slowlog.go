@@ -0,0 +1,56 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// commonQueryArgs contains arguments shared by most of
+// the worker functions (used for logging purposes only)
+type commonQueryArgs struct {
+	CorpusPath string `json:"corpusPath"`
+	SubcPath   string `json:"subcPath"`
+	Query      string `json:"query"`
+}
+
+// logIfSlowQuery logs the current job in case it took
+// longer than the configured threshold.
+func (w *Worker) logIfSlowQuery(procTime time.Duration, resultSize int) {
+	if w.slowQueryThreshold <= 0 || procTime < w.slowQueryThreshold {
+		return
+	}
+	var args commonQueryArgs
+	// not all the functions have the common args so we
+	// don't care much about possible errors here
+	json.Unmarshal(w.currQuery.Args, &args)
+	log.Warn().
+		Str("workerId", w.ID).
+		Str("func", w.currQuery.Func).
+		Str("corpus", args.CorpusPath).
+		Str("subc", args.SubcPath).
+		Str("query", args.Query).
+		Float64("procTimeSecs", procTime.Seconds()).
+		Int("resultSize", resultSize).
+		Bool("cached", w.currCached).
+		Msg("slow query")
+}
@@ -0,0 +1,99 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"mquery/rdb"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// captureLog redirects the global logger to a buffer
+// for the duration of a test
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buff bytes.Buffer
+	origLogger := log.Logger
+	log.Logger = zerolog.New(&buff).Level(zerolog.DebugLevel)
+	t.Cleanup(func() {
+		log.Logger = origLogger
+	})
+	return &buff
+}
+
+func newTestSlowLogWorker(t *testing.T, threshold time.Duration) *Worker {
+	args, err := json.Marshal(rdb.FreqDistribArgs{
+		CorpusPath: "/var/registry/corp1",
+		SubcPath:   "/var/subc/sub1.subc",
+		Query:      `[lemma="pes"]`,
+	})
+	assert.NoError(t, err)
+	return &Worker{
+		ID:                 "worker-1",
+		slowQueryThreshold: threshold,
+		currQuery:          rdb.Query{Func: "freqDistrib", Args: args},
+		currCached:         true,
+	}
+}
+
+func TestLogIfSlowQuery(t *testing.T) {
+	buff := captureLog(t)
+	w := newTestSlowLogWorker(t, 2*time.Second)
+	w.logIfSlowQuery(3*time.Second, 1024)
+
+	var entry map[string]any
+	assert.NoError(t, json.Unmarshal(buff.Bytes(), &entry))
+	assert.Equal(t, "warn", entry["level"])
+	assert.Equal(t, "slow query", entry["message"])
+	assert.Equal(t, "worker-1", entry["workerId"])
+	assert.Equal(t, "freqDistrib", entry["func"])
+	assert.Equal(t, "/var/registry/corp1", entry["corpus"])
+	assert.Equal(t, "/var/subc/sub1.subc", entry["subc"])
+	assert.Equal(t, `[lemma="pes"]`, entry["query"])
+	assert.Equal(t, 3.0, entry["procTimeSecs"])
+	assert.Equal(t, 1024.0, entry["resultSize"])
+	assert.Equal(t, true, entry["cached"])
+}
+
+func TestLogIfSlowQueryFastQuery(t *testing.T) {
+	buff := captureLog(t)
+	w := newTestSlowLogWorker(t, 2*time.Second)
+	w.logIfSlowQuery(time.Second, 1024)
+	assert.Empty(t, buff.String())
+}
+
+func TestLogIfSlowQueryDisabled(t *testing.T) {
+	buff := captureLog(t)
+	w := newTestSlowLogWorker(t, 0)
+	w.logIfSlowQuery(time.Hour, 1024)
+	assert.Empty(t, buff.String())
+}
+
+func TestLogIfSlowQueryNonCommonArgs(t *testing.T) {
+	buff := captureLog(t)
+	w := newTestSlowLogWorker(t, time.Second)
+	w.currQuery = rdb.Query{Func: "corpusInfo", Args: []byte(`[1, 2]`)}
+	w.logIfSlowQuery(2*time.Second, 10)
+	assert.Contains(t, buff.String(), `"func":"corpusInfo"`)
+}
@@ -0,0 +1,218 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"fmt"
+	"mquery/corpus"
+	"mquery/mango"
+	"mquery/rdb"
+	"mquery/results"
+)
+
+// tokenRanges represents a corpus (or a subcorpus) as a list
+// of [from, to) position ranges which are treated as a single
+// continuous sequence of tokens.
+type tokenRanges [][2]int64
+
+func (tr tokenRanges) size() int64 {
+	var ans int64
+	for _, rng := range tr {
+		ans += rng[1] - rng[0]
+	}
+	return ans
+}
+
+// values returns values of `attr` for the tokens [offset, offset + length)
+// of the sequence. Tokens spanning multiple ranges are joined together.
+func (tr tokenRanges) values(
+	corpusPath, attr string,
+	offset, length int64,
+	getRegion corpRegionFunc,
+) ([]string, error) {
+	ans := make([]string, 0, length)
+	for _, rng := range tr {
+		if length <= 0 {
+			break
+		}
+		rngSize := rng[1] - rng[0]
+		if offset >= rngSize {
+			offset -= rngSize
+			continue
+		}
+		to := rng[0] + offset + length
+		if to > rng[1] {
+			to = rng[1]
+		}
+		tokens, _, err := getRegion(corpusPath, []string{attr}, rng[0]+offset, to)
+		if err != nil {
+			return ans, err
+		}
+		for _, t := range tokens {
+			ans = append(ans, t[0])
+		}
+		length -= to - rng[0] - offset
+		offset = 0
+	}
+	return ans, nil
+}
+
+// calcTTR returns a type-token ratio or nil in case
+// there are no tokens.
+func calcTTR(types, tokens int64) *float64 {
+	if tokens == 0 {
+		return nil
+	}
+	ans := float64(types) / float64(tokens)
+	return &ans
+}
+
+// movingTTRs calculates type-token ratios of all the windows
+// of the size `winSize` sliding (by a single token) over `tokens`.
+// In case there are less than `winSize` tokens, nil is returned.
+func movingTTRs(tokens []string, winSize int) []float64 {
+	if winSize < 1 || len(tokens) < winSize {
+		return nil
+	}
+	ans := make([]float64, 0, len(tokens)-winSize+1)
+	counts := make(map[string]int)
+	for i, t := range tokens {
+		counts[t]++
+		if i >= winSize {
+			out := tokens[i-winSize]
+			counts[out]--
+			if counts[out] == 0 {
+				delete(counts, out)
+			}
+		}
+		if i >= winSize-1 {
+			ans = append(ans, float64(len(counts))/float64(winSize))
+		}
+	}
+	return ans
+}
+
+// sampleOffsets returns starting offsets of `numSamples` regions
+// of the size `sampleSize` spread evenly over a sequence of
+// `total` tokens. In case the sequence is too small, the number
+// of regions is reduced accordingly.
+func sampleOffsets(total, sampleSize int64, numSamples int) []int64 {
+	if sampleSize > total || numSamples < 1 {
+		return []int64{}
+	}
+	if maxSamples := total / sampleSize; int64(numSamples) > maxSamples {
+		numSamples = int(maxSamples)
+	}
+	ans := make([]int64, numSamples)
+	step := total / int64(numSamples)
+	for i := range ans {
+		ans[i] = int64(i) * step
+	}
+	return ans
+}
+
+func average(values []float64) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	ans := sum / float64(len(values))
+	return &ans
+}
+
+func (w *Worker) typeTokenRatio(args rdb.TypeTokenRatioArgs) *results.TypeTokenRatio {
+	ans := results.TypeTokenRatio{Attr: args.Attr, WindowSize: args.WindowSize}
+	var ranges tokenRanges
+	if args.SubcPath != "" {
+		ans.IsSubcorpus = true
+		_, err := mango.ForEachWordListItem(
+			args.CorpusPath, args.SubcPath, args.Attr, 1, false,
+			func(word string, freq int64) {
+				ans.Types++
+				ans.Tokens += freq
+			},
+		)
+		if err != nil {
+			ans.Error = err.Error()
+			return &ans
+		}
+		ranges, err = corpus.ReadSubcorpusRanges(args.SubcPath)
+		if err != nil {
+			ans.Error = err.Error()
+			return &ans
+		}
+
+	} else {
+		var err error
+		ans.Types, err = mango.GetVocabSize(args.CorpusPath, args.Attr)
+		if err != nil {
+			ans.Error = err.Error()
+			return &ans
+		}
+		ans.Tokens, err = mango.GetCorpusSize(args.CorpusPath)
+		if err != nil {
+			ans.Error = err.Error()
+			return &ans
+		}
+		ranges = tokenRanges{{0, ans.Tokens}}
+	}
+	ans.TTR = calcTTR(ans.Types, ans.Tokens)
+	if err := sampleWindowTTRs(&ans, args, ranges, mango.GetCorpRegion); err != nil {
+		ans.Error = err.Error()
+	}
+	return &ans
+}
+
+// sampleWindowTTRs calculates the window-based TTR variants (STTR, MATTR)
+// of the sequence `ranges` from regions sampled evenly across the sequence
+// and stores them in `ans`.
+func sampleWindowTTRs(
+	ans *results.TypeTokenRatio,
+	args rdb.TypeTokenRatioArgs,
+	ranges tokenRanges,
+	getRegion corpRegionFunc,
+) error {
+	// each sample contains one "standardized" window followed
+	// by tokens needed for all the moving windows starting within it
+	sampleSize := int64(2*args.WindowSize - 1)
+	if total := ranges.size(); total < sampleSize && total >= int64(args.WindowSize) {
+		sampleSize = total
+	}
+	stdTTRs := make([]float64, 0, args.NumSamples)
+	movTTRs := make([]float64, 0, args.NumSamples*args.WindowSize)
+	for _, offset := range sampleOffsets(ranges.size(), sampleSize, args.NumSamples) {
+		tokens, err := ranges.values(args.CorpusPath, args.Attr, offset, sampleSize, getRegion)
+		if err != nil {
+			return fmt.Errorf("failed to sample corpus data: %w", err)
+		}
+		ttrs := movingTTRs(tokens, args.WindowSize)
+		if len(ttrs) == 0 {
+			continue
+		}
+		stdTTRs = append(stdTTRs, ttrs[0])
+		movTTRs = append(movTTRs, ttrs...)
+	}
+	ans.NumSamples = len(stdTTRs)
+	ans.STTR = average(stdTTRs)
+	ans.MATTR = average(movTTRs)
+	return nil
+}
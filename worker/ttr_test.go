@@ -0,0 +1,120 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"errors"
+	"mquery/rdb"
+	"mquery/results"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCorpRegion creates a corpRegionFunc reading tokens
+// of a tiny corpus specified as a space-separated string
+func testCorpRegion(text string) corpRegionFunc {
+	tokens := strings.Split(text, " ")
+	return func(corpusPath string, attrs []string, fromPos, toPos int64) ([][]string, [2]int64, error) {
+		ans := make([][]string, 0, toPos-fromPos)
+		for _, t := range tokens[fromPos:toPos] {
+			ans = append(ans, []string{t})
+		}
+		return ans, [2]int64{fromPos, toPos}, nil
+	}
+}
+
+func TestCalcTTR(t *testing.T) {
+	assert.InDelta(t, 0.5, *calcTTR(4, 8), 1e-9)
+	assert.Nil(t, calcTTR(0, 0))
+}
+
+func TestSampleWindowTTRs(t *testing.T) {
+	// windows: "a b a" (2/3), "b a c" (1), "a c b" (1)
+	ans := results.TypeTokenRatio{}
+	err := sampleWindowTTRs(
+		&ans,
+		rdb.TypeTokenRatioArgs{Attr: "word", WindowSize: 3, NumSamples: 1},
+		tokenRanges{{0, 8}},
+		testCorpRegion("a b a c b a d a"),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, ans.NumSamples)
+	assert.InDelta(t, 2.0/3.0, *ans.STTR, 1e-9)
+	assert.InDelta(t, 8.0/9.0, *ans.MATTR, 1e-9)
+}
+
+func TestSampleWindowTTRsMultipleSamples(t *testing.T) {
+	// samples: "a a b" (windows 1/2, 1) and "c c d" (windows 1/2, 1)
+	ans := results.TypeTokenRatio{}
+	err := sampleWindowTTRs(
+		&ans,
+		rdb.TypeTokenRatioArgs{Attr: "word", WindowSize: 2, NumSamples: 2},
+		tokenRanges{{0, 8}},
+		testCorpRegion("a a b a c c d d"),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, ans.NumSamples)
+	assert.InDelta(t, 0.5, *ans.STTR, 1e-9)
+	assert.InDelta(t, 0.75, *ans.MATTR, 1e-9)
+}
+
+func TestSampleWindowTTRsSubcorpus(t *testing.T) {
+	// the ranges form a sequence "b a | b a d a", the only sample
+	// is "b a b a d" (windows 2/3, 2/3, 1)
+	ans := results.TypeTokenRatio{}
+	err := sampleWindowTTRs(
+		&ans,
+		rdb.TypeTokenRatioArgs{Attr: "word", WindowSize: 3, NumSamples: 10},
+		tokenRanges{{1, 3}, {4, 8}},
+		testCorpRegion("a b a c b a d a"),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, ans.NumSamples)
+	assert.InDelta(t, 2.0/3.0, *ans.STTR, 1e-9)
+	assert.InDelta(t, 7.0/9.0, *ans.MATTR, 1e-9)
+}
+
+func TestSampleWindowTTRsEmpty(t *testing.T) {
+	ans := results.TypeTokenRatio{}
+	err := sampleWindowTTRs(
+		&ans,
+		rdb.TypeTokenRatioArgs{Attr: "word", WindowSize: 3, NumSamples: 10},
+		tokenRanges{},
+		testCorpRegion(""),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, ans.NumSamples)
+	assert.Nil(t, ans.STTR)
+	assert.Nil(t, ans.MATTR)
+}
+
+func TestSampleWindowTTRsRegionError(t *testing.T) {
+	ans := results.TypeTokenRatio{}
+	err := sampleWindowTTRs(
+		&ans,
+		rdb.TypeTokenRatioArgs{Attr: "word", WindowSize: 2, NumSamples: 2},
+		tokenRanges{{0, 8}},
+		func(corpusPath string, attrs []string, fromPos, toPos int64) ([][]string, [2]int64, error) {
+			return nil, [2]int64{}, errors.New("corpus not available")
+		},
+	)
+	assert.ErrorContains(t, err, "corpus not available")
+}
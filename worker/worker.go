@@ -19,29 +19,38 @@
 package worker
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math/rand"
+	"mquery/corpus"
 	"mquery/corpus/baseinfo"
 	"mquery/corpus/infoload"
 	"mquery/mango"
 	"mquery/rdb"
 	"mquery/results"
+	"mquery/tracing"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/czcorpus/cnc-gokit/fs"
 	"github.com/czcorpus/mquery-common/concordance"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	DefaultTickerInterval = 2 * time.Second
 	MaxFreqResultItems    = 100
+
+	// maxGlossLength is a maximum number of tokens
+	// of an aligned segment attached to a concordance line
+	maxGlossLength = 200
 )
 
 type jobLogger interface {
@@ -60,22 +69,53 @@ type Worker struct {
 	ticker     time.Ticker
 	jobLogger  jobLogger
 	currJobLog *results.JobLog
+	concCache  *ConcCache
+	ttNorms    *corpus.TTNormsCache
+
+	// calcFreqs calculates frequency distributions
+	// (mango.CalcFreqDist, replaceable for testing)
+	calcFreqs freqDistFunc
+
+	// getColls calculates collocations
+	// (mango.GetCollcations, replaceable for testing)
+	getColls collsFunc
+
+	// slowQueryThreshold specifies how long a query must take
+	// to be logged as a slow one (zero disables the logging)
+	slowQueryThreshold time.Duration
+
+	// currQuery and currCached describe the processed
+	// job for the slow query logging
+	currQuery  rdb.Query
+	currCached bool
 }
 
-func (w *Worker) publishResult(res results.SerializableResult, channel string) error {
+func (w *Worker) publishResult(
+	ctx context.Context, res results.SerializableResult, channel string,
+) error {
 	ans, err := rdb.CreateWorkerResult(res)
 	if err != nil {
 		return err
 	}
+	trace.SpanFromContext(ctx).SetAttributes(tracing.AttrResultSize.Int(len(ans.Value)))
 
 	w.currJobLog.End = time.Now()
 	w.currJobLog.Err = res.Err()
+	w.logIfSlowQuery(w.currJobLog.End.Sub(w.currJobLog.Begin), len(ans.Value))
 	w.jobLogger.Log(*w.currJobLog)
 	w.currJobLog = nil
-	return w.radapter.PublishResult(channel, ans)
+	return w.radapter.PublishResult(channel, w.ID, ans)
 }
 
 func (w *Worker) runQueryProtected(query rdb.Query) (ansErr error) {
+	ctx, span := tracing.Start(
+		tracing.ExtractContext(query.TraceContext),
+		"worker."+query.Func,
+		tracing.AttrFunc.String(query.Func),
+	)
+	defer func() {
+		tracing.EndSpan(span, ansErr)
+	}()
 	defer func() {
 		if r := recover(); r != nil {
 			ansErr = recoveredError{fmt.Errorf(fmt.Sprintf("recovered error: %v", r))}
@@ -89,7 +129,7 @@ func (w *Worker) runQueryProtected(query rdb.Query) (ansErr error) {
 			return err
 		}
 		ans := w.corpusInfo(args)
-		if err := w.publishResult(ans, query.Channel); err != nil {
+		if err := w.publishResult(ctx, ans, query.Channel); err != nil {
 			return err
 		}
 	case "freqDistrib":
@@ -97,8 +137,62 @@ func (w *Worker) runQueryProtected(query rdb.Query) (ansErr error) {
 		if err := json.Unmarshal(query.Args, &args); err != nil {
 			return err
 		}
-		ans := w.freqDistrib(args)
-		if err := w.publishResult(ans, query.Channel); err != nil {
+		ans := w.freqDistrib(ctx, args)
+		if err := w.publishResult(ctx, ans, query.Channel); err != nil {
+			return err
+		}
+	case "freqSpectrum":
+		var args rdb.FreqSpectrumArgs
+		if err := json.Unmarshal(query.Args, &args); err != nil {
+			return err
+		}
+		ans := w.freqSpectrum(args)
+		if err := w.publishResult(ctx, ans, query.Channel); err != nil {
+			return err
+		}
+	case "wordList":
+		var args rdb.WordListArgs
+		if err := json.Unmarshal(query.Args, &args); err != nil {
+			return err
+		}
+		ans := w.wordList(args)
+		if err := w.publishResult(ctx, ans, query.Channel); err != nil {
+			return err
+		}
+	case "timeSeries":
+		var args rdb.TimeSeriesArgs
+		if err := json.Unmarshal(query.Args, &args); err != nil {
+			return err
+		}
+		ans := w.timeSeries(args)
+		if err := w.publishResult(ctx, ans, query.Channel); err != nil {
+			return err
+		}
+	case "corpRegion":
+		var args rdb.CorpRegionArgs
+		if err := json.Unmarshal(query.Args, &args); err != nil {
+			return err
+		}
+		ans := w.corpRegion(args)
+		if err := w.publishResult(ctx, ans, query.Channel); err != nil {
+			return err
+		}
+	case "vocabSize":
+		var args rdb.VocabSizeArgs
+		if err := json.Unmarshal(query.Args, &args); err != nil {
+			return err
+		}
+		ans := w.vocabSize(args)
+		if err := w.publishResult(ctx, ans, query.Channel); err != nil {
+			return err
+		}
+	case "typeTokenRatio":
+		var args rdb.TypeTokenRatioArgs
+		if err := json.Unmarshal(query.Args, &args); err != nil {
+			return err
+		}
+		ans := w.typeTokenRatio(args)
+		if err := w.publishResult(ctx, ans, query.Channel); err != nil {
 			return err
 		}
 	case "concSize":
@@ -107,7 +201,7 @@ func (w *Worker) runQueryProtected(query rdb.Query) (ansErr error) {
 			return err
 		}
 		ans := w.concSize(args)
-		if err := w.publishResult(ans, query.Channel); err != nil {
+		if err := w.publishResult(ctx, ans, query.Channel); err != nil {
 			return err
 		}
 	case "concordance":
@@ -115,8 +209,8 @@ func (w *Worker) runQueryProtected(query rdb.Query) (ansErr error) {
 		if err := json.Unmarshal(query.Args, &args); err != nil {
 			return err
 		}
-		ans := w.concordance(args)
-		if err := w.publishResult(ans, query.Channel); err != nil {
+		ans := w.concordance(ctx, args)
+		if err := w.publishResult(ctx, ans, query.Channel); err != nil {
 			return err
 		}
 	case "collocations":
@@ -124,8 +218,8 @@ func (w *Worker) runQueryProtected(query rdb.Query) (ansErr error) {
 		if err := json.Unmarshal(query.Args, &args); err != nil {
 			return err
 		}
-		ans := w.collocations(args)
-		if err := w.publishResult(ans, query.Channel); err != nil {
+		ans := w.collocations(ctx, args)
+		if err := w.publishResult(ctx, ans, query.Channel); err != nil {
 			return err
 		}
 	case "calcCollFreqData":
@@ -134,12 +228,12 @@ func (w *Worker) runQueryProtected(query rdb.Query) (ansErr error) {
 			return err
 		}
 		ans := w.calcCollFreqData(args)
-		if err := w.publishResult(ans, query.Channel); err != nil {
+		if err := w.publishResult(ctx, ans, query.Channel); err != nil {
 			return err
 		}
 	default:
 		ans := &results.ErrorResult{Error: fmt.Sprintf("unknown query function: %s", query.Func)}
-		if err := w.publishResult(ans, query.Channel); err != nil {
+		if err := w.publishResult(ctx, ans, query.Channel); err != nil {
 			return err
 		}
 	}
@@ -174,12 +268,17 @@ func (w *Worker) tryNextQuery() error {
 			Msg("worker found an inactive query")
 		return nil
 	}
+	if err := w.radapter.ClaimQuery(query, w.ID); err != nil {
+		log.Error().Err(err).Str("channel", query.Channel).Msg("failed to claim query")
+	}
 
 	w.currJobLog = &results.JobLog{
 		WorkerID: w.ID,
 		Func:     query.Func,
 		Begin:    time.Now(),
 	}
+	w.currQuery = query
+	w.currCached = false
 
 	err = w.runQueryProtected(query)
 	var rcvErr recoveredError
@@ -188,7 +287,7 @@ func (w *Worker) tryNextQuery() error {
 			Error: fmt.Sprintf("worker panicked: %s", rcvErr.Error()),
 			Func:  query.Func,
 		}
-		if err := w.publishResult(ans, query.Channel); err != nil {
+		if err := w.publishResult(context.Background(), ans, query.Channel); err != nil {
 			return err
 		}
 	}
@@ -199,55 +298,252 @@ func (w *Worker) Listen() {
 	for {
 		select {
 		case <-w.ticker.C:
+			w.concCache.RemoveExpired()
 			w.tryNextQuery()
 		case <-w.exitEvent:
 			log.Info().Msg("worker exiting")
+			w.concCache.Close()
 			return
 		case msg := <-w.messages:
 			if msg.Payload == rdb.MsgNewQuery {
 				w.tryNextQuery()
+
+			} else if strings.HasPrefix(msg.Payload, rdb.MsgInvalidateCorpusPrefix) {
+				corpusPath := strings.TrimPrefix(msg.Payload, rdb.MsgInvalidateCorpusPrefix)
+				numRemoved := w.concCache.RemoveCorpus(corpusPath)
+				numNorms := w.ttNorms.RemoveCorpus(corpusPath)
+				log.Info().
+					Str("corpusPath", corpusPath).
+					Int("numRemoved", numRemoved).
+					Int("numRemovedNorms", numNorms).
+					Msg("invalidated cached concordances and text types norms")
 			}
 		}
 	}
 }
 
-func (w *Worker) freqDistrib(args rdb.FreqDistribArgs) *results.FreqDistrib {
+func (w *Worker) freqDistrib(ctx context.Context, args rdb.FreqDistribArgs) *results.FreqDistrib {
 	var ans results.FreqDistrib
-	freqs, err := mango.CalcFreqDist(args.CorpusPath, args.SubcPath, args.Query, args.Crit, args.FreqLimit)
-	if err != nil {
-		ans.Error = err.Error()
-		return &ans
-	}
 	maxResults := args.MaxResults
 	if maxResults == 0 {
 		maxResults = MaxFreqResultItems
 	}
+	if args.ItemsLimit > 0 && maxResults > args.ItemsLimit {
+		maxResults = args.ItemsLimit
+	}
+	// we always return at most `maxResults` most frequent items (after
+	// the offset) so there is no need to fetch more items from Manatee
+	fetchLimit := args.Offset + maxResults
+	srcFreqLimit, srcFetchLimit := args.FreqLimit, fetchLimit
+	if args.MultivalueSeparator != "" {
+		// the values are split and aggregated afterwards so we
+		// need all the (packed) values regardless of their freqs.
+		srcFreqLimit, srcFetchLimit = 1, args.ItemsLimit
+
+	} else if args.FreqMax > 0 {
+		// the most frequent items are removed afterwards so we
+		// cannot rely just on the `fetchLimit` most frequent ones
+		srcFetchLimit = args.ItemsLimit
+
+	} else if args.SortByIPM {
+		// the items are ranked by their IPM afterwards so we
+		// cannot rely just on the `fetchLimit` most frequent ones
+		srcFetchLimit = args.ItemsLimit
+
+	} else if args.ItemsBudget > 0 && srcFetchLimit < args.ItemsBudget+1 {
+		// to find out whether the distribution fits the budget,
+		// we need one more item than the budget allows
+		srcFetchLimit = args.ItemsBudget + 1
+	}
+	_, span := tracing.Start(
+		ctx, "mango.CalcFreqDist", tracing.AttrCorpus.String(args.CorpusPath))
+	freqs, err := w.calcFreqs(
+		args.CorpusPath, args.SubcPath, args.Query, args.Crit, srcFreqLimit, srcFetchLimit)
+	span.SetAttributes(tracing.AttrResultSize.Int(len(freqs.Freqs)))
+	tracing.EndSpan(span, err)
+	if err != nil {
+		ans.SetError(err)
+		return &ans
+	}
+	if args.MultivalueSeparator != "" && corpus.FreqCritAttr(args.Crit) != "" {
+		freqs = splitMultivalueFreqs(freqs, args.MultivalueSeparator, args.FreqLimit)
+		freqs.Truncated = freqs.Truncated || len(freqs.Words) > fetchLimit
+	}
+	if args.FreqMax > 0 {
+		freqs = filterFreqsAbove(freqs, args.FreqMax)
+		freqs.Truncated = freqs.Truncated || len(freqs.Words) > fetchLimit
+	}
+	ans.Flimit = args.FreqLimit
+	if args.ItemsBudget > 0 {
+		freqs, ans.Flimit = fitFreqsToBudget(freqs, args.ItemsBudget, args.FreqLimit)
+		// the distribution is now complete with respect
+		// to the (possibly raised) freq. limit
+		freqs.Truncated = len(freqs.Words) > fetchLimit
+	}
 	var norms map[string]int64
+	norm := freqs.SearchSize
 	if args.IsTextTypes {
 		attr := extractAttrFromTTCrit(args.Crit)
-		norms, err = mango.GetTextTypesNorms(args.CorpusPath, attr)
+		norms, err = w.ttNorms.Get(args.CorpusPath, attr)
 
 		if err != nil {
-			ans.Error = err.Error()
+			ans.SetError(err)
+		}
+
+	} else if args.NormBasis == rdb.NormBasisItem {
+		norms, err = itemFreqNorms(freqs)
+		if err != nil {
+			ans.SetError(err)
+			return &ans
+		}
+		ans.NormBasis = args.NormBasis
+
+	} else {
+		norm, err = determineFreqNorm(args, freqs, mango.GetStructSize)
+		if err != nil {
+			ans.SetError(err)
+			return &ans
 		}
+		ans.NormBasis = args.NormBasis
+		if ans.NormBasis == "" {
+			ans.NormBasis = rdb.NormBasisSearch
+		}
+	}
+	compileLimit := fetchLimit
+	if args.SortByIPM {
+		compileLimit = len(freqs.Words)
 	}
 	mergedFreqs, err := CompileFreqResult(
-		freqs, freqs.SearchSize, MaxFreqResultItems, norms)
-	ans.Freqs = mergedFreqs
+		freqs, norm, compileLimit, norms)
+	if err != nil {
+		ans.SetError(err)
+		return &ans
+	}
+	if args.SortByIPM {
+		results.FreqDistribItemList(mergedFreqs).SortByIPM()
+		freqs.Truncated = freqs.Truncated || len(mergedFreqs) > fetchLimit
+		mergedFreqs = results.FreqDistribItemList(mergedFreqs).Cut(fetchLimit)
+	}
+	if args.Offset < len(mergedFreqs) {
+		ans.Freqs = mergedFreqs[args.Offset:]
+
+	} else {
+		ans.Freqs = results.FreqDistribItemList{}
+	}
 	ans.ConcSize = freqs.ConcSize
 	ans.CorpusSize = freqs.CorpusSize
+	ans.SearchSize = freqs.SearchSize
 	ans.Fcrit = args.Crit
+	ans.Attr = corpus.FreqCritAttr(args.Crit)
+	ans.Truncated = freqs.Truncated
+	if ans.Truncated {
+		ans.NextOffset = args.Offset + len(ans.Freqs)
+	}
+	return &ans
+}
+
+func (w *Worker) freqSpectrum(args rdb.FreqSpectrumArgs) *results.FreqSpectrum {
+	var ans results.FreqSpectrum
+	// only frequencies are needed so there is no need
+	// to convert the (possibly huge) list of values
+	freqs := make([]int64, 0, 1000)
+	sizes, err := mango.ForEachWordListItem(
+		args.CorpusPath, args.SubcPath, args.Attr, 1, false,
+		func(word string, freq int64) {
+			freqs = append(freqs, freq)
+		},
+	)
+	if err != nil {
+		ans.Error = err.Error()
+		return &ans
+	}
+	ans.Items = CompileFreqSpectrum(freqs)
+	ans.VocabSize = int64(len(freqs))
+	ans.CorpusSize = sizes.CorpusSize
+	ans.SearchSize = sizes.SearchSize
+	ans.Attr = args.Attr
+	return &ans
+}
+
+func (w *Worker) wordList(args rdb.WordListArgs) *results.WordList {
+	ans := results.WordList{Attr: args.Attr}
+	if args.MaxItems > 0 && args.MultivalueSeparator == "" {
+		// only the most frequent items are needed so we avoid
+		// creating the whole (possibly huge) list in Go
+		topK := wordListTopK{maxItems: args.MaxItems}
+		sizes, err := mango.ForEachWordListItem(
+			args.CorpusPath, "", args.Attr, args.MinFreq, true, topK.Add)
+		if err != nil {
+			ans.Error = err.Error()
+			return &ans
+		}
+		ans.Items, ans.Truncated = topK.Result()
+		ans.CorpusSize = sizes.CorpusSize
+		return &ans
+	}
+	minFreq := args.MinFreq
+	if args.MultivalueSeparator != "" {
+		minFreq = 1
+	}
+	wlist, err := mango.GetWordList(args.CorpusPath, "", args.Attr, minFreq)
+	if err != nil {
+		ans.Error = err.Error()
+		return &ans
+	}
+	if args.MultivalueSeparator != "" {
+		wlist = splitMultivalueFreqs(wlist, args.MultivalueSeparator, args.MinFreq)
+	}
+	ans.Items, ans.Truncated = CompileWordList(wlist.Words, wlist.Freqs, args.MaxItems)
+	ans.CorpusSize = wlist.CorpusSize
+	return &ans
+}
+
+func (w *Worker) timeSeries(args rdb.TimeSeriesArgs) *results.TimeSeries {
+	var ans results.TimeSeries
+	freqs, err := w.calcFreqs(
+		args.CorpusPath, "", args.Query, fmt.Sprintf("%s 0", args.Attr), args.FreqLimit, 0)
+	if err != nil {
+		ans.Error = err.Error()
+		return &ans
+	}
+	norms, err := w.ttNorms.Get(args.CorpusPath, args.Attr)
+	if err != nil {
+		ans.Error = err.Error()
+		return &ans
+	}
+	ans.Items = CompileTimeSeries(freqs, norms, args.FromYear, args.ToYear)
+	ans.Attr = args.Attr
+	ans.ConcSize = freqs.ConcSize
+	ans.CorpusSize = freqs.CorpusSize
 	return &ans
 }
 
-func (w *Worker) collocations(args rdb.CollocationsArgs) *results.Collocations {
+func (w *Worker) vocabSize(args rdb.VocabSizeArgs) *results.VocabSize {
+	ans := results.VocabSize{Attr: args.Attr}
+	var err error
+	if args.SubcPath != "" {
+		ans.IsSubcorpus = true
+		ans.Value, err = mango.GetSubcVocabSize(args.CorpusPath, args.SubcPath, args.Attr)
+
+	} else {
+		ans.Value, err = mango.GetVocabSize(args.CorpusPath, args.Attr)
+	}
+	if err != nil {
+		ans.Error = err.Error()
+	}
+	return &ans
+}
+
+func (w *Worker) collocations(ctx context.Context, args rdb.CollocationsArgs) *results.Collocations {
 	var ans results.Collocations
 	msr, err := mango.ImportCollMeasure(args.Measure)
 	if err != nil {
 		ans.Error = err.Error()
 		return &ans
 	}
-	colls, err := mango.GetCollcations(
+	_, span := tracing.Start(
+		ctx, "mango.GetCollcations", tracing.AttrCorpus.String(args.CorpusPath))
+	colls, err := w.getColls(
 		args.CorpusPath,
 		args.SubcPath,
 		args.Query,
@@ -255,17 +551,36 @@ func (w *Worker) collocations(args rdb.CollocationsArgs) *results.Collocations {
 		msr,
 		args.SrchRange,
 		args.MinFreq,
+		args.MinCoocFreq,
 		args.MaxItems,
 	)
+	span.SetAttributes(tracing.AttrResultSize.Int(len(colls.Colls)))
+	tracing.EndSpan(span, err)
 	if err != nil {
 		ans.Error = err.Error()
 		return &ans
 	}
+	if args.MinScore != nil {
+		colls.Colls = filterCollsByScore(colls.Colls, *args.MinScore)
+	}
+	if len(args.CollAttrs) > 0 {
+		if err := attachCollAttrs(args, colls.Colls, w.calcFreqs); err != nil {
+			ans.Error = err.Error()
+			return &ans
+		}
+	}
 	ans.Colls = colls.Colls
 	ans.ConcSize = colls.ConcSize
 	ans.CorpusSize = colls.CorpusSize
 	ans.SearchSize = colls.SearchSize
 	ans.Measure = args.Measure
+	ans.MeasureCode = string(msr)
+	ans.SizeDependent = mango.IsSizeDependentCollMeasure(msr)
+	ans.MeasureLabel, err = mango.CollMeasureLabel(msr)
+	if err != nil {
+		ans.Error = err.Error()
+		return &ans
+	}
 	ans.SrchRange = args.SrchRange
 	return &ans
 }
@@ -295,7 +610,7 @@ func (w *Worker) concSize(args rdb.ConcSizeArgs) *results.ConcSize {
 	var ans results.ConcSize
 	concSizeInfo, err := mango.GetConcSize(args.CorpusPath, args.Query)
 	if err != nil {
-		ans.Error = err.Error()
+		ans.SetError(err)
 		return &ans
 	}
 	ans.ConcSize = concSizeInfo.Value
@@ -303,21 +618,308 @@ func (w *Worker) concSize(args rdb.ConcSizeArgs) *results.ConcSize {
 	return &ans
 }
 
-func (w *Worker) concordance(args rdb.ConcordanceArgs) *results.Concordance {
+func (w *Worker) concordance(ctx context.Context, args rdb.ConcordanceArgs) *results.Concordance {
 	var ans results.Concordance
-	concEx, err := mango.GetConcordance(
-		args.CorpusPath, args.Query, args.Attrs, args.StartLine, args.MaxItems,
-		args.MaxContext, args.ViewContextStruct)
+	_, span := tracing.Start(
+		ctx, "mango.GetConcordance", tracing.AttrCorpus.String(args.CorpusPath))
+	var concEx mango.GoConcordance
+	var err error
+	if args.SampleSize > 0 {
+		concEx, err = concordanceSample(args)
+
+	} else {
+		var cached bool
+		cached, err = w.concCache.Use(
+			args.CorpusPath, args.SubcPath, args.Query,
+			func(handle *mango.ConcHandle) error {
+				var err error
+				concEx, err = mango.GetConcordanceFromHandle(
+					handle, args.Attrs, args.StartLine, args.MaxItems,
+					args.MaxContext, args.ViewContextStruct, args.ContextStructs,
+					args.BoundaryStruct)
+				return err
+			},
+		)
+		span.SetAttributes(tracing.AttrConcCached.Bool(cached))
+		w.currCached = cached
+		ans.Cached = cached
+	}
+	span.SetAttributes(tracing.AttrResultSize.Int(len(concEx.Lines)))
+	tracing.EndSpan(span, err)
 	if err != nil {
-		ans.Error = err.Error()
+		ans.SetError(err)
 		return &ans
 	}
+	var boundaries [][]results.StructBoundary
+	if args.BoundaryStruct != "" {
+		boundaries = make([][]results.StructBoundary, len(concEx.Lines))
+		for i, line := range concEx.Lines {
+			concEx.Lines[i], boundaries[i] = extractStructBoundaries(line)
+		}
+	}
 	parser := concordance.NewLineParser(args.Attrs)
-	ans.Lines = parser.Parse(concEx.Lines)
+	lines := parser.Parse(concEx.Lines)
+	if len(args.KWICAttrs) > 0 || len(args.ContextAttrs) > 0 {
+		filterPositionAttrs(lines, args.KWICAttrs, args.ContextAttrs)
+	}
+	ans.Lines = compileConcLines(
+		filepath.Base(args.CorpusPath), lines, boundaries, args.HideTokenPosRef)
+	if args.ContextStructs > 0 {
+		err := alignConcContextToStructs(
+			args, ans.Lines, mango.GetStructNumsAtPositions, mango.GetStructRanges)
+		if err != nil {
+			ans.Error = fmt.Sprintf("failed to align context to structures: %s", err)
+			return &ans
+		}
+	}
+	if args.MaxDocs > 0 {
+		ans.Lines, err = limitConcLinesDocs(args, ans.Lines, mango.GetStructNumsAtPositions)
+		if err != nil {
+			ans.Error = err.Error()
+			return &ans
+		}
+	}
+	if args.GlossCorpusPath != "" {
+		err := attachConcLinesGlosses(
+			args,
+			ans.Lines,
+			mango.GetCorpusConf,
+			mango.GetStructNumsAtPositions,
+			mango.GetStructRanges,
+			mango.GetCorpRegion,
+		)
+		if err != nil {
+			ans.Error = fmt.Sprintf("failed to attach glosses: %s", err)
+			return &ans
+		}
+	}
 	ans.ConcSize = concEx.ConcSize
 	return &ans
 }
 
+// limitConcLinesDocs keeps only lines from the first `args.MaxDocs`
+// distinct documents (in the order of the lines). Lines with unknown
+// document are treated as if they belonged to the same document.
+func limitConcLinesDocs(
+	args rdb.ConcordanceArgs,
+	lines []results.ConcordanceLine,
+	getStructNums structNumsFunc,
+) ([]results.ConcordanceLine, error) {
+	positions := make([]int64, len(lines))
+	for i, line := range lines {
+		positions[i] = line.TokenPos
+	}
+	docNums, err := getStructNums(args.CorpusPath, args.DocStruct, positions)
+	if err != nil {
+		return lines, err
+	}
+	docs := make(map[int64]bool)
+	ans := make([]results.ConcordanceLine, 0, len(lines))
+	for i, line := range lines {
+		if !docs[docNums[i]] {
+			if len(docs) == args.MaxDocs {
+				continue
+			}
+			docs[docNums[i]] = true
+		}
+		ans = append(ans, line)
+	}
+	return ans, nil
+}
+
+// alignConcContextToStructs makes the context of lines requested
+// via `args.ContextStructs` start and end on edges of whole
+// `args.ViewContextStruct` instances. Manatee cuts the context
+// once it reaches `args.MaxContext` tokens (possibly in the middle
+// of a structure) - in such case, the context is trimmed to the farthest
+// structure fitting the line. In case even the structure containing
+// KWIC does not fit, the respective side of the line is left as it is.
+func alignConcContextToStructs(
+	args rdb.ConcordanceArgs,
+	lines []results.ConcordanceLine,
+	getStructNums structNumsFunc,
+	getStructRanges structRangesFunc,
+) error {
+	positions := make([]int64, len(lines))
+	for i, line := range lines {
+		positions[i] = line.TokenPos
+	}
+	structNums, err := getStructNums(args.CorpusPath, args.ViewContextStruct, positions)
+	if err != nil {
+		return err
+	}
+	// for each line, we need `numStructs` structures on the left
+	// and on the right (the one containing KWIC is shared)
+	numStructs := int64(args.ContextStructs)
+	numLineStructs := 2*numStructs - 1
+	nums := make([]int64, 0, int64(len(lines))*numLineStructs)
+	for _, num := range structNums {
+		for n := num - numStructs + 1; n < num+numStructs; n++ {
+			if num < 0 {
+				nums = append(nums, -1)
+
+			} else {
+				nums = append(nums, n)
+			}
+		}
+	}
+	ranges, err := getStructRanges(args.CorpusPath, args.ViewContextStruct, nums)
+	if err != nil {
+		return err
+	}
+	for i := range lines {
+		kwicIdx := -1
+		for j, token := range lines[i].Text {
+			if token.Strong {
+				kwicIdx = j
+				break
+			}
+		}
+		if structNums[i] < 0 || kwicIdx < 0 {
+			continue
+		}
+		lineStart := lines[i].TokenPos - int64(kwicIdx)
+		lineEnd := lineStart + int64(len(lines[i].Text))
+		lineRanges := ranges[int64(i)*numLineStructs : int64(i+1)*numLineStructs]
+		from, to := lineStart, lineEnd
+		for _, rng := range lineRanges[:numStructs] {
+			if rng[0] >= lineStart && rng[0] <= lines[i].TokenPos {
+				from = rng[0]
+				break
+			}
+		}
+		for j := len(lineRanges) - 1; j >= int(numStructs)-1; j-- {
+			if lineRanges[j][1] <= lineEnd && lineRanges[j][1] > lines[i].TokenPos {
+				to = lineRanges[j][1]
+				break
+			}
+		}
+		trimConcLine(&lines[i], int(from-lineStart), int(to-lineStart))
+	}
+	return nil
+}
+
+// trimConcLine keeps only tokens [from, to) of the line. Structure
+// boundaries are shifted accordingly and the ones outside the kept
+// tokens (including a closing tag at the start and an opening tag
+// at the end of the trimmed line) are removed.
+func trimConcLine(line *results.ConcordanceLine, from, to int) {
+	origLen := len(line.Text)
+	if from == 0 && to == origLen {
+		return
+	}
+	line.Text = line.Text[from:to]
+	if line.Boundaries == nil {
+		return
+	}
+	boundaries := make([]results.StructBoundary, 0, len(line.Boundaries))
+	for _, b := range line.Boundaries {
+		if b.Pos < from || b.Pos > to ||
+			from > 0 && b.Pos == from && b.Close ||
+			to < origLen && b.Pos == to && !b.Close {
+			continue
+		}
+		b.Pos -= from
+		boundaries = append(boundaries, b)
+	}
+	line.Boundaries = boundaries
+}
+
+// attachConcLinesGlosses attaches segments of the aligned corpus
+// `args.GlossCorpusPath` to the lines. Segments are determined via
+// the alignment structure (ALIGNSTRUCT) of the corpora - i.e. the n-th
+// structure of the primary corpus is aligned with the n-th structure
+// of the aligned one. Lines without an aligned segment (or with
+// an empty one) are left without a gloss.
+func attachConcLinesGlosses(
+	args rdb.ConcordanceArgs,
+	lines []results.ConcordanceLine,
+	getCorpusConf corpusConfFunc,
+	getStructNums structNumsFunc,
+	getStructRanges structRangesFunc,
+	getRegion corpRegionFunc,
+) error {
+	srcStruct, err := getCorpusConf(args.CorpusPath, "ALIGNSTRUCT")
+	if err != nil {
+		return err
+	}
+	glossStruct, err := getCorpusConf(args.GlossCorpusPath, "ALIGNSTRUCT")
+	if err != nil {
+		return err
+	}
+	if srcStruct == "" || glossStruct == "" {
+		return errors.New("missing alignment structure (ALIGNSTRUCT)")
+	}
+	positions := make([]int64, len(lines))
+	for i, line := range lines {
+		positions[i] = line.TokenPos
+	}
+	segNums, err := getStructNums(args.CorpusPath, srcStruct, positions)
+	if err != nil {
+		return err
+	}
+	ranges, err := getStructRanges(args.GlossCorpusPath, glossStruct, segNums)
+	if err != nil {
+		return err
+	}
+	for i, rng := range ranges {
+		if rng[0] < 0 || rng[1] <= rng[0] {
+			continue
+		}
+		if rng[1]-rng[0] > maxGlossLength {
+			rng[1] = rng[0] + maxGlossLength
+		}
+		tokens, _, err := getRegion(
+			args.GlossCorpusPath, []string{args.GlossAttr}, rng[0], rng[1])
+		if err != nil {
+			return err
+		}
+		words := make([]string, len(tokens))
+		for j, t := range tokens {
+			words[j] = t[0]
+		}
+		gloss := strings.Join(words, " ")
+		lines[i].Gloss = &gloss
+	}
+	return nil
+}
+
+// concordanceSample returns a random sample of concordance lines.
+// Samples are not cached as they are not used for paging. The returned
+// ConcSize is the size of the whole concordance.
+func concordanceSample(args rdb.ConcordanceArgs) (mango.GoConcordance, error) {
+	handle, err := mango.OpenConcordanceSample(
+		args.CorpusPath, args.SubcPath, args.Query, args.SampleSize, args.SampleSeed)
+	if err != nil {
+		return mango.GoConcordance{Lines: []string{}}, err
+	}
+	defer handle.Close()
+	ans, err := mango.GetConcordanceFromHandle(
+		handle, args.Attrs, 0, args.SampleSize, args.MaxContext, args.ViewContextStruct,
+		args.ContextStructs, args.BoundaryStruct)
+	ans.ConcSize = handle.ConcSize
+	return ans, err
+}
+
+func (w *Worker) corpRegion(args rdb.CorpRegionArgs) *results.CorpRegion {
+	var ans results.CorpRegion
+	tokens, rng, err := mango.GetCorpRegion(
+		args.CorpusPath,
+		args.Attrs,
+		args.TokenPos-args.LeftCtx,
+		args.TokenPos+args.KWICLen+args.RightCtx,
+	)
+	if err != nil {
+		ans.Error = err.Error()
+		return &ans
+	}
+	ans.Text = compileRegionTokens(tokens, args.Attrs, rng[0], args.TokenPos, args.KWICLen)
+	ans.TokenPos = args.TokenPos
+	ans.FromPos = rng[0]
+	ans.ToPos = rng[1]
+	return &ans
+}
+
 func (w *Worker) corpusInfo(args rdb.CorpusInfoArgs) *results.CorpusInfo {
 	var ans results.CorpusInfo
 	ans.Data = baseinfo.Corpus{Corpname: filepath.Base(args.CorpusPath)}
@@ -354,13 +956,21 @@ func NewWorker(
 	messages <-chan *redis.Message,
 	exitEvent chan os.Signal,
 	jobLogger jobLogger,
+	concCache *ConcCache,
+	ttNorms *corpus.TTNormsCache,
+	slowQueryThreshold time.Duration,
 ) *Worker {
 	return &Worker{
-		ID:        workerID,
-		radapter:  radapter,
-		messages:  messages,
-		exitEvent: exitEvent,
-		ticker:    *time.NewTicker(DefaultTickerInterval),
-		jobLogger: jobLogger,
+		ID:                 workerID,
+		radapter:           radapter,
+		messages:           messages,
+		exitEvent:          exitEvent,
+		ticker:             *time.NewTicker(DefaultTickerInterval),
+		jobLogger:          jobLogger,
+		concCache:          concCache,
+		ttNorms:            ttNorms,
+		slowQueryThreshold: slowQueryThreshold,
+		calcFreqs:          mango.CalcFreqDist,
+		getColls:           mango.GetCollcations,
 	}
 }
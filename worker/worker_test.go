@@ -0,0 +1,823 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MQUERY.
+//
+//  MQUERY is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MQUERY is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MQUERY.  If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mquery/corpus"
+	"mquery/mango"
+	"mquery/merror"
+	"mquery/rdb"
+	"mquery/results"
+	"sort"
+	"testing"
+
+	"github.com/czcorpus/mquery-common/concordance"
+	"github.com/stretchr/testify/assert"
+)
+
+// testDocSize is a size of all the documents of a fake corpus
+// used to test document-based limiting of concordances
+const testDocSize = 10
+
+func testStructNums(corpusPath, name string, positions []int64) ([]int64, error) {
+	ans := make([]int64, len(positions))
+	for i, pos := range positions {
+		ans[i] = pos / testDocSize
+	}
+	return ans, nil
+}
+
+func TestLimitConcLinesDocs(t *testing.T) {
+	// hits spread over 100 documents (3 hits per document)
+	lines := make([]results.ConcordanceLine, 0, 300)
+	for doc := int64(0); doc < 100; doc++ {
+		for _, offs := range []int64{1, 4, 7} {
+			lines = append(lines, results.ConcordanceLine{TokenPos: doc*testDocSize + offs})
+		}
+	}
+	args := rdb.ConcordanceArgs{CorpusPath: "/corpora/corp1", MaxDocs: 7, DocStruct: "doc"}
+	ans, err := limitConcLinesDocs(args, lines, testStructNums)
+	assert.NoError(t, err)
+	docs := make(map[int64]bool)
+	for _, line := range ans {
+		docs[line.TokenPos/testDocSize] = true
+	}
+	assert.Len(t, docs, 7)
+	assert.Equal(t, lines[:21], ans)
+}
+
+func TestLimitConcLinesDocsInterleaved(t *testing.T) {
+	lines := []results.ConcordanceLine{
+		{TokenPos: 5}, {TokenPos: 35}, {TokenPos: 7}, {TokenPos: 52}, {TokenPos: 38},
+	}
+	args := rdb.ConcordanceArgs{MaxDocs: 2, DocStruct: "doc"}
+	ans, err := limitConcLinesDocs(args, lines, testStructNums)
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		[]results.ConcordanceLine{{TokenPos: 5}, {TokenPos: 35}, {TokenPos: 7}, {TokenPos: 38}},
+		ans,
+	)
+}
+
+func TestLimitConcLinesDocsFewerDocs(t *testing.T) {
+	lines := []results.ConcordanceLine{{TokenPos: 5}, {TokenPos: 35}}
+	args := rdb.ConcordanceArgs{MaxDocs: 10, DocStruct: "doc"}
+	ans, err := limitConcLinesDocs(args, lines, testStructNums)
+	assert.NoError(t, err)
+	assert.Equal(t, lines, ans)
+}
+
+func TestLimitConcLinesDocsError(t *testing.T) {
+	args := rdb.ConcordanceArgs{MaxDocs: 1, DocStruct: "doc"}
+	_, err := limitConcLinesDocs(
+		args,
+		[]results.ConcordanceLine{{TokenPos: 5}},
+		func(corpusPath, name string, positions []int64) ([]int64, error) {
+			return nil, errors.New("unknown structure")
+		},
+	)
+	assert.Error(t, err)
+}
+
+// newTestFreqsWorker creates a worker with a fake frequency
+// distribution of `size` items - the item `w<i>` has freq.
+// `i / 3 + 1` so there are many items with equal frequencies.
+// The fake Manatee returns the items in the same order as the real
+// one (i.e. by freq. and value) limited to the requested number.
+func newTestFreqsWorker(size int) *Worker {
+	words := make([]string, size)
+	freqs := make(map[string]int64)
+	for i := range words {
+		words[i] = fmt.Sprintf("w%d", i)
+		freqs[words[i]] = int64(i/3 + 1)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if freqs[words[i]] != freqs[words[j]] {
+			return freqs[words[i]] > freqs[words[j]]
+		}
+		return words[i] < words[j]
+	})
+	calcFreqs := func(
+		corpusID, subcID, query, fcrit string, flimit, maxItems int,
+	) (*mango.Freqs, error) {
+		ans := &mango.Freqs{ConcSize: 1000, CorpusSize: 1000000, SearchSize: 1000000}
+		for i, w := range words {
+			if i == maxItems {
+				ans.Truncated = true
+				break
+			}
+			ans.Words = append(ans.Words, w)
+			ans.Freqs = append(ans.Freqs, freqs[w])
+		}
+		return ans, nil
+	}
+	return &Worker{calcFreqs: calcFreqs}
+}
+
+func TestFreqDistribPaging(t *testing.T) {
+	w := newTestFreqsWorker(95)
+	args := rdb.FreqDistribArgs{
+		CorpusPath: "/var/registry/corp1",
+		Query:      `[lemma="pes"]`,
+		Crit:       "word/e 0~0>0",
+		FreqLimit:  1,
+		MaxResults: 10,
+	}
+	seen := make(map[string]bool)
+	var prevFreq int64 = -1
+	var numPages int
+	for {
+		res := w.freqDistrib(context.Background(), args)
+		assert.NoError(t, res.Err())
+		numPages++
+		for _, item := range res.Freqs {
+			assert.False(t, seen[item.Word], "item %s on multiple pages", item.Word)
+			seen[item.Word] = true
+			if prevFreq >= 0 {
+				assert.LessOrEqual(t, item.Freq, prevFreq)
+			}
+			prevFreq = item.Freq
+		}
+		if !res.Truncated {
+			assert.Zero(t, res.NextOffset)
+			break
+		}
+		assert.Len(t, res.Freqs, 10)
+		assert.Equal(t, args.Offset+10, res.NextOffset)
+		args.Offset = res.NextOffset
+		if numPages > 20 {
+			assert.Fail(t, "paging does not end")
+			break
+		}
+	}
+	assert.Equal(t, 10, numPages)
+	assert.Len(t, seen, 95)
+}
+
+func TestFreqDistribOffsetBeyondEnd(t *testing.T) {
+	w := newTestFreqsWorker(15)
+	res := w.freqDistrib(
+		context.Background(),
+		rdb.FreqDistribArgs{Crit: "word/e 0~0>0", FreqLimit: 1, MaxResults: 10, Offset: 20},
+	)
+	assert.NoError(t, res.Err())
+	assert.Empty(t, res.Freqs)
+	assert.False(t, res.Truncated)
+}
+
+func TestFreqDistribInvalidQuery(t *testing.T) {
+	w := &Worker{
+		calcFreqs: func(
+			corpusID, subcID, query, fcrit string, flimit, maxItems int,
+		) (*mango.Freqs, error) {
+			return &mango.Freqs{}, merror.NewInputError("invalid query: unexpected token")
+		},
+	}
+	res := w.freqDistrib(context.Background(), rdb.FreqDistribArgs{
+		CorpusPath: "/var/registry/corp1",
+		Query:      `[lemma="pes"`,
+		Crit:       "word/e 0~0>0",
+		FreqLimit:  1,
+		MaxResults: 10,
+	})
+	assert.Error(t, res.Err())
+	assert.True(t, res.IsInputError())
+}
+
+func TestFreqDistribMultivalue(t *testing.T) {
+	var srcFlimit, srcMaxItems int
+	w := &Worker{
+		calcFreqs: func(
+			corpusID, subcID, query, fcrit string, flimit, maxItems int,
+		) (*mango.Freqs, error) {
+			srcFlimit, srcMaxItems = flimit, maxItems
+			return &mango.Freqs{
+				Words:      []string{"NOUN|VERB", "ADJ", "VERB", "ADJ|NOUN|VERB"},
+				Freqs:      []int64{5, 4, 3, 1},
+				ConcSize:   13,
+				CorpusSize: 1000,
+				SearchSize: 1000,
+			}, nil
+		},
+	}
+	res := w.freqDistrib(context.Background(), rdb.FreqDistribArgs{
+		CorpusPath:          "/var/registry/corp1",
+		Query:               `[lemma="pes"]`,
+		Crit:                "upos/e 0~0>0",
+		FreqLimit:           5,
+		MaxResults:          10,
+		ItemsLimit:          1000,
+		MultivalueSeparator: "|",
+	})
+	assert.NoError(t, res.Err())
+	// packed values must be fetched regardless of their freqs.
+	assert.Equal(t, 1, srcFlimit)
+	assert.Equal(t, 1000, srcMaxItems)
+	words := make([]string, len(res.Freqs))
+	freqs := make([]int64, len(res.Freqs))
+	for i, item := range res.Freqs {
+		words[i], freqs[i] = item.Word, item.Freq
+	}
+	assert.Equal(t, []string{"VERB", "NOUN", "ADJ"}, words)
+	assert.Equal(t, []int64{9, 6, 5}, freqs)
+}
+
+func TestFreqDistribItemsBudget(t *testing.T) {
+	var srcFlimit, srcMaxItems int
+	w := &Worker{
+		calcFreqs: func(
+			corpusID, subcID, query, fcrit string, flimit, maxItems int,
+		) (*mango.Freqs, error) {
+			srcFlimit, srcMaxItems = flimit, maxItems
+			ans := &mango.Freqs{ConcSize: 60, CorpusSize: 1000, SearchSize: 1000}
+			// the source distribution sorted by freqs
+			for i, f := range []int64{20, 15, 10, 7, 4, 2, 1, 1} {
+				if f < int64(flimit) {
+					break
+				}
+				if len(ans.Words) == maxItems {
+					ans.Truncated = true
+					break
+				}
+				ans.Words = append(ans.Words, fmt.Sprintf("w%d", i))
+				ans.Freqs = append(ans.Freqs, f)
+			}
+			return ans, nil
+		},
+	}
+	res := w.freqDistrib(context.Background(), rdb.FreqDistribArgs{
+		CorpusPath:  "/var/registry/corp1",
+		Query:       `[lemma="pes"]`,
+		Crit:        "word/e 0~0>0",
+		FreqLimit:   1,
+		MaxResults:  2,
+		ItemsLimit:  1000,
+		ItemsBudget: 3,
+	})
+	assert.NoError(t, res.Err())
+	assert.Equal(t, 1, srcFlimit)
+	// one item more than the budget is needed to detect it is exceeded
+	assert.Equal(t, 4, srcMaxItems)
+	assert.Equal(t, 8, res.Flimit)
+	assert.Len(t, res.Freqs, 2)
+	assert.True(t, res.Truncated)
+	data, err := json.Marshal(res)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"flimit":8`)
+
+	// a distribution fitting the budget keeps the requested limit
+	res = w.freqDistrib(context.Background(), rdb.FreqDistribArgs{
+		CorpusPath:  "/var/registry/corp1",
+		Query:       `[lemma="pes"]`,
+		Crit:        "word/e 0~0>0",
+		FreqLimit:   4,
+		MaxResults:  10,
+		ItemsLimit:  1000,
+		ItemsBudget: 5,
+	})
+	assert.NoError(t, res.Err())
+	assert.Equal(t, 4, res.Flimit)
+	assert.Len(t, res.Freqs, 5)
+	assert.False(t, res.Truncated)
+}
+
+func TestFreqDistribItemNormsMatchTextTypesNorms(t *testing.T) {
+	genreSizes := map[string]int64{"fiction": 40000, "news": 25000, "poetry": 500}
+	w := &Worker{
+		calcFreqs: func(
+			corpusID, subcID, query, fcrit string, flimit, maxItems int,
+		) (*mango.Freqs, error) {
+			return &mango.Freqs{
+				Words:      []string{"news", "fiction", "poetry"},
+				Freqs:      []int64{30, 12, 5},
+				Norms:      []int64{25000, 40000, 500},
+				ConcSize:   47,
+				CorpusSize: 65500,
+				SearchSize: 65500,
+			}, nil
+		},
+		ttNorms: corpus.NewTTNormsCache(
+			10,
+			func(corpusPath, attr string) (map[string]int64, error) {
+				return genreSizes, nil
+			},
+		),
+	}
+	args := rdb.FreqDistribArgs{
+		CorpusPath: "/var/registry/corp1",
+		Query:      `[lemma="pes"]`,
+		Crit:       "doc.genre 0",
+		FreqLimit:  1,
+		MaxResults: 10,
+		NormBasis:  rdb.NormBasisItem,
+	}
+	// norms provided by Manatee
+	byItem := w.freqDistrib(context.Background(), args)
+	assert.NoError(t, byItem.Err())
+	assert.Equal(t, rdb.NormBasisItem, byItem.NormBasis)
+
+	// norms calculated by mquery (text types)
+	args.NormBasis = ""
+	args.IsTextTypes = true
+	byTT := w.freqDistrib(context.Background(), args)
+	assert.NoError(t, byTT.Err())
+
+	assert.Len(t, byItem.Freqs, 3)
+	assert.Equal(t, len(byTT.Freqs), len(byItem.Freqs))
+	for i, item := range byItem.Freqs {
+		assert.Equal(t, byTT.Freqs[i].Word, item.Word)
+		assert.Equal(t, genreSizes[item.Word], item.Norm, item.Word)
+		assert.Equal(t, byTT.Freqs[i].Norm, item.Norm, item.Word)
+		assert.InDelta(t, byTT.Freqs[i].IPM, item.IPM, 0.001, item.Word)
+	}
+	assert.InDelta(t, 10000, byItem.FindItem("poetry").IPM, 0.001)
+}
+
+func TestFreqDistribItemNormsUnavailable(t *testing.T) {
+	w := &Worker{
+		calcFreqs: func(
+			corpusID, subcID, query, fcrit string, flimit, maxItems int,
+		) (*mango.Freqs, error) {
+			return &mango.Freqs{Words: []string{"pes"}, Freqs: []int64{3}}, nil
+		},
+	}
+	res := w.freqDistrib(context.Background(), rdb.FreqDistribArgs{
+		CorpusPath: "/var/registry/corp1",
+		Query:      `[lemma="pes"]`,
+		Crit:       "lemma/e 0~0>0",
+		FreqLimit:  1,
+		NormBasis:  rdb.NormBasisItem,
+	})
+	assert.Error(t, res.Err())
+	assert.True(t, res.IsInputError())
+}
+
+func TestFreqDistribTextTypesFreqBand(t *testing.T) {
+	var srcFlimit, srcMaxItems int
+	genres := []string{"boilerplate", "news", "fiction", "poetry", "drama"}
+	genreFreqs := []int64{500, 40, 10, 3, 2}
+	w := &Worker{
+		calcFreqs: func(
+			corpusID, subcID, query, fcrit string, flimit, maxItems int,
+		) (*mango.Freqs, error) {
+			srcFlimit, srcMaxItems = flimit, maxItems
+			ans := &mango.Freqs{ConcSize: 555, CorpusSize: 100000, SearchSize: 100000}
+			for i, f := range genreFreqs {
+				if f >= int64(flimit) && len(ans.Words) < maxItems {
+					ans.Words = append(ans.Words, genres[i])
+					ans.Freqs = append(ans.Freqs, f)
+				}
+			}
+			return ans, nil
+		},
+		ttNorms: corpus.NewTTNormsCache(
+			10,
+			func(corpusPath, attr string) (map[string]int64, error) {
+				ans := make(map[string]int64)
+				for _, g := range genres {
+					ans[g] = 20000
+				}
+				return ans, nil
+			},
+		),
+	}
+	res := w.freqDistrib(context.Background(), rdb.FreqDistribArgs{
+		CorpusPath:  "/var/registry/corp1",
+		Query:       `[lemma="pes"]`,
+		Crit:        "doc.genre 0",
+		IsTextTypes: true,
+		FreqLimit:   3,
+		FreqMax:     40,
+		MaxResults:  2,
+		ItemsLimit:  1000,
+	})
+	assert.NoError(t, res.Err())
+	assert.Equal(t, 3, srcFlimit)
+	// the most frequent items are removed so the whole
+	// distribution must be fetched
+	assert.Equal(t, 1000, srcMaxItems)
+	var words []string
+	for _, item := range res.Freqs {
+		words = append(words, item.Word)
+		assert.GreaterOrEqual(t, item.Freq, int64(3))
+		assert.LessOrEqual(t, item.Freq, int64(40))
+	}
+	assert.Equal(t, []string{"news", "fiction"}, words)
+	assert.True(t, res.Truncated)
+}
+
+func TestFreqDistribSortByIPM(t *testing.T) {
+	var srcMaxItems int
+	// documents with uneven density of hits - the ones with
+	// the most hits are not the densest ones
+	docs := []string{"d1", "d2", "d3", "d4", "d5"}
+	docHits := []int64{50, 30, 8, 4, 1}
+	docSizes := map[string]int64{"d1": 50000, "d2": 3000, "d3": 400, "d4": 8000, "d5": 10}
+	w := &Worker{
+		calcFreqs: func(
+			corpusID, subcID, query, fcrit string, flimit, maxItems int,
+		) (*mango.Freqs, error) {
+			srcMaxItems = maxItems
+			ans := &mango.Freqs{ConcSize: 93, CorpusSize: 100000, SearchSize: 100000}
+			for i, f := range docHits {
+				if len(ans.Words) == maxItems {
+					ans.Truncated = true
+					break
+				}
+				ans.Words = append(ans.Words, docs[i])
+				ans.Freqs = append(ans.Freqs, f)
+			}
+			return ans, nil
+		},
+		ttNorms: corpus.NewTTNormsCache(
+			10,
+			func(corpusPath, attr string) (map[string]int64, error) {
+				return docSizes, nil
+			},
+		),
+	}
+	args := rdb.FreqDistribArgs{
+		CorpusPath:  "/var/registry/corp1",
+		Query:       `[lemma="pes"]`,
+		Crit:        "doc.id 0",
+		IsTextTypes: true,
+		FreqLimit:   1,
+		MaxResults:  3,
+		ItemsLimit:  1000,
+		SortByIPM:   true,
+	}
+	res := w.freqDistrib(context.Background(), args)
+	assert.NoError(t, res.Err())
+	// the items are ranked after they are fetched so the
+	// whole distribution must be fetched
+	assert.Equal(t, 1000, srcMaxItems)
+	var words []string
+	for _, item := range res.Freqs {
+		words = append(words, item.Word)
+		assert.Equal(t, docSizes[item.Word], item.Norm)
+	}
+	// densities: d5 100/1000, d3 20/1000, d2 10/1000, d1 1/1000, d4 0.5/1000
+	assert.Equal(t, []string{"d5", "d3", "d2"}, words)
+	assert.True(t, res.Truncated)
+
+	// without ranking, the documents with the most hits are returned
+	args.SortByIPM = false
+	res = w.freqDistrib(context.Background(), args)
+	assert.NoError(t, res.Err())
+	words = words[:0]
+	for _, item := range res.Freqs {
+		words = append(words, item.Word)
+	}
+	assert.Equal(t, []string{"d1", "d2", "d3"}, words)
+
+	// the items limit is the only guard of the fetched documents
+	args.SortByIPM = true
+	args.ItemsLimit = 4
+	res = w.freqDistrib(context.Background(), args)
+	assert.NoError(t, res.Err())
+	assert.Equal(t, 4, srcMaxItems)
+	words = words[:0]
+	for _, item := range res.Freqs {
+		words = append(words, item.Word)
+	}
+	assert.Equal(t, []string{"d3", "d2", "d1"}, words)
+	assert.True(t, res.Truncated)
+}
+
+// testParallelCorpus is a tiny parallel corpus fixture
+type testParallelCorpus struct {
+	words [][]string
+	segs  [][2]int64
+}
+
+var testParallelCorpora = map[string]testParallelCorpus{
+	"/var/registry/cs": {
+		words: [][]string{
+			{"Starý", "starý"}, {"pes", "pes"}, {"štěká", "štěkat"}, {".", "."},
+			{"Prázdno", "prázdno"}, {".", "."},
+			{"Psi", "pes"}, {"štěkají", "štěkat"}, {".", "."},
+			{"Konec", "konec"},
+		},
+		// the last token is not within any segment
+		segs: [][2]int64{{0, 4}, {4, 6}, {6, 9}},
+	},
+	"/var/registry/en": {
+		words: [][]string{
+			{"The", "the"}, {"old", "old"}, {"dog", "dog"}, {"barks", "bark"},
+			{"Dogs", "dog"}, {"bark", "bark"},
+		},
+		// the second segment has no aligned content
+		segs: [][2]int64{{0, 4}, {4, 4}, {4, 6}},
+	},
+}
+
+func testParallelConf(corpusPath string, prop string) (string, error) {
+	if prop == "ALIGNSTRUCT" {
+		return "seg", nil
+	}
+	return "", nil
+}
+
+func testParallelStructNums(corpusPath, name string, positions []int64) ([]int64, error) {
+	ans := make([]int64, len(positions))
+	for i, pos := range positions {
+		ans[i] = -1
+		for num, seg := range testParallelCorpora[corpusPath].segs {
+			if pos >= seg[0] && pos < seg[1] {
+				ans[i] = int64(num)
+			}
+		}
+	}
+	return ans, nil
+}
+
+func testParallelStructRanges(corpusPath, name string, nums []int64) ([][2]int64, error) {
+	segs := testParallelCorpora[corpusPath].segs
+	ans := make([][2]int64, len(nums))
+	for i, num := range nums {
+		ans[i] = [2]int64{-1, -1}
+		if num >= 0 && num < int64(len(segs)) {
+			ans[i] = segs[num]
+		}
+	}
+	return ans, nil
+}
+
+func testParallelRegion(
+	corpusPath string, attrs []string, fromPos, toPos int64,
+) ([][]string, [2]int64, error) {
+	ans := make([][]string, 0, toPos-fromPos)
+	for _, token := range testParallelCorpora[corpusPath].words[fromPos:toPos] {
+		if attrs[0] == "lemma" {
+			ans = append(ans, []string{token[1]})
+
+		} else {
+			ans = append(ans, []string{token[0]})
+		}
+	}
+	return ans, [2]int64{fromPos, toPos}, nil
+}
+
+func TestAttachConcLinesGlosses(t *testing.T) {
+	lines := []results.ConcordanceLine{
+		{TokenPos: 2}, {TokenPos: 4}, {TokenPos: 7}, {TokenPos: 9}, {TokenPos: -1},
+	}
+	args := rdb.ConcordanceArgs{
+		CorpusPath:      "/var/registry/cs",
+		GlossCorpusPath: "/var/registry/en",
+		GlossAttr:       "word",
+	}
+	err := attachConcLinesGlosses(
+		args, lines, testParallelConf, testParallelStructNums,
+		testParallelStructRanges, testParallelRegion)
+	assert.NoError(t, err)
+	glosses := make([]*string, len(lines))
+	for i, line := range lines {
+		glosses[i] = line.Gloss
+	}
+	gloss1, gloss2 := "The old dog barks", "Dogs bark"
+	// an empty aligned segment, a position out of segments
+	// and an unknown position produce no gloss
+	assert.Equal(t, []*string{&gloss1, nil, &gloss2, nil, nil}, glosses)
+
+	args.GlossAttr = "lemma"
+	lines = []results.ConcordanceLine{{TokenPos: 7}}
+	err = attachConcLinesGlosses(
+		args, lines, testParallelConf, testParallelStructNums,
+		testParallelStructRanges, testParallelRegion)
+	assert.NoError(t, err)
+	assert.Equal(t, "dog bark", *lines[0].Gloss)
+}
+
+func TestAttachConcLinesGlossesLimitsLength(t *testing.T) {
+	var regionSize int64
+	err := attachConcLinesGlosses(
+		rdb.ConcordanceArgs{CorpusPath: "/var/registry/cs", GlossCorpusPath: "/var/registry/en"},
+		[]results.ConcordanceLine{{TokenPos: 2}},
+		testParallelConf,
+		testParallelStructNums,
+		func(corpusPath, name string, nums []int64) ([][2]int64, error) {
+			return [][2]int64{{100, 1000}}, nil
+		},
+		func(corpusPath string, attrs []string, fromPos, toPos int64) ([][]string, [2]int64, error) {
+			regionSize = toPos - fromPos
+			return [][]string{{"x"}}, [2]int64{fromPos, toPos}, nil
+		},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(maxGlossLength), regionSize)
+}
+
+func TestAttachConcLinesGlossesNoAlignStruct(t *testing.T) {
+	err := attachConcLinesGlosses(
+		rdb.ConcordanceArgs{CorpusPath: "/var/registry/cs", GlossCorpusPath: "/var/registry/en"},
+		[]results.ConcordanceLine{{TokenPos: 2}},
+		func(corpusPath string, prop string) (string, error) {
+			if corpusPath == "/var/registry/en" {
+				return "", nil
+			}
+			return "seg", nil
+		},
+		testParallelStructNums,
+		testParallelStructRanges,
+		testParallelRegion,
+	)
+	assert.Error(t, err)
+}
+
+func newTestCollsWorker() *Worker {
+	return &Worker{
+		getColls: func(
+			corpusID, subcID, query string,
+			attrName string,
+			measure byte,
+			srchRange [2]int,
+			minFreq int64,
+			minCoocFreq int64,
+			maxItems int,
+		) (mango.GoColls, error) {
+			ans := mango.GoColls{ConcSize: 420, CorpusSize: 1000000, SearchSize: 1000000}
+			if subcID != "" {
+				ans.SearchSize = 25000
+			}
+			ans.Colls = []*mango.GoCollItem{{Word: "štěkat", Score: 9.5, Freq: 17}}
+			return ans, nil
+		},
+	}
+}
+
+func TestCollocationsSizes(t *testing.T) {
+	w := newTestCollsWorker()
+	for _, tc := range []struct {
+		measure       string
+		subcPath      string
+		searchSize    int64
+		sizeDependent bool
+	}{
+		{"relFreq", "", 1000000, false},
+		{"minSensitivity", "/var/subc/corp1/sub1.subc", 25000, false},
+		{"tScore", "", 1000000, true},
+		{"mutualInfo", "/var/subc/corp1/sub1.subc", 25000, true},
+	} {
+		res := w.collocations(context.Background(), rdb.CollocationsArgs{
+			CorpusPath: "/var/registry/corp1",
+			SubcPath:   tc.subcPath,
+			Query:      `[lemma="pes"]`,
+			Attr:       "lemma",
+			Measure:    tc.measure,
+			SrchRange:  [2]int{-3, 3},
+			MaxItems:   10,
+		})
+		assert.NoError(t, res.Err(), tc.measure)
+		assert.Equal(t, int64(420), res.ConcSize, tc.measure)
+		assert.Equal(t, int64(1000000), res.CorpusSize, tc.measure)
+		assert.Equal(t, tc.searchSize, res.SearchSize, tc.measure)
+		assert.Equal(t, tc.sizeDependent, res.SizeDependent, tc.measure)
+		assert.NotEmpty(t, res.MeasureLabel, tc.measure)
+	}
+}
+
+// testSentences are [begin, end) ranges of sentences of a fake corpus
+// used to test structure-based concordance context
+var testSentences = [][2]int64{{0, 5}, {5, 12}, {12, 20}, {20, 26}, {26, 30}}
+
+func testSentenceNums(corpusPath, name string, positions []int64) ([]int64, error) {
+	ans := make([]int64, len(positions))
+	for i, pos := range positions {
+		ans[i] = -1
+		for num, sent := range testSentences {
+			if pos >= sent[0] && pos < sent[1] {
+				ans[i] = int64(num)
+			}
+		}
+	}
+	return ans, nil
+}
+
+func testSentenceRanges(corpusPath, name string, nums []int64) ([][2]int64, error) {
+	ans := make([][2]int64, len(nums))
+	for i, num := range nums {
+		ans[i] = [2]int64{-1, -1}
+		if num >= 0 && num < int64(len(testSentences)) {
+			ans[i] = testSentences[num]
+		}
+	}
+	return ans, nil
+}
+
+// newTestStructContextLine creates a line with tokens [fromPos, toPos)
+// (each token's word is its position) and KWIC at `kwicPos`
+func newTestStructContextLine(fromPos, toPos, kwicPos int64) results.ConcordanceLine {
+	ans := results.ConcordanceLine{TokenPos: kwicPos}
+	for pos := fromPos; pos < toPos; pos++ {
+		ans.Text = append(
+			ans.Text, &concordance.Token{Word: fmt.Sprint(pos), Strong: pos == kwicPos})
+	}
+	return ans
+}
+
+func structContextLineRange(line results.ConcordanceLine) [2]string {
+	return [2]string{line.Text[0].Word, line.Text[len(line.Text)-1].Word}
+}
+
+func TestAlignConcContextToStructs(t *testing.T) {
+	args := rdb.ConcordanceArgs{
+		CorpusPath:        "/var/registry/corp1",
+		ViewContextStruct: "s",
+		ContextStructs:    2,
+	}
+	lines := []results.ConcordanceLine{
+		// a complete 2-sentence context (as returned by Manatee)
+		newTestStructContextLine(5, 26, 14),
+		// the context cut by the max. context in the middle of sentences
+		newTestStructContextLine(8, 23, 14),
+		// cut on the left side only
+		newTestStructContextLine(2, 26, 14),
+		// the first sentence of the corpus (no previous sentence)
+		newTestStructContextLine(0, 12, 1),
+		// the sentence containing KWIC does not fit
+		newTestStructContextLine(13, 18, 14),
+	}
+	err := alignConcContextToStructs(args, lines, testSentenceNums, testSentenceRanges)
+	assert.NoError(t, err)
+	ranges := make([][2]string, len(lines))
+	for i, line := range lines {
+		ranges[i] = structContextLineRange(line)
+	}
+	assert.Equal(
+		t,
+		[][2]string{{"5", "25"}, {"12", "19"}, {"5", "25"}, {"0", "11"}, {"13", "17"}},
+		ranges,
+	)
+	for _, line := range lines {
+		for _, token := range line.Text {
+			if token.Word == "14" || token.Word == "1" {
+				assert.True(t, token.Strong)
+			}
+		}
+	}
+}
+
+func TestAlignConcContextToStructsBoundaries(t *testing.T) {
+	args := rdb.ConcordanceArgs{
+		CorpusPath:        "/var/registry/corp1",
+		ViewContextStruct: "s",
+		ContextStructs:    2,
+	}
+	line := newTestStructContextLine(3, 23, 14)
+	for _, pos := range []int{5, 12, 20} {
+		line.Boundaries = append(
+			line.Boundaries,
+			results.StructBoundary{Pos: pos - 3, Struct: "s", Close: true},
+			results.StructBoundary{Pos: pos - 3, Struct: "s"},
+		)
+	}
+	lines := []results.ConcordanceLine{line}
+	err := alignConcContextToStructs(args, lines, testSentenceNums, testSentenceRanges)
+	assert.NoError(t, err)
+	assert.Equal(t, [2]string{"5", "19"}, structContextLineRange(lines[0]))
+	// the line starts with an opening tag and ends with a closing one
+	assert.Equal(
+		t,
+		[]results.StructBoundary{
+			{Pos: 0, Struct: "s"},
+			{Pos: 7, Struct: "s", Close: true},
+			{Pos: 7, Struct: "s"},
+			{Pos: 15, Struct: "s", Close: true},
+		},
+		lines[0].Boundaries,
+	)
+}
+
+func TestAlignConcContextToStructsUnknownStruct(t *testing.T) {
+	args := rdb.ConcordanceArgs{
+		CorpusPath:        "/var/registry/corp1",
+		ViewContextStruct: "s",
+		ContextStructs:    1,
+	}
+	lines := []results.ConcordanceLine{newTestStructContextLine(28, 35, 32)}
+	err := alignConcContextToStructs(args, lines, testSentenceNums, testSentenceRanges)
+	assert.NoError(t, err)
+	assert.Equal(t, [2]string{"28", "34"}, structContextLineRange(lines[0]))
+}